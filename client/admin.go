@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+type logLevel struct {
+	Level string `json:"level"`
+}
+
+// LogLevel returns the server's current log level.
+func (c *Client) LogLevel(ctx context.Context) (string, error) {
+	var resp logLevel
+	req := &request{method: http.MethodGet, path: "/admin/log-level"}
+	err := c.do(ctx, req, &resp)
+	return resp.Level, err
+}
+
+// SetLogLevel changes the server's log level to debug, info, warn or error.
+func (c *Client) SetLogLevel(ctx context.Context, level string) (string, error) {
+	var resp logLevel
+	err := c.call(ctx, http.MethodPut, "/admin/log-level", logLevel{Level: level}, &resp)
+	return resp.Level, err
+}
+
+type discountResponse struct {
+	Discount db.DiscountCode `json:"discount"`
+}
+
+// CreateDiscount creates a discount code, admins only. The code comes back upper case.
+func (c *Client) CreateDiscount(ctx context.Context, discount db.DiscountCode) (db.DiscountCode, error) {
+	var resp discountResponse
+	err := c.create(ctx, "/admin/discounts", discount, &resp)
+	return resp.Discount, err
+}
+
+func (c *Client) ListDiscounts(ctx context.Context, opts ListOptions) (Page[db.DiscountCode], error) {
+	return list[db.DiscountCode](ctx, c, "/admin/discounts", "discounts", opts)
+}
+
+// Discounts iterates over every discount code, pageSize at a time.
+func (c *Client) Discounts(ctx context.Context, pageSize int) iter.Seq2[db.DiscountCode, error] {
+	return all[db.DiscountCode](ctx, c, "/admin/discounts", "discounts", pageSize)
+}
+
+func (c *Client) GetDiscount(ctx context.Context, code string) (db.DiscountCode, error) {
+	var resp discountResponse
+	err := c.call(ctx, http.MethodGet, "/admin/discounts/"+url.PathEscape(code), nil, &resp)
+	return resp.Discount, err
+}
+
+// UpdateDiscount replaces the rules of discount.Code.
+func (c *Client) UpdateDiscount(ctx context.Context, discount db.DiscountCode) error {
+	return c.call(ctx, http.MethodPut, "/admin/discounts/"+url.PathEscape(discount.Code), discount, nil)
+}
+
+func (c *Client) DeleteDiscount(ctx context.Context, code string) error {
+	return c.call(ctx, http.MethodDelete, "/admin/discounts/"+url.PathEscape(code), nil, nil)
+}
+
+// RebuildSearchIndex rebuilds the server's search index from the items table and returns how many items it holds.
+func (c *Client) RebuildSearchIndex(ctx context.Context) (int, error) {
+	var resp struct {
+		Items int `json:"items"`
+	}
+	err := c.call(ctx, http.MethodPost, "/admin/search/rebuild", nil, &resp)
+	return resp.Items, err
+}
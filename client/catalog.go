@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"upgraded-telegram/main.go/server/services/catalog"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/search"
+)
+
+// Categories returns the category tree.
+func (c *Client) Categories(ctx context.Context) ([]catalog.Node, error) {
+	var resp struct {
+		Categories []catalog.Node `json:"categories"`
+	}
+	err := c.call(ctx, http.MethodGet, path("categories"), nil, &resp)
+	return resp.Categories, err
+}
+
+// GetCategory returns a category with its subcategories, and the categories above it from the top level down.
+func (c *Client) GetCategory(ctx context.Context, id string) (catalog.Node, []db.Category, error) {
+	var resp struct {
+		Category catalog.Node  `json:"category"`
+		Path     []db.Category `json:"path"`
+	}
+	err := c.call(ctx, http.MethodGet, path("categories", id), nil, &resp)
+	return resp.Category, resp.Path, err
+}
+
+// CreateCategory creates a category keyed by category.ID, staff only.
+func (c *Client) CreateCategory(ctx context.Context, category db.Category) (db.Category, error) {
+	var resp struct {
+		Category db.Category `json:"category"`
+	}
+	err := c.create(ctx, path("categories"), category, &resp)
+	return resp.Category, err
+}
+
+// UpdateCategory replaces the category with category.ID, staff only.
+func (c *Client) UpdateCategory(ctx context.Context, category db.Category) error {
+	return c.call(ctx, http.MethodPut, path("categories", category.ID), category, nil)
+}
+
+func (c *Client) DeleteCategory(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, path("categories", id), nil, nil)
+}
+
+// ListCategoryItems lists the active items in a category and the categories below it.
+func (c *Client) ListCategoryItems(ctx context.Context, id string, opts ListOptions) (Page[db.Item], error) {
+	return list[db.Item](ctx, c, path("categories", id, "items"), "items", opts)
+}
+
+// CategoryItems iterates over the active items in a category and the categories below it, pageSize at a time.
+func (c *Client) CategoryItems(ctx context.Context, id string, pageSize int) iter.Seq2[db.Item, error] {
+	return all[db.Item](ctx, c, path("categories", id, "items"), "items", pageSize)
+}
+
+// ListTagItems lists the active items with a tag.
+func (c *Client) ListTagItems(ctx context.Context, tag string, opts ListOptions) (Page[db.Item], error) {
+	return list[db.Item](ctx, c, path("tags", tag, "items"), "items", opts)
+}
+
+// TagItems iterates over the active items with a tag, pageSize at a time.
+func (c *Client) TagItems(ctx context.Context, tag string, pageSize int) iter.Seq2[db.Item, error] {
+	return all[db.Item](ctx, c, path("tags", tag, "items"), "items", pageSize)
+}
+
+// SearchOptions filters and orders a search, zero values are left to the server. Prices are in minor units of
+// Currency. Active is "true" unless set, "false" and "any" are staff only.
+type SearchOptions struct {
+	Query    string
+	Currency string
+	MinPrice *int64
+	MaxPrice *int64
+	InStock  *bool
+	Active   string
+	Category string
+	Sort     string // search.Relevance, search.Newest, search.PriceAsc or search.PriceDesc
+	Limit    int    // 20 when zero
+	Cursor   string
+}
+
+// SearchItems returns one page of the items matching opts, with the total and facets of every match.
+func (c *Client) SearchItems(ctx context.Context, opts SearchOptions) (search.Result, error) {
+	query := url.Values{}
+	for name, value := range map[string]string{"q": opts.Query, "currency": opts.Currency, "active": opts.Active, "category": opts.Category, "sort": opts.Sort, "cursor": opts.Cursor} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if opts.MinPrice != nil {
+		query.Set("min_price", strconv.FormatInt(*opts.MinPrice, 10))
+	}
+	if opts.MaxPrice != nil {
+		query.Set("max_price", strconv.FormatInt(*opts.MaxPrice, 10))
+	}
+	if opts.InStock != nil {
+		query.Set("in_stock", strconv.FormatBool(*opts.InStock))
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+
+	var resp search.Result
+	req := &request{method: http.MethodGet, path: path("search", "items"), query: query}
+	err := c.do(ctx, req, &resp)
+	return resp, err
+}
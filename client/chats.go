@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+// CreateChat opens a chat between chat.Users and returns its id.
+func (c *Client) CreateChat(ctx context.Context, chat db.Chat) (string, error) {
+	var resp struct {
+		ID string `json:"chat.id"`
+	}
+	err := c.create(ctx, path("chats"), chat, &resp)
+	return resp.ID, err
+}
+
+func (c *Client) ListChats(ctx context.Context, opts ListOptions) (Page[db.Chat], error) {
+	return list[db.Chat](ctx, c, path("chats"), "chats", opts)
+}
+
+// Chats iterates over every chat, pageSize at a time.
+func (c *Client) Chats(ctx context.Context, pageSize int) iter.Seq2[db.Chat, error] {
+	return all[db.Chat](ctx, c, path("chats"), "chats", pageSize)
+}
+
+func (c *Client) GetChat(ctx context.Context, id string) (db.Chat, error) {
+	var resp struct {
+		Chat db.Chat `json:"chat"`
+	}
+	err := c.call(ctx, http.MethodGet, path("chats", id), nil, &resp)
+	return resp.Chat, err
+}
+
+// UpdateChat updates the chat with chat.ID.
+func (c *Client) UpdateChat(ctx context.Context, chat db.Chat) error {
+	return c.call(ctx, http.MethodPatch, path("chats", chat.ID), chat, nil)
+}
+
+func (c *Client) DeleteChat(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, path("chats", id), nil, nil)
+}
+
+// SendMessage adds a message to a chat and returns the message id.
+func (c *Client) SendMessage(ctx context.Context, chatID string, message db.Message) (string, error) {
+	var resp struct {
+		ID string `json:"message.id"`
+	}
+	err := c.create(ctx, path("chats", chatID, "messages"), message, &resp)
+	return resp.ID, err
+}
+
+func (c *Client) ListMessages(ctx context.Context, chatID string) ([]db.Message, error) {
+	var resp struct {
+		Messages []db.Message `json:"messages"`
+	}
+	err := c.call(ctx, http.MethodGet, path("chats", chatID, "messages"), nil, &resp)
+	return resp.Messages, err
+}
+
+func (c *Client) DeleteMessage(ctx context.Context, chatID, messageID string) error {
+	return c.call(ctx, http.MethodDelete, path("chats", chatID, "messages", messageID), nil, nil)
+}
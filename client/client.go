@@ -0,0 +1,346 @@
+// Package client is a typed Go client for the /v1 API. Request and response bodies reuse the db structs.
+//
+// Every call takes a context. Requests answered with 429 or 503 are retried with backoff, waiting for
+// Retry-After when the server sends it, and an expired access token is refreshed once per call.
+// Create calls send an Idempotency-Key that their retries reuse, so a retry never creates a duplicate.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+)
+
+type Client struct {
+	baseURL       string
+	httpClient    *http.Client
+	maxRetries    int
+	minBackoff    time.Duration
+	maxBackoff    time.Duration
+	maxRetryAfter time.Duration
+
+	mu           sync.Mutex
+	token        string
+	refreshToken string
+
+	// refreshing serializes token refreshes so concurrent calls that all see a 401 refresh only once
+	refreshing sync.Mutex
+}
+
+type Option func(*Client)
+
+// WithHTTPClient replaces http.DefaultClient, for example to set a transport or an overall timeout.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTokens starts the client with tokens from an earlier login.
+func WithTokens(token, refreshToken string) Option {
+	return func(c *Client) {
+		c.token = token
+		c.refreshToken = refreshToken
+	}
+}
+
+// WithRetries sets how often a 429 or 503 is retried and the bounds of the exponential backoff
+// used when the response has no Retry-After. Zero retries disables retrying.
+func WithRetries(maxRetries int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.minBackoff = minBackoff
+		c.maxBackoff = maxBackoff
+	}
+}
+
+// WithMaxRetryAfter sets the longest Retry-After the client waits for, a longer one is returned as an error.
+func WithMaxRetryAfter(d time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetryAfter = d
+	}
+}
+
+// New returns a client for the API at baseURL, for example "http://localhost:8080".
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:       strings.TrimSuffix(baseURL, "/"),
+		httpClient:    http.DefaultClient,
+		maxRetries:    3,
+		minBackoff:    200 * time.Millisecond,
+		maxBackoff:    5 * time.Second,
+		maxRetryAfter: time.Minute,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Tokens returns the current access and refresh tokens, to persist them between runs.
+func (c *Client) Tokens() (string, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token, c.refreshToken
+}
+
+func (c *Client) SetTokens(token, refreshToken string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+	c.refreshToken = refreshToken
+}
+
+// Error is a problem document returned by the API.
+type Error struct {
+	StatusCode int
+	apperrors.Problem
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%d %s", e.StatusCode, e.Code)
+	}
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Code, e.Detail)
+}
+
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        []byte
+	contentType string
+	public      bool   // sent without a token, a 401 is not retried after a refresh
+	idempotency string // Idempotency-Key sent with every attempt of the request
+}
+
+func jsonRequest(method, path string, body any) (*request, error) {
+	req := &request{method: method, path: path}
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		req.body = data
+		req.contentType = "application/json"
+	}
+	return req, nil
+}
+
+// call sends a JSON request and decodes the response into out, which may be nil.
+func (c *Client) call(ctx context.Context, method, path string, body, out any) error {
+	req, err := jsonRequest(method, path, body)
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, req, out)
+}
+
+// create is call for POST requests that create a record, with an Idempotency-Key.
+func (c *Client) create(ctx context.Context, path string, body, out any) error {
+	req, err := jsonRequest(http.MethodPost, path, body)
+	if err != nil {
+		return err
+	}
+	req.idempotency = newIdempotencyKey()
+	return c.do(ctx, req, out)
+}
+
+func (c *Client) do(ctx context.Context, req *request, out any) error {
+	refreshed := false
+	for {
+		token, _ := c.Tokens()
+		resp, err := c.send(ctx, req, token)
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && !req.public && !refreshed {
+			_, refreshToken := c.Tokens()
+			if refreshToken != "" {
+				drain(resp)
+				err = c.refresh(ctx, token)
+				if err != nil {
+					return err
+				}
+				refreshed = true
+				continue
+			}
+		}
+		return decode(resp, out)
+	}
+}
+
+// send performs the request, retrying 429 and 503 responses.
+func (c *Client) send(ctx context.Context, req *request, token string) (*http.Response, error) {
+	target := c.baseURL + req.path
+	if len(req.query) > 0 {
+		target += "?" + req.query.Encode()
+	}
+
+	for attempt := 0; ; attempt++ {
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, target, bytes.NewReader(req.body))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Accept", "application/json")
+		if req.contentType != "" {
+			httpReq.Header.Set("Content-Type", req.contentType)
+		}
+		if !req.public && token != "" {
+			httpReq.Header.Set("Authorization", "Bearer "+token)
+		}
+		if req.idempotency != "" {
+			httpReq.Header.Set("Idempotency-Key", req.idempotency)
+		}
+
+		resp, err := c.httpClient.Do(httpReq)
+		if err != nil {
+			return nil, err
+		}
+		if !retryable(req, resp) {
+			return resp, nil
+		}
+		if attempt >= c.maxRetries {
+			return resp, nil
+		}
+
+		wait, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		if !ok {
+			wait = c.backoff(attempt)
+		} else if wait > c.maxRetryAfter {
+			return resp, nil
+		}
+		drain(resp)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// retryable reports whether the request may be sent again: on 429 and 503, and on the 409 with Retry-After
+// that the server returns while an earlier attempt with the same Idempotency-Key is still being handled.
+func retryable(req *request, resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusConflict:
+		return req.idempotency != "" && resp.Header.Get("Retry-After") != ""
+	}
+	return false
+}
+
+// newIdempotencyKey identifies one create call.
+func newIdempotencyKey() string {
+	return fmt.Sprintf("%016x%016x", rand.Uint64(), rand.Uint64())
+}
+
+// backoff doubles from minBackoff up to maxBackoff, randomized over its upper half so clients spread out.
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.maxBackoff
+	if attempt < 30 && c.minBackoff<<attempt < c.maxBackoff {
+		d = c.minBackoff << attempt
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// retryAfter parses either form of the Retry-After header, delay-seconds or an HTTP date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// refresh exchanges the refresh token for a new pair unless another call already replaced the stale access token.
+func (c *Client) refresh(ctx context.Context, stale string) error {
+	c.refreshing.Lock()
+	defer c.refreshing.Unlock()
+
+	token, refreshToken := c.Tokens()
+	if token != stale {
+		return nil
+	}
+
+	req, err := jsonRequest(http.MethodPost, "/v1/auth/refresh", map[string]string{"refresh_token": refreshToken})
+	if err != nil {
+		return err
+	}
+	req.public = true
+
+	var resp struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	err = c.do(ctx, req, &resp)
+	if err != nil {
+		return err
+	}
+	c.SetTokens(resp.Token, resp.RefreshToken)
+	return nil
+}
+
+// decode closes the response and turns an error status into *Error. A *string out receives the raw body.
+func decode(resp *http.Response, out any) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(body, &apiErr.Problem) != nil || apiErr.Code == "" {
+			apiErr.Problem = apperrors.Problem{Status: resp.StatusCode, Title: http.StatusText(resp.StatusCode), Detail: strings.TrimSpace(string(body))}
+		}
+		return apiErr
+	}
+
+	switch out := out.(type) {
+	case nil:
+		_, err := io.Copy(io.Discard, resp.Body)
+		return err
+	case *string:
+		body, err := io.ReadAll(resp.Body)
+		*out = string(body)
+		return err
+	default:
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+}
+
+// drain lets the connection be reused before the request is sent again.
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+}
+
+func path(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		escaped[i] = url.PathEscape(segment)
+	}
+	return "/v1/" + strings.Join(escaped, "/")
+}
@@ -0,0 +1,271 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"upgraded-telegram/main.go/client"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func TestRefreshesAnExpiredTokenOnce(t *testing.T) {
+	var refreshes atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			RefreshToken string `json:"refresh_token"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		if body.RefreshToken != "refresh-1" || r.Header.Get("Authorization") != "" {
+			apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "bad refresh"))
+			return
+		}
+		refreshes.Add(1)
+		writeJSON(w, map[string]string{"token": "access-2", "refresh_token": "refresh-2"})
+	})
+	mux.HandleFunc("GET /v1/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access-2" {
+			apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "expired"))
+			return
+		}
+		writeJSON(w, map[string]any{"item": db.Item{ID: r.PathValue("id")}})
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c := client.New(srv.URL, client.WithTokens("access-1", "refresh-1"))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			item, err := c.GetItem(context.Background(), "a")
+			if err != nil || item.ID != "a" {
+				t.Errorf("got %+v, %v", item, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := refreshes.Load(); n != 1 {
+		t.Fatalf("refreshed %d times, want once", n)
+	}
+	if token, refreshToken := c.Tokens(); token != "access-2" || refreshToken != "refresh-2" {
+		t.Fatalf("tokens are %q, %q", token, refreshToken)
+	}
+}
+
+func TestFailedRefreshReturnsTheError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "expired"))
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, client.WithTokens("access-1", "refresh-1"))
+	_, err := c.GetItem(context.Background(), "a")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Code != "invalid_token" {
+		t.Fatalf("got %v, want a 401 invalid_token", err)
+	}
+}
+
+func TestRetriesAfterRetryAfter(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusServiceUnavailable} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					w.Header().Set("Retry-After", "1")
+					w.WriteHeader(status)
+					return
+				}
+				writeJSON(w, map[string]any{"item": db.Item{ID: "a"}})
+			}))
+			defer srv.Close()
+
+			// the backoff is far shorter than Retry-After, so waiting a second means the header was honored
+			c := client.New(srv.URL, client.WithRetries(3, time.Millisecond, time.Millisecond))
+			start := time.Now()
+			_, err := c.GetItem(context.Background(), "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if calls.Load() != 2 {
+				t.Fatalf("%d calls, want 2", calls.Load())
+			}
+			if elapsed := time.Since(start); elapsed < time.Second {
+				t.Fatalf("retried after %v, before Retry-After", elapsed)
+			}
+		})
+	}
+}
+
+func TestGivesUpAfterMaxRetries(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		apperrors.Write(w, r, apperrors.TooManyRequests("rate_limited", "slow down"))
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, client.WithRetries(2, time.Millisecond, time.Millisecond))
+	_, err := c.GetItem(context.Background(), "a")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("got %v, want a 429", err)
+	}
+	if calls.Load() != 3 {
+		t.Fatalf("%d calls, want 3", calls.Load())
+	}
+}
+
+func TestDoesNotWaitForALongRetryAfter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		apperrors.Write(w, r, apperrors.Unavailable("draining", "shutting down"))
+	}))
+	defer srv.Close()
+
+	c := client.New(srv.URL, client.WithMaxRetryAfter(time.Second))
+	start := time.Now()
+	_, err := c.GetItem(context.Background(), "a")
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("got %v, want a 503", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("waited for a Retry-After above the maximum")
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	t.Run("while waiting to retry", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := client.New(srv.URL).GetItem(ctx, "a")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, want the context's error", err)
+		}
+	})
+
+	t.Run("while the request is in flight", func(t *testing.T) {
+		release := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		}))
+		defer srv.Close()
+		defer close(release)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(50*time.Millisecond, cancel)
+		_, err := client.New(srv.URL).GetItem(ctx, "a")
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("got %v, want context.Canceled", err)
+		}
+	})
+}
+
+// pagedItems serves ids as the items collection, paginated like the server with the last id as the cursor.
+func pagedItems(ids []string, requests *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		start := 0
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			for i, id := range ids {
+				if id == cursor {
+					start = i + 1
+				}
+			}
+		}
+		end := min(start+limit, len(ids))
+		items := []db.Item{}
+		for _, id := range ids[start:end] {
+			items = append(items, db.Item{ID: id})
+		}
+		response := map[string]any{"items": items}
+		if end < len(ids) {
+			response["next_cursor"] = ids[end-1]
+		}
+		writeJSON(w, response)
+	}))
+}
+
+func TestItemsIteratesEveryPage(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	var requests atomic.Int32
+	srv := pagedItems(ids, &requests)
+	defer srv.Close()
+
+	var got []string
+	for item, err := range client.New(srv.URL).Items(context.Background(), 2) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, item.ID)
+	}
+	if !slices.Equal(got, ids) {
+		t.Fatalf("got %v, want %v", got, ids)
+	}
+	if requests.Load() != 3 {
+		t.Fatalf("%d requests, want 3 pages", requests.Load())
+	}
+}
+
+func TestItemsStopsFetchingWhenTheLoopBreaks(t *testing.T) {
+	var requests atomic.Int32
+	srv := pagedItems([]string{"a", "b", "c", "d", "e"}, &requests)
+	defer srv.Close()
+
+	for item := range client.New(srv.URL).Items(context.Background(), 2) {
+		if item.ID == "b" {
+			break
+		}
+	}
+	if requests.Load() != 1 {
+		t.Fatalf("%d requests, want 1", requests.Load())
+	}
+}
+
+func TestItemsYieldsTheErrorAndStops(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		apperrors.Write(w, r, apperrors.Forbidden("forbidden", "no"))
+	}))
+	defer srv.Close()
+
+	yields := 0
+	for _, err := range client.New(srv.URL).Items(context.Background(), 2) {
+		yields++
+		var apiErr *client.Error
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+			t.Fatalf("got %v, want a 403", err)
+		}
+	}
+	if yields != 1 {
+		t.Fatalf("%d yields, want 1", yields)
+	}
+}
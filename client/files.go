@@ -0,0 +1,44 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// UploadFile stores content under filename and returns the object URL.
+// The content is buffered so that the upload can be retried.
+func (c *Client) UploadFile(ctx context.Context, filename string, content io.Reader) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filename)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(part, content)
+	if err != nil {
+		return "", err
+	}
+	err = form.Close()
+	if err != nil {
+		return "", err
+	}
+
+	req := &request{method: http.MethodPost, path: path("files"), body: body.Bytes(), contentType: form.FormDataContentType()}
+	var resp string
+	err = c.do(ctx, req, &resp)
+	return strings.TrimPrefix(resp, "File uploaded successfully: "), err
+}
+
+// DownloadURL returns a presigned URL for filename, which may contain slashes.
+func (c *Client) DownloadURL(ctx context.Context, filename string) (string, error) {
+	segments := append([]string{"files"}, strings.Split(filename, "/")...)
+	var resp struct {
+		URL string `json:"downloadUrl"`
+	}
+	err := c.call(ctx, http.MethodGet, path(segments...), nil, &resp)
+	return resp.URL, err
+}
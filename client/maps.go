@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"googlemaps.github.io/maps"
+)
+
+// the server returns the Google Maps results unchanged under "route"
+
+func (c *Client) mapsCall(ctx context.Context, endpoint string, query url.Values, out any) error {
+	req := &request{method: http.MethodGet, path: path("maps", endpoint), query: query}
+	resp := struct {
+		Route any `json:"route"`
+	}{Route: out}
+	return c.do(ctx, req, &resp)
+}
+
+func (c *Client) Directions(ctx context.Context, origin, destination string) ([]maps.Route, error) {
+	var routes []maps.Route
+	err := c.mapsCall(ctx, "directions", url.Values{"origin": {origin}, "destination": {destination}}, &routes)
+	return routes, err
+}
+
+func (c *Client) Geocode(ctx context.Context, address string) ([]maps.GeocodingResult, error) {
+	var results []maps.GeocodingResult
+	err := c.mapsCall(ctx, "geocode", url.Values{"address": {address}}, &results)
+	return results, err
+}
+
+func (c *Client) ReverseGeocode(ctx context.Context, lat, long float64) ([]maps.GeocodingResult, error) {
+	var results []maps.GeocodingResult
+	query := url.Values{
+		"lat":  {strconv.FormatFloat(lat, 'f', -1, 64)},
+		"long": {strconv.FormatFloat(long, 'f', -1, 64)},
+	}
+	err := c.mapsCall(ctx, "reverse-geocode", query, &results)
+	return results, err
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// DefaultPageSize is used by the iterators when no page size is given.
+const DefaultPageSize = 100
+
+type ListOptions struct {
+	Limit  int    // page size up to 1000, the server returns the whole collection when zero
+	Cursor string // NextCursor of the previous page
+}
+
+type Page[T any] struct {
+	Items      []T
+	NextCursor string // empty on the last page
+}
+
+// list fetches one page of a collection whose items are returned under key.
+func list[T any](ctx context.Context, c *Client, path, key string, opts ListOptions) (Page[T], error) {
+	req := &request{method: http.MethodGet, path: path, query: url.Values{}}
+	if opts.Limit > 0 {
+		req.query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		req.query.Set("cursor", opts.Cursor)
+	}
+
+	var resp map[string]json.RawMessage
+	err := c.do(ctx, req, &resp)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	var page Page[T]
+	if raw, ok := resp[key]; ok {
+		err = json.Unmarshal(raw, &page.Items)
+		if err != nil {
+			return Page[T]{}, err
+		}
+	}
+	if raw, ok := resp["next_cursor"]; ok {
+		err = json.Unmarshal(raw, &page.NextCursor)
+		if err != nil {
+			return Page[T]{}, err
+		}
+	}
+	return page, nil
+}
+
+// all iterates over every item of a collection, fetching pageSize items at a time.
+// Iteration stops after the first error, which is yielded with a zero item.
+func all[T any](ctx context.Context, c *Client, path, key string, pageSize int) iter.Seq2[T, error] {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+	return func(yield func(T, error) bool) {
+		opts := ListOptions{Limit: pageSize}
+		for {
+			page, err := list[T](ctx, c, path, key, opts)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range page.Items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if page.NextCursor == "" {
+				return
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+}
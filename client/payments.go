@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+type paymentResponse struct {
+	Payment db.Payment `json:"payment"`
+}
+
+// CreatePayment starts paying an order and returns the payment with the client secret for confirming it.
+// With manualCapture confirming only authorizes the amount until CapturePayment.
+func (c *Client) CreatePayment(ctx context.Context, orderID string, manualCapture bool) (db.Payment, string, error) {
+	body := map[string]string{"capture_method": "automatic"}
+	if manualCapture {
+		body["capture_method"] = "manual"
+	}
+	var resp struct {
+		Payment      db.Payment `json:"payment"`
+		ClientSecret string     `json:"client_secret"`
+	}
+	err := c.create(ctx, path("orders", orderID, "payments"), body, &resp)
+	return resp.Payment, resp.ClientSecret, err
+}
+
+func (c *Client) GetPayment(ctx context.Context, id string) (db.Payment, error) {
+	var resp paymentResponse
+	err := c.call(ctx, http.MethodGet, path("payments", id), nil, &resp)
+	return resp.Payment, err
+}
+
+// ConfirmPayment charges paymentMethod. The order becomes paid or failed once the payment settles.
+func (c *Client) ConfirmPayment(ctx context.Context, id, paymentMethod string) (db.Payment, error) {
+	var resp paymentResponse
+	err := c.call(ctx, http.MethodPost, path("payments", id, "confirm"), map[string]string{"payment_method": paymentMethod}, &resp)
+	return resp.Payment, err
+}
+
+// CapturePayment charges an authorized payment, staff only.
+func (c *Client) CapturePayment(ctx context.Context, id string) (db.Payment, error) {
+	var resp paymentResponse
+	err := c.call(ctx, http.MethodPost, path("payments", id, "capture"), nil, &resp)
+	return resp.Payment, err
+}
+
+// RefundPayment refunds amount of a payment, everything left when amount is zero. Admins only.
+func (c *Client) RefundPayment(ctx context.Context, id string, amount int64, reason string) (db.Refund, error) {
+	var resp struct {
+		Refund db.Refund `json:"refund"`
+	}
+	err := c.create(ctx, path("payments", id, "refunds"), map[string]any{"amount": amount, "reason": reason}, &resp)
+	return resp.Refund, err
+}
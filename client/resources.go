@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"net/http"
+	"net/url"
+
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// events, items and orders share the same routes and response envelopes
+
+func create(ctx context.Context, c *Client, collection, name string, v any) (string, error) {
+	var resp map[string]any
+	err := c.create(ctx, path(collection), v, &resp)
+	id, _ := resp[name+".id"].(string)
+	return id, err
+}
+
+func get[T any](ctx context.Context, c *Client, collection, name, id string) (T, error) {
+	var resp map[string]json.RawMessage
+	var v T
+	err := c.call(ctx, http.MethodGet, path(collection, id), nil, &resp)
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(resp[name], &v)
+	return v, err
+}
+
+// CreateEvent creates an event and returns its id.
+func (c *Client) CreateEvent(ctx context.Context, event db.Event) (string, error) {
+	return create(ctx, c, "events", "event", event)
+}
+
+func (c *Client) ListEvents(ctx context.Context, opts ListOptions) (Page[db.Event], error) {
+	return list[db.Event](ctx, c, path("events"), "events", opts)
+}
+
+// Events iterates over every event, pageSize at a time.
+func (c *Client) Events(ctx context.Context, pageSize int) iter.Seq2[db.Event, error] {
+	return all[db.Event](ctx, c, path("events"), "events", pageSize)
+}
+
+func (c *Client) GetEvent(ctx context.Context, id string) (db.Event, error) {
+	return get[db.Event](ctx, c, "events", "event", id)
+}
+
+// UpdateEvent updates the event with event.ID.
+func (c *Client) UpdateEvent(ctx context.Context, event db.Event) error {
+	return c.call(ctx, http.MethodPatch, path("events", event.ID), event, nil)
+}
+
+func (c *Client) DeleteEvent(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, path("events", id), nil, nil)
+}
+
+// CreateItem creates a catalog item and returns its id.
+func (c *Client) CreateItem(ctx context.Context, item db.Item) (string, error) {
+	return create(ctx, c, "items", "item", item)
+}
+
+func (c *Client) ListItems(ctx context.Context, opts ListOptions) (Page[db.Item], error) {
+	return list[db.Item](ctx, c, path("items"), "items", opts)
+}
+
+// Items iterates over every item, pageSize at a time.
+func (c *Client) Items(ctx context.Context, pageSize int) iter.Seq2[db.Item, error] {
+	return all[db.Item](ctx, c, path("items"), "items", pageSize)
+}
+
+func (c *Client) GetItem(ctx context.Context, id string) (db.Item, error) {
+	return get[db.Item](ctx, c, "items", "item", id)
+}
+
+// UpdateItem updates the item with item.ID.
+func (c *Client) UpdateItem(ctx context.Context, item db.Item) error {
+	return c.call(ctx, http.MethodPatch, path("items", item.ID), item, nil)
+}
+
+func (c *Client) DeleteItem(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, path("items", id), nil, nil)
+}
+
+// CreateOrder places an order, reserving the stock of every line, and returns its id.
+func (c *Client) CreateOrder(ctx context.Context, order db.Order) (string, error) {
+	return create(ctx, c, "orders", "order", order)
+}
+
+func (c *Client) ListOrders(ctx context.Context, opts ListOptions) (Page[db.Order], error) {
+	return list[db.Order](ctx, c, path("orders"), "orders", opts)
+}
+
+// Orders iterates over the caller's orders, or every order for staff, pageSize at a time.
+func (c *Client) Orders(ctx context.Context, pageSize int) iter.Seq2[db.Order, error] {
+	return all[db.Order](ctx, c, path("orders"), "orders", pageSize)
+}
+
+func (c *Client) GetOrder(ctx context.Context, id string) (db.Order, error) {
+	return get[db.Order](ctx, c, "orders", "order", id)
+}
+
+// ExchangeRates returns the rates the server converts prices with when an order is in another currency.
+func (c *Client) ExchangeRates(ctx context.Context) (money.Rates, error) {
+	var resp struct {
+		ExchangeRates money.Rates `json:"exchange_rates"`
+	}
+	err := c.call(ctx, http.MethodGet, path("exchange-rates"), nil, &resp)
+	return resp.ExchangeRates, err
+}
+
+// UpdateOrder updates the order with order.ID.
+func (c *Client) UpdateOrder(ctx context.Context, order db.Order) error {
+	return c.call(ctx, http.MethodPatch, path("orders", order.ID), order, nil)
+}
+
+// DeleteOrder deletes a canceled or refunded order, staff only.
+func (c *Client) DeleteOrder(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, path("orders", id), nil, nil)
+}
+
+// TransitionOrder moves an order to status, reason is recorded in its history.
+func (c *Client) TransitionOrder(ctx context.Context, id, status, reason string) (db.Order, error) {
+	var resp struct {
+		Order db.Order `json:"order"`
+	}
+	err := c.call(ctx, http.MethodPost, path("orders", id, "status"), map[string]string{"status": status, "reason": reason}, &resp)
+	return resp.Order, err
+}
+
+// CancelOrder cancels an order, its items go back to stock.
+func (c *Client) CancelOrder(ctx context.Context, id, reason string) (db.Order, error) {
+	req := &request{method: http.MethodPost, path: path("orders", id, "cancel"), query: url.Values{}}
+	if reason != "" {
+		req.query.Set("reason", reason)
+	}
+
+	var resp struct {
+		Order db.Order `json:"order"`
+	}
+	err := c.do(ctx, req, &resp)
+	return resp.Order, err
+}
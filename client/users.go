@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+// SignUp creates a user and returns its id. It does not log in.
+func (c *Client) SignUp(ctx context.Context, user db.User) (string, error) {
+	req, err := jsonRequest(http.MethodPost, path("users"), user)
+	if err != nil {
+		return "", err
+	}
+	req.public = true
+	req.idempotency = newIdempotencyKey()
+
+	var resp struct {
+		ID string `json:"user.id"`
+	}
+	err = c.do(ctx, req, &resp)
+	return resp.ID, err
+}
+
+// Login authenticates the client, later calls use and refresh the returned tokens.
+func (c *Client) Login(ctx context.Context, email, password string) (db.User, error) {
+	req, err := jsonRequest(http.MethodPost, path("auth", "login"), map[string]string{"email": email, "password": password})
+	if err != nil {
+		return db.User{}, err
+	}
+	req.public = true
+
+	var resp struct {
+		Token        string  `json:"token"`
+		RefreshToken string  `json:"refresh_token"`
+		User         db.User `json:"user"`
+	}
+	err = c.do(ctx, req, &resp)
+	if err != nil {
+		return db.User{}, err
+	}
+	c.SetTokens(resp.Token, resp.RefreshToken)
+	return resp.User, nil
+}
+
+// Refresh replaces the token pair now instead of waiting for the access token to be rejected.
+func (c *Client) Refresh(ctx context.Context) error {
+	token, _ := c.Tokens()
+	return c.refresh(ctx, token)
+}
+
+func (c *Client) ListUsers(ctx context.Context, opts ListOptions) (Page[db.User], error) {
+	return list[db.User](ctx, c, path("users"), "users", opts)
+}
+
+// Users iterates over every user, pageSize at a time.
+func (c *Client) Users(ctx context.Context, pageSize int) iter.Seq2[db.User, error] {
+	return all[db.User](ctx, c, path("users"), "users", pageSize)
+}
+
+func (c *Client) GetUser(ctx context.Context, id string) (db.User, error) {
+	var resp struct {
+		User db.User `json:"user"`
+	}
+	err := c.call(ctx, http.MethodGet, path("users", id), nil, &resp)
+	return resp.User, err
+}
+
+// UpdateUser updates the user with user.ID. Use UpdatePassword to change the password.
+func (c *Client) UpdateUser(ctx context.Context, user db.User) error {
+	return c.call(ctx, http.MethodPatch, path("users", user.ID), user, nil)
+}
+
+// UpdatePassword changes the password of the user with id. Changing your own takes currentPassword, an admin
+// changing another user's leaves it empty.
+func (c *Client) UpdatePassword(ctx context.Context, id, currentPassword, password string) error {
+	body := map[string]string{"password": password}
+	if currentPassword != "" {
+		body["current_password"] = currentPassword
+	}
+	return c.call(ctx, http.MethodPut, path("users", id, "password"), body, nil)
+}
+
+func (c *Client) DeleteUser(ctx context.Context, id string) error {
+	return c.call(ctx, http.MethodDelete, path("users", id), nil, nil)
+}
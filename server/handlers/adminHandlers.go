@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/logging"
+	"upgraded-telegram/main.go/server/services/validation"
+)
+
+// the log level is managed by admins only
+
+func GetLogLevel(w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("log_level_forbidden", "Only admins can manage the log level."))
+		return
+	}
+
+	writeLogLevel(w, r)
+}
+
+// change the log level without restarting the server
+func SetLogLevel(w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("log_level_forbidden", "Only admins can manage the log level."))
+		return
+	}
+
+	var req struct {
+		Level string `json:"level" validate:"required,oneof=debug info warn error DEBUG INFO WARN ERROR"`
+	}
+	err := validation.Decode(r, &req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validation.Create(req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = logging.SetLevel(req.Level)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("invalid_level", "Unknown log level."))
+		return
+	}
+
+	writeLogLevel(w, r)
+}
+
+func writeLogLevel(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{
+		"level": logging.Level.Level().String(),
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
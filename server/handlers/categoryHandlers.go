@@ -0,0 +1,481 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/catalog"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/validation"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// categories are browsed by every signed in user and managed by staff and admins
+
+func CreateCategory(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("catalog_forbidden", "Only staff and admins can manage categories."))
+		return
+	}
+
+	var category db.Category
+	err := validation.Decode(r, &category)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = checkCategory(r.Context(), client, category, true)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	category.CreatedAt = time.Now().UnixMilli()
+	category.UpdatedAt = category.CreatedAt
+
+	item, err := attributevalue.MarshalMap(category)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode category.", err))
+		return
+	}
+
+	err = db.CreateCategory(r.Context(), client, db.Tables.Categories, item)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":  "Category created!",
+		"category": category,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+// GetCategoryTree returns every category, nested under its parent.
+func GetCategoryTree(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	categories, err := allCategories(r.Context(), client)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":    "Got categories!",
+		"categories": catalog.Tree(categories),
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// GetCategory returns a category with its subcategories and the path of categories above it.
+func GetCategory(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	categories, err := allCategories(r.Context(), client)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	node, ok := findNode(catalog.Tree(categories), id)
+	if !ok {
+		apperrors.Write(w, r, categoryNotFound(id))
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":  "Got category!",
+		"category": node,
+		"path":     catalog.Ancestors(categories, id),
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// UpdateCategory replaces a category, it can be moved under another parent but not below itself.
+func UpdateCategory(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("catalog_forbidden", "Only staff and admins can manage categories."))
+		return
+	}
+
+	var category db.Category
+	err := validation.Decode(r, &category)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = useRouteID(id, &category.ID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = checkCategory(r.Context(), client, category, false)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = db.UpdateCategory(r.Context(), client, db.Tables.Categories, category)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	message := `{"message": "Category updated"}`
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(message))
+}
+
+// DeleteCategory removes a category without subcategories. Items keep its id until they are next updated, and no
+// longer show up under it.
+func DeleteCategory(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("catalog_forbidden", "Only staff and admins can manage categories."))
+		return
+	}
+
+	categories, err := allCategories(r.Context(), client)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	for _, c := range categories {
+		if c.Parent == id {
+			apperrors.Write(w, r, apperrors.Conflict("category_has_children", fmt.Sprintf("Category %s has subcategories, move or delete them first.", id)))
+			return
+		}
+	}
+
+	err = db.DeleteCategory(r.Context(), client, db.Tables.Categories, id)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	message := `{"message": "Category deleted"}`
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(message))
+}
+
+// GetCategoryItems lists the active items in a category or any category below it.
+func GetCategoryItems(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	page, err := pageFromQuery(r)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	categories, err := allCategories(r.Context(), client)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	if _, ok := findNode(catalog.Tree(categories), id); !ok {
+		apperrors.Write(w, r, categoryNotFound(id))
+		return
+	}
+
+	resp, nextCursor, err := db.GetItemsInCategories(r.Context(), client, db.Tables.Items, catalog.Descendants(categories, id), page)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	var items []db.Item
+	err = attributevalue.UnmarshalListOfMaps(resp, &items)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode items.", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Got items!",
+		"items":   items,
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// GetTagItems lists the active items with a tag.
+func GetTagItems(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, tag string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	page, err := pageFromQuery(r)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	resp, nextCursor, err := db.GetItemsWithTag(r.Context(), client, db.Tables.Items, catalog.Tag(tag), page)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	var items []db.Item
+	err = attributevalue.UnmarshalListOfMaps(resp, &items)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode items.", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Got items!",
+		"items":   items,
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// checkCategory validates a category as a whole, on create its id must be a valid slug.
+func checkCategory(ctx context.Context, client *dynamodb.Client, category db.Category, create bool) error {
+	err := validation.Create(category)
+	if err != nil {
+		return err
+	}
+	if create && !catalog.ValidSlug(category.ID) {
+		return apperrors.InvalidFields([]apperrors.FieldError{{Field: "id", Code: "invalid_slug", Message: "must be lower case letters, digits and single dashes"}})
+	}
+	if category.Parent == "" {
+		return nil
+	}
+	categories, err := allCategories(ctx, client)
+	if err != nil {
+		return err
+	}
+	return catalog.CheckParent(categories, category.ID, category.Parent)
+}
+
+// checkCategories reports the categories of an item that do not exist.
+func checkCategories(ctx context.Context, client *dynamodb.Client, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	resp, err := db.GetItemsByIds(ctx, client, db.Tables.Categories, ids)
+	if err != nil {
+		return err
+	}
+	var found []db.Category
+	err = attributevalue.UnmarshalListOfMaps(resp, &found)
+	if err != nil {
+		return apperrors.Internal("decode_failed", "Failed to decode categories.", err)
+	}
+	exists := map[string]bool{}
+	for _, c := range found {
+		exists[c.ID] = true
+	}
+	var fields []apperrors.FieldError
+	for i, id := range ids {
+		if !exists[id] {
+			fields = append(fields, apperrors.FieldError{Field: fmt.Sprintf("categories[%d]", i), Code: "category_not_found", Message: fmt.Sprintf("category %s does not exist", id)})
+		}
+	}
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+func allCategories(ctx context.Context, client *dynamodb.Client) ([]db.Category, error) {
+	resp, err := db.GetAllCategories(ctx, client, db.Tables.Categories)
+	if err != nil {
+		return nil, err
+	}
+	var categories []db.Category
+	err = attributevalue.UnmarshalListOfMaps(resp, &categories)
+	if err != nil {
+		return nil, apperrors.Internal("decode_failed", "Failed to decode categories.", err)
+	}
+	return categories, nil
+}
+
+func findNode(nodes []catalog.Node, id string) (catalog.Node, bool) {
+	for _, node := range nodes {
+		if node.ID == id {
+			return node, true
+		}
+		if found, ok := findNode(node.Children, id); ok {
+			return found, true
+		}
+	}
+	return catalog.Node{}, false
+}
+
+func categoryNotFound(id string) error {
+	return apperrors.NotFound("category_not_found", fmt.Sprintf("No category found with id %s.", id))
+}
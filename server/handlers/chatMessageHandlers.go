@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
 	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/validation"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -18,48 +20,44 @@ import (
 
 func CreateChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	var chat db.Chat
-	err := json.NewDecoder(r.Body).Decode(&chat)
+	err := validation.Decode(r, &chat)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	id, err := uuid.NewV1()
+	err = validation.Create(chat)
 	if err != nil {
-		http.Error(w, `{"error": "Error generating user id"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	chatId := fmt.Sprintf("c_%s", id)
-
-	if len(chat.Users) == 0 {
-		http.Error(w, `{"error": "Users array must not be empty"}`, http.StatusInternalServerError)
+	id, err := uuid.NewV1()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("id_generation_failed", "Error generating id.", err))
 		return
 	}
 
+	chatId := fmt.Sprintf("c_%s", id)
+
 	newChat := map[string]types.AttributeValue{
 		"id":     &types.AttributeValueMemberS{Value: chatId},
 		"users":  &types.AttributeValueMemberSS{Value: chat.Users},
@@ -70,9 +68,9 @@ func CreateChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 		newChat["messages"] = &types.AttributeValueMemberSS{Value: chat.Messages}
 	}
 
-	err = db.CreateChat(client, "chats", newChat)
+	err = db.CreateChat(r.Context(), client, db.Tables.Chats, newChat)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -83,7 +81,7 @@ func CreateChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -94,38 +92,39 @@ func CreateChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 
 func CreateChatMessage(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, chatId string) {
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	var message db.Message
-	err := json.NewDecoder(r.Body).Decode(&message)
+	err := validation.Decode(r, &message)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
+	err = validation.Create(message)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
 	id, err := uuid.NewV1()
 	if err != nil {
-		http.Error(w, `{"error": "Error generating user id"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("id_generation_failed", "Error generating id.", err))
 		return
 	}
 
@@ -145,29 +144,29 @@ func CreateChatMessage(client *dynamodb.Client, w http.ResponseWriter, r *http.R
 		newMessage["media"] = &types.AttributeValueMemberSS{Value: message.Media}
 	}
 
-	err = db.CreateMessage(client, "messages", newMessage)
+	err = db.CreateMessage(r.Context(), client, db.Tables.Messages, newMessage)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	resp, err := db.GetUserById(client, "chats", chatId)
+	resp, err := db.GetChatById(r.Context(), client, db.Tables.Chats, chatId)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get all users"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var chat db.Chat
 	err = attributevalue.UnmarshalMap(resp, &chat)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode chat"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode chat.", err))
 		return
 	}
 
 	chat.Messages = append(chat.Messages, messageId)
-	err = db.UpdateChat(client, "chats", chat)
+	err = db.UpdateChat(r.Context(), client, db.Tables.Chats, chat)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -179,7 +178,7 @@ func CreateChatMessage(client *dynamodb.Client, w http.ResponseWriter, r *http.R
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -190,37 +189,32 @@ func CreateChatMessage(client *dynamodb.Client, w http.ResponseWriter, r *http.R
 
 func GetChatById(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	resp, err := db.GetChatById(client, "chats", id)
+	resp, err := db.GetChatById(r.Context(), client, db.Tables.Chats, id)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get chat"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var chat db.Chat
 	err = attributevalue.UnmarshalMap(resp, &chat)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode chat"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode chat.", err))
 		return
 	}
 
@@ -231,7 +225,7 @@ func GetChatById(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -242,37 +236,38 @@ func GetChatById(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 func GetAllChats(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	page, err := pageFromQuery(r)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	resp, err := db.GetAllChats(client, "chats")
+	resp, nextCursor, err := db.GetAllChats(r.Context(), client, db.Tables.Chats, page)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get all chats"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var chats []db.Chat
 	err = attributevalue.UnmarshalListOfMaps(resp, &chats)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode chats"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode chats.", err))
 		return
 	}
 
@@ -280,10 +275,13 @@ func GetAllChats(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 		"message": "Got chat messages!",
 		"chats":   chats,
 	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -294,52 +292,47 @@ func GetAllChats(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 func GetChatMessages(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, chatId string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	resp, err := db.GetChatById(client, "chats", chatId)
+	resp, err := db.GetChatById(r.Context(), client, db.Tables.Chats, chatId)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get chat"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var chat db.Chat
 	err = attributevalue.UnmarshalMap(resp, &chat)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode chat"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode chat.", err))
 		return
 	}
 
 	var messages []db.Message
 	for _, messageId := range chat.Messages {
-		resp, err := db.GetMessageById(client, "messages", messageId)
+		resp, err := db.GetMessageById(r.Context(), client, db.Tables.Messages, messageId)
 		if err != nil {
-			http.Error(w, `{"error": "Failed to get message"}`, http.StatusInternalServerError)
+			apperrors.Write(w, r, err)
 			return
 		}
 
 		var message db.Message
 		err = attributevalue.UnmarshalMap(resp, &message)
 		if err != nil {
-			http.Error(w, `{"error": "Failed to decode message"}`, http.StatusInternalServerError)
+			apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode message.", err))
 			return
 		}
 
@@ -353,7 +346,7 @@ func GetChatMessages(client *dynamodb.Client, w http.ResponseWriter, r *http.Req
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -362,40 +355,47 @@ func GetChatMessages(client *dynamodb.Client, w http.ResponseWriter, r *http.Req
 	w.Write(jsonResponse)
 }
 
-func UpdateChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
-
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func UpdateChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	var chat db.Chat
-	err := json.NewDecoder(r.Body).Decode(&chat)
+	err := validation.Decode(r, &chat)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	err = db.UpdateChat(client, "chats", chat)
+	err = useRouteID(id, &chat.ID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = validation.Update(chat)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = db.UpdateChat(r.Context(), client, db.Tables.Chats, chat)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -407,30 +407,25 @@ func UpdateChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 
 func DeleteChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	err := db.DeleteChat(client, "chats", id)
+	err := db.DeleteChat(r.Context(), client, db.Tables.Chats, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -442,43 +437,38 @@ func DeleteChat(client *dynamodb.Client, w http.ResponseWriter, r *http.Request,
 
 func DeleteChatMessage(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, chatId, messageId string) {
 
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	err := db.DeleteMessage(client, "messages", messageId)
+	err := db.DeleteMessage(r.Context(), client, db.Tables.Messages, messageId)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	resp, err := db.GetChatById(client, "chats", chatId)
+	resp, err := db.GetChatById(r.Context(), client, db.Tables.Chats, chatId)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get chat"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var chat db.Chat
 	err = attributevalue.UnmarshalMap(resp, &chat)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode chat"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode chat.", err))
 		return
 	}
 
@@ -490,9 +480,9 @@ func DeleteChatMessage(client *dynamodb.Client, w http.ResponseWriter, r *http.R
 	}
 
 	chat.Messages = newMessages
-	err = db.UpdateChat(client, "chats", chat)
+	err = db.UpdateChat(r.Context(), client, db.Tables.Chats, chat)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+// useRouteID puts the ID from the URL into a decoded update payload. Legacy routes pass an empty
+// routeID and keep the ID sent in the body.
+func useRouteID(routeID string, bodyID *string) error {
+	if routeID == "" {
+		return nil
+	}
+	if *bodyID != "" && *bodyID != routeID {
+		return apperrors.InvalidFields([]apperrors.FieldError{{
+			Field:   "id",
+			Code:    "id_mismatch",
+			Message: "must match the id in the URL",
+		}})
+	}
+	*bodyID = routeID
+	return nil
+}
+
+// maxPageSize bounds the limit query parameter of list endpoints.
+const maxPageSize = 1000
+
+// pageFromQuery reads the optional limit and cursor query parameters of a list endpoint.
+// Without a limit the whole collection is returned, as before pagination was added.
+func pageFromQuery(r *http.Request) (db.Page, error) {
+	query := r.URL.Query()
+	page := db.Page{Cursor: query.Get("cursor")}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return page, apperrors.BadRequest("invalid_limit", fmt.Sprintf("limit must be between 1 and %d.", maxPageSize))
+		}
+		page.Limit = int32(limit)
+	}
+	return page, nil
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/promotions"
+	"upgraded-telegram/main.go/server/services/validation"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// discount codes are managed by admins only
+
+func CreateDiscount(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("discounts_forbidden", "Only admins can manage discount codes."))
+		return
+	}
+
+	var discount db.DiscountCode
+	err := validation.Decode(r, &discount)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	discount.Code = promotions.Normalize(discount.Code)
+	err = validation.Create(discount)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	err = promotions.CheckRules(discount)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	discount.Redemptions = 0
+	discount.CreatedAt = time.Now().UnixMilli()
+	discount.UpdatedAt = discount.CreatedAt
+
+	item, err := attributevalue.MarshalMap(discount)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode discount code.", err))
+		return
+	}
+
+	err = db.CreateDiscount(r.Context(), client, db.Tables.Discounts, item)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":  "Discount code created!",
+		"discount": discount,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+func GetAllDiscounts(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("discounts_forbidden", "Only admins can manage discount codes."))
+		return
+	}
+
+	page, err := pageFromQuery(r)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	resp, nextCursor, err := db.GetAllDiscounts(r.Context(), client, db.Tables.Discounts, page)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	var discounts []db.DiscountCode
+	err = attributevalue.UnmarshalListOfMaps(resp, &discounts)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode discount codes.", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":   "Got discount codes!",
+		"discounts": discounts,
+	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+func GetDiscount(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, code string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("discounts_forbidden", "Only admins can manage discount codes."))
+		return
+	}
+
+	resp, err := db.GetDiscountByCode(r.Context(), client, db.Tables.Discounts, promotions.Normalize(code))
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	var discount db.DiscountCode
+	err = attributevalue.UnmarshalMap(resp, &discount)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode discount code.", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":  "Got discount code!",
+		"discount": discount,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// UpdateDiscount replaces the rules of a code, orders being placed with the old rules fail with code_changed.
+func UpdateDiscount(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, code string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("discounts_forbidden", "Only admins can manage discount codes."))
+		return
+	}
+
+	var discount db.DiscountCode
+	err := validation.Decode(r, &discount)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	code = promotions.Normalize(code)
+	if discount.Code != "" && promotions.Normalize(discount.Code) != code {
+		apperrors.Write(w, r, apperrors.InvalidFields([]apperrors.FieldError{{Field: "code", Code: "id_mismatch", Message: "must match the code in the URL"}}))
+		return
+	}
+	discount.Code = code
+
+	// the rules are replaced as a whole, so they are validated as for a new code
+	err = validation.Create(discount)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	err = promotions.CheckRules(discount)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = db.UpdateDiscount(r.Context(), client, db.Tables.Discounts, discount)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	message := `{"message": "Discount code updated"}`
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(message))
+}
+
+// DeleteDiscount removes a code, orders already placed with it keep their discount.
+func DeleteDiscount(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, code string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("discounts_forbidden", "Only admins can manage discount codes."))
+		return
+	}
+
+	err := db.DeleteDiscount(r.Context(), client, db.Tables.Discounts, promotions.Normalize(code))
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	message := `{"message": "Discount code deleted"}`
+
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(message))
+}
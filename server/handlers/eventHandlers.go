@@ -8,7 +8,9 @@ import (
 	"time"
 
 	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
 	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/validation"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -18,63 +20,49 @@ import (
 
 func CreateEventHandler(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	var event db.Event
-	err := json.NewDecoder(r.Body).Decode(&event)
+	err := validation.Decode(r, &event)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	id, err := uuid.NewV1()
+	err = validation.Create(event)
 	if err != nil {
-		http.Error(w, `{"error": "Error generating user id"}`, http.StatusInternalServerError)
-		return
-	}
-
-	eventId := fmt.Sprintf("e_%s", id)
-
-	if event.Name == "" {
-		http.Error(w, `{"error": "Event Name is required"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	if event.StartDate == 0 {
-		http.Error(w, `{"error": "Event Start Date is required"}`, http.StatusInternalServerError)
+	id, err := uuid.NewV1()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("id_generation_failed", "Error generating id.", err))
 		return
 	}
 
-	if event.EndDate == 0 {
-		http.Error(w, `{"error": "Event End Date is required"}`, http.StatusInternalServerError)
-		return
-	}
+	eventId := fmt.Sprintf("e_%s", id)
 
 	newEvent := map[string]types.AttributeValue{
 		"id":         &types.AttributeValueMemberS{Value: eventId},
 		"name":       &types.AttributeValueMemberS{Value: event.Name},
 		"start_date": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", event.StartDate)},
-		"emd_date":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", event.EndDate)},
+		"end_date":   &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", event.EndDate)},
 		"active":     &types.AttributeValueMemberBOOL{Value: false},
 		"created_at": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().UnixMilli())},
 	}
@@ -115,9 +103,9 @@ func CreateEventHandler(client *dynamodb.Client, w http.ResponseWriter, r *http.
 		newEvent["second_notification"] = &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", event.SecondNotification)}
 	}
 
-	err = db.CreateEvent(client, "events", newEvent)
+	err = db.CreateEvent(r.Context(), client, db.Tables.Events, newEvent)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -128,7 +116,7 @@ func CreateEventHandler(client *dynamodb.Client, w http.ResponseWriter, r *http.
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -139,37 +127,32 @@ func CreateEventHandler(client *dynamodb.Client, w http.ResponseWriter, r *http.
 
 func GetEventById(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	resp, err := db.GetEventById(client, "events", id)
+	resp, err := db.GetEventById(r.Context(), client, db.Tables.Events, id)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get event"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var event db.Event
 	err = attributevalue.UnmarshalMap(resp, &event)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode event"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode event.", err))
 		return
 	}
 
@@ -180,7 +163,7 @@ func GetEventById(client *dynamodb.Client, w http.ResponseWriter, r *http.Reques
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -191,37 +174,38 @@ func GetEventById(client *dynamodb.Client, w http.ResponseWriter, r *http.Reques
 
 func GetAllEvents(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	resp, err := db.GetAllEvents(client, "events")
+	page, err := pageFromQuery(r)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get all events"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	resp, nextCursor, err := db.GetAllEvents(r.Context(), client, db.Tables.Events, page)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var events []db.Event
 	err = attributevalue.UnmarshalListOfMaps(resp, &events)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode events"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode events.", err))
 		return
 	}
 
@@ -229,10 +213,13 @@ func GetAllEvents(client *dynamodb.Client, w http.ResponseWriter, r *http.Reques
 		"message": "Got evemts!",
 		"events":  events,
 	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -241,40 +228,47 @@ func GetAllEvents(client *dynamodb.Client, w http.ResponseWriter, r *http.Reques
 	w.Write(jsonResponse)
 }
 
-func UpdateEvent(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
-
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func UpdateEvent(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	var event db.Event
-	err := json.NewDecoder(r.Body).Decode(&event)
+	err := validation.Decode(r, &event)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	err = db.UpdateEvent(client, "events", event)
+	err = useRouteID(id, &event.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = validation.Update(event)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = db.UpdateEvent(r.Context(), client, db.Tables.Events, event)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -286,30 +280,25 @@ func UpdateEvent(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 func DeleteEvent(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	err := db.DeleteEvent(client, "events", id)
+	err := db.DeleteEvent(r.Context(), client, db.Tables.Events, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// GetExchangeRates is public, clients use it to show prices in other currencies before ordering.
+func GetExchangeRates(rates money.Source, w http.ResponseWriter, r *http.Request) {
+
+	current, err := rates.Rates(r.Context())
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("exchange_rates_unavailable", "Failed to load exchange rates.", err))
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":        "Got exchange rates!",
+		"currency":       money.Default,
+		"exchange_rates": current,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
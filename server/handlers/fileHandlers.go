@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
 	"upgraded-telegram/main.go/server/services/fileIO"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -13,37 +14,32 @@ import (
 
 func HandleFileUpload(client *s3.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "Failed to retrieve file", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("file_missing", "Failed to retrieve file from the \"file\" form field.").WithCause(err))
 		return
 	}
 	defer file.Close()
 
-	fileURL, err := fileIO.UploadFile(client, header.Filename, file)
+	fileURL, err := fileIO.UploadFile(r.Context(), client, header.Filename, file)
 	if err != nil {
-		http.Error(w, "Failed to upload file", http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("upload_failed", "Failed to upload file.", err))
 		return
 	}
 
@@ -51,38 +47,32 @@ func HandleFileUpload(client *s3.Client, w http.ResponseWriter, r *http.Request)
 	w.Write([]byte(fmt.Sprintf("File uploaded successfully: %s", fileURL)))
 }
 
-func HandleFileDownload(client *s3.Client, w http.ResponseWriter, r *http.Request) {
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func HandleFileDownload(client *s3.Client, w http.ResponseWriter, r *http.Request, filename string) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	filename := r.URL.Query().Get("filename")
 	if filename == "" {
-		http.Error(w, "Missing filename parameter", http.StatusBadRequest)
+		apperrors.Write(w, r, apperrors.BadRequest("filename_required", "Missing filename parameter."))
 		return
 	}
 
-	url, err := fileIO.DownloadFile(client, filename)
+	url, err := fileIO.DownloadFile(r.Context(), client, filename)
 	if err != nil {
-		http.Error(w, "Failed to generate download URL", http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("download_url_failed", "Failed to generate download URL.", err))
 		return
 	}
 
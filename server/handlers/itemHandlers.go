@@ -8,7 +8,11 @@ import (
 	"time"
 
 	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/catalog"
 	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/search"
+	"upgraded-telegram/main.go/server/services/validation"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -16,64 +20,77 @@ import (
 	"github.com/gofrs/uuid"
 )
 
-func CreateItem(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func CreateItem(client *dynamodb.Client, index *search.Index, w http.ResponseWriter, r *http.Request) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	// prices and stock are trusted at checkout, so only staff and admins manage items
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("items_forbidden", "Only staff and admins can manage items."))
 		return
 	}
 
 	var item db.Item
-	err := json.NewDecoder(r.Body).Decode(&item)
+	err := validation.Decode(r, &item)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
+	err = validation.Create(item)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
 	id, err := uuid.NewV1()
 	if err != nil {
-		http.Error(w, `{"error": "Error generating user id"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("id_generation_failed", "Error generating id.", err))
 		return
 	}
 
 	itemId := fmt.Sprintf("i_%s", id)
 
-	if item.Name == "" {
-		http.Error(w, `{"error": "Item name must not be empty"}`, http.StatusInternalServerError)
+	item.ID = itemId
+	item.CreatedAt = time.Now().UnixMilli()
+
+	err = catalog.Prepare(&item)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
-
-	newItem := map[string]types.AttributeValue{
-		"id":         &types.AttributeValueMemberS{Value: itemId},
-		"created_at": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().UnixMilli())},
+	err = checkCategories(r.Context(), client, item.Categories)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
 	}
 
-	if len(item.Images) > 0 {
-		newItem["images"] = &types.AttributeValueMemberSS{Value: item.Images}
+	newItem, err := attributevalue.MarshalMap(item)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode item.", err))
+		return
 	}
 
-	err = db.CreateItem(client, "items", newItem)
+	err = db.CreateItem(r.Context(), client, db.Tables.Items, newItem)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
+	index.Put(item)
 
 	response := map[string]interface{}{
 		"message": "Item craeted!",
@@ -82,7 +99,7 @@ func CreateItem(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -93,37 +110,32 @@ func CreateItem(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 
 func GetItemById(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	resp, err := db.GetItemById(client, "items", id)
+	resp, err := db.GetItemById(r.Context(), client, db.Tables.Items, id)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get item"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var item db.Item
 	err = attributevalue.UnmarshalMap(resp, &item)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode item"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode item.", err))
 		return
 	}
 
@@ -134,7 +146,7 @@ func GetItemById(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -145,37 +157,45 @@ func GetItemById(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 func GetAllItems(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	resp, err := db.GetAllItems(client, "items")
+	page, err := pageFromQuery(r)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get all items"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	// items that are not for sale are only listed for staff and admins
+	var resp []map[string]types.AttributeValue
+	var nextCursor string
+	if hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		resp, nextCursor, err = db.GetAllItems(r.Context(), client, db.Tables.Items, page)
+	} else {
+		resp, nextCursor, err = db.GetActiveItems(r.Context(), client, db.Tables.Items, page)
+	}
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var items []db.Item
 	err = attributevalue.UnmarshalListOfMaps(resp, &items)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode items"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode items.", err))
 		return
 	}
 
@@ -183,10 +203,13 @@ func GetAllItems(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 		"message": "Got items!",
 		"items":   items,
 	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -195,77 +218,105 @@ func GetAllItems(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 	w.Write(jsonResponse)
 }
 
-func UpdateItem(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
-
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func UpdateItem(client *dynamodb.Client, index *search.Index, w http.ResponseWriter, r *http.Request, id string) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("items_forbidden", "Only staff and admins can manage items."))
 		return
 	}
 
 	var item db.Item
-	err := json.NewDecoder(r.Body).Decode(&item)
+	err := validation.Decode(r, &item)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	err = db.UpdateItem(client, "items", item)
+	err = useRouteID(id, &item.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
+	err = validation.Update(item)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	err = catalog.Prepare(&item)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	err = checkCategories(r.Context(), client, item.Categories)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	updated, err := db.UpdateItem(r.Context(), client, db.Tables.Items, item)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	var stored db.Item
+	err = attributevalue.UnmarshalMap(updated, &stored)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode item.", err))
+		return
+	}
+	index.Put(stored)
+
 	message := `{"message": "Item updated"}`
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(message))
 }
 
-func DeleteItem(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
-
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func DeleteItem(client *dynamodb.Client, index *search.Index, w http.ResponseWriter, r *http.Request, id string) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("items_forbidden", "Only staff and admins can manage items."))
 		return
 	}
 
-	err := db.DeleteItem(client, "items", id)
+	err := db.DeleteItem(r.Context(), client, db.Tables.Items, id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
+	index.Delete(id)
 
 	message := `{"message": "Item deleted"}`
 
@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services"
+)
+
+func TestCustomersCannotManageItems(t *testing.T) {
+	auth := bearer(t, "u_1", services.RoleCustomer)
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/items", strings.NewReader(`{"name": "Mug", "price": {"amount": 0, "currency": "USD"}}`))
+	r.Header.Set("Authorization", auth)
+	w := httptest.NewRecorder()
+	CreateItem(nil, nil, w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("create: status %d, want 403", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPatch, "/v1/items/i_1", strings.NewReader(`{"price": {"amount": 0, "currency": "USD"}}`))
+	r.Header.Set("Authorization", auth)
+	w = httptest.NewRecorder()
+	UpdateItem(nil, nil, w, r, "i_1")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("update: status %d, want 403", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodDelete, "/v1/items/i_1", nil)
+	r.Header.Set("Authorization", auth)
+	w = httptest.NewRecorder()
+	DeleteItem(nil, nil, w, r, "i_1")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("delete: status %d, want 403", w.Code)
+	}
+}
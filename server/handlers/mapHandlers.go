@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
 	"upgraded-telegram/main.go/server/services/mapping"
 
 	"googlemaps.github.io/maps"
@@ -14,30 +15,25 @@ import (
 
 func GetDirections(client *maps.Client, w http.ResponseWriter, r *http.Request, a string, b string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	route, err := mapping.GetRoute(client, a, b)
+	route, err := mapping.GetRoute(r.Context(), client, a, b)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get route"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -48,7 +44,7 @@ func GetDirections(client *maps.Client, w http.ResponseWriter, r *http.Request,
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -59,30 +55,25 @@ func GetDirections(client *maps.Client, w http.ResponseWriter, r *http.Request,
 
 func Geocode(client *maps.Client, w http.ResponseWriter, r *http.Request, address string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	result, err := mapping.Geocode(client, address)
+	result, err := mapping.Geocode(r.Context(), client, address)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to geocode address"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("geocode_failed", "Failed to geocode address.", err))
 		return
 	}
 
@@ -93,7 +84,7 @@ func Geocode(client *maps.Client, w http.ResponseWriter, r *http.Request, addres
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -104,40 +95,35 @@ func Geocode(client *maps.Client, w http.ResponseWriter, r *http.Request, addres
 
 func ReverseGeocode(client *maps.Client, w http.ResponseWriter, r *http.Request, lat string, long string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	lat64, err := strconv.ParseFloat(lat, 64)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to convert latitude to float64"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.BadRequest("invalid_latitude", "Latitude must be a number."))
 		return
 	}
 	long64, err := strconv.ParseFloat(long, 64)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to convert longitude to float64"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.BadRequest("invalid_longitude", "Longitude must be a number."))
 		return
 	}
-	result, err := mapping.ReverseGeocode(client, lat64, long64)
+	result, err := mapping.ReverseGeocode(r.Context(), client, lat64, long64)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to reverse geocode coordinates"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("reverse_geocode_failed", "Failed to reverse geocode coordinates.", err))
 		return
 	}
 
@@ -148,7 +134,7 @@ func ReverseGeocode(client *maps.Client, w http.ResponseWriter, r *http.Request,
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
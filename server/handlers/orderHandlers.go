@@ -1,14 +1,25 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
 	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/checkout"
 	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+	"upgraded-telegram/main.go/server/services/pricing"
+	"upgraded-telegram/main.go/server/services/promotions"
+	"upgraded-telegram/main.go/server/services/tax"
+	"upgraded-telegram/main.go/server/services/validation"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -16,78 +27,97 @@ import (
 	"github.com/gofrs/uuid"
 )
 
-func CreateOrder(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
-
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// CreateOrder prices the order in its currency, discounts and taxes it, and places it through store, which reserves the
+// stock of every line in the same write. Items without a price in that currency are converted with rates.
+func CreateOrder(store checkout.Store, client *dynamodb.Client, rates money.Source, taxes *tax.Calculator, w http.ResponseWriter, r *http.Request) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	var order db.Order
-	err := json.NewDecoder(r.Body).Decode(&order)
+	err := validation.Decode(r, &order)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	id, err := uuid.NewV1()
+	if order.Currency == "" {
+		order.Currency = money.Default
+	}
+	// customers always order for themselves, only staff place orders for someone else
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		order.User = claims.ID
+	}
+	err = validation.Create(order)
 	if err != nil {
-		http.Error(w, `{"error": "Error generating user id"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	orderId := fmt.Sprintf("o_%s", id)
-
-	if order.User == "" {
-		http.Error(w, `{"error": "No user for this order found."}`, http.StatusInternalServerError)
+	breakdown, err := priceOrder(r.Context(), client, rates, order.Items, order.Currency)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	if order.Status == "" {
-		http.Error(w, `{"error": "No status for this order found."}`, http.StatusInternalServerError)
+	id, err := uuid.NewV1()
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("id_generation_failed", "Error generating id.", err))
 		return
 	}
 
-	newOrder := map[string]types.AttributeValue{
-		"id":         &types.AttributeValueMemberS{Value: orderId},
-		"created_at": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", time.Now().UnixMilli())},
-	}
+	order.ID = fmt.Sprintf("o_%s", id)
+	order.Status = orderstatus.Pending
+	order.PaymentID = ""
+	order.CreatedAt = time.Now().UnixMilli()
+	order.History = []db.StatusChange{{To: orderstatus.Pending, By: claims.ID, Role: role(claims), At: order.CreatedAt}}
 
-	if len(order.Items) > 0 {
-		newOrder["items"] = &types.AttributeValueMemberSS{Value: order.Items}
+	var code *db.DiscountCode
+	if order.DiscountCode != "" {
+		order.DiscountCode = promotions.Normalize(order.DiscountCode)
+		code, err = discountOrder(r.Context(), client, &breakdown, order.DiscountCode, order.User, order.CreatedAt)
+		if err != nil {
+			apperrors.Write(w, r, err)
+			return
+		}
 	}
+	taxBreakdown, taxTotal, err := taxes.Tax(r.Context(), order.ShippingAddress, breakdown.Lines, breakdown.Discount)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	breakdown.WithTax(taxBreakdown, taxTotal)
+	breakdown.Apply(&order)
 
-	err = db.CreateOrder(client, "items", newOrder)
+	err = store.PlaceOrder(r.Context(), order, code)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	response := map[string]interface{}{
 		"message":  "Order craeted!",
-		"order.id": orderId,
+		"order.id": order.ID,
+		"order":    order,
 	}
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -98,37 +128,25 @@ func CreateOrder(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 func GetOrderById(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
-		return
-	}
-
-	resp, err := db.GetItemById(client, "items", id)
-	if err != nil {
-		http.Error(w, `{"error": "Failed to get order"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	var order db.Order
-	err = attributevalue.UnmarshalMap(resp, &order)
+	order, err := ownOrder(r.Context(), client, id, claims)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode order"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -139,7 +157,7 @@ func GetOrderById(client *dynamodb.Client, w http.ResponseWriter, r *http.Reques
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -150,37 +168,45 @@ func GetOrderById(client *dynamodb.Client, w http.ResponseWriter, r *http.Reques
 
 func GetAllOrders(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	resp, err := db.GetAllOrders(client, "orders")
+	page, err := pageFromQuery(r)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get all orders"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	// customers only list their own orders, staff and admins list everyone's
+	var resp []map[string]types.AttributeValue
+	var nextCursor string
+	if hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		resp, nextCursor, err = db.GetAllOrders(r.Context(), client, db.Tables.Orders, page)
+	} else {
+		resp, nextCursor, err = db.GetOrdersByUser(r.Context(), client, db.Tables.Orders, claims.ID, page)
+	}
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
 	var orders []db.Order
 	err = attributevalue.UnmarshalListOfMaps(resp, &orders)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode orders"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode orders.", err))
 		return
 	}
 
@@ -188,10 +214,13 @@ func GetAllOrders(client *dynamodb.Client, w http.ResponseWriter, r *http.Reques
 		"message": "Got orders!",
 		"orders":  orders,
 	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -200,40 +229,68 @@ func GetAllOrders(client *dynamodb.Client, w http.ResponseWriter, r *http.Reques
 	w.Write(jsonResponse)
 }
 
-func UpdateOrder(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
-
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func UpdateOrder(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	var order db.Order
-	err := json.NewDecoder(r.Body).Decode(&order)
+	err := validation.Decode(r, &order)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	err = db.UpdateOrders(client, "orders", order)
+	err = useRouteID(id, &order.ID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = validation.Update(order)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	if order.Status != "" {
+		apperrors.Write(w, r, apperrors.Conflict("status_requires_transition", "Change the status with POST /v1/orders/{id}/status."))
+		return
+	}
+	// the user owns the order, which lets them cancel and pay it
+	if order.User != "" && !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("order_user_forbidden", "Only staff and admins can move an order to another user."))
+		return
+	}
+	// the items hold reserved stock, changing them means canceling and checking out again
+	if order.Items != nil {
+		apperrors.Write(w, r, apperrors.Conflict("order_items_immutable", "The items of an order cannot be changed, cancel it and place a new order."))
+		return
+	}
+
+	_, err = ownOrder(r.Context(), client, id, claims)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = db.UpdateOrders(r.Context(), client, db.Tables.Orders, order)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -243,32 +300,154 @@ func UpdateOrder(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 	w.Write([]byte(message))
 }
 
-func DeleteOrder(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
+type transitionRequest struct {
+	Status string `json:"status" validate:"required"`
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// TransitionOrder moves an order along its lifecycle, see the orderstatus package for who may make which change.
+func TransitionOrder(store checkout.Store, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	var req transitionRequest
+	err := validation.Decode(r, &req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validation.Create(req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	transition(store, w, r, id, claims, req.Status, req.Reason)
+}
+
+// CancelOrder cancels a pending or paid order and returns its items to stock.
+func CancelOrder(store checkout.Store, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	transition(store, w, r, id, claims, orderstatus.Canceled, r.URL.Query().Get("reason"))
+}
+
+func transition(store checkout.Store, w http.ResponseWriter, r *http.Request, id string, claims *services.UserClaims, status, reason string) {
+	order, err := store.Transition(r.Context(), id, db.StatusChange{
+		To:     status,
+		By:     claims.ID,
+		Role:   role(claims),
+		Reason: reason,
+		At:     time.Now().UnixMilli(),
+	})
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": fmt.Sprintf("Order %s", order.Status),
+		"order":   order,
+	}
 
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// ownOrder reads an order for claims. Customers only get their own, other customers' orders are not found.
+func ownOrder(ctx context.Context, client *dynamodb.Client, id string, claims *services.UserClaims) (db.Order, error) {
+	resp, err := db.GetOrderById(ctx, client, db.Tables.Orders, id)
+	if err != nil {
+		return db.Order{}, err
+	}
+	var order db.Order
+	err = attributevalue.UnmarshalMap(resp, &order)
+	if err != nil {
+		return db.Order{}, apperrors.Internal("decode_failed", "Failed to decode order.", err)
+	}
+	if order.User != claims.ID && !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		return db.Order{}, apperrors.NotFound("order_not_found", fmt.Sprintf("No order found with id %s.", id))
+	}
+	return order, nil
+}
+
+// role defaults tokens issued before roles existed to customer
+func role(claims *services.UserClaims) string {
+	if claims.Role == "" {
+		return services.RoleCustomer
+	}
+	return claims.Role
+}
+
+func hasRole(claims *services.UserClaims, roles ...string) bool {
+	return slices.Contains(roles, role(claims))
+}
+
+// DeleteOrder removes a canceled or refunded order, staff only. Other orders still hold stock, cancel them first.
+func DeleteOrder(store checkout.Store, w http.ResponseWriter, r *http.Request, id string) {
+
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	err := db.DeleteOrder(client, "orders", id)
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("order_delete_forbidden", "Only staff and admins can delete orders."))
+		return
+	}
+
+	err := store.DeleteOrder(r.Context(), id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -277,3 +456,56 @@ func DeleteOrder(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(message))
 }
+
+// discountOrder evaluates the code for the priced order and takes its discount off the breakdown.
+// The code is returned as evaluated, placing the order redeems it only if it is still the same.
+func discountOrder(ctx context.Context, client *dynamodb.Client, breakdown *pricing.Breakdown, code, user string, now int64) (*db.DiscountCode, error) {
+	resp, err := db.GetDiscountByCode(ctx, client, db.Tables.Discounts, code)
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) && appErr.Code == "discount_not_found" {
+		return nil, promotions.Rejected(promotions.CodeNotFound, code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var discount db.DiscountCode
+	err = attributevalue.UnmarshalMap(resp, &discount)
+	if err != nil {
+		return nil, apperrors.Internal("decode_failed", "Failed to decode discount code.", err)
+	}
+
+	used, err := db.GetRedemptions(ctx, client, db.Tables.Redemptions, code, user)
+	if err != nil {
+		return nil, err
+	}
+	amount, err := promotions.Evaluate(discount, breakdown.Lines, breakdown.Subtotal, used, now)
+	if err != nil {
+		return nil, err
+	}
+	breakdown.WithDiscount(amount)
+	return &discount, nil
+}
+
+// priceOrder loads the items referenced by lines and prices the order from them in currency
+func priceOrder(ctx context.Context, client *dynamodb.Client, source money.Source, lines []db.LineItem, currency string) (pricing.Breakdown, error) {
+	resp, err := db.GetItemsByIds(ctx, client, db.Tables.Items, pricing.ItemIDs(lines))
+	if err != nil {
+		return pricing.Breakdown{}, err
+	}
+
+	items := make(map[string]db.Item, len(resp))
+	for _, av := range resp {
+		var item db.Item
+		err = attributevalue.UnmarshalMap(av, &item)
+		if err != nil {
+			return pricing.Breakdown{}, apperrors.Internal("decode_failed", "Failed to decode item.", err)
+		}
+		items[item.ID] = item
+	}
+
+	rates, err := source.Rates(ctx)
+	if err != nil {
+		return pricing.Breakdown{}, apperrors.Internal("exchange_rates_unavailable", "Failed to load exchange rates.", err)
+	}
+	return pricing.Price(lines, items, currency, rates)
+}
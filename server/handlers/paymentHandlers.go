@@ -0,0 +1,360 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/payments"
+	"upgraded-telegram/main.go/server/services/validation"
+)
+
+type createPaymentRequest struct {
+	CaptureMethod string `json:"capture_method" validate:"oneof=automatic manual"`
+}
+
+// CreatePayment starts paying an order, only its customer or staff may pay it.
+func CreatePayment(service *payments.Service, w http.ResponseWriter, r *http.Request, orderID string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	// no body pays with the default settings, automatic capture
+	var req createPaymentRequest
+	err := validation.DecodeOptional(r, &req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validation.Create(req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	order, err := service.Order(r.Context(), orderID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	if order.User != claims.ID && !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("payment_forbidden", "Only the customer who placed the order can pay it."))
+		return
+	}
+
+	payment, clientSecret, err := service.CreatePayment(r.Context(), order, req.CaptureMethod == "manual")
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message":       "Payment created!",
+		"payment":       payment,
+		"client_secret": clientSecret,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+func GetPayment(service *payments.Service, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	payment, err := ownPayment(service, r, claims, id)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Got payment!",
+		"payment": payment,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+type confirmPaymentRequest struct {
+	PaymentMethod string `json:"payment_method" validate:"required,max=200"`
+}
+
+// ConfirmPayment charges the payment method. The response holds the gateway's answer so far, the order only
+// moves once the payment settled.
+func ConfirmPayment(service *payments.Service, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	var req confirmPaymentRequest
+	err := validation.Decode(r, &req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validation.Create(req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	payment, err := ownPayment(service, r, claims, id)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	payment, err = service.Confirm(r.Context(), payment, req.PaymentMethod)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Payment " + payment.Status,
+		"payment": payment,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// CapturePayment charges a payment confirmed with manual capture, typically once the order is ready to ship.
+func CapturePayment(service *payments.Service, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	if !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("capture_forbidden", "Only staff can capture payments."))
+		return
+	}
+
+	payment, err := service.Payment(r.Context(), id)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	payment, err = service.Capture(r.Context(), payment)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Payment " + payment.Status,
+		"payment": payment,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+type refundRequest struct {
+	Amount int64  `json:"amount" validate:"min=0"`
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// RefundPayment refunds part of a payment, or all that is left of it when no amount is given.
+func RefundPayment(service *payments.Service, w http.ResponseWriter, r *http.Request, id string) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	// same rule as moving an order to refunded
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("refund_forbidden", "Only admins can refund payments."))
+		return
+	}
+
+	var req refundRequest
+	err := validation.Decode(r, &req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validation.Create(req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	payment, err := service.Payment(r.Context(), id)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	refund, err := service.Refund(r.Context(), payment, req.Amount, req.Reason, db.StatusChange{
+		By:   claims.ID,
+		Role: role(claims),
+		At:   time.Now().UnixMilli(),
+	})
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Refund requested!",
+		"refund":  refund,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+// PaymentWebhook receives the gateway's events. It is authenticated by the signature instead of a token.
+func PaymentWebhook(service *payments.Service, w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		apperrors.Write(w, r, apperrors.BadRequest("invalid_body", "Failed to read the request body."))
+		return
+	}
+	defer r.Body.Close()
+
+	err = service.HandleWebhook(r.Context(), body, r.Header.Get(payments.SignatureHeader))
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"received": true}`))
+}
+
+// ownPayment loads a payment that claims may see: one of their own orders, or any payment for staff
+func ownPayment(service *payments.Service, r *http.Request, claims *services.UserClaims, id string) (db.Payment, error) {
+	payment, err := service.Payment(r.Context(), id)
+	if err != nil {
+		return db.Payment{}, err
+	}
+	if hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		return payment, nil
+	}
+	order, err := service.Order(r.Context(), payment.OrderID)
+	if err != nil {
+		return db.Payment{}, err
+	}
+	if order.User != claims.ID {
+		// do not reveal other customers' payments
+		return db.Payment{}, apperrors.NotFound("payment_not_found", "No payment found with id "+id+".")
+	}
+	return payment, nil
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/checkout"
+	"upgraded-telegram/main.go/server/services/config"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+	"upgraded-telegram/main.go/server/services/payments"
+)
+
+// capturingGateway remembers whether the last intent was created for manual capture.
+type capturingGateway struct {
+	*payments.FakeGateway
+	manual bool
+}
+
+func (g *capturingGateway) CreateIntent(ctx context.Context, req payments.IntentRequest) (payments.Intent, error) {
+	g.manual = req.ManualCapture
+	return g.FakeGateway.CreateIntent(ctx, req)
+}
+
+func TestCreatePaymentBody(t *testing.T) {
+	services.InitAuth(config.Auth{TokenSecret: "test-secret"})
+	token, err := services.NewAccessToken(services.UserClaims{ID: "user-1", Role: services.RoleCustomer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := db.Order{ID: "o1", User: "user-1", Status: orderstatus.Pending, Total: money.New(1000, "USD")}
+
+	tests := []struct {
+		name   string
+		body   string
+		manual bool
+	}{
+		{name: "no body", body: ""},
+		{name: "automatic", body: `{"capture_method": "automatic"}`},
+		{name: "manual", body: `{"capture_method": "manual"}`, manual: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders := checkout.NewMemoryStore()
+			err := orders.PlaceOrder(context.Background(), order, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gateway := &capturingGateway{FakeGateway: payments.NewFakeGateway("webhook-secret", nil), manual: !tt.manual}
+			service := payments.NewService(gateway, payments.NewMemoryStore(orders), orders, nil, config.Payments{})
+
+			r := httptest.NewRequest(http.MethodPost, "/v1/orders/o1/payments", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+token)
+			w := httptest.NewRecorder()
+			CreatePayment(service, w, r, "o1")
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var resp struct {
+				Payment      db.Payment `json:"payment"`
+				ClientSecret string     `json:"client_secret"`
+			}
+			err = json.Unmarshal(w.Body.Bytes(), &resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Payment.OrderID != "o1" || resp.Payment.Amount != 1000 || resp.ClientSecret == "" {
+				t.Fatalf("got %+v", resp)
+			}
+			if gateway.manual != tt.manual {
+				t.Fatalf("manual capture is %t, want %t", gateway.manual, tt.manual)
+			}
+			_, err = service.Payment(context.Background(), resp.Payment.ID)
+			if err != nil {
+				t.Fatalf("payment not stored: %v", err)
+			}
+		})
+	}
+}
+
+func TestCreatePaymentRejectsAnInvalidBody(t *testing.T) {
+	services.InitAuth(config.Auth{TokenSecret: "test-secret"})
+	token, err := services.NewAccessToken(services.UserClaims{ID: "user-1", Role: services.RoleCustomer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"capture_method": "later"}`, `{"capture_method": `} {
+		r := httptest.NewRequest(http.MethodPost, "/v1/orders/o1/payments", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		CreatePayment(nil, w, r, "o1")
+		if w.Code != http.StatusBadRequest && w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: status %d, want 400 or 422", body, w.Code)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/catalog"
+	"upgraded-telegram/main.go/server/services/money"
+	"upgraded-telegram/main.go/server/services/search"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// defaultSearchLimit is the page size of a search without a limit, unlike the other lists which return everything.
+const defaultSearchLimit = 20
+
+// SearchItems searches the catalog. Customers only see active items; staff and admins can pass active=false or
+// active=any.
+func SearchItems(index *search.Index, client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	query, err := searchQuery(r)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	if (query.Active == nil || !*query.Active) && !hasRole(claims, services.RoleStaff, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("search_forbidden", "Only staff and admins can search items that are not for sale."))
+		return
+	}
+
+	if category := r.URL.Query().Get("category"); category != "" {
+		categories, err := allCategories(r.Context(), client)
+		if err != nil {
+			apperrors.Write(w, r, err)
+			return
+		}
+		if _, ok := findNode(catalog.Tree(categories), category); !ok {
+			apperrors.Write(w, r, categoryNotFound(category))
+			return
+		}
+		query.Categories = catalog.Descendants(categories, category)
+	}
+
+	result, err := index.Search(query)
+	if errors.Is(err, search.ErrNotReady) {
+		apperrors.Write(w, r, apperrors.Unavailable("search_unavailable", "The search index is still being built, try again shortly."))
+		return
+	}
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"message": "Got items!",
+		"items":   result.Items,
+		"total":   result.Total,
+		"facets":  result.Facets,
+	}
+	if result.NextCursor != "" {
+		response["next_cursor"] = result.NextCursor
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// RebuildSearchIndex rebuilds the search index from the items table now, admins only.
+func RebuildSearchIndex(index *search.Index, w http.ResponseWriter, r *http.Request) {
+
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
+		return
+	}
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	if token == authHeader {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
+		return
+	}
+	claims := services.ParseAccessToken(token)
+	if claims == nil {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+	if !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("search_forbidden", "Only admins can rebuild the search index."))
+		return
+	}
+
+	count, err := index.Rebuild(r.Context())
+	if errors.Is(err, search.ErrRebuilding) {
+		apperrors.Write(w, r, apperrors.Conflict("rebuild_in_progress", "The search index is already being rebuilt."))
+		return
+	}
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	_, built := index.Stats()
+
+	response := map[string]interface{}{
+		"message":  "Search index rebuilt!",
+		"items":    count,
+		"built_at": built,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// searchQuery reads the query parameters of a search, active defaults to true.
+func searchQuery(r *http.Request) (search.Query, error) {
+	values := r.URL.Query()
+	page, err := pageFromQuery(r)
+	if err != nil {
+		return search.Query{}, err
+	}
+	query := search.Query{
+		Text:     values.Get("q"),
+		Currency: strings.ToUpper(values.Get("currency")),
+		Sort:     values.Get("sort"),
+		Limit:    int(page.Limit),
+		Cursor:   page.Cursor,
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultSearchLimit
+	}
+	if query.Currency != "" && !money.Valid(query.Currency) {
+		return query, apperrors.BadRequest("invalid_currency", "currency must be a supported ISO 4217 currency code.")
+	}
+	if query.Sort != "" && !slices.Contains(search.Sorts, query.Sort) {
+		return query, apperrors.BadRequest("invalid_sort", fmt.Sprintf("sort must be one of %s.", strings.Join(search.Sorts, ", ")))
+	}
+
+	for name, bound := range map[string]**int64{"min_price": &query.MinPrice, "max_price": &query.MaxPrice} {
+		raw := values.Get(name)
+		if raw == "" {
+			continue
+		}
+		amount, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || amount < 0 {
+			return query, apperrors.BadRequest("invalid_price", name+" must be a non-negative amount in minor units.")
+		}
+		*bound = &amount
+	}
+	if query.MinPrice != nil && query.MaxPrice != nil && *query.MinPrice > *query.MaxPrice {
+		return query, apperrors.BadRequest("invalid_price", "min_price must not be above max_price.")
+	}
+
+	if raw := values.Get("in_stock"); raw != "" {
+		inStock, err := strconv.ParseBool(raw)
+		if err != nil {
+			return query, apperrors.BadRequest("invalid_filter", "in_stock must be true or false.")
+		}
+		query.InStock = &inStock
+	}
+	switch raw := values.Get("active"); raw {
+	case "", "true":
+		active := true
+		query.Active = &active
+	case "false":
+		active := false
+		query.Active = &active
+	case "any":
+	default:
+		return query, apperrors.BadRequest("invalid_filter", "active must be true, false or any.")
+	}
+	return query, nil
+}
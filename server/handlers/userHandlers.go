@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,7 +9,9 @@ import (
 	"time"
 
 	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
 	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/validation"
 
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -19,32 +22,46 @@ import (
 
 func CreateUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	var user db.User
 
-	err := json.NewDecoder(r.Body).Decode(&user)
+	err := validation.Decode(r, &user)
 	if err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
+	err = validation.Create(user)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
 	id, err := uuid.NewV1()
 	if err != nil {
-		http.Error(w, `{"error": "Error generating user id"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("id_generation_failed", "Error generating id.", err))
+		return
+	}
+
+	// roles are given by admins, never chosen when signing up
+	if user.Role != "" {
+		apperrors.Write(w, r, apperrors.Forbidden("role_forbidden", "Only admins can change a user's role."))
 		return
 	}
 
 	email := strings.ToLower(user.Email)
 
+	err = checkEmail(r.Context(), client, email, "")
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
 	userId := fmt.Sprintf("u_%s", id)
 
 	hashedPassword, err := services.HashedPassword(user.Password)
 	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("password_hash_failed", "Failed to hash password.", err))
 		return
 	}
 
@@ -53,118 +70,217 @@ func CreateUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 		"name":     &types.AttributeValueMemberS{Value: user.Name},
 		"email":    &types.AttributeValueMemberS{Value: email},
 		"password": &types.AttributeValueMemberS{Value: hashedPassword},
+		"role":     &types.AttributeValueMemberS{Value: services.RoleCustomer},
 	}
 
-	err = db.CreateUser(client, "users", newUser)
+	err = db.CreateUser(r.Context(), client, db.Tables.Users, newUser)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	message := fmt.Sprintf(`{"message": "User created successfully", "user.id": %s}`, userId)
+	response := map[string]interface{}{
+		"message": "User created successfully",
+		"user.id": userId,
+	}
 
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte(message))
+	w.Write(jsonResponse)
 
 }
 
 func AuthUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	type LoginRequest struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email    string `json:"email" validate:"required,email"`
+		Password string `json:"password" validate:"required"`
 	}
 
 	var req LoginRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+	err := validation.Decode(r, &req)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	user, err := db.GetUserByEmail(client, "users", req.Email)
+	err = validation.Create(req)
 	if err != nil {
-		http.Error(w, `{"error": "No user found with that email."}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	user, err := db.GetUserByEmail(r.Context(), client, db.Tables.Users, req.Email)
+	if err != nil {
+		if apperrors.From(err).Status == http.StatusNotFound {
+			err = apperrors.Unauthorized("invalid_credentials", "Invalid email or password.")
+		}
+		apperrors.Write(w, r, err)
+		return
 	}
 
 	pass := services.CheckPasswordHash(req.Password, user.Password)
 	if !pass {
-		http.Error(w, `{"error": "Password Verfication Failed"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_credentials", "Invalid email or password."))
 		return
 	}
 
-	userClaims := services.UserClaims{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
-		StandardClaims: jwt.StandardClaims{
-			IssuedAt:  time.Now().Unix(),
-			ExpiresAt: time.Now().Add(time.Minute * 15).Unix(),
-		},
+	token, refreshToken, err := issueTokens(user.ID, user.Name, user.Email, user.Role)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("token_generation_failed", "Failed to generate tokens.", err))
+		return
 	}
 
-	token, err := services.NewAccessToken(userClaims)
+	response := map[string]interface{}{
+		"message":       "Login Success",
+		"token":         token,
+		"refresh_token": refreshToken,
+		"user":          user,
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+
+}
+
+// exchange a refresh token for a new token pair, the refresh token is rotated too
+func RefreshToken(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
+
+	var req struct {
+		RefreshToken string `json:"refresh_token" validate:"required"`
+	}
+	err := validation.Decode(r, &req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	err = validation.Create(req)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	claims := services.ParseRefreshToken(req.RefreshToken)
+	if claims == nil || claims.Subject == "" {
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_refresh_token", "Failed to verify refresh token."))
+		return
+	}
+
+	// the user may have been deleted since the refresh token was issued
+	resp, err := db.GetUserById(r.Context(), client, db.Tables.Users, claims.Subject)
 	if err != nil {
-		http.Error(w, `{"error": "Password Verfication Failed"}`, http.StatusInternalServerError)
+		if apperrors.From(err).Status == http.StatusNotFound {
+			err = apperrors.Unauthorized("invalid_refresh_token", "Failed to verify refresh token.")
+		}
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	refreshToken, err := services.NewRefreshToken(userClaims.StandardClaims)
+	var user db.User
+	err = attributevalue.UnmarshalMap(resp, &user)
 	if err != nil {
-		http.Error(w, `{"error": "Password Verfication Failed"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode user.", err))
+		return
+	}
+
+	token, refreshToken, err := issueTokens(user.ID, user.Name, user.Email, user.Role)
+	if err != nil {
+		apperrors.Write(w, r, apperrors.Internal("token_generation_failed", "Failed to generate tokens.", err))
 		return
 	}
 
 	response := map[string]interface{}{
-		"message":       "Login Success",
+		"message":       "Token Refreshed",
 		"token":         token,
 		"refresh_token": refreshToken,
-		"user":          user,
 	}
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	w.Write(jsonResponse)
-
 }
 
-func GetAllUsers(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
+// issueTokens signs an access token and a refresh token whose subject is the user id
+func issueTokens(id, name, email, role string) (string, string, error) {
+	if role == "" {
+		role = services.RoleCustomer
+	}
+	now := time.Now()
+	token, err := services.NewAccessToken(services.UserClaims{
+		ID:    id,
+		Name:  name,
+		Email: email,
+		Role:  role,
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(services.AccessTokenTTL).Unix(),
+		},
+	})
+	if err != nil {
+		return "", "", err
+	}
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	refreshToken, err := services.NewRefreshToken(jwt.StandardClaims{
+		Subject:   id,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(services.RefreshTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", "", err
 	}
+	return token, refreshToken, nil
+}
+
+func GetAllUsers(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
-	resp, err := db.GetAllUsers(client, "users")
+
+	page, err := pageFromQuery(r)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get all users"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	resp, nextCursor, err := db.GetAllUsers(r.Context(), client, db.Tables.Users, page)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 	var users []db.User
@@ -173,7 +289,7 @@ func GetAllUsers(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 		var user db.User
 		err = attributevalue.UnmarshalMap(item, &user)
 		if err != nil {
-			http.Error(w, `{"error": "Failed to decode users"}`, http.StatusInternalServerError)
+			apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode users.", err))
 			return
 		}
 		users = append(users, user)
@@ -183,10 +299,13 @@ func GetAllUsers(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 		"message": "Users Found!",
 		"users":   users,
 	}
+	if nextCursor != "" {
+		response["next_cursor"] = nextCursor
+	}
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -197,35 +316,30 @@ func GetAllUsers(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 func GetUserByID(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
-	resp, err := db.GetUserById(client, "users", id)
+	resp, err := db.GetUserById(r.Context(), client, db.Tables.Users, id)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to get all users"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 	var user db.User
 	err = attributevalue.UnmarshalMap(resp, &user)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to decode user"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("decode_failed", "Failed to decode user.", err))
 		return
 	}
 
@@ -236,7 +350,7 @@ func GetUserByID(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -245,40 +359,66 @@ func GetUserByID(client *dynamodb.Client, w http.ResponseWriter, r *http.Request
 	w.Write(jsonResponse)
 }
 
-func UpdateUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
-
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func UpdateUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
 	var user db.User
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+	err := validation.Decode(r, &user)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	err := db.UpdateUser(client, "users", user)
+	err = useRouteID(id, &user.ID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = validation.Update(user)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to update user"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = mayChangeUser(claims, user.ID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+	if user.Role != "" && !hasRole(claims, services.RoleAdmin) {
+		apperrors.Write(w, r, apperrors.Forbidden("role_forbidden", "Only admins can change a user's role."))
+		return
+	}
+	if user.Email != "" {
+		user.Email = strings.ToLower(user.Email)
+		err = checkEmail(r.Context(), client, user.Email, user.ID)
+		if err != nil {
+			apperrors.Write(w, r, err)
+			return
+		}
+	}
+
+	err = db.UpdateUser(r.Context(), client, db.Tables.Users, user)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -288,7 +428,7 @@ func UpdateUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -297,47 +437,77 @@ func UpdateUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request)
 	w.Write(jsonResponse)
 }
 
-func UpdatePassword(client *dynamodb.Client, w http.ResponseWriter, r *http.Request) {
+type updatePasswordRequest struct {
+	ID              string `json:"id"`
+	CurrentPassword string `json:"current_password" validate:"max=72"`
+	Password        string `json:"password" validate:"required,min=8,max=72"`
+}
 
-	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+func UpdatePassword(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 		return
 	}
 
-	var user db.User
+	var req updatePasswordRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+	err := validation.Decode(r, &req)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	hashedPassword, err := services.HashedPassword(user.Password)
+	err = useRouteID(id, &req.ID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	err = validation.Create(req)
 	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	user.Password = hashedPassword
+	err = mayChangeUser(claims, req.ID)
+	if err != nil {
+		apperrors.Write(w, r, err)
+		return
+	}
+
+	// changing your own password takes the current one, admins resetting someone else's do not know it
+	if req.ID == claims.ID {
+		err = checkCurrentPassword(r.Context(), client, req.ID, req.CurrentPassword)
+		if err != nil {
+			apperrors.Write(w, r, err)
+			return
+		}
+	}
 
-	err = db.UpdatePassword(client, "users", user)
+	hashedPassword, err := services.HashedPassword(req.Password)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to update user password"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("password_hash_failed", "Failed to hash password.", err))
+		return
+	}
+
+	user := db.User{ID: req.ID, Password: hashedPassword}
+
+	err = db.UpdatePassword(r.Context(), client, db.Tables.Users, user)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -347,7 +517,7 @@ func UpdatePassword(client *dynamodb.Client, w http.ResponseWriter, r *http.Requ
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -358,30 +528,31 @@ func UpdatePassword(client *dynamodb.Client, w http.ResponseWriter, r *http.Requ
 
 func DeleteUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request, id string) {
 
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
 	authHeader := r.Header.Get("Authorization")
 	if authHeader == "" {
-		http.Error(w, `{"error": "Authorization header"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 		return
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 	if token == authHeader {
-		http.Error(w, `{"error": "Invalid token format"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token_format", "Authorization header must use the Bearer scheme."))
 		return
 	}
 	claims := services.ParseAccessToken(token)
 	if claims == nil {
-		http.Error(w, `{"error": "Failed to verify token"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
+		return
+	}
+
+	err := mayChangeUser(claims, id)
+	if err != nil {
+		apperrors.Write(w, r, err)
 		return
 	}
 
-	err := db.DeleteUser(client, "users", id)
+	err = db.DeleteUser(r.Context(), client, db.Tables.Users, id)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to update user"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, err)
 		return
 	}
 
@@ -391,7 +562,7 @@ func DeleteUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request,
 
 	jsonResponse, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 		return
 	}
 
@@ -400,3 +571,49 @@ func DeleteUser(client *dynamodb.Client, w http.ResponseWriter, r *http.Request,
 	w.Write(jsonResponse)
 
 }
+
+// mayChangeUser allows changing or deleting the user with id to that user and to admins.
+func mayChangeUser(claims *services.UserClaims, id string) error {
+	if id != claims.ID && !hasRole(claims, services.RoleAdmin) {
+		return apperrors.Forbidden("user_forbidden", "Only the user or an admin can change this user.")
+	}
+	return nil
+}
+
+// checkCurrentPassword compares password with the stored password of the user with id.
+func checkCurrentPassword(ctx context.Context, client *dynamodb.Client, id, password string) error {
+	if password == "" {
+		return apperrors.InvalidFields([]apperrors.FieldError{{Field: "current_password", Code: "required", Message: "is required"}})
+	}
+	resp, err := db.GetUserById(ctx, client, db.Tables.Users, id)
+	if err != nil {
+		return err
+	}
+	var user db.User
+	err = attributevalue.UnmarshalMap(resp, &user)
+	if err != nil {
+		return apperrors.Internal("decode_failed", "Failed to decode user.", err)
+	}
+	if !services.CheckPasswordHash(password, user.Password) {
+		return apperrors.InvalidFields([]apperrors.FieldError{{Field: "current_password", Code: "invalid", Message: "does not match the current password"}})
+	}
+	return nil
+}
+
+// checkEmail refuses email when another user than id has it, or when it is reserved for a configured role.
+func checkEmail(ctx context.Context, client *dynamodb.Client, email, id string) error {
+	existing, err := db.GetUserByEmail(ctx, client, db.Tables.Users, email)
+	if err != nil && apperrors.From(err).Status != http.StatusNotFound {
+		return err
+	}
+	if existing != nil {
+		if existing.ID == id {
+			return nil
+		}
+		return apperrors.Conflict("email_taken", "A user with that email already exists.")
+	}
+	if services.ReservedEmail(email) {
+		return apperrors.Conflict("email_reserved", "That email is reserved.")
+	}
+	return nil
+}
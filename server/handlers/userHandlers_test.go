@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// wire encodes an attribute value the way DynamoDB sends it over the wire.
+func wire(av types.AttributeValue) any {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return map[string]any{"S": v.Value}
+	case *types.AttributeValueMemberN:
+		return map[string]any{"N": v.Value}
+	case *types.AttributeValueMemberBOOL:
+		return map[string]any{"BOOL": v.Value}
+	case *types.AttributeValueMemberNULL:
+		return map[string]any{"NULL": true}
+	case *types.AttributeValueMemberL:
+		list := []any{}
+		for _, item := range v.Value {
+			list = append(list, wire(item))
+		}
+		return map[string]any{"L": list}
+	case *types.AttributeValueMemberM:
+		return map[string]any{"M": wireItem(v.Value)}
+	}
+	panic("unsupported attribute value")
+}
+
+func wireItem(item map[string]types.AttributeValue) map[string]any {
+	encoded := map[string]any{}
+	for name, av := range item {
+		encoded[name] = wire(av)
+	}
+	return encoded
+}
+
+// localDynamo answers GetItem and Query with record and counts the writes, enough for handlers that read one
+// record and write another.
+func localDynamo(t *testing.T, record any) (*dynamodb.Client, func() int) {
+	t.Helper()
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	writes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		switch r.Header.Get("X-Amz-Target") {
+		case "DynamoDB_20120810.GetItem":
+			json.NewEncoder(w).Encode(map[string]any{"Item": wireItem(item)})
+		case "DynamoDB_20120810.Query":
+			json.NewEncoder(w).Encode(map[string]any{"Items": []any{wireItem(item)}, "Count": 1})
+		case "DynamoDB_20120810.PutItem", "DynamoDB_20120810.UpdateItem", "DynamoDB_20120810.DeleteItem":
+			mu.Lock()
+			writes++
+			mu.Unlock()
+			w.Write([]byte("{}"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"com.amazon.coral.validate#ValidationException","message":"unsupported"}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	client := dynamodb.New(dynamodb.Options{
+		Region:           "us-east-1",
+		BaseEndpoint:     aws.String(srv.URL),
+		Credentials:      aws.AnonymousCredentials{},
+		RetryMaxAttempts: 1,
+	})
+	return client, func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return writes
+	}
+}
+
+func bearer(t *testing.T, id, role string) string {
+	t.Helper()
+	services.InitAuth(config.Auth{TokenSecret: "test-secret"})
+	token, err := services.NewAccessToken(services.UserClaims{ID: id, Role: role})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "Bearer " + token
+}
+
+func TestOnlyTheUserOrAnAdminChangesAUser(t *testing.T) {
+	customer := bearer(t, "u_1", services.RoleCustomer)
+	staff := bearer(t, "u_2", services.RoleStaff)
+
+	for _, auth := range []string{customer, staff} {
+		r := httptest.NewRequest(http.MethodPut, "/v1/users/u_3/password", strings.NewReader(`{"password": "new-password"}`))
+		r.Header.Set("Authorization", auth)
+		w := httptest.NewRecorder()
+		UpdatePassword(nil, w, r, "u_3")
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("password: status %d, want 403", w.Code)
+		}
+
+		r = httptest.NewRequest(http.MethodPatch, "/v1/users/u_3", strings.NewReader(`{"name": "Mallory"}`))
+		r.Header.Set("Authorization", auth)
+		w = httptest.NewRecorder()
+		UpdateUser(nil, w, r, "u_3")
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("update: status %d, want 403", w.Code)
+		}
+
+		r = httptest.NewRequest(http.MethodDelete, "/v1/users/u_3", nil)
+		r.Header.Set("Authorization", auth)
+		w = httptest.NewRecorder()
+		DeleteUser(nil, w, r, "u_3")
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("delete: status %d, want 403", w.Code)
+		}
+	}
+
+	// the legacy update route takes the id from the body
+	r := httptest.NewRequest(http.MethodPut, "/users/update", strings.NewReader(`{"id": "u_3", "name": "Mallory"}`))
+	r.Header.Set("Authorization", customer)
+	w := httptest.NewRecorder()
+	UpdateUser(nil, w, r, "")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("legacy update: status %d, want 403", w.Code)
+	}
+}
+
+func TestChangingYourOwnPasswordTakesTheCurrentOne(t *testing.T) {
+	hash, err := services.HashedPassword("old-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := map[string]string{"id": "u_1", "name": "Ada", "email": "ada@example.com", "password": hash}
+
+	tests := []struct {
+		name   string
+		auth   string
+		body   string
+		status int
+		writes int
+	}{
+		{name: "missing", auth: bearer(t, "u_1", services.RoleCustomer), body: `{"password": "new-password"}`, status: http.StatusUnprocessableEntity},
+		{name: "wrong", auth: bearer(t, "u_1", services.RoleCustomer), body: `{"current_password": "guess", "password": "new-password"}`, status: http.StatusUnprocessableEntity},
+		{name: "right", auth: bearer(t, "u_1", services.RoleCustomer), body: `{"current_password": "old-password", "password": "new-password"}`, status: http.StatusOK, writes: 1},
+		{name: "admin resetting it", auth: bearer(t, "u_9", services.RoleAdmin), body: `{"password": "new-password"}`, status: http.StatusOK, writes: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, writes := localDynamo(t, stored)
+			r := httptest.NewRequest(http.MethodPut, "/v1/users/u_1/password", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", tt.auth)
+			w := httptest.NewRecorder()
+			UpdatePassword(client, w, r, "u_1")
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if writes() != tt.writes {
+				t.Fatalf("%d writes, want %d", writes(), tt.writes)
+			}
+		})
+	}
+}
+
+func TestOnlyAdminsGiveRoles(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(`{"name": "Eve", "email": "eve@example.com", "password": "password1", "role": "admin"}`))
+	w := httptest.NewRecorder()
+	CreateUser(nil, w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("sign up: status %d, want 403", w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPatch, "/v1/users/u_1", strings.NewReader(`{"role": "admin"}`))
+	r.Header.Set("Authorization", bearer(t, "u_1", services.RoleStaff))
+	w = httptest.NewRecorder()
+	UpdateUser(nil, w, r, "u_1")
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("own role: status %d, want 403", w.Code)
+	}
+
+	client, writes := localDynamo(t, map[string]string{"id": "u_1", "email": "ada@example.com"})
+	r = httptest.NewRequest(http.MethodPatch, "/v1/users/u_1", strings.NewReader(`{"role": "staff"}`))
+	r.Header.Set("Authorization", bearer(t, "u_9", services.RoleAdmin))
+	w = httptest.NewRecorder()
+	UpdateUser(client, w, r, "u_1")
+	if w.Code != http.StatusOK || writes() != 1 {
+		t.Fatalf("admin: status %d and %d writes, want 200 and 1", w.Code, writes())
+	}
+}
+
+func TestEmailsBelongToOneUser(t *testing.T) {
+	client, writes := localDynamo(t, map[string]string{"id": "u_2", "email": "bob@example.com"})
+	r := httptest.NewRequest(http.MethodPatch, "/v1/users/u_1", strings.NewReader(`{"email": "Bob@Example.com"}`))
+	r.Header.Set("Authorization", bearer(t, "u_1", services.RoleCustomer))
+	w := httptest.NewRecorder()
+	UpdateUser(client, w, r, "u_1")
+	if w.Code != http.StatusConflict || writes() != 0 {
+		t.Fatalf("status %d and %d writes, want 409 and none", w.Code, writes())
+	}
+
+	// keeping your own address is not a conflict
+	r = httptest.NewRequest(http.MethodPatch, "/v1/users/u_2", strings.NewReader(`{"email": "BOB@example.com"}`))
+	r.Header.Set("Authorization", bearer(t, "u_2", services.RoleCustomer))
+	w = httptest.NewRecorder()
+	UpdateUser(client, w, r, "u_2")
+	if w.Code != http.StatusOK || writes() != 1 {
+		t.Fatalf("status %d and %d writes, want 200 and 1", w.Code, writes())
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"net/http"
+
+	"upgraded-telegram/main.go/server/services/catalog"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/openapi"
+	"upgraded-telegram/main.go/server/services/payments"
+	"upgraded-telegram/main.go/server/services/search"
+)
+
+// Every route registered in this package must be described here, StartServer refuses to start otherwise.
+// Routes behind a feature flag are described too, they only appear in the document when registered.
+
+type loginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type passwordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"max=72"`
+	Password        string `json:"password" validate:"required,min=8,max=72"`
+}
+
+type logLevelRequest struct {
+	Level string `json:"level" validate:"required,oneof=debug info warn error"`
+}
+
+type transitionRequest struct {
+	Status string `json:"status" validate:"required,oneof=pending failed paid fulfilled shipped delivered canceled refunded"`
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+type createPaymentRequest struct {
+	CaptureMethod string `json:"capture_method" validate:"oneof=automatic manual"`
+}
+
+type confirmPaymentRequest struct {
+	PaymentMethod string `json:"payment_method" validate:"required,max=200"`
+}
+
+type refundRequest struct {
+	Amount int64  `json:"amount" validate:"min=0"`
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+var message = openapi.Object{"message": ""}
+
+func created(key string) openapi.Object {
+	return openapi.Object{"message": "", key + ".id": ""}
+}
+
+func got(key string, v any) openapi.Object {
+	return openapi.Object{"message": "", key: v}
+}
+
+// list endpoints return everything unless a limit is given
+var pageParams = []openapi.Param{
+	{Name: "limit", Description: "Page size from 1 to 1000. Without it the whole collection is returned."},
+	{Name: "cursor", Description: "The next_cursor of the previous page."},
+}
+
+func listed(key string, v any) openapi.Object {
+	return openapi.Object{"message": "", key: v, "next_cursor": openapi.Optional{Value: ""}}
+}
+
+// deprecated describes a legacy route by its /v1 successor.
+func deprecated(op openapi.Operation) openapi.Operation {
+	op.Deprecated = true
+	return op
+}
+
+func describeRoutes(docs *openapi.Registry) {
+	docs.Describe("GET /{$}", openapi.Operation{Summary: "Welcome message", Tag: "meta", Public: true, Response: openapi.Object{"message": "", "updated": ""}})
+	docs.Describe("GET /healthz", openapi.Operation{Summary: "Liveness probe", Tag: "meta", Public: true, Response: map[string]any{}})
+	docs.Describe("GET /readyz", openapi.Operation{Summary: "Readiness probe, 503 while a dependency is down or the server is draining", Tag: "meta", Public: true, Response: map[string]any{}})
+	docs.Describe("GET /metrics", openapi.Operation{Summary: "Prometheus metrics", Tag: "meta", Public: true, Response: "", ContentType: "text/plain"})
+	docs.Describe("GET /openapi.json", openapi.Operation{Summary: "This document", Tag: "meta", Public: true, Response: map[string]any{}})
+	docs.Describe("GET /docs", openapi.Operation{Summary: "Interactive API documentation", Tag: "meta", Public: true, Response: "", ContentType: "text/html"})
+
+	logLevel := openapi.Object{"level": ""}
+	docs.Describe("GET /admin/log-level", openapi.Operation{Summary: "Current log level, admins only", Tag: "admin", Response: logLevel})
+	docs.Describe("PUT /admin/log-level", openapi.Operation{Summary: "Change the log level, admins only", Tag: "admin", Body: logLevelRequest{}, Response: logLevel})
+	// discount codes are admin only, codes are case-insensitive and stored upper case
+	discount := got("discount", db.DiscountCode{})
+	docs.Describe("POST /admin/discounts", openapi.Operation{Summary: "Create a discount code", Tag: "admin", Idempotent: true, Body: db.DiscountCode{}, Status: http.StatusCreated, Response: discount})
+	docs.Describe("GET /admin/discounts", openapi.Operation{Summary: "List discount codes", Tag: "admin", Query: pageParams, Response: listed("discounts", []db.DiscountCode{})})
+	docs.Describe("GET /admin/discounts/{code}", openapi.Operation{Summary: "Get a discount code with its redemption count", Tag: "admin", Response: discount})
+	docs.Describe("PUT /admin/discounts/{code}", openapi.Operation{Summary: "Replace the rules of a discount code", Tag: "admin", Body: db.DiscountCode{}, Response: message})
+	docs.Describe("DELETE /admin/discounts/{code}", openapi.Operation{Summary: "Delete a discount code", Tag: "admin", Response: message})
+
+	// users
+	createUser := openapi.Operation{Summary: "Sign up", Tag: "users", Public: true, Idempotent: true, Body: db.User{}, Status: http.StatusCreated, Response: created("user")}
+	login := openapi.Operation{Summary: "Log in", Tag: "users", Public: true, Body: loginRequest{}, Response: openapi.Object{"message": "", "token": "", "refresh_token": "", "user": db.User{}}}
+	tokens := openapi.Object{"message": "", "token": "", "refresh_token": ""}
+	refresh := openapi.Operation{Summary: "Exchange a refresh token for a new token pair", Tag: "users", Public: true, Body: refreshRequest{}, Response: tokens}
+	listUsers := openapi.Operation{Summary: "List users", Tag: "users", Query: pageParams, Response: listed("users", []db.User{})}
+	getUser := openapi.Operation{Summary: "Get a user", Tag: "users", Response: got("user", db.User{})}
+	updateUser := openapi.Operation{Summary: "Update a user, yourself or admins only", Tag: "users", Body: db.User{}, Partial: true, Response: message}
+	deleteUser := openapi.Operation{Summary: "Delete a user, yourself or admins only", Tag: "users", Response: message}
+	docs.Describe("POST /v1/users", createUser)
+	docs.Describe("POST /v1/auth/login", login)
+	docs.Describe("POST /v1/auth/refresh", refresh)
+	docs.Describe("GET /v1/users", listUsers)
+	docs.Describe("GET /v1/users/{id}", getUser)
+	docs.Describe("PATCH /v1/users/{id}", updateUser)
+	docs.Describe("PUT /v1/users/{id}/password", openapi.Operation{Summary: "Change your password, current_password required, or any user's as an admin", Tag: "users", Body: passwordRequest{}, Response: message})
+	docs.Describe("DELETE /v1/users/{id}", deleteUser)
+	docs.Describe("POST /users/new", deprecated(createUser))
+	docs.Describe("POST /users/login", deprecated(login))
+	docs.Describe("GET /users/all", deprecated(listUsers))
+	docs.Describe("GET /users/id/{id}", deprecated(getUser))
+	docs.Describe("PUT /users/update", deprecated(updateUser))
+	docs.Describe("DELETE /users/delete/{id}", deprecated(deleteUser))
+
+	// chats and messages
+	createChat := openapi.Operation{Summary: "Open a chat", Tag: "chats", Idempotent: true, Body: db.Chat{}, Status: http.StatusCreated, Response: created("chat")}
+	listChats := openapi.Operation{Summary: "List chats", Tag: "chats", Query: pageParams, Response: listed("chats", []db.Chat{})}
+	getChat := openapi.Operation{Summary: "Get a chat", Tag: "chats", Response: got("chat", db.Chat{})}
+	updateChat := openapi.Operation{Summary: "Update a chat", Tag: "chats", Body: db.Chat{}, Partial: true, Response: message}
+	deleteChat := openapi.Operation{Summary: "Delete a chat", Tag: "chats", Response: message}
+	createMessage := openapi.Operation{Summary: "Send a message", Tag: "chats", Idempotent: true, Body: db.Message{}, Status: http.StatusCreated, Response: openapi.Object{"message": "", "message.id": "", "chat.id": ""}}
+	listMessages := openapi.Operation{Summary: "List a chat's messages", Tag: "chats", Response: got("messages", []db.Message{})}
+	deleteMessage := openapi.Operation{Summary: "Delete a message", Tag: "chats", Response: message}
+	docs.Describe("POST /v1/chats", createChat)
+	docs.Describe("GET /v1/chats", listChats)
+	docs.Describe("GET /v1/chats/{id}", getChat)
+	docs.Describe("PATCH /v1/chats/{id}", updateChat)
+	docs.Describe("DELETE /v1/chats/{id}", deleteChat)
+	docs.Describe("POST /v1/chats/{id}/messages", createMessage)
+	docs.Describe("GET /v1/chats/{id}/messages", listMessages)
+	docs.Describe("DELETE /v1/chats/{id}/messages/{messageId}", deleteMessage)
+	docs.Describe("POST /chats/new", deprecated(createChat))
+	docs.Describe("GET /chats/all", deprecated(listChats))
+	docs.Describe("GET /chats/chat/{id}", deprecated(getChat))
+	docs.Describe("PUT /chats/chat/update", deprecated(updateChat))
+	docs.Describe("DELETE /chats/chat/{id}/delete", deprecated(deleteChat))
+	docs.Describe("POST /chats/chat/{id}/messages/new", deprecated(createMessage))
+	docs.Describe("GET /chats/chat/{id}/messages", deprecated(listMessages))
+	docs.Describe("DELETE /chats/chat/{id}/messages/message/{messageId}/delete", deprecated(deleteMessage))
+
+	// files
+	upload := openapi.Operation{Summary: "Upload a file to S3", Tag: "files", Upload: true, Response: "", ContentType: "text/plain"}
+	download := openapi.Operation{Summary: "Get a presigned download URL", Tag: "files", Response: openapi.Object{"downloadUrl": ""}}
+	docs.Describe("POST /v1/files", upload)
+	docs.Describe("GET /v1/files/{filename...}", download)
+	docs.Describe("POST /upload", deprecated(upload))
+	legacyDownload := deprecated(download)
+	legacyDownload.Query = []openapi.Param{{Name: "filename", Required: true}}
+	docs.Describe("POST /download", legacyDownload)
+
+	describeResource(docs, "events", "event", db.Event{}, []db.Event{})
+	describeResource(docs, "items", "item", db.Item{}, []db.Item{})
+	// items are priced and stocked by staff, customers only browse them
+	createItem := openapi.Operation{Summary: "Create an item, staff only", Tag: "items", Idempotent: true, Body: db.Item{}, Status: http.StatusCreated, Response: created("item")}
+	updateItem := openapi.Operation{Summary: "Update an item, staff only", Tag: "items", Body: db.Item{}, Partial: true, Response: message}
+	deleteItem := openapi.Operation{Summary: "Delete an item, staff only", Tag: "items", Response: message}
+	docs.Describe("POST /v1/items", createItem)
+	docs.Describe("PATCH /v1/items/{id}", updateItem)
+	docs.Describe("DELETE /v1/items/{id}", deleteItem)
+	docs.Describe("POST /items/new", deprecated(createItem))
+	docs.Describe("PUT /items/item/update", deprecated(updateItem))
+	docs.Describe("DELETE /items/item/{id}/delete", deprecated(deleteItem))
+
+	describeResource(docs, "orders", "order", db.Order{}, []db.Order{})
+
+	// the catalog tree, browsing lists active items only and is paginated like the other lists
+	docs.Describe("GET /v1/categories", openapi.Operation{Summary: "The category tree", Tag: "catalog", Response: got("categories", []catalog.Node{})})
+	docs.Describe("POST /v1/categories", openapi.Operation{Summary: "Create a category, staff only", Tag: "catalog", Idempotent: true, Body: db.Category{}, Status: http.StatusCreated, Response: got("category", db.Category{})})
+	docs.Describe("GET /v1/categories/{id}", openapi.Operation{Summary: "Get a category with its subcategories and the categories above it", Tag: "catalog", Response: openapi.Object{"message": "", "category": catalog.Node{}, "path": []db.Category{}}})
+	docs.Describe("PUT /v1/categories/{id}", openapi.Operation{Summary: "Replace or move a category, staff only", Tag: "catalog", Body: db.Category{}, Response: message})
+	docs.Describe("DELETE /v1/categories/{id}", openapi.Operation{Summary: "Delete a category without subcategories, staff only", Tag: "catalog", Response: message})
+	docs.Describe("GET /v1/categories/{id}/items", openapi.Operation{Summary: "List the items in a category and the categories below it", Tag: "catalog", Query: pageParams, Response: listed("items", []db.Item{})})
+	docs.Describe("GET /v1/tags/{tag}/items", openapi.Operation{Summary: "List the items with a tag", Tag: "catalog", Query: pageParams, Response: listed("items", []db.Item{})})
+	// search pages default to 20 items, unlike the other lists
+	searchParams := []openapi.Param{
+		{Name: "q", Description: "Words to find in the name or description, all must match."},
+		{Name: "currency", Description: "Currency of the price filters, sort and facet, the default currency if not given."},
+		{Name: "min_price", Description: "Lowest price in minor units."},
+		{Name: "max_price", Description: "Highest price in minor units."},
+		{Name: "in_stock", Description: "true or false."},
+		{Name: "active", Description: "true (the default), false or any. Only staff and admins can see inactive items."},
+		{Name: "category", Description: "A category, items in the categories below it match too."},
+		{Name: "sort", Description: "relevance (the default with q), newest (the default without), price_asc or price_desc."},
+		{Name: "limit", Description: "Page size from 1 to 1000, 20 if not given."},
+		{Name: "cursor", Description: "The next_cursor of the previous page."},
+	}
+	searched := openapi.Object{"message": "", "items": []db.Item{}, "total": 0, "facets": search.Facets{}, "next_cursor": openapi.Optional{Value: ""}}
+	docs.Describe("GET /v1/search/items", openapi.Operation{Summary: "Search the catalog, 503 until the index is first built", Tag: "catalog", Query: searchParams, Response: searched})
+	docs.Describe("POST /admin/search/rebuild", openapi.Operation{Summary: "Rebuild the search index from the items table", Tag: "admin", Response: openapi.Object{"message": "", "items": 0, "built_at": ""}})
+
+	// orders are priced by the server and reserve their stock, the created order comes back with its breakdown
+	createOrder := openapi.Operation{Summary: "Place an order", Tag: "orders", Idempotent: true, Body: db.Order{}, Status: http.StatusCreated, Response: openapi.Object{"message": "", "order.id": "", "order": db.Order{}}}
+	docs.Describe("POST /v1/orders", createOrder)
+	docs.Describe("POST /orders/new", deprecated(createOrder))
+	// status changes follow the lifecycle in the orderstatus package, a disallowed one is a 409 and a missing role a 403
+	transitioned := openapi.Object{"message": "", "order": db.Order{}}
+	docs.Describe("POST /v1/orders/{id}/status", openapi.Operation{Summary: "Move an order to another status", Tag: "orders", Body: transitionRequest{}, Response: transitioned})
+	// prices in other currencies are converted with these when an order is placed
+	docs.Describe("GET /v1/exchange-rates", openapi.Operation{Summary: "Exchange rates used to price orders", Tag: "orders", Public: true, Response: openapi.Object{"message": "", "currency": "", "exchange_rates": openapi.Object{"base": "", "as_of": "", "rates": map[string]string{}}}})
+	docs.Describe("POST /v1/orders/{id}/cancel", openapi.Operation{Summary: "Cancel an order and restock its items", Tag: "orders", Query: []openapi.Param{{Name: "reason", Description: "recorded in the status history"}}, Response: transitioned})
+	// customers only see their own orders, another customer's order is not found
+	listOrders := openapi.Operation{Summary: "List your orders, or everyone's for staff", Tag: "orders", Query: pageParams, Response: listed("orders", []db.Order{})}
+	getOrder := openapi.Operation{Summary: "Get one of your orders, or anyone's for staff", Tag: "orders", Response: got("order", db.Order{})}
+	docs.Describe("GET /v1/orders", listOrders)
+	docs.Describe("GET /v1/orders/{id}", getOrder)
+	docs.Describe("GET /orders/all", deprecated(listOrders))
+	docs.Describe("GET /orders/order/{id}", deprecated(getOrder))
+	// only finished orders can be deleted, anything else still holds stock
+	deleteOrder := openapi.Operation{Summary: "Delete a canceled or refunded order, staff only", Tag: "orders", Response: message}
+	docs.Describe("DELETE /v1/orders/{id}", deleteOrder)
+	docs.Describe("DELETE /orders/order/{id}/delete", deprecated(deleteOrder))
+
+	// payments, the order only moves to paid or failed once the gateway's webhook arrives
+	payment := got("payment", db.Payment{})
+	docs.Describe("POST /v1/orders/{id}/payments", openapi.Operation{Summary: "Start paying an order", Tag: "payments", Idempotent: true, Body: createPaymentRequest{}, NoBody: true, Status: http.StatusCreated, Response: openapi.Object{"message": "", "payment": db.Payment{}, "client_secret": ""}})
+	docs.Describe("GET /v1/payments/{id}", openapi.Operation{Summary: "Get a payment", Tag: "payments", Response: payment})
+	docs.Describe("POST /v1/payments/{id}/confirm", openapi.Operation{Summary: "Confirm a payment with a payment method", Tag: "payments", Body: confirmPaymentRequest{}, Response: payment})
+	docs.Describe("POST /v1/payments/{id}/capture", openapi.Operation{Summary: "Capture a manually captured payment, staff only", Tag: "payments", Response: payment})
+	docs.Describe("POST /v1/payments/{id}/refunds", openapi.Operation{Summary: "Refund a payment, admins only", Tag: "payments", Idempotent: true, Body: refundRequest{}, Status: http.StatusCreated, Response: got("refund", db.Refund{})})
+	docs.Describe("POST /v1/payments/webhook", openapi.Operation{Summary: "Gateway events, signed with the Payment-Signature header", Tag: "payments", Public: true, Body: payments.Event{}, Response: openapi.Object{"received": true}})
+
+	// maps
+	route := map[string]any{}
+	directions := openapi.Operation{Summary: "Directions between two places", Tag: "maps", Response: got("route", route)}
+	geocode := openapi.Operation{Summary: "Coordinates of an address", Tag: "maps", Response: got("route", route)}
+	reverseGeocode := openapi.Operation{Summary: "Addresses at a coordinate", Tag: "maps", Response: got("route", route)}
+	v1Directions := directions
+	v1Directions.Query = []openapi.Param{{Name: "origin", Required: true}, {Name: "destination", Required: true}}
+	v1Geocode := geocode
+	v1Geocode.Query = []openapi.Param{{Name: "address", Required: true}}
+	v1ReverseGeocode := reverseGeocode
+	v1ReverseGeocode.Query = []openapi.Param{{Name: "lat", Required: true}, {Name: "long", Required: true}}
+	docs.Describe("GET /v1/maps/directions", v1Directions)
+	docs.Describe("GET /v1/maps/geocode", v1Geocode)
+	docs.Describe("GET /v1/maps/reverse-geocode", v1ReverseGeocode)
+	docs.Describe("GET /maps/from/{origin}/to/{destination}", deprecated(directions))
+	docs.Describe("GET /maps/geocode/{address}", deprecated(geocode))
+	docs.Describe("GET /maps/reversegeocode/lat/{lat}/long/{long}", deprecated(reverseGeocode))
+}
+
+// describeResource describes the collection routes shared by events, items and orders, with their legacy forms.
+func describeResource(docs *openapi.Registry, path, name string, v, list any) {
+	create := openapi.Operation{Summary: "Create " + article(name) + " " + name, Tag: path, Idempotent: true, Body: v, Status: http.StatusCreated, Response: created(name)}
+	listAll := openapi.Operation{Summary: "List " + path, Tag: path, Query: pageParams, Response: listed(path, list)}
+	get := openapi.Operation{Summary: "Get " + article(name) + " " + name, Tag: path, Response: got(name, v)}
+	update := openapi.Operation{Summary: "Update " + article(name) + " " + name, Tag: path, Body: v, Partial: true, Response: message}
+	remove := openapi.Operation{Summary: "Delete " + article(name) + " " + name, Tag: path, Response: message}
+
+	docs.Describe("POST /v1/"+path, create)
+	docs.Describe("GET /v1/"+path, listAll)
+	docs.Describe("GET /v1/"+path+"/{id}", get)
+	docs.Describe("PATCH /v1/"+path+"/{id}", update)
+	docs.Describe("DELETE /v1/"+path+"/{id}", remove)
+	docs.Describe("POST /"+path+"/new", deprecated(create))
+	docs.Describe("GET /"+path+"/all", deprecated(listAll))
+	docs.Describe("GET /"+path+"/"+name+"/{id}", deprecated(get))
+	docs.Describe("PUT /"+path+"/"+name+"/update", deprecated(update))
+	docs.Describe("DELETE /"+path+"/"+name+"/{id}/delete", deprecated(remove))
+}
+
+func article(name string) string {
+	switch name[0] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return "an"
+	}
+	return "a"
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/config"
+	"upgraded-telegram/main.go/server/services/openapi"
+)
+
+// routes registers every route StartServer can serve, with every feature and the legacy routes on.
+func routes(t *testing.T) (*router, *openapi.Registry) {
+	t.Helper()
+	mux := newRouter()
+	docs := openapi.NewRegistry(openapi.Info{Title: "test", Version: "v1"})
+	addRoutes(mux, dependencies{docs: docs, legacy: &services.Deprecation{}}, config.Features{
+		AI:           true,
+		Maps:         true,
+		Metrics:      true,
+		Payments:     true,
+		LegacyRoutes: true,
+	})
+	describeRoutes(docs)
+	return mux, docs
+}
+
+func TestEveryRouteIsDescribed(t *testing.T) {
+	mux, docs := routes(t)
+	if err := docs.Build(mux.patterns); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUndescribedRouteFailsTheBuild(t *testing.T) {
+	mux, docs := routes(t)
+	mux.HandleFunc("GET /v1/undescribed", func(w http.ResponseWriter, r *http.Request) {})
+	err := docs.Build(mux.patterns)
+	if err == nil || !strings.Contains(err.Error(), "GET /v1/undescribed") {
+		t.Fatalf("got %v, want an error naming the undescribed route", err)
+	}
+}
@@ -0,0 +1,26 @@
+package server
+
+import (
+	"net/http"
+)
+
+// router is a ServeMux that remembers the patterns registered on it, in order,
+// so the OpenAPI document can be checked against what is actually served.
+type router struct {
+	*http.ServeMux
+	patterns []string
+}
+
+func newRouter() *router {
+	return &router{ServeMux: http.NewServeMux()}
+}
+
+func (mux *router) Handle(pattern string, handler http.Handler) {
+	mux.patterns = append(mux.patterns, pattern)
+	mux.ServeMux.Handle(pattern, handler)
+}
+
+func (mux *router) HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	mux.patterns = append(mux.patterns, pattern)
+	mux.ServeMux.HandleFunc(pattern, handler)
+}
@@ -1,67 +1,346 @@
 package server
 
 import (
+	"context"
+	"os/signal"
+	"syscall"
+	"time"
+
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"upgraded-telegram/main.go/server/handlers"
 	"upgraded-telegram/main.go/server/services"
 	"upgraded-telegram/main.go/server/services/ai"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/checkout"
+	"upgraded-telegram/main.go/server/services/config"
 	"upgraded-telegram/main.go/server/services/db"
 	"upgraded-telegram/main.go/server/services/fileIO"
+	"upgraded-telegram/main.go/server/services/health"
+	"upgraded-telegram/main.go/server/services/logging"
 	"upgraded-telegram/main.go/server/services/mapping"
+	"upgraded-telegram/main.go/server/services/metrics"
+	"upgraded-telegram/main.go/server/services/money"
+	"upgraded-telegram/main.go/server/services/openapi"
+	"upgraded-telegram/main.go/server/services/payments"
+	"upgraded-telegram/main.go/server/services/search"
+	"upgraded-telegram/main.go/server/services/tax"
+	"upgraded-telegram/main.go/server/services/tracing"
 
 	"googlemaps.github.io/maps"
 
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/openai/openai-go"
+	"golang.org/x/time/rate"
 )
 
+// legacyDeprecatedAt is when the /v1 routes replaced the unversioned ones.
+var legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
 func StartServer() {
 
-	mux := http.NewServeMux()
+	conf, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
 
-	// implement rate limiting
-	rateLimiter := services.NewRateLimiter(5, 10)
-	handler := rateLimiter.RateLimitMiddleware(mux)
+	err = logging.Init(conf.LogLevel)
+	if err != nil {
+		log.Fatalf("invalid LOG_LEVEL, %v", err)
+	}
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "configuration loaded", conf.Summary()...)
+
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		log.Fatalf("unable to start tracing, %v", err)
+	}
+
+	mux := newRouter()
+
+	// per-dependency call timeouts and resource names
+	db.Timeout = conf.Timeouts.DynamoDB
+	fileIO.Timeout = conf.Timeouts.S3
+	mapping.Timeout = conf.Timeouts.Maps
+	ai.Timeout = conf.Timeouts.OpenAI
+	db.Tables = db.TableNames(conf.Tables)
+	fileIO.Bucket = conf.AWS.Bucket
+	money.Default = conf.Money.Currency
+
+	// exchange rates are read once here so that a bad rates file stops the server instead of failing checkouts
+	rates := money.NewTable(money.NewStaticFile(conf.Money.RatesFile), conf.Money.RatesRefresh)
+	if _, err := rates.Rates(context.Background()); err != nil {
+		log.Fatalf("unable to load exchange rates, %v", err)
+	}
 
 	// connect with AWS
-	cfg := services.StartAws()
+	cfg := services.StartAws(conf.AWS)
 
 	// connect with DynamoDB
 	dynamoClient := db.ConnectDB(cfg)
 
+	// middleware that needs the matched route looks it up in mux before the request is routed
+	var routed http.Handler = services.RoutingErrors(mux.ServeMux)
+
+	// retried creates with the same Idempotency-Key get the first response back instead of a duplicate
+	var idempotencyStore services.IdempotencyStore
+	if conf.Idempotency.Store == "dynamodb" {
+		idempotencyStore = services.NewDynamoIdempotencyStore(dynamoClient, db.Tables.IdempotencyKeys)
+	} else {
+		memoryStore := services.NewMemoryIdempotencyStore()
+		defer memoryStore.Stop()
+		idempotencyStore = memoryStore
+	}
+	idempotency := services.NewIdempotency(idempotencyStore, conf.Idempotency.TTL, conf.Timeouts.Write)
+	for _, pattern := range []string{
+		"POST /v1/users", "POST /v1/chats", "POST /v1/chats/{id}/messages", "POST /v1/events", "POST /v1/items", "POST /v1/orders",
+		"POST /v1/orders/{id}/payments", "POST /v1/payments/{id}/refunds", "POST /admin/discounts",
+		"POST /users/new", "POST /chats/new", "POST /chats/chat/{id}/messages/new", "POST /events/new", "POST /items/new", "POST /orders/new",
+	} {
+		idempotency.SetRoute(pattern)
+	}
+	routed = idempotency.Middleware(mux.ServeMux, routed)
+
+	// implement rate limiting
+	if conf.RateLimit.Enabled {
+		proxies, _ := conf.RateLimit.Proxies() // already validated
+		var store services.RateLimitStore
+		if conf.RateLimit.Store == "dynamodb" {
+			store = services.NewDynamoStore(dynamoClient, db.Tables.RateLimits)
+		} else {
+			memoryStore := services.NewMemoryStore()
+			defer memoryStore.Stop()
+			store = memoryStore
+		}
+		rateLimiter := services.NewRateLimiter(store,
+			services.Policy{Name: "read", Rate: rate.Limit(conf.RateLimit.ReadRPS), Burst: conf.RateLimit.ReadBurst},
+			services.Policy{Name: "write", Rate: rate.Limit(conf.RateLimit.RPS), Burst: conf.RateLimit.Burst},
+			proxies,
+		)
+		auth := services.Policy{Name: "auth", Rate: rate.Limit(conf.RateLimit.AuthRPS), Burst: conf.RateLimit.AuthBurst}
+		rateLimiter.SetRoutePolicy("POST /v1/auth/login", auth)
+		rateLimiter.SetRoutePolicy("POST /v1/auth/refresh", auth)
+		rateLimiter.SetRoutePolicy("POST /v1/users", auth)
+		rateLimiter.SetRoutePolicy("POST /users/login", auth)
+		rateLimiter.SetRoutePolicy("POST /users/new", auth)
+		rateLimiter.SetRoutePolicy("GET /healthz", services.Unlimited)
+		rateLimiter.SetRoutePolicy("GET /readyz", services.Unlimited)
+		rateLimiter.SetRoutePolicy("GET /metrics", services.Unlimited)
+		// webhooks come from the gateway's few addresses and are verified by signature
+		rateLimiter.SetRoutePolicy("POST /v1/payments/webhook", services.Unlimited)
+		routed = rateLimiter.RateLimitMiddleware(mux.ServeMux, routed)
+	}
+
+	bodyLimiter := services.NewBodyLimiter(int64(conf.Security.MaxBodyBytes))
+	bodyLimiter.SetRouteLimit("POST /v1/files", int64(conf.Security.MaxUploadBytes))
+	bodyLimiter.SetRouteLimit("POST /upload", int64(conf.Security.MaxUploadBytes))
+	routed = bodyLimiter.Middleware(mux.ServeMux, routed)
+
+	if origins := config.List(conf.Security.CORSAllowedOrigins); len(origins) > 0 {
+		cors := &services.CORS{
+			AllowedOrigins:   origins,
+			AllowedMethods:   config.List(conf.Security.CORSAllowedMethods),
+			AllowedHeaders:   config.List(conf.Security.CORSAllowedHeaders),
+			ExposedHeaders:   []string{apperrors.RequestIDHeader, services.IdempotentReplayedHeader, "RateLimit-Policy", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
+			AllowCredentials: conf.Security.CORSAllowCredentials,
+			MaxAge:           conf.Security.CORSMaxAge,
+		}
+		routed = cors.Middleware(routed)
+	}
+
+	// recovery sits inside metrics and tracing so that a panic is still recorded as a 500
+	routed = services.RecoverMiddleware(services.SecurityHeaders(conf.Security.HSTSMaxAge, routed))
+	handler := services.RequestIDMiddleware(tracing.Middleware(metrics.Middleware(routed)))
+
 	// connect with S3
 	s3Client := fileIO.ConnectS3(cfg)
 
-	// connect with OpenAI
-	aiClient := ai.Open()
-
-	// connect with Google Maps
-	mapClient := mapping.FindMaps()
-
-	services.InitAuth()
-	addUserRoutes(dynamoClient, mux)
-	addChatMessageRoutes(dynamoClient, mux)
-	addFileIORoutes(s3Client, mux)
-	addAIRoutes(aiClient, mux)
-	addEventRoutes(dynamoClient, mux)
-	addMapRoutes(mapClient, mux)
-	addItemRoutes(dynamoClient, mux)
-	addOrderRoutes(dynamoClient, mux)
-	addMainRoute(mux)
+	// readiness checks, Google Maps has no free endpoint to probe so its failures only show up on the map routes
+	checker := health.NewChecker(conf.Timeouts.ReadinessCacheTTL, 5*time.Second)
+	checker.Add("dynamodb", func(ctx context.Context) error {
+		_, err := db.GetTables(ctx, dynamoClient)
+		return err
+	})
+	checker.Add("s3", func(ctx context.Context) error {
+		return fileIO.CheckBucket(ctx, s3Client)
+	})
+
+	// the unversioned routes stay mounted until the sunset date, marked deprecated
+	var legacy *services.Deprecation
+	if conf.Features.LegacyRoutes {
+		sunset, _ := time.Parse(time.DateOnly, conf.LegacySunset) // already validated
+		legacy = &services.Deprecation{Date: legacyDeprecatedAt, Sunset: sunset}
+	}
+
+	services.InitAuth(conf.Auth)
+	// ADMIN_EMAILS and STAFF_EMAILS grant their role to the accounts that have them, roles live on the user after that
+	err = services.GrantConfiguredRoles(context.Background(), dynamoClient)
+	if err != nil {
+		log.Fatalf("unable to grant configured roles, %v", err)
+	}
+	// the search index is built from the items table in the background, searches are a 503 until it is
+	index := search.NewIndex(func(ctx context.Context) ([]db.Item, error) {
+		resp, _, err := db.GetAllItems(ctx, dynamoClient, db.Tables.Items, db.Page{})
+		if err != nil {
+			return nil, err
+		}
+		var items []db.Item
+		err = attributevalue.UnmarshalListOfMaps(resp, &items)
+		return items, err
+	}, conf.Search.Refresh)
+	defer index.Stop()
 
-	fmt.Println("Server started on port 8080")
-	err := http.ListenAndServe(":8080", handler)
+	// shipping addresses without a country are geocoded for tax when Google Maps is enabled
+	var mapClient *maps.Client
+	var locator tax.Locator
+	if conf.Features.Maps {
+		mapClient = mapping.FindMaps(conf.MapsKey)
+		locator = tax.NewGeocoder(mapClient)
+	}
+	taxRules, err := tax.LoadFile(conf.Tax.RulesFile, conf.Tax.Mode == "inclusive")
 	if err != nil {
-		log.Fatalf("unable to load dynamoDB tables, %v", err)
+		log.Fatalf("unable to load tax rules, %v", err)
+	}
+	taxes := tax.NewCalculator(taxRules, locator, conf.Tax.DefaultRegion)
+
+	orders := checkout.NewDynamoStore(dynamoClient, db.Tables.Items, db.Tables.Orders, db.Tables.Discounts, db.Tables.Redemptions)
+
+	var paymentService *payments.Service
+	if conf.Features.Payments {
+		// the fake gateway sends its webhooks to this server, like a real provider would
+		gateway := payments.NewFakeGateway(conf.Payments.WebhookSecret, payments.PostWebhook(fmt.Sprintf("http://localhost:%d/v1/payments/webhook", conf.Port)))
+		store := payments.NewDynamoStore(dynamoClient, db.Tables.Payments, db.Tables.Refunds, db.Tables.Orders)
+		paymentService = payments.NewService(gateway, store, orders, idempotencyStore, conf.Payments)
 	}
+
+	var aiClient *openai.Client
+	if conf.Features.AI {
+		// connect with OpenAI
+		aiClient = ai.Open(conf.OpenAIKey)
+		checker.Add("openai", func(ctx context.Context) error {
+			return ai.Ping(ctx, aiClient)
+		})
+	}
+
+	docs := openapi.NewRegistry(openapi.Info{Title: "Upgraded-Telegram API", Version: "v1"})
+	addRoutes(mux, dependencies{
+		dynamo:   dynamoClient,
+		s3:       s3Client,
+		ai:       aiClient,
+		maps:     mapClient,
+		index:    index,
+		orders:   orders,
+		rates:    rates,
+		taxes:    taxes,
+		payments: paymentService,
+		checker:  checker,
+		docs:     docs,
+		legacy:   legacy,
+	}, conf.Features)
+
+	describeRoutes(docs)
+	err = docs.Build(mux.patterns)
+	if err != nil {
+		log.Fatalf("unable to build the OpenAPI document, %v", err)
+	}
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", conf.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: conf.Timeouts.ReadHeader,
+		ReadTimeout:       conf.Timeouts.Read,
+		WriteTimeout:      conf.Timeouts.Write,
+		IdleTimeout:       conf.Timeouts.Idle,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
+	}
+	// Shutdown does not wait for hijacked connections, tell long-lived handlers to finish
+	srv.RegisterOnShutdown(checker.StartDraining)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("server started", "port", conf.Port)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		shutdownTracing(context.Background())
+		log.Fatalf("unable to start HTTP server, %v", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// fail readiness first so the load balancer stops routing here before connections close
+	slog.Info("shutting down, draining connections")
+	checker.StartDraining()
+	time.Sleep(conf.Timeouts.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), conf.Timeouts.Shutdown)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("graceful shutdown timed out, closing remaining connections", "error", err)
+		srv.Close()
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("unable to flush traces", "error", err)
+	}
+	slog.Info("server stopped")
+}
+
+// dependencies are what the routes are served with.
+type dependencies struct {
+	dynamo   *dynamodb.Client
+	s3       *s3.Client
+	ai       *openai.Client
+	maps     *maps.Client
+	index    *search.Index
+	orders   checkout.Store
+	rates    money.Source
+	taxes    *tax.Calculator
+	payments *payments.Service
+	checker  *health.Checker
+	docs     *openapi.Registry
+	legacy   *services.Deprecation // nil leaves out the unversioned routes
+}
+
+// addRoutes registers every route, the feature-flagged ones when their feature is on.
+func addRoutes(mux *router, deps dependencies, features config.Features) {
+	addUserRoutes(deps.dynamo, mux, deps.legacy)
+	addChatMessageRoutes(deps.dynamo, mux, deps.legacy)
+	addFileIORoutes(deps.s3, mux, deps.legacy)
+	addEventRoutes(deps.dynamo, mux, deps.legacy)
+	addItemRoutes(deps.dynamo, deps.index, mux, deps.legacy)
+	addCategoryRoutes(deps.dynamo, mux)
+	addOrderRoutes(deps.orders, deps.dynamo, deps.rates, deps.taxes, mux, deps.legacy)
+	addAdminRoutes(deps.dynamo, mux)
+	if features.Payments {
+		addPaymentRoutes(deps.payments, mux)
+	}
+	if features.AI {
+		addAIRoutes(deps.ai, mux)
+	}
+	if features.Maps {
+		addMapRoutes(deps.maps, mux, deps.legacy)
+	}
+	if features.Metrics {
+		addMetricsRoute(mux)
+	}
+	addHealthRoutes(deps.checker, mux)
+	addDocsRoutes(deps.docs, mux)
+	addMainRoute(mux)
 }
 
-func addMainRoute(mux *http.ServeMux) {
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+func addMainRoute(mux *router) {
+	// {$} matches only "/" itself, any other unknown path is a 404
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
 		response := map[string]interface{}{
 			"message": "Welcome to Upgraded-Telegram Server!",
 			"updated": "2025-04-07",
@@ -69,7 +348,7 @@ func addMainRoute(mux *http.ServeMux) {
 
 		jsonResponse, err := json.Marshal(response)
 		if err != nil {
-			http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+			apperrors.Write(w, r, apperrors.Internal("encode_failed", "Failed to encode response.", err))
 			return
 		}
 
@@ -79,148 +358,343 @@ func addMainRoute(mux *http.ServeMux) {
 	})
 }
 
-func addUserRoutes(client *dynamodb.Client, mux *http.ServeMux) {
-	mux.HandleFunc("/users/new", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handlers.CreateUser(client, w, r)
-	}))
-	mux.HandleFunc("/users/login", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handlers.AuthUser(client, w, r)
-	}))
-	mux.HandleFunc("/users/all", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.GetAllUsers(client, w, r)
-	})))
-	mux.HandleFunc("/users/id/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.GetUserByID(client, w, r, id)
-	})))
-	mux.HandleFunc("/users/update", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.UpdateUser(client, w, r)
-	})))
-	mux.HandleFunc("/users/delete/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.DeleteUser(client, w, r, id)
-	})))
+func addHealthRoutes(checker *health.Checker, mux *router) {
+	mux.HandleFunc("GET /healthz", checker.Liveness)
+	mux.HandleFunc("GET /readyz", checker.Readiness)
 }
 
-func addChatMessageRoutes(client *dynamodb.Client, mux *http.ServeMux) {
-	mux.HandleFunc("/chats/new", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handlers.CreateChat(client, w, r)
-	}))
-	mux.HandleFunc("/chats/chat/{id}/messages/new", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.CreateChatMessage(client, w, r, id)
+func addDocsRoutes(docs *openapi.Registry, mux *router) {
+	mux.HandleFunc("GET /openapi.json", docs.ServeSpec)
+	mux.HandleFunc("GET /docs", docs.DocsHandler("/openapi.json"))
+}
+
+func addMetricsRoute(mux *router) {
+	mux.Handle("GET /metrics", metrics.Handler())
+}
+
+func addAdminRoutes(client *dynamodb.Client, mux *router) {
+	mux.HandleFunc("GET /admin/log-level", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetLogLevel(w, r)
 	})))
-	mux.HandleFunc("/chats/all", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.GetAllChats(client, w, r)
+	mux.HandleFunc("PUT /admin/log-level", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.SetLogLevel(w, r)
 	})))
-	mux.HandleFunc("/chats/chat/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.GetChatById(client, w, r, id)
+
+	mux.HandleFunc("POST /admin/discounts", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateDiscount(client, w, r)
 	})))
-	mux.HandleFunc("/chats/chat/{chatId}/messages", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("chatId")
-		handlers.GetChatMessages(client, w, r, id)
+	mux.HandleFunc("GET /admin/discounts", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAllDiscounts(client, w, r)
 	})))
-	mux.HandleFunc("/chats/chat/update", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.UpdateChat(client, w, r)
+	mux.HandleFunc("GET /admin/discounts/{code}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetDiscount(client, w, r, r.PathValue("code"))
 	})))
-	mux.HandleFunc("/chats/chat/{id}/delete", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.DeleteChat(client, w, r, id)
+	mux.HandleFunc("PUT /admin/discounts/{code}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateDiscount(client, w, r, r.PathValue("code"))
 	})))
-	mux.HandleFunc("/chats/chat/{chatId}/messages/message/{messageId}/delete", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		chatId := r.PathValue("chatId")
-		messageId := r.PathValue("messageId")
-		handlers.DeleteChatMessage(client, w, r, chatId, messageId)
+	mux.HandleFunc("DELETE /admin/discounts/{code}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteDiscount(client, w, r, r.PathValue("code"))
 	})))
 }
 
-func addFileIORoutes(client *s3.Client, mux *http.ServeMux) {
-	mux.HandleFunc("/upload", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handlers.HandleFileUpload(client, w, r)
+// Every resource is served under /v1. When legacy is not nil, the original verb-style routes are also
+// mounted with the same handlers and marked deprecated.
+
+func addUserRoutes(client *dynamodb.Client, mux *router, legacy *services.Deprecation) {
+	createUser := services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateUser(client, w, r)
+	})
+	login := services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.AuthUser(client, w, r)
+	})
+	refresh := services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.RefreshToken(client, w, r)
+	})
+	listUsers := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAllUsers(client, w, r)
+	}))
+	getUser := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetUserByID(client, w, r, r.PathValue("id"))
+	}))
+	updateUser := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateUser(client, w, r, r.PathValue("id"))
+	}))
+	updatePassword := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdatePassword(client, w, r, r.PathValue("id"))
+	}))
+	deleteUser := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteUser(client, w, r, r.PathValue("id"))
+	}))
+
+	mux.HandleFunc("POST /v1/users", createUser)
+	mux.HandleFunc("POST /v1/auth/login", login)
+	mux.HandleFunc("POST /v1/auth/refresh", refresh)
+	mux.HandleFunc("GET /v1/users", listUsers)
+	mux.HandleFunc("GET /v1/users/{id}", getUser)
+	mux.HandleFunc("PATCH /v1/users/{id}", updateUser)
+	mux.HandleFunc("PUT /v1/users/{id}/password", updatePassword)
+	mux.HandleFunc("DELETE /v1/users/{id}", deleteUser)
+
+	if legacy != nil {
+		mux.HandleFunc("POST /users/new", legacy.Wrap("/v1/users", createUser))
+		mux.HandleFunc("POST /users/login", legacy.Wrap("/v1/auth/login", login))
+		mux.HandleFunc("GET /users/all", legacy.Wrap("/v1/users", listUsers))
+		mux.HandleFunc("GET /users/id/{id}", legacy.Wrap("/v1/users/{id}", getUser))
+		mux.HandleFunc("PUT /users/update", legacy.Wrap("/v1/users/{id}", updateUser))
+		mux.HandleFunc("DELETE /users/delete/{id}", legacy.Wrap("/v1/users/{id}", deleteUser))
+	}
+}
+
+func addChatMessageRoutes(client *dynamodb.Client, mux *router, legacy *services.Deprecation) {
+	createChat := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateChat(client, w, r)
+	}))
+	listChats := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAllChats(client, w, r)
+	}))
+	getChat := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetChatById(client, w, r, r.PathValue("id"))
+	}))
+	updateChat := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateChat(client, w, r, r.PathValue("id"))
 	}))
-	mux.HandleFunc("/download", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
-		handlers.HandleFileDownload(client, w, r)
+	deleteChat := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteChat(client, w, r, r.PathValue("id"))
+	}))
+	createMessage := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateChatMessage(client, w, r, r.PathValue("id"))
+	}))
+	listMessages := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetChatMessages(client, w, r, r.PathValue("id"))
+	}))
+	deleteMessage := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteChatMessage(client, w, r, r.PathValue("id"), r.PathValue("messageId"))
+	}))
+
+	mux.HandleFunc("POST /v1/chats", createChat)
+	mux.HandleFunc("GET /v1/chats", listChats)
+	mux.HandleFunc("GET /v1/chats/{id}", getChat)
+	mux.HandleFunc("PATCH /v1/chats/{id}", updateChat)
+	mux.HandleFunc("DELETE /v1/chats/{id}", deleteChat)
+	mux.HandleFunc("POST /v1/chats/{id}/messages", createMessage)
+	mux.HandleFunc("GET /v1/chats/{id}/messages", listMessages)
+	mux.HandleFunc("DELETE /v1/chats/{id}/messages/{messageId}", deleteMessage)
+
+	if legacy != nil {
+		mux.HandleFunc("POST /chats/new", legacy.Wrap("/v1/chats", createChat))
+		mux.HandleFunc("GET /chats/all", legacy.Wrap("/v1/chats", listChats))
+		mux.HandleFunc("GET /chats/chat/{id}", legacy.Wrap("/v1/chats/{id}", getChat))
+		mux.HandleFunc("PUT /chats/chat/update", legacy.Wrap("/v1/chats/{id}", updateChat))
+		mux.HandleFunc("DELETE /chats/chat/{id}/delete", legacy.Wrap("/v1/chats/{id}", deleteChat))
+		mux.HandleFunc("POST /chats/chat/{id}/messages/new", legacy.Wrap("/v1/chats/{id}/messages", createMessage))
+		mux.HandleFunc("GET /chats/chat/{id}/messages", legacy.Wrap("/v1/chats/{id}/messages", listMessages))
+		mux.HandleFunc("DELETE /chats/chat/{id}/messages/message/{messageId}/delete", legacy.Wrap("/v1/chats/{id}/messages/{messageId}", deleteMessage))
+	}
+}
+
+func addFileIORoutes(client *s3.Client, mux *router, legacy *services.Deprecation) {
+	upload := services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleFileUpload(client, w, r)
+	})
+
+	mux.HandleFunc("POST /v1/files", upload)
+	mux.HandleFunc("GET /v1/files/{filename...}", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleFileDownload(client, w, r, r.PathValue("filename"))
 	}))
+
+	if legacy != nil {
+		mux.HandleFunc("POST /upload", legacy.Wrap("/v1/files", upload))
+		mux.HandleFunc("POST /download", legacy.Wrap("/v1/files/{filename}", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			handlers.HandleFileDownload(client, w, r, r.URL.Query().Get("filename"))
+		})))
+	}
 }
 
-func addAIRoutes(client *openai.Client, mux *http.ServeMux) {
+func addAIRoutes(client *openai.Client, mux *router) {
 	// ready for routes!
 }
 
-func addEventRoutes(client *dynamodb.Client, mux *http.ServeMux) {
-	mux.HandleFunc("/events/new", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+func addEventRoutes(client *dynamodb.Client, mux *router, legacy *services.Deprecation) {
+	createEvent := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
 		handlers.CreateEventHandler(client, w, r)
 	}))
-	mux.HandleFunc("/events/event/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.GetEventById(client, w, r, id)
-	})))
-	mux.HandleFunc("/events/all", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+	listEvents := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
 		handlers.GetAllEvents(client, w, r)
+	}))
+	getEvent := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetEventById(client, w, r, r.PathValue("id"))
+	}))
+	updateEvent := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateEvent(client, w, r, r.PathValue("id"))
+	}))
+	deleteEvent := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteEvent(client, w, r, r.PathValue("id"))
+	}))
+
+	mux.HandleFunc("POST /v1/events", createEvent)
+	mux.HandleFunc("GET /v1/events", listEvents)
+	mux.HandleFunc("GET /v1/events/{id}", getEvent)
+	mux.HandleFunc("PATCH /v1/events/{id}", updateEvent)
+	mux.HandleFunc("DELETE /v1/events/{id}", deleteEvent)
+
+	if legacy != nil {
+		mux.HandleFunc("POST /events/new", legacy.Wrap("/v1/events", createEvent))
+		mux.HandleFunc("GET /events/all", legacy.Wrap("/v1/events", listEvents))
+		mux.HandleFunc("GET /events/event/{id}", legacy.Wrap("/v1/events/{id}", getEvent))
+		mux.HandleFunc("PUT /events/event/update", legacy.Wrap("/v1/events/{id}", updateEvent))
+		mux.HandleFunc("DELETE /events/event/{id}/delete", legacy.Wrap("/v1/events/{id}", deleteEvent))
+	}
+}
+
+func addMapRoutes(client *maps.Client, mux *router, legacy *services.Deprecation) {
+	mux.HandleFunc("GET /v1/maps/directions", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		handlers.GetDirections(client, w, r, query.Get("origin"), query.Get("destination"))
 	})))
-	mux.HandleFunc("/events/event/update", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.UpdateChat(client, w, r)
+	mux.HandleFunc("GET /v1/maps/geocode", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.Geocode(client, w, r, r.URL.Query().Get("address"))
 	})))
-	mux.HandleFunc("/events/event/{id}/delete", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.DeleteEvent(client, w, r, id)
+	mux.HandleFunc("GET /v1/maps/reverse-geocode", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		handlers.ReverseGeocode(client, w, r, query.Get("lat"), query.Get("long"))
 	})))
+
+	if legacy != nil {
+		mux.HandleFunc("GET /maps/from/{origin}/to/{destination}", legacy.Wrap("/v1/maps/directions", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+			handlers.GetDirections(client, w, r, r.PathValue("origin"), r.PathValue("destination"))
+		}))))
+		mux.HandleFunc("GET /maps/reversegeocode/lat/{lat}/long/{long}", legacy.Wrap("/v1/maps/reverse-geocode", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+			handlers.ReverseGeocode(client, w, r, r.PathValue("lat"), r.PathValue("long"))
+		}))))
+		mux.HandleFunc("GET /maps/geocode/{address}", legacy.Wrap("/v1/maps/geocode", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+			handlers.Geocode(client, w, r, r.PathValue("address"))
+		}))))
+	}
 }
 
-func addMapRoutes(client *maps.Client, mux *http.ServeMux) {
-	mux.HandleFunc("/maps/from/{origin}/to/{destination}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		a := r.PathValue("origin")
-		b := r.PathValue("destination")
-		handlers.GetDirections(client, w, r, a, b)
-	})))
-	mux.HandleFunc("/maps/reversegeocode/lat/{lat}/long/{long}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		long := r.PathValue("long")
-		lat := r.PathValue("lat")
-		handlers.ReverseGeocode(client, w, r, lat, long)
+func addItemRoutes(client *dynamodb.Client, index *search.Index, mux *router, legacy *services.Deprecation) {
+	createItem := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateItem(client, index, w, r)
+	}))
+	listItems := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetAllItems(client, w, r)
+	}))
+	getItem := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetItemById(client, w, r, r.PathValue("id"))
+	}))
+	updateItem := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateItem(client, index, w, r, r.PathValue("id"))
+	}))
+	deleteItem := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteItem(client, index, w, r, r.PathValue("id"))
+	}))
+
+	mux.HandleFunc("POST /v1/items", createItem)
+	mux.HandleFunc("GET /v1/items", listItems)
+	mux.HandleFunc("GET /v1/items/{id}", getItem)
+	mux.HandleFunc("PATCH /v1/items/{id}", updateItem)
+	mux.HandleFunc("DELETE /v1/items/{id}", deleteItem)
+	mux.HandleFunc("GET /v1/search/items", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.SearchItems(index, client, w, r)
 	})))
-	mux.HandleFunc("/maps/geocode/{address}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		address := r.PathValue("address")
-		handlers.Geocode(client, w, r, address)
+	mux.HandleFunc("POST /admin/search/rebuild", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.RebuildSearchIndex(index, w, r)
 	})))
+
+	if legacy != nil {
+		mux.HandleFunc("POST /items/new", legacy.Wrap("/v1/items", createItem))
+		mux.HandleFunc("GET /items/all", legacy.Wrap("/v1/items", listItems))
+		mux.HandleFunc("GET /items/item/{id}", legacy.Wrap("/v1/items/{id}", getItem))
+		mux.HandleFunc("PUT /items/item/update", legacy.Wrap("/v1/items/{id}", updateItem))
+		mux.HandleFunc("DELETE /items/item/{id}/delete", legacy.Wrap("/v1/items/{id}", deleteItem))
+	}
 }
 
-func addItemRoutes(client *dynamodb.Client, mux *http.ServeMux) {
-	mux.HandleFunc("/items/new", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.CreateItem(client, w, r)
+func addCategoryRoutes(client *dynamodb.Client, mux *router) {
+	mux.HandleFunc("GET /v1/categories", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetCategoryTree(client, w, r)
 	})))
-	mux.HandleFunc("/items/all", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.GetAllItems(client, w, r)
+	mux.HandleFunc("POST /v1/categories", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateCategory(client, w, r)
+	})))
+	mux.HandleFunc("GET /v1/categories/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetCategory(client, w, r, r.PathValue("id"))
 	})))
-	mux.HandleFunc("/items/item/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.GetItemById(client, w, r, id)
+	mux.HandleFunc("PUT /v1/categories/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateCategory(client, w, r, r.PathValue("id"))
 	})))
-	mux.HandleFunc("/items/item/update", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.UpdateItem(client, w, r)
+	mux.HandleFunc("DELETE /v1/categories/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteCategory(client, w, r, r.PathValue("id"))
 	})))
-	mux.HandleFunc("/items/item/{id}/delete", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.DeleteItem(client, w, r, id)
+	mux.HandleFunc("GET /v1/categories/{id}/items", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetCategoryItems(client, w, r, r.PathValue("id"))
+	})))
+	mux.HandleFunc("GET /v1/tags/{tag}/items", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetTagItems(client, w, r, r.PathValue("tag"))
 	})))
 }
 
-func addOrderRoutes(client *dynamodb.Client, mux *http.ServeMux) {
-	mux.HandleFunc("/orders/new", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.CreateOrder(client, w, r)
-	})))
-	mux.HandleFunc("/orders/all", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+func addOrderRoutes(store checkout.Store, client *dynamodb.Client, rates money.Source, taxes *tax.Calculator, mux *router, legacy *services.Deprecation) {
+	createOrder := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreateOrder(store, client, rates, taxes, w, r)
+	}))
+	listOrders := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
 		handlers.GetAllOrders(client, w, r)
+	}))
+	getOrder := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetOrderById(client, w, r, r.PathValue("id"))
+	}))
+	updateOrder := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.UpdateOrder(client, w, r, r.PathValue("id"))
+	}))
+	deleteOrder := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.DeleteOrder(store, w, r, r.PathValue("id"))
+	}))
+	cancelOrder := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CancelOrder(store, w, r, r.PathValue("id"))
+	}))
+	transitionOrder := services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.TransitionOrder(store, w, r, r.PathValue("id"))
+	}))
+
+	mux.HandleFunc("POST /v1/orders", createOrder)
+	mux.HandleFunc("GET /v1/orders", listOrders)
+	mux.HandleFunc("GET /v1/orders/{id}", getOrder)
+	mux.HandleFunc("PATCH /v1/orders/{id}", updateOrder)
+	mux.HandleFunc("DELETE /v1/orders/{id}", deleteOrder)
+	mux.HandleFunc("POST /v1/orders/{id}/cancel", cancelOrder)
+	mux.HandleFunc("POST /v1/orders/{id}/status", transitionOrder)
+	mux.HandleFunc("GET /v1/exchange-rates", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetExchangeRates(rates, w, r)
+	}))
+
+	if legacy != nil {
+		mux.HandleFunc("POST /orders/new", legacy.Wrap("/v1/orders", createOrder))
+		mux.HandleFunc("GET /orders/all", legacy.Wrap("/v1/orders", listOrders))
+		mux.HandleFunc("GET /orders/order/{id}", legacy.Wrap("/v1/orders/{id}", getOrder))
+		mux.HandleFunc("PUT /orders/order/update", legacy.Wrap("/v1/orders/{id}", updateOrder))
+		mux.HandleFunc("DELETE /orders/order/{id}/delete", legacy.Wrap("/v1/orders/{id}", deleteOrder))
+	}
+}
+
+func addPaymentRoutes(service *payments.Service, mux *router) {
+	mux.HandleFunc("POST /v1/orders/{id}/payments", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CreatePayment(service, w, r, r.PathValue("id"))
 	})))
-	mux.HandleFunc("/orders/order/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.GetOrderById(client, w, r, id)
+	mux.HandleFunc("GET /v1/payments/{id}", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.GetPayment(service, w, r, r.PathValue("id"))
 	})))
-	mux.HandleFunc("/orders/order/update", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		handlers.UpdateOrder(client, w, r)
+	mux.HandleFunc("POST /v1/payments/{id}/confirm", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.ConfirmPayment(service, w, r, r.PathValue("id"))
 	})))
-	mux.HandleFunc("/orders/order/{id}/delete", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
-		id := r.PathValue("id")
-		handlers.DeleteOrder(client, w, r, id)
+	mux.HandleFunc("POST /v1/payments/{id}/capture", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.CapturePayment(service, w, r, r.PathValue("id"))
 	})))
+	mux.HandleFunc("POST /v1/payments/{id}/refunds", services.LoggerMiddleware(services.VerifyJWT(func(w http.ResponseWriter, r *http.Request) {
+		handlers.RefundPayment(service, w, r, r.PathValue("id"))
+	})))
+	// signed by the gateway instead of carrying a token
+	mux.HandleFunc("POST /v1/payments/webhook", services.LoggerMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handlers.PaymentWebhook(service, w, r)
+	}))
 }
@@ -1,26 +1,33 @@
 package ai
 
 import (
-	"log"
-	"os"
+	"context"
+	"log/slog"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/metrics"
+	"upgraded-telegram/main.go/server/services/tracing"
 
-	"github.com/joho/godotenv"
 	"github.com/openai/openai-go" // imported as openai
 	"github.com/openai/openai-go/option"
 )
 
-func Open() *openai.Client {
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	key := os.Getenv("OPENAI_API_KEY")
+// Timeout bounds every OpenAI request, set it before calling Open.
+var Timeout = 60 * time.Second
 
+func Open(key string) *openai.Client {
 	client := openai.NewClient(
 		option.WithAPIKey(key),
+		option.WithMiddleware(metrics.HTTPMiddleware("openai")),
+		option.WithHTTPClient(tracing.HTTPClient()),
+		option.WithRequestTimeout(Timeout),
 	)
-	log.Printf("Connected to OpenAI\n")
+	slog.Info("connected to OpenAI")
 	return &client
 }
+
+// Ping lists models, which needs a valid key but does not spend tokens.
+func Ping(ctx context.Context, client *openai.Client) error {
+	_, err := client.Models.List(ctx)
+	return err
+}
@@ -0,0 +1,187 @@
+package apperrors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// problem details per RFC 7807 https://www.rfc-editor.org/rfc/rfc7807
+
+const (
+	ProblemContentType = "application/problem+json"
+	RequestIDHeader    = "X-Request-ID"
+
+	// StatusClientClosedRequest is the nginx convention for a request the client abandoned.
+	StatusClientClosedRequest = 499
+)
+
+// Error is a domain error that knows how it should be reported to the client.
+// Code is a stable, machine-readable identifier; Err is the underlying cause and is never sent to the client.
+type Error struct {
+	Status int
+	Code   string
+	Detail string
+	Fields []FieldError
+	Err    error
+}
+
+// FieldError describes a single invalid field in a request payload.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Detail, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithCause attaches the underlying error for logging.
+func (e *Error) WithCause(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// WithFields lists the fields or records the error is about, for example the items a checkout could not reserve.
+func (e *Error) WithFields(fields []FieldError) *Error {
+	e.Fields = fields
+	return e
+}
+
+func newError(status int, code, detail string) *Error {
+	return &Error{Status: status, Code: code, Detail: detail}
+}
+
+func BadRequest(code, detail string) *Error {
+	return newError(http.StatusBadRequest, code, detail)
+}
+
+func Validation(code, detail string) *Error {
+	return newError(http.StatusUnprocessableEntity, code, detail)
+}
+
+func InvalidFields(fields []FieldError) *Error {
+	appErr := newError(http.StatusUnprocessableEntity, "validation_failed", "One or more fields are invalid.")
+	appErr.Fields = fields
+	return appErr
+}
+
+func Unauthorized(code, detail string) *Error {
+	return newError(http.StatusUnauthorized, code, detail)
+}
+
+func Forbidden(code, detail string) *Error {
+	return newError(http.StatusForbidden, code, detail)
+}
+
+func NotFound(code, detail string) *Error {
+	return newError(http.StatusNotFound, code, detail)
+}
+
+func MethodNotAllowed(code, detail string) *Error {
+	return newError(http.StatusMethodNotAllowed, code, detail)
+}
+
+func Conflict(code, detail string) *Error {
+	return newError(http.StatusConflict, code, detail)
+}
+
+func PayloadTooLarge(code, detail string) *Error {
+	return newError(http.StatusRequestEntityTooLarge, code, detail)
+}
+
+func TooManyRequests(code, detail string) *Error {
+	return newError(http.StatusTooManyRequests, code, detail)
+}
+
+func Unavailable(code, detail string) *Error {
+	return newError(http.StatusServiceUnavailable, code, detail)
+}
+
+func Internal(code, detail string, err error) *Error {
+	return newError(http.StatusInternalServerError, code, detail).WithCause(err)
+}
+
+// Problem is the application/problem+json response body.
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// From converts any error into a domain error. Expired and canceled contexts become 504 and 499,
+// a body cut off by http.MaxBytesReader becomes 413;
+// any other error becomes a generic internal error so that driver and SDK messages never reach the client.
+func From(err error) *Error {
+	// checked first so a timeout wrapped in an internal error still reports as a timeout
+	if errors.Is(err, context.DeadlineExceeded) {
+		return newError(http.StatusGatewayTimeout, "upstream_timeout", "A dependency did not respond in time.").WithCause(err)
+	}
+	if errors.Is(err, context.Canceled) {
+		return newError(StatusClientClosedRequest, "client_closed_request", "The client closed the request.").WithCause(err)
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return PayloadTooLarge("body_too_large", fmt.Sprintf("Request body must not exceed %d bytes.", maxBytesErr.Limit)).WithCause(err)
+	}
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal("internal_error", "An unexpected error occurred.", err)
+}
+
+func statusTitle(status int) string {
+	if status == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(status)
+}
+
+func NewProblem(r *http.Request, appErr *Error) Problem {
+	return Problem{
+		Type:      "about:blank",
+		Title:     statusTitle(appErr.Status),
+		Status:    appErr.Status,
+		Detail:    appErr.Detail,
+		Instance:  r.URL.Path,
+		Code:      appErr.Code,
+		RequestID: r.Header.Get(RequestIDHeader),
+		Errors:    appErr.Fields,
+	}
+}
+
+// Write sends err to the client as a problem details document.
+func Write(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := From(err)
+	if appErr.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "request failed", "code", appErr.Code, "status", appErr.Status, "error", appErr)
+	}
+
+	body, marshalErr := json.Marshal(NewProblem(r, appErr))
+	if marshalErr != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(appErr.Status)
+	w.Write(body)
+}
@@ -1,13 +1,19 @@
 package services
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"net/http"
+	"strings"
 	"time"
 
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/config"
+	"upgraded-telegram/main.go/server/services/db"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/golang-jwt/jwt"
-	"github.com/joho/godotenv"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -26,27 +32,70 @@ var (
 	RefreshTokenSecret string
 	AccessTokenTTL     = time.Minute * 15
 	RefreshTokenTTL    = time.Hour * 24 * 7
+
+	// roles granted at startup by lower-cased email, these addresses cannot be taken by anyone else
+	roles = map[string]string{}
+)
+
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+	RoleSystem   = "system" // changes made by the server itself, such as payment webhooks, never put into a token
 )
 
-// Load .env once at startup
-func InitAuth() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+// InitAuth sets the token secrets and lifetimes once at startup, the configuration is already validated
+func InitAuth(c config.Auth) {
+	AccessTokenSecret = c.TokenSecret
+	RefreshTokenSecret = c.RefreshTokenSecret
+	AccessTokenTTL = c.AccessTokenTTL
+	RefreshTokenTTL = c.RefreshTokenTTL
+
+	roles = map[string]string{}
+	for _, email := range config.List(c.StaffEmails) {
+		roles[strings.ToLower(email)] = RoleStaff
+	}
+	for _, email := range config.List(c.AdminEmails) {
+		roles[strings.ToLower(email)] = RoleAdmin
 	}
+}
 
-	AccessTokenSecret = os.Getenv("TOKEN_SECRET")
-	RefreshTokenSecret = os.Getenv("REFRESH_TOKEN_SECRET")
+// ReservedEmail reports whether email is one of ADMIN_EMAILS or STAFF_EMAILS. Signing up with it or changing an
+// email to it is refused, so that only the account that had it when it was configured gets the role.
+func ReservedEmail(email string) bool {
+	_, ok := roles[strings.ToLower(email)]
+	return ok
+}
 
-	if AccessTokenSecret == "" || RefreshTokenSecret == "" {
-		log.Fatal("TOKEN_SECRET or REFRESH_TOKEN_SECRET is missing")
+// GrantConfiguredRoles stores the role of ADMIN_EMAILS and STAFF_EMAILS on the accounts that have those emails.
+// It runs once at startup, an address nobody has signed up with yet grants nothing.
+func GrantConfiguredRoles(ctx context.Context, client *dynamodb.Client) error {
+	for email, role := range roles {
+		user, err := db.GetUserByEmail(ctx, client, db.Tables.Users, email)
+		if err != nil {
+			if apperrors.From(err).Status == http.StatusNotFound {
+				slog.WarnContext(ctx, "no account for configured role", "role", role, "email", email)
+				continue
+			}
+			return err
+		}
+		if user.Role == role {
+			continue
+		}
+		err = db.UpdateUser(ctx, client, db.Tables.Users, db.User{ID: user.ID, Role: role})
+		if err != nil {
+			return err
+		}
+		slog.InfoContext(ctx, "granted configured role", "role", role, "user", user.ID)
 	}
+	return nil
 }
 
 type UserClaims struct {
 	ID    string `json:"id"`
 	Name  string `json:"name"`
 	Email string `json:"email"`
+	Role  string `json:"role,omitempty"` // empty in tokens issued before roles existed, treated as customer
 	jwt.StandardClaims
 }
 
@@ -69,13 +118,13 @@ func ParseAccessToken(accessToken string) *UserClaims {
 		return []byte(AccessTokenSecret), nil
 	})
 	if err != nil || !parsedAccessToken.Valid {
-		fmt.Println("Token verification failed:", err) // Debugging output
+		slog.Debug("access token verification failed", "error", err)
 		return nil
 	}
 
 	claims, ok := parsedAccessToken.Claims.(*UserClaims)
 	if !ok {
-		fmt.Println("Failed to cast token claims")
+		slog.Debug("failed to cast access token claims")
 		return nil
 	}
 
@@ -91,13 +140,13 @@ func ParseRefreshToken(refreshToken string) *jwt.StandardClaims {
 		return []byte(RefreshTokenSecret), nil
 	})
 	if err != nil || !parsedRefreshToken.Valid {
-		fmt.Println("Refresh token verification failed:", err)
+		slog.Debug("refresh token verification failed", "error", err)
 		return nil
 	}
 
 	claims, ok := parsedRefreshToken.Claims.(*jwt.StandardClaims)
 	if !ok {
-		fmt.Println("Failed to cast refresh token claims")
+		slog.Debug("failed to cast refresh token claims")
 		return nil
 	}
 
@@ -3,30 +3,34 @@ package services
 import (
 	"context"
 	"log"
-	"os"
+
+	appconfig "upgraded-telegram/main.go/server/services/config"
+	"upgraded-telegram/main.go/server/services/metrics"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
 )
 
-func StartAws() aws.Config {
+func StartAws(c appconfig.AWS) aws.Config {
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	options := []func(*config.LoadOptions) error{config.WithRegion(c.Region)}
+	// without static keys the SDK falls back to its default chain (environment, shared config, task or instance role)
+	if c.AccessKeyID != "" {
+		options = append(options, config.WithCredentialsProvider(aws.NewCredentialsCache(
+			credentials.NewStaticCredentialsProvider(c.AccessKeyID, c.SecretAccessKey, ""))))
 	}
 
-	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
-	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
-	region := os.Getenv("AWS_REGION")
+	if c.Endpoint != "" {
+		options = append(options, config.WithBaseEndpoint(c.Endpoint))
+	}
 
-	// Load AWS SDK configuration using environment variables
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(region),
-		config.WithCredentialsProvider(aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(accessKey, secretKey, ""))),
-	)
+	cfg, err := config.LoadDefaultConfig(context.TODO(), options...)
+	if err == nil {
+		cfg.APIOptions = append(cfg.APIOptions, metrics.AWSMiddleware)
+		otelaws.AppendMiddlewares(&cfg.APIOptions)
+	}
 	if err != nil {
 		log.Fatal("Error loading AWS Config.")
 	}
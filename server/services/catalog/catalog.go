@@ -0,0 +1,145 @@
+// Package catalog checks items, their variants, categories and tags before they are stored, and arranges
+// categories into a tree.
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// Prepare normalizes an item about to be stored, whose ID must be set, and checks what the validate tags cannot
+// reach: explicit prices, variants and tags. Base prices without a currency are in the default one and tags are
+// lower case without duplicates. An item with variants gets the default variant's prices and the total stock of
+// its variants; with a single variant the default SKU may be left out.
+func Prepare(item *db.Item) error {
+	var fields []apperrors.FieldError
+	fields = append(fields, prices("", &item.Price, item.Prices)...)
+
+	if len(item.Variants) == 0 {
+		if item.DefaultSKU != "" {
+			fields = append(fields, apperrors.FieldError{Field: "default_sku", Code: "variant_not_found", Message: "must be one of the item's variants"})
+		}
+	} else {
+		skus := make([]string, 0, len(item.Variants))
+		for sku := range item.Variants {
+			skus = append(skus, sku)
+		}
+		sort.Strings(skus)
+		if item.DefaultSKU == "" && len(skus) == 1 {
+			item.DefaultSKU = skus[0]
+		}
+
+		inventory := 0
+		for _, sku := range skus {
+			variant := item.Variants[sku]
+			field := "variants." + sku
+			switch {
+			case !ValidSKU(sku):
+				fields = append(fields, apperrors.FieldError{Field: field, Code: "invalid_sku", Message: "must be 1 to 64 letters, digits, '-', '_' or '.'"})
+			case sku == item.ID:
+				fields = append(fields, apperrors.FieldError{Field: field, Code: "invalid_sku", Message: "must differ from the item's id"})
+			}
+			fields = append(fields, prices(field+".", &variant.Price, variant.Prices)...)
+			for name := range variant.Attributes {
+				if strings.TrimSpace(name) == "" {
+					fields = append(fields, apperrors.FieldError{Field: field + ".attributes", Code: "invalid_attribute", Message: "names must not be empty"})
+				}
+			}
+			item.Variants[sku] = variant
+			inventory += variant.Inventory
+		}
+
+		if variant, ok := item.Variants[item.DefaultSKU]; ok {
+			item.Price = variant.Price
+			item.Prices = variant.Prices
+			item.Inventory = inventory
+		} else {
+			fields = append(fields, apperrors.FieldError{Field: "default_sku", Code: "variant_not_found", Message: "must be one of the item's variants"})
+		}
+	}
+
+	for name := range item.Attributes {
+		if strings.TrimSpace(name) == "" {
+			fields = append(fields, apperrors.FieldError{Field: "attributes", Code: "invalid_attribute", Message: "names must not be empty"})
+		}
+	}
+	item.Categories = unique(item.Categories, strings.TrimSpace)
+	tags, ok := Tags(item.Tags)
+	if !ok {
+		fields = append(fields, apperrors.FieldError{Field: "tags", Code: "invalid_tag", Message: "must be 1 to 50 characters"})
+	}
+	item.Tags = tags
+
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+// prices puts a base price without a currency in the default one and checks explicit prices.
+func prices(prefix string, price *money.Money, explicit map[string]int64) []apperrors.FieldError {
+	if price.Currency == "" {
+		price.Currency = money.Default
+	}
+	var fields []apperrors.FieldError
+	for currency, amount := range explicit {
+		field := fmt.Sprintf("%sprices.%s", prefix, currency)
+		switch {
+		case !money.Valid(currency):
+			fields = append(fields, apperrors.FieldError{Field: field, Code: "invalid_currency", Message: "must be keyed by a supported ISO 4217 currency code"})
+		case amount < 0:
+			fields = append(fields, apperrors.FieldError{Field: field, Code: "too_small", Message: "must be at least 0"})
+		}
+	}
+	return fields
+}
+
+// ValidSKU tells whether sku can name a variant.
+func ValidSKU(sku string) bool {
+	if sku == "" || len(sku) > 64 {
+		return false
+	}
+	for _, c := range sku {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// Tag normalizes a tag as it is stored and looked up.
+func Tag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// Tags normalizes tags, dropping duplicates. It reports false when a tag is empty or too long.
+func Tags(tags []string) ([]string, bool) {
+	ok := true
+	for _, tag := range tags {
+		if n := len([]rune(Tag(tag))); n == 0 || n > 50 {
+			ok = false
+		}
+	}
+	return unique(tags, Tag), ok
+}
+
+func unique(values []string, normalize func(string) string) []string {
+	if values == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	out := []string{}
+	for _, value := range values {
+		value = normalize(value)
+		if value != "" && !seen[value] {
+			seen[value] = true
+			out = append(out, value)
+		}
+	}
+	return out
+}
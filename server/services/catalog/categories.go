@@ -0,0 +1,124 @@
+package catalog
+
+import (
+	"sort"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+// Node is a category with its subcategories.
+type Node struct {
+	db.Category
+	Children []Node `json:"children"`
+}
+
+// ValidSlug tells whether id can name a category: lower case letters, digits and single dashes between them.
+func ValidSlug(id string) bool {
+	if id == "" || len(id) > 64 || id[0] == '-' || id[len(id)-1] == '-' {
+		return false
+	}
+	for i, c := range id {
+		if c == '-' && id[i-1] == '-' {
+			return false
+		}
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// Tree arranges categories under their parents, siblings ordered by position then name. Categories whose parent
+// no longer exists are shown at the top level.
+func Tree(categories []db.Category) []Node {
+	byID := index(categories)
+	children := map[string][]db.Category{}
+	for _, c := range categories {
+		parent := c.Parent
+		if _, ok := byID[parent]; !ok {
+			parent = ""
+		}
+		children[parent] = append(children[parent], c)
+	}
+
+	var build func(parent string, seen map[string]bool) []Node
+	build = func(parent string, seen map[string]bool) []Node {
+		list := children[parent]
+		sort.Slice(list, func(a, b int) bool {
+			if list[a].Position != list[b].Position {
+				return list[a].Position < list[b].Position
+			}
+			return list[a].Name < list[b].Name
+		})
+		nodes := []Node{}
+		for _, c := range list {
+			if seen[c.ID] {
+				continue
+			}
+			seen[c.ID] = true
+			nodes = append(nodes, Node{Category: c, Children: build(c.ID, seen)})
+		}
+		return nodes
+	}
+	return build("", map[string]bool{})
+}
+
+// Descendants returns id and the ids of all categories below it.
+func Descendants(categories []db.Category, id string) []string {
+	children := map[string][]string{}
+	for _, c := range categories {
+		children[c.Parent] = append(children[c.Parent], c.ID)
+	}
+	ids := []string{id}
+	seen := map[string]bool{id: true}
+	for i := 0; i < len(ids); i++ {
+		for _, child := range children[ids[i]] {
+			if !seen[child] {
+				seen[child] = true
+				ids = append(ids, child)
+			}
+		}
+	}
+	return ids
+}
+
+// Ancestors returns the categories above id, from the top level down to its parent.
+func Ancestors(categories []db.Category, id string) []db.Category {
+	byID := index(categories)
+	ancestors := []db.Category{}
+	seen := map[string]bool{id: true}
+	for parent := byID[id].Parent; parent != "" && !seen[parent]; parent = byID[parent].Parent {
+		c, ok := byID[parent]
+		if !ok {
+			break
+		}
+		seen[parent] = true
+		ancestors = append([]db.Category{c}, ancestors...)
+	}
+	return ancestors
+}
+
+// CheckParent checks that category id can be moved under parent: the parent exists and is neither id nor below it.
+func CheckParent(categories []db.Category, id, parent string) error {
+	if parent == "" {
+		return nil
+	}
+	if _, ok := index(categories)[parent]; !ok {
+		return apperrors.InvalidFields([]apperrors.FieldError{{Field: "parent", Code: "category_not_found", Message: "must be an existing category"}})
+	}
+	for _, below := range Descendants(categories, id) {
+		if below == parent {
+			return apperrors.InvalidFields([]apperrors.FieldError{{Field: "parent", Code: "category_cycle", Message: "must not be the category itself or one below it"}})
+		}
+	}
+	return nil
+}
+
+func index(categories []db.Category) map[string]db.Category {
+	byID := make(map[string]db.Category, len(categories))
+	for _, c := range categories {
+		byID[c.ID] = c
+	}
+	return byID
+}
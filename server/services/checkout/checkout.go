@@ -0,0 +1,106 @@
+package checkout
+
+import (
+	"context"
+	"fmt"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+	"upgraded-telegram/main.go/server/services/promotions"
+)
+
+// Store places and cancels orders together with the inventory they hold. Every implementation must
+// make each call atomic: either the order and all of its stock changes are written, or none are.
+type Store interface {
+	// PlaceOrder reserves the quantity of every line and writes the priced order. With a code, the code as it was
+	// evaluated for the order, one redemption of it is recorded for the order's user in the same write. When any
+	// line cannot be reserved or the code can no longer be redeemed nothing is written and the error is a 409
+	// listing every line that failed.
+	PlaceOrder(ctx context.Context, order db.Order, code *db.DiscountCode) error
+
+	// Transition moves an order to change.To if orderstatus.Check allows it, appending change to its history.
+	// A transition to canceled returns the order's stock and its discount code redemption in the same write,
+	// so it happens exactly once.
+	Transition(ctx context.Context, id string, change db.StatusChange) (db.Order, error)
+
+	// DeleteOrder removes an order that is canceled or refunded, whose stock and redemption the transition there
+	// already settled. Any other order is a 409: deleting it would keep its stock reserved for good.
+	DeleteOrder(ctx context.Context, id string) error
+}
+
+// MaxLines keeps a checkout within one DynamoDB transaction, which holds at most 100 actions:
+// at most one per line plus the order itself.
+const MaxLines = 99
+
+// MaxLinesWithCode leaves room for the two writes that redeem a discount code.
+const MaxLinesWithCode = MaxLines - 2
+
+// reasons a line could not be reserved, checked in this order
+const (
+	itemNotFound      = "item_not_found"
+	itemInactive      = "item_inactive"
+	variantNotFound   = "variant_not_found"
+	priceChanged      = "price_changed"
+	insufficientStock = "insufficient_stock"
+)
+
+// check compares a line with the item as stored, item is nil when it does not exist.
+func check(index int, line db.LineItem, item *db.Item) *apperrors.FieldError {
+	field := fmt.Sprintf("items[%d]", index)
+	if item == nil {
+		return &apperrors.FieldError{Field: field + ".item_id", Code: itemNotFound, Message: fmt.Sprintf("item %s does not exist", line.ItemID)}
+	}
+	if !item.Active {
+		return &apperrors.FieldError{Field: field + ".item_id", Code: itemInactive, Message: fmt.Sprintf("item %s is not available for sale", line.ItemID)}
+	}
+	sku, variant, ok := item.Variant(line.SKU)
+	switch {
+	case !ok:
+		return &apperrors.FieldError{Field: field + ".sku", Code: variantNotFound, Message: fmt.Sprintf("item %s has no variant %s", line.ItemID, line.SKU)}
+	case variant.PriceIn(line.UnitPrice.Currency) != line.ListPrice:
+		return &apperrors.FieldError{Field: field + ".unit_price", Code: priceChanged, Message: fmt.Sprintf("item %s now costs %s", line.ItemID, variant.PriceIn(line.UnitPrice.Currency))}
+	case variant.Inventory < line.Quantity:
+		return &apperrors.FieldError{Field: field + ".quantity", Code: insufficientStock, Message: fmt.Sprintf("only %d of item %s %s available", max(variant.Inventory, 0), line.ItemID, sku)}
+	}
+	return nil
+}
+
+// checkCode compares the code an order was priced with to the code as stored, stored is nil when it was deleted.
+// used is how often the order's user already redeemed it.
+func checkCode(code db.DiscountCode, stored *db.DiscountCode, used int, now int64) *apperrors.FieldError {
+	reason := ""
+	switch {
+	case stored == nil:
+		reason = promotions.CodeNotFound
+	case stored.UpdatedAt != code.UpdatedAt:
+		reason = promotions.CodeChanged
+	default:
+		reason = promotions.Unavailable(*stored, now)
+		if reason == "" && stored.MaxPerUser != 0 && used >= stored.MaxPerUser {
+			reason = promotions.CodeLimitReached
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+	fe := promotions.Field(reason, code.Code)
+	return &fe
+}
+
+func notReserved(fields []apperrors.FieldError) error {
+	return apperrors.Conflict("checkout_failed", "Some items or the discount code could not be reserved, nothing was ordered.").WithFields(fields)
+}
+
+// deletable statuses have nothing left to return
+func deletable(status string) bool {
+	return status == orderstatus.Canceled || status == orderstatus.Refunded
+}
+
+func notDeletable(id, status string) error {
+	return apperrors.Conflict("order_not_deletable", fmt.Sprintf("Order %s is %s, only canceled or refunded orders can be deleted.", id, status))
+}
+
+func orderNotFound(id string) error {
+	return apperrors.NotFound("order_not_found", fmt.Sprintf("No order found with id %s.", id))
+}
@@ -0,0 +1,574 @@
+package checkout
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+	"upgraded-telegram/main.go/server/services/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxAttempts bounds the retries after a transaction lost a race with another write to the same records.
+const maxAttempts = 5
+
+var errContention = apperrors.Conflict("checkout_contention", "The order or its items are being changed by other requests, try again.")
+
+// DynamoStore writes each checkout and cancellation with a single TransactWriteItems call.
+type DynamoStore struct {
+	client      *dynamodb.Client
+	items       string
+	orders      string
+	codes       string
+	redemptions string
+}
+
+func NewDynamoStore(client *dynamodb.Client, itemsTable, ordersTable, codesTable, redemptionsTable string) *DynamoStore {
+	return &DynamoStore{client: client, items: itemsTable, orders: ordersTable, codes: codesTable, redemptions: redemptionsTable}
+}
+
+// PlaceOrder decrements the inventory of every line, on the condition that the item is still active, its variant
+// still has the price the order was priced with and has enough stock, and puts the order, all in one transaction.
+// With a code the same transaction adds one to the code's and the user's redemption counts, on the condition that
+// the code is unchanged since it was evaluated and neither limit has been reached.
+func (s *DynamoStore) PlaceOrder(ctx context.Context, order db.Order, code *db.DiscountCode) error {
+	ctx, span := tracing.Start(ctx, "checkout.PlaceOrder", attribute.Int("order.lines", len(order.Items)))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, db.Timeout)
+	defer cancel()
+
+	if len(order.Items) > MaxLines {
+		return apperrors.Validation("too_many_lines", "An order can have at most "+strconv.Itoa(MaxLines)+" lines.")
+	}
+	if code != nil && len(order.Items) > MaxLinesWithCode {
+		return apperrors.Validation("too_many_lines", "An order with a discount code can have at most "+strconv.Itoa(MaxLinesWithCode)+" lines.")
+	}
+	orderItem, err := attributevalue.MarshalMap(order)
+	if err != nil {
+		return err
+	}
+
+	groups := byItem(order.Items)
+	actions := make([]types.TransactWriteItem, 0, len(groups)+3)
+	for _, group := range groups {
+		actions = append(actions, types.TransactWriteItem{Update: s.reserve(order.Items, group)})
+	}
+	if code != nil {
+		actions = append(actions, s.redeem(order, *code)...)
+	}
+	actions = append(actions, types.TransactWriteItem{Put: &types.Put{
+		TableName:           aws.String(s.orders),
+		Item:                orderItem,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	}})
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			err = wait(ctx, attempt)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: actions})
+		var canceled *types.TransactionCanceledException
+		if !errors.As(err, &canceled) {
+			return err
+		}
+
+		var fields []apperrors.FieldError
+		for i, reason := range canceled.CancellationReasons {
+			if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+				continue
+			}
+			if i == len(actions)-1 {
+				return apperrors.Conflict("order_exists", "An order with this id already exists.").WithCause(err)
+			}
+			if i >= len(groups) {
+				if fe := s.codeFailure(order, *code, i == len(groups), reason.Item); fe != nil {
+					fields = append(fields, *fe)
+				}
+				continue
+			}
+			var stored *db.Item
+			if reason.Item != nil {
+				var item db.Item
+				if attributevalue.UnmarshalMap(reason.Item, &item) == nil {
+					stored = &item
+				}
+			}
+			for _, j := range groups[i].lines {
+				if fe := check(j, order.Items[j], stored); fe != nil {
+					fields = append(fields, *fe)
+				}
+			}
+		}
+		if len(fields) > 0 {
+			return notReserved(fields)
+		}
+		// a TransactionConflict, or stock that came back after the condition failed: try again
+	}
+	return errContention
+}
+
+// group is the lines of an order that change the same item, which a transaction can only write once.
+type group struct {
+	id    string
+	lines []int
+}
+
+// byItem groups lines by item, in order of first appearance.
+func byItem(lines []db.LineItem) []group {
+	var groups []group
+	index := map[string]int{}
+	for i, line := range lines {
+		j, ok := index[line.ItemID]
+		if !ok {
+			j = len(groups)
+			index[line.ItemID] = j
+			groups = append(groups, group{id: line.ItemID})
+		}
+		groups[j].lines = append(groups[j].lines, i)
+	}
+	return groups
+}
+
+// sku returns the variant a line ordered, lines of items without variants and lines placed before items had
+// variants order the item itself.
+func sku(line db.LineItem) string {
+	if line.SKU == "" {
+		return line.ItemID
+	}
+	return line.SKU
+}
+
+// plain tells whether the lines of g order an item without variants.
+func (g group) plain(lines []db.LineItem) bool {
+	for _, i := range g.lines {
+		if sku(lines[i]) != g.id {
+			return false
+		}
+	}
+	return true
+}
+
+// reserve decrements the stock of the lines of one item, on the condition that it is active, has enough stock and
+// is still priced as the lines were: with the same explicit price in the order's currency, or with no explicit
+// price and the same base price. Base prices stored as a bare number are in the default currency. The lines of an
+// item with variants also decrement the stock of their variant, whose stock and price are checked instead.
+func (s *DynamoStore) reserve(lines []db.LineItem, g group) *types.Update {
+	update := &types.Update{
+		TableName: aws.String(s.items),
+		Key:       key(g.id),
+		ExpressionAttributeNames: map[string]string{
+			"#inventory": "inventory",
+			"#active":    "active",
+			"#price":     "price",
+			"#prices":    "prices",
+			"#amount":    "amount",
+			"#currency":  "currency",
+			"#order":     lines[g.lines[0]].UnitPrice.Currency,
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":active": &types.AttributeValueMemberBOOL{Value: true},
+		},
+		// the item as it was lets a failure say which condition each line broke
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+
+	plain := g.plain(lines)
+	var total int64
+	set := []string{"#inventory = #inventory - :total"}
+	conditions := []string{"attribute_exists(id)", "#active = :active"}
+	if plain {
+		conditions = append(conditions, "attribute_not_exists(#variants)", "#inventory >= :total")
+	}
+	for n, i := range g.lines {
+		line := lines[i]
+		suffix := strconv.Itoa(n)
+		variant := ""
+		if !plain {
+			variant = "#variants.#s" + suffix + "."
+			update.ExpressionAttributeNames["#s"+suffix] = sku(line)
+			update.ExpressionAttributeValues[":q"+suffix] = number(int64(line.Quantity))
+			set = append(set, variant+"#inventory = "+variant+"#inventory - :q"+suffix)
+			conditions = append(conditions, variant+"#inventory >= :q"+suffix)
+		}
+		total += int64(line.Quantity)
+		conditions = append(conditions, "("+priced(line, variant, suffix, update.ExpressionAttributeValues)+")")
+	}
+	update.ExpressionAttributeNames["#variants"] = "variants"
+	update.ExpressionAttributeValues[":total"] = number(total)
+	update.UpdateExpression = aws.String("SET " + strings.Join(set, ", "))
+	update.ConditionExpression = aws.String(strings.Join(conditions, " AND "))
+	return update
+}
+
+// priced is the condition that the prices at path, the item or one of its variants, still give line its list
+// price, adding the line's price to values under suffix.
+func priced(line db.LineItem, path, suffix string, values map[string]types.AttributeValue) string {
+	values[":amount"+suffix] = number(line.ListPrice.Amount)
+	values[":currency"+suffix] = &types.AttributeValueMemberS{Value: line.ListPrice.Currency}
+
+	base := path + "#price.#amount = :amount" + suffix + " AND " + path + "#price.#currency = :currency" + suffix
+	if path == "" && line.ListPrice.Currency == money.Default {
+		base = "(" + base + ") OR #price = :amount" + suffix
+	}
+	price := "attribute_not_exists(" + path + "#prices.#order) AND (" + base + ")"
+	if line.ListPrice.Currency == line.UnitPrice.Currency {
+		price = path + "#prices.#order = :amount" + suffix + " OR (" + price + ")"
+	}
+	return price
+}
+
+// redeem counts one redemption of code by the order's user, conditioned on the code's limits.
+func (s *DynamoStore) redeem(order db.Order, code db.DiscountCode) []types.TransactWriteItem {
+	user := types.Update{
+		TableName:        aws.String(s.redemptions),
+		Key:              key(db.RedemptionID(code.Code, order.User)),
+		UpdateExpression: aws.String("SET #code = :code, #user = :user, updated_at = :now ADD #count :one, orders :order"),
+		ExpressionAttributeNames: map[string]string{
+			"#code":  "code",
+			"#user":  "user",
+			"#count": "count",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":code":  &types.AttributeValueMemberS{Value: code.Code},
+			":user":  &types.AttributeValueMemberS{Value: order.User},
+			":now":   number(order.CreatedAt),
+			":one":   number(1),
+			":order": &types.AttributeValueMemberSS{Value: []string{order.ID}},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	if code.MaxPerUser != 0 {
+		user.ConditionExpression = aws.String("attribute_not_exists(#count) OR #count < :limit")
+		user.ExpressionAttributeValues[":limit"] = number(int64(code.MaxPerUser))
+	}
+
+	return []types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName:           aws.String(s.codes),
+			Key:                 key(code.Code),
+			UpdateExpression:    aws.String("ADD redemptions :one"),
+			ConditionExpression: aws.String("updated_at = :version AND active = :active AND starts_at <= :now AND (ends_at = :zero OR ends_at > :now) AND (max_redemptions = :zero OR redemptions < max_redemptions)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":one":     number(1),
+				":version": number(code.UpdatedAt),
+				":active":  &types.AttributeValueMemberBOOL{Value: true},
+				":now":     number(order.CreatedAt),
+				":zero":    number(0),
+			},
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		}},
+		{Update: &user},
+	}
+}
+
+// codeFailure explains a failed redemption from the record it was conditioned on, onCode tells the code's
+// record from the user's.
+func (s *DynamoStore) codeFailure(order db.Order, code db.DiscountCode, onCode bool, old map[string]types.AttributeValue) *apperrors.FieldError {
+	if !onCode {
+		var redemption db.Redemption
+		_ = attributevalue.UnmarshalMap(old, &redemption)
+		return checkCode(code, &code, redemption.Count, order.CreatedAt)
+	}
+	if old == nil {
+		return checkCode(code, nil, 0, order.CreatedAt)
+	}
+	var stored db.DiscountCode
+	err := attributevalue.UnmarshalMap(old, &stored)
+	if err != nil {
+		return nil
+	}
+	// the per-user limit is the other record's condition
+	return checkCode(code, &stored, 0, order.CreatedAt)
+}
+
+// Transition sets the order's status and appends to its history, on the condition that the status has not changed
+// since the order was read. A cancellation adds every line back to its item's and variant's inventory in the same
+// transaction.
+func (s *DynamoStore) Transition(ctx context.Context, id string, change db.StatusChange) (db.Order, error) {
+	ctx, span := tracing.Start(ctx, "checkout.Transition", attribute.String("order.status", change.To))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, db.Timeout)
+	defer cancel()
+
+	// stock of items, variants and redemptions of codes deleted since the order was placed have nowhere to go back to
+	deleted := map[string]bool{}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if attempt > 0 {
+			err := wait(ctx, attempt)
+			if err != nil {
+				return db.Order{}, err
+			}
+		}
+
+		result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(s.orders),
+			Key:            key(id),
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return db.Order{}, err
+		}
+		if result.Item == nil {
+			return db.Order{}, orderNotFound(id)
+		}
+		var order db.Order
+		err = attributevalue.UnmarshalMap(result.Item, &order)
+		if err != nil {
+			return db.Order{}, err
+		}
+		err = orderstatus.Check(order, change)
+		if err != nil {
+			return db.Order{}, err
+		}
+
+		from := order.Status
+		orderstatus.Apply(&order, change)
+		entry, err := attributevalue.Marshal(order.History[len(order.History)-1:])
+		if err != nil {
+			return db.Order{}, err
+		}
+
+		actions := []types.TransactWriteItem{{Update: &types.Update{
+			TableName:                aws.String(s.orders),
+			Key:                      key(id),
+			UpdateExpression:         aws.String("SET #status = :to, updated_at = :now, history = list_append(if_not_exists(history, :empty), :entry)"),
+			ConditionExpression:      aws.String("#status = :from"),
+			ExpressionAttributeNames: map[string]string{"#status": "status"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":to":    &types.AttributeValueMemberS{Value: order.Status},
+				":from":  &types.AttributeValueMemberS{Value: from},
+				":now":   number(order.UpdatedAt),
+				":entry": entry,
+				":empty": &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			},
+		}}}
+		// the items and the records of the code the cancellation writes to besides the order, a failed condition
+		// means the record, or some of the item's variants, were deleted
+		var restocks []group
+		var returned []string
+		if change.To == orderstatus.Canceled {
+			for _, g := range byItem(order.Items) {
+				var lines []int
+				for _, i := range g.lines {
+					if !deleted[lineKey(order.Items[i])] {
+						lines = append(lines, i)
+					}
+				}
+				if len(lines) == 0 {
+					continue
+				}
+				g.lines = lines
+				restocks = append(restocks, g)
+				actions = append(actions, types.TransactWriteItem{Update: s.restock(order.Items, g)})
+			}
+			if order.DiscountCode != "" {
+				unredeem, keys := s.unredeem(order, deleted)
+				actions = append(actions, unredeem...)
+				returned = append(returned, keys...)
+			}
+		}
+
+		_, err = s.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: actions})
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			// a changed status is picked up by reading the order again, a deleted record is skipped next time
+			for i, reason := range canceled.CancellationReasons {
+				if i == 0 || aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+					continue
+				}
+				if i <= len(restocks) {
+					err = s.unrestockable(ctx, order.Items, restocks[i-1], deleted)
+					if err != nil {
+						return db.Order{}, err
+					}
+					continue
+				}
+				deleted[returned[i-1-len(restocks)]] = true
+			}
+			continue
+		}
+		if err != nil {
+			return db.Order{}, err
+		}
+		return order, nil
+	}
+	return db.Order{}, errContention
+}
+
+// DeleteOrder deletes the order on the condition that it is canceled or refunded.
+func (s *DynamoStore) DeleteOrder(ctx context.Context, id string) error {
+	ctx, span := tracing.Start(ctx, "checkout.DeleteOrder")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, db.Timeout)
+	defer cancel()
+
+	_, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(s.orders),
+		Key:                      key(id),
+		ConditionExpression:      aws.String("#status IN (:canceled, :refunded)"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":canceled": &types.AttributeValueMemberS{Value: orderstatus.Canceled},
+			":refunded": &types.AttributeValueMemberS{Value: orderstatus.Refunded},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		if failed.Item == nil {
+			return orderNotFound(id)
+		}
+		var order db.Order
+		err = attributevalue.UnmarshalMap(failed.Item, &order)
+		if err != nil {
+			return err
+		}
+		return notDeletable(id, order.Status)
+	}
+	return err
+}
+
+// restock adds the lines of one item back to its inventory, and to their variants' when they ordered one.
+func (s *DynamoStore) restock(lines []db.LineItem, g group) *types.Update {
+	update := &types.Update{
+		TableName:                 aws.String(s.items),
+		Key:                       key(g.id),
+		ExpressionAttributeNames:  map[string]string{"#inventory": "inventory", "#variants": "variants"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{},
+	}
+	plain := g.plain(lines)
+	var total int64
+	set := []string{"#inventory = #inventory + :total"}
+	conditions := []string{"attribute_exists(id)"}
+	if plain {
+		conditions = append(conditions, "attribute_not_exists(#variants)")
+	}
+	for n, i := range g.lines {
+		total += int64(lines[i].Quantity)
+		if plain {
+			continue
+		}
+		suffix := strconv.Itoa(n)
+		variant := "#variants.#s" + suffix
+		update.ExpressionAttributeNames["#s"+suffix] = sku(lines[i])
+		update.ExpressionAttributeValues[":q"+suffix] = number(int64(lines[i].Quantity))
+		set = append(set, variant+".#inventory = "+variant+".#inventory + :q"+suffix)
+		conditions = append(conditions, "attribute_exists("+variant+")")
+	}
+	update.ExpressionAttributeValues[":total"] = number(total)
+	update.UpdateExpression = aws.String("SET " + strings.Join(set, ", "))
+	update.ConditionExpression = aws.String(strings.Join(conditions, " AND "))
+	return update
+}
+
+// unrestockable reads an item whose restock failed and marks the lines of g that can no longer go back: the item
+// was deleted, the variant was, or the item gained or lost variants since the order was placed.
+func (s *DynamoStore) unrestockable(ctx context.Context, lines []db.LineItem, g group, deleted map[string]bool) error {
+	result, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.items),
+		Key:            key(g.id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	var item *db.Item
+	if result.Item != nil {
+		item = &db.Item{}
+		err = attributevalue.UnmarshalMap(result.Item, item)
+		if err != nil {
+			return err
+		}
+	}
+	for _, i := range g.lines {
+		if !restockable(lines[i], item) {
+			deleted[lineKey(lines[i])] = true
+		}
+	}
+	return nil
+}
+
+// restockable tells whether line's stock can go back to item, nil when it was deleted.
+func restockable(line db.LineItem, item *db.Item) bool {
+	if item == nil {
+		return false
+	}
+	if len(item.Variants) == 0 {
+		return sku(line) == item.ID
+	}
+	_, ok := item.Variants[sku(line)]
+	return ok
+}
+
+func lineKey(line db.LineItem) string {
+	return "item:" + line.ItemID + "|" + sku(line)
+}
+
+// unredeem takes a canceled order's redemption back, returning the writes and the keys that mark them deleted.
+// The user's record is only changed while it still lists the order.
+func (s *DynamoStore) unredeem(order db.Order, deleted map[string]bool) ([]types.TransactWriteItem, []string) {
+	var actions []types.TransactWriteItem
+	var keys []string
+	if code := "discount:" + order.DiscountCode; !deleted[code] {
+		keys = append(keys, code)
+		actions = append(actions, types.TransactWriteItem{Update: &types.Update{
+			TableName:                 aws.String(s.codes),
+			Key:                       key(order.DiscountCode),
+			UpdateExpression:          aws.String("ADD redemptions :minus"),
+			ConditionExpression:       aws.String("attribute_exists(id)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":minus": number(-1)},
+		}})
+	}
+	if redemption := db.RedemptionID(order.DiscountCode, order.User); !deleted[redemption] {
+		keys = append(keys, redemption)
+		actions = append(actions, types.TransactWriteItem{Update: &types.Update{
+			TableName:                aws.String(s.redemptions),
+			Key:                      key(redemption),
+			UpdateExpression:         aws.String("ADD #count :minus DELETE orders :order"),
+			ConditionExpression:      aws.String("contains(orders, :id)"),
+			ExpressionAttributeNames: map[string]string{"#count": "count"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":minus": number(-1),
+				":order": &types.AttributeValueMemberSS{Value: []string{order.ID}},
+				":id":    &types.AttributeValueMemberS{Value: order.ID},
+			},
+		}})
+	}
+	return actions, keys
+}
+
+func key(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}}
+}
+
+func number(n int64) *types.AttributeValueMemberN {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+// wait backs off before another attempt, 20ms, 40ms, 80ms and so on.
+func wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(20 * time.Millisecond << attempt)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,174 @@
+package checkout
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"sync"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+)
+
+// MemoryStore keeps items, orders and discount codes in memory for development and tests.
+// A single lock around each call gives it the same all-or-nothing behaviour as the DynamoDB transaction.
+type MemoryStore struct {
+	mu          sync.Mutex
+	items       map[string]db.Item
+	orders      map[string]db.Order
+	codes       map[string]db.DiscountCode
+	redemptions map[string]int
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{items: map[string]db.Item{}, orders: map[string]db.Order{}, codes: map[string]db.DiscountCode{}, redemptions: map[string]int{}}
+}
+
+func (s *MemoryStore) PutCode(code db.DiscountCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codes[code.Code] = code
+}
+
+func (s *MemoryStore) Code(code string) (db.DiscountCode, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.codes[code]
+	return stored, ok
+}
+
+// Redemptions returns how many orders user has placed with code.
+func (s *MemoryStore) Redemptions(code, user string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.redemptions[db.RedemptionID(code, user)]
+}
+
+func (s *MemoryStore) PutItem(item db.Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[item.ID] = item
+}
+
+func (s *MemoryStore) Item(id string) (db.Item, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[id]
+	return item, ok
+}
+
+func (s *MemoryStore) Order(id string) (db.Order, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order, ok := s.orders[id]
+	return order, ok
+}
+
+func (s *MemoryStore) PlaceOrder(ctx context.Context, order db.Order, code *db.DiscountCode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[order.ID]; ok {
+		return apperrors.Conflict("order_exists", "An order with this id already exists.")
+	}
+
+	var fields []apperrors.FieldError
+	for i, line := range order.Items {
+		var stored *db.Item
+		if item, ok := s.items[line.ItemID]; ok {
+			stored = &item
+		}
+		if fe := check(i, line, stored); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+	if code != nil {
+		var stored *db.DiscountCode
+		if c, ok := s.codes[code.Code]; ok {
+			stored = &c
+		}
+		if fe := checkCode(*code, stored, s.redemptions[db.RedemptionID(code.Code, order.User)], order.CreatedAt); fe != nil {
+			fields = append(fields, *fe)
+		}
+	}
+	if len(fields) > 0 {
+		return notReserved(fields)
+	}
+
+	for _, line := range order.Items {
+		s.adjust(line, -line.Quantity)
+	}
+	if code != nil {
+		stored := s.codes[code.Code]
+		stored.Redemptions++
+		s.codes[code.Code] = stored
+		s.redemptions[db.RedemptionID(code.Code, order.User)]++
+	}
+	s.orders[order.ID] = order
+	return nil
+}
+
+func (s *MemoryStore) Transition(ctx context.Context, id string, change db.StatusChange) (db.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[id]
+	if !ok {
+		return db.Order{}, orderNotFound(id)
+	}
+	err := orderstatus.Check(order, change)
+	if err != nil {
+		return db.Order{}, err
+	}
+
+	// stock of items, variants and redemptions of codes deleted since the order was placed have nowhere to go back to
+	if change.To == orderstatus.Canceled {
+		for _, line := range order.Items {
+			if item, ok := s.items[line.ItemID]; ok && restockable(line, &item) {
+				s.adjust(line, line.Quantity)
+			}
+		}
+		if order.DiscountCode != "" {
+			if code, ok := s.codes[order.DiscountCode]; ok {
+				code.Redemptions--
+				s.codes[order.DiscountCode] = code
+			}
+			s.redemptions[db.RedemptionID(order.DiscountCode, order.User)]--
+		}
+	}
+	// copy the history so orders handed out earlier do not share its backing array
+	order.History = slices.Clone(order.History)
+	orderstatus.Apply(&order, change)
+	s.orders[id] = order
+	return order, nil
+}
+
+func (s *MemoryStore) DeleteOrder(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[id]
+	if !ok {
+		return orderNotFound(id)
+	}
+	if !deletable(order.Status) {
+		return notDeletable(id, order.Status)
+	}
+	delete(s.orders, id)
+	return nil
+}
+
+// adjust changes the stock of line's item by quantity, and of its variant when it has variants. The variants are
+// copied so that items handed out earlier do not share them.
+func (s *MemoryStore) adjust(line db.LineItem, quantity int) {
+	item := s.items[line.ItemID]
+	item.Inventory += quantity
+	if len(item.Variants) > 0 {
+		item.Variants = maps.Clone(item.Variants)
+		variant := item.Variants[sku(line)]
+		variant.Inventory += quantity
+		item.Variants[sku(line)] = variant
+	}
+	s.items[line.ItemID] = item
+}
@@ -0,0 +1,249 @@
+package checkout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+	"upgraded-telegram/main.go/server/services/promotions"
+)
+
+const stock = 10
+
+func newStore(item db.Item) *MemoryStore {
+	store := NewMemoryStore()
+	store.PutItem(item)
+	return store
+}
+
+func order(id, user string, line db.LineItem) db.Order {
+	return db.Order{ID: id, User: user, Status: orderstatus.Pending, Items: []db.LineItem{line}}
+}
+
+// hammer places orders of one unit of line from many goroutines at once and returns the ids of those placed.
+func hammer(t *testing.T, store *MemoryStore, line db.LineItem, orders int) []string {
+	t.Helper()
+	var mu sync.Mutex
+	var placed []string
+	var wg sync.WaitGroup
+	for i := 0; i < orders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("order-%d", i)
+			err := store.PlaceOrder(context.Background(), order(id, "user-1", line), nil)
+			var appErr *apperrors.Error
+			switch {
+			case err == nil:
+				mu.Lock()
+				placed = append(placed, id)
+				mu.Unlock()
+			case errors.As(err, &appErr) && appErr.Code == "checkout_failed":
+			default:
+				t.Errorf("order %s: %v", id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return placed
+}
+
+// cancel cancels id from many goroutines at once and returns how many of them succeeded.
+func cancel(store *MemoryStore, id string, tries int) int {
+	var canceled atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < tries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Transition(context.Background(), id, db.StatusChange{To: orderstatus.Canceled, By: "user-1", Role: services.RoleCustomer})
+			if err == nil {
+				canceled.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	return int(canceled.Load())
+}
+
+func TestConcurrentCheckoutsDoNotOversell(t *testing.T) {
+	price := money.New(1000, "USD")
+	store := newStore(db.Item{ID: "mug", Active: true, Price: price, Inventory: stock})
+	line := db.LineItem{ItemID: "mug", Quantity: 1, ListPrice: price, UnitPrice: price}
+
+	placed := hammer(t, store, line, 100)
+	if len(placed) != stock {
+		t.Fatalf("%d orders placed for %d in stock", len(placed), stock)
+	}
+	if item, _ := store.Item("mug"); item.Inventory != 0 {
+		t.Fatalf("inventory is %d after selling out", item.Inventory)
+	}
+
+	if n := cancel(store, placed[0], 20); n != 1 {
+		t.Fatalf("canceled %d times, want once", n)
+	}
+	if item, _ := store.Item("mug"); item.Inventory != 1 {
+		t.Fatalf("inventory is %d after one cancellation, want 1", item.Inventory)
+	}
+}
+
+func TestConcurrentCheckoutsDoNotOversellAVariant(t *testing.T) {
+	price := money.New(2500, "USD")
+	store := newStore(db.Item{ID: "shirt", Active: true, Price: price, Inventory: stock + 5, DefaultSKU: "shirt-m", Variants: map[string]db.Variant{
+		"shirt-m": {Price: price, Inventory: stock},
+		"shirt-l": {Price: price, Inventory: 5},
+	}})
+	line := db.LineItem{ItemID: "shirt", SKU: "shirt-m", Quantity: 1, ListPrice: price, UnitPrice: price}
+
+	placed := hammer(t, store, line, 100)
+	if len(placed) != stock {
+		t.Fatalf("%d orders placed for %d in stock", len(placed), stock)
+	}
+	item, _ := store.Item("shirt")
+	if item.Variants["shirt-m"].Inventory != 0 || item.Variants["shirt-l"].Inventory != 5 || item.Inventory != 5 {
+		t.Fatalf("stock is %d in total, %+v by variant", item.Inventory, item.Variants)
+	}
+
+	if n := cancel(store, placed[0], 20); n != 1 {
+		t.Fatalf("canceled %d times, want once", n)
+	}
+	if item, _ := store.Item("shirt"); item.Variants["shirt-m"].Inventory != 1 || item.Inventory != 6 {
+		t.Fatalf("stock is %d in total, %+v by variant after one cancellation", item.Inventory, item.Variants)
+	}
+}
+
+func TestDeleteOrderOnlyOnceFinished(t *testing.T) {
+	price := money.New(1000, "USD")
+	store := newStore(db.Item{ID: "mug", Active: true, Price: price, Inventory: stock})
+	line := db.LineItem{ItemID: "mug", Quantity: 1, ListPrice: price, UnitPrice: price}
+	err := store.PlaceOrder(context.Background(), order("o1", "user-1", line), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var appErr *apperrors.Error
+	err = store.DeleteOrder(context.Background(), "o1")
+	if !errors.As(err, &appErr) || appErr.Code != "order_not_deletable" {
+		t.Fatalf("deleting a pending order: got %v, want order_not_deletable", err)
+	}
+	if cancel(store, "o1", 1) != 1 {
+		t.Fatal("could not cancel")
+	}
+	err = store.DeleteOrder(context.Background(), "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Order("o1"); ok {
+		t.Fatal("order still stored")
+	}
+	if item, _ := store.Item("mug"); item.Inventory != stock {
+		t.Fatalf("inventory is %d, want %d", item.Inventory, stock)
+	}
+}
+
+// redeem places an order with code for every entry of users from many goroutines at once. It returns the ids of
+// the orders placed by user and how many failed for each reason.
+func redeem(t *testing.T, store *MemoryStore, line db.LineItem, code db.DiscountCode, users []string) (map[string][]string, map[string]int) {
+	t.Helper()
+	var mu sync.Mutex
+	placed := map[string][]string{}
+	reasons := map[string]int{}
+	var wg sync.WaitGroup
+	for i, user := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			o := order(fmt.Sprintf("order-%s-%d", user, i), user, line)
+			o.DiscountCode = code.Code
+			err := store.PlaceOrder(context.Background(), o, &code)
+			var appErr *apperrors.Error
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				placed[user] = append(placed[user], o.ID)
+			case errors.As(err, &appErr) && appErr.Code == "checkout_failed" && len(appErr.Fields) == 1:
+				reasons[appErr.Fields[0].Code]++
+			default:
+				t.Errorf("order %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return placed, reasons
+}
+
+func TestConcurrentCheckoutsKeepMaxRedemptions(t *testing.T) {
+	price := money.New(1000, "USD")
+	store := newStore(db.Item{ID: "mug", Active: true, Price: price, Inventory: 100})
+	code := db.DiscountCode{Code: "LAUNCH", Type: promotions.Percent, Value: 10, Active: true, MaxRedemptions: 5}
+	store.PutCode(code)
+	line := db.LineItem{ItemID: "mug", Quantity: 1, ListPrice: price, UnitPrice: price}
+
+	var users []string
+	for i := 0; i < 50; i++ {
+		users = append(users, fmt.Sprintf("user-%d", i))
+	}
+	placed, reasons := redeem(t, store, line, code, users)
+	if len(placed) != 5 || reasons[promotions.CodeExhausted] != 45 {
+		t.Fatalf("%d users placed orders, failures %v; want 5 and 45 %s", len(placed), reasons, promotions.CodeExhausted)
+	}
+	if stored, _ := store.Code("LAUNCH"); stored.Redemptions != 5 {
+		t.Fatalf("%d redemptions recorded, want 5", stored.Redemptions)
+	}
+	if item, _ := store.Item("mug"); item.Inventory != 95 {
+		t.Fatalf("inventory is %d, want 95", item.Inventory)
+	}
+
+	// a canceled order gives its redemption back, and only that one can be taken again
+	for user, ids := range placed {
+		_, err := store.Transition(context.Background(), ids[0], db.StatusChange{To: orderstatus.Canceled, By: user, Role: services.RoleCustomer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if store.Redemptions("LAUNCH", user) != 0 {
+			t.Fatalf("%s still has %d redemptions", user, store.Redemptions("LAUNCH", user))
+		}
+		break
+	}
+	placed, reasons = redeem(t, store, line, code, []string{"late-1", "late-2", "late-3", "late-4"})
+	if len(placed) != 1 || reasons[promotions.CodeExhausted] != 3 {
+		t.Fatalf("%d users placed orders after a cancellation, failures %v; want 1 and 3", len(placed), reasons)
+	}
+	if stored, _ := store.Code("LAUNCH"); stored.Redemptions != 5 {
+		t.Fatalf("%d redemptions recorded, want 5", stored.Redemptions)
+	}
+}
+
+func TestConcurrentCheckoutsKeepMaxPerUser(t *testing.T) {
+	price := money.New(1000, "USD")
+	store := newStore(db.Item{ID: "mug", Active: true, Price: price, Inventory: 100})
+	code := db.DiscountCode{Code: "WELCOME", Type: promotions.Fixed, Value: 200, Active: true, MaxPerUser: 2}
+	store.PutCode(code)
+	line := db.LineItem{ItemID: "mug", Quantity: 1, ListPrice: price, UnitPrice: price}
+
+	var users []string
+	for i := 0; i < 20; i++ {
+		users = append(users, "user-1", "user-2", "user-3")
+	}
+	placed, reasons := redeem(t, store, line, code, users)
+	for _, user := range []string{"user-1", "user-2", "user-3"} {
+		if len(placed[user]) != 2 || store.Redemptions("WELCOME", user) != 2 {
+			t.Fatalf("%s placed %d orders and has %d redemptions, want 2", user, len(placed[user]), store.Redemptions("WELCOME", user))
+		}
+	}
+	if reasons[promotions.CodeLimitReached] != 54 {
+		t.Fatalf("failures %v, want 54 %s", reasons, promotions.CodeLimitReached)
+	}
+	if stored, _ := store.Code("WELCOME"); stored.Redemptions != 6 {
+		t.Fatalf("%d redemptions recorded, want 6", stored.Redemptions)
+	}
+}
@@ -0,0 +1,507 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/money"
+
+	"github.com/joho/godotenv"
+)
+
+// Every setting is read from, in increasing order of precedence: the defaults below, an optional
+// KEY=VALUE file, the environment, and command-line flags. The flag name is the env key in
+// lower case with dashes, so TABLE_USERS can also be set with -table-users.
+//
+// Fields tagged `secret:"true"` are never printed by Summary.
+
+type Config struct {
+	Port     int    `env:"PORT" usage:"HTTP listen port"`
+	LogLevel string `env:"LOG_LEVEL" usage:"debug, info, warn or error"`
+
+	AWS         AWS
+	Tables      Tables
+	Auth        Auth
+	RateLimit   RateLimit
+	Idempotency Idempotency
+	Money       Money
+	Tax         Tax
+	Search      Search
+	Payments    Payments
+	Security    Security
+	Features    Features
+	Timeouts    Timeouts
+
+	OpenAIKey    string `env:"OPENAI_API_KEY" secret:"true"`
+	MapsKey      string `env:"GOOGLE_MAPS_API_KEY" secret:"true"`
+	SimulatorJWT string `env:"SIMULATOR_JWT" secret:"true" usage:"bearer token used by the load simulator"`
+
+	LegacySunset string `env:"LEGACY_SUNSET" usage:"date (YYYY-MM-DD) after which the unversioned routes may be removed"`
+}
+
+type AWS struct {
+	Region          string `env:"AWS_REGION"`
+	AccessKeyID     string `env:"AWS_ACCESS_KEY_ID" secret:"true" usage:"leave empty to use the default credential chain"`
+	SecretAccessKey string `env:"AWS_SECRET_ACCESS_KEY" secret:"true"`
+	Bucket          string `env:"AWS_BUCKET_NAME" usage:"S3 bucket for uploads"`
+	Endpoint        string `env:"AWS_ENDPOINT_URL" usage:"override the AWS endpoint, e.g. http://localhost:8000 for DynamoDB Local"`
+}
+
+type Tables struct {
+	Users    string `env:"TABLE_USERS"`
+	Chats    string `env:"TABLE_CHATS"`
+	Messages string `env:"TABLE_MESSAGES"`
+	Events   string `env:"TABLE_EVENTS"`
+	Items    string `env:"TABLE_ITEMS"`
+	Orders   string `env:"TABLE_ORDERS"`
+
+	Payments        string `env:"TABLE_PAYMENTS"`
+	Refunds         string `env:"TABLE_REFUNDS"`
+	Discounts       string `env:"TABLE_DISCOUNTS"`
+	Redemptions     string `env:"TABLE_REDEMPTIONS"`
+	Categories      string `env:"TABLE_CATEGORIES"`
+	RateLimits      string `env:"TABLE_RATE_LIMITS" usage:"only used when RATE_LIMIT_STORE is dynamodb"`
+	IdempotencyKeys string `env:"TABLE_IDEMPOTENCY_KEYS" usage:"only used when IDEMPOTENCY_STORE is dynamodb"`
+}
+
+type Auth struct {
+	TokenSecret        string        `env:"TOKEN_SECRET" secret:"true"`
+	RefreshTokenSecret string        `env:"REFRESH_TOKEN_SECRET" secret:"true"`
+	AccessTokenTTL     time.Duration `env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL    time.Duration `env:"REFRESH_TOKEN_TTL"`
+	AdminEmails        string        `env:"ADMIN_EMAILS" usage:"comma-separated emails of users with the admin role"`
+	StaffEmails        string        `env:"STAFF_EMAILS" usage:"comma-separated emails of users with the staff role"`
+}
+
+type RateLimit struct {
+	Enabled        bool    `env:"RATE_LIMIT_ENABLED"`
+	Store          string  `env:"RATE_LIMIT_STORE" usage:"memory (single instance) or dynamodb (shared between instances)"`
+	RPS            float64 `env:"RATE_LIMIT_RPS" usage:"requests per second per client for writes"`
+	Burst          int     `env:"RATE_LIMIT_BURST"`
+	ReadRPS        float64 `env:"RATE_LIMIT_READ_RPS" usage:"requests per second per client for GET and HEAD"`
+	ReadBurst      int     `env:"RATE_LIMIT_READ_BURST"`
+	AuthRPS        float64 `env:"RATE_LIMIT_AUTH_RPS" usage:"requests per second per client for login and sign-up"`
+	AuthBurst      int     `env:"RATE_LIMIT_AUTH_BURST"`
+	TrustedProxies string  `env:"TRUSTED_PROXIES" usage:"comma-separated CIDRs whose X-Forwarded-For is trusted"`
+}
+
+// Proxies parses TrustedProxies, bare addresses are accepted as single-host prefixes.
+func (r RateLimit) Proxies() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(r.TrustedProxies, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(field); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR", field)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+type Idempotency struct {
+	Store string        `env:"IDEMPOTENCY_STORE" usage:"memory (single instance) or dynamodb (shared between instances)"`
+	TTL   time.Duration `env:"IDEMPOTENCY_TTL" usage:"how long a response is replayed for a retried Idempotency-Key"`
+}
+
+type Money struct {
+	Currency     string        `env:"CURRENCY" usage:"ISO 4217 code of prices and orders that do not name a currency"`
+	RatesSource  string        `env:"EXCHANGE_RATES_SOURCE" usage:"static, rates read from EXCHANGE_RATES_FILE, is the only one so far"`
+	RatesFile    string        `env:"EXCHANGE_RATES_FILE" usage:"JSON file of exchange rates; empty means orders can only use currencies items are priced in"`
+	RatesRefresh time.Duration `env:"EXCHANGE_RATES_REFRESH" usage:"how long exchange rates are cached before they are read again"`
+}
+
+type Tax struct {
+	RulesFile     string `env:"TAX_RULES_FILE" usage:"JSON file of tax rules by region and category; empty means no tax"`
+	Mode          string `env:"TAX_MODE" usage:"exclusive (tax is added to prices) or inclusive (prices include tax)"`
+	DefaultRegion string `env:"TAX_DEFAULT_REGION" usage:"region orders without a shipping address are taxed in, e.g. US-CA; empty means untaxed"`
+}
+
+type Search struct {
+	Refresh time.Duration `env:"SEARCH_REFRESH" usage:"how often the search index is rebuilt from the items table, to pick up stock changed by orders and writes by other instances; 0 only builds it at startup and on demand"`
+}
+
+type Payments struct {
+	Gateway          string        `env:"PAYMENTS_GATEWAY" usage:"fake, an in-process gateway for development and tests, is the only one so far"`
+	WebhookSecret    string        `env:"PAYMENTS_WEBHOOK_SECRET" secret:"true" usage:"shared secret the gateway signs webhooks with"`
+	WebhookTolerance time.Duration `env:"PAYMENTS_WEBHOOK_TOLERANCE" usage:"how old a signed webhook may be before it is rejected as a replay"`
+}
+
+type Security struct {
+	CORSAllowedOrigins   string        `env:"CORS_ALLOWED_ORIGINS" usage:"comma-separated origins allowed to call the API from a browser, or *; empty disables CORS"`
+	CORSAllowedMethods   string        `env:"CORS_ALLOWED_METHODS"`
+	CORSAllowedHeaders   string        `env:"CORS_ALLOWED_HEADERS"`
+	CORSAllowCredentials bool          `env:"CORS_ALLOW_CREDENTIALS"`
+	CORSMaxAge           time.Duration `env:"CORS_MAX_AGE" usage:"how long browsers may cache a preflight response"`
+	HSTSMaxAge           time.Duration `env:"HSTS_MAX_AGE" usage:"send Strict-Transport-Security when positive, only behind HTTPS"`
+	MaxBodyBytes         int           `env:"MAX_BODY_BYTES"`
+	MaxUploadBytes       int           `env:"MAX_UPLOAD_BYTES"`
+}
+
+// List splits a comma-separated setting, dropping empty entries.
+func List(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+type Features struct {
+	AI      bool `env:"FEATURE_AI" usage:"connect to OpenAI and serve the AI routes"`
+	Maps    bool `env:"FEATURE_MAPS" usage:"connect to Google Maps and serve the map routes"`
+	Metrics bool `env:"FEATURE_METRICS" usage:"serve /metrics"`
+
+	Payments bool `env:"FEATURE_PAYMENTS" usage:"serve the payment routes and the payment webhook"`
+
+	LegacyRoutes bool `env:"FEATURE_LEGACY_ROUTES" usage:"also serve the deprecated unversioned routes next to /v1"`
+}
+
+type Timeouts struct {
+	DynamoDB          time.Duration `env:"DYNAMODB_TIMEOUT"`
+	S3                time.Duration `env:"S3_TIMEOUT"`
+	Maps              time.Duration `env:"MAPS_TIMEOUT"`
+	OpenAI            time.Duration `env:"OPENAI_TIMEOUT"`
+	ReadHeader        time.Duration `env:"SERVER_READ_HEADER_TIMEOUT"`
+	Read              time.Duration `env:"SERVER_READ_TIMEOUT"`
+	Write             time.Duration `env:"SERVER_WRITE_TIMEOUT"`
+	Idle              time.Duration `env:"SERVER_IDLE_TIMEOUT"`
+	Shutdown          time.Duration `env:"SHUTDOWN_TIMEOUT"`
+	ShutdownDelay     time.Duration `env:"SHUTDOWN_DELAY" usage:"time to keep serving after readiness starts failing"`
+	ReadinessCacheTTL time.Duration `env:"READINESS_CACHE_TTL"`
+}
+
+func Default() Config {
+	return Config{
+		Port:     8080,
+		LogLevel: "info",
+		Tables: Tables{
+			Users:    "users",
+			Chats:    "chats",
+			Messages: "messages",
+			Events:   "events",
+			Items:    "items",
+			Orders:   "orders",
+
+			Payments:        "payments",
+			Refunds:         "refunds",
+			Discounts:       "discounts",
+			Redemptions:     "redemptions",
+			Categories:      "categories",
+			RateLimits:      "rate_limits",
+			IdempotencyKeys: "idempotency_keys",
+		},
+		Auth: Auth{
+			AccessTokenTTL:  15 * time.Minute,
+			RefreshTokenTTL: 7 * 24 * time.Hour,
+		},
+		RateLimit: RateLimit{
+			Enabled:   true,
+			Store:     "memory",
+			RPS:       5,
+			Burst:     10,
+			ReadRPS:   20,
+			ReadBurst: 40,
+			AuthRPS:   0.2,
+			AuthBurst: 5,
+		},
+		Idempotency: Idempotency{
+			Store: "memory",
+			TTL:   24 * time.Hour,
+		},
+		Money: Money{
+			Currency:     "USD",
+			RatesSource:  "static",
+			RatesRefresh: time.Hour,
+		},
+		Tax: Tax{
+			Mode: "exclusive",
+		},
+		Search: Search{
+			Refresh: 5 * time.Minute,
+		},
+		Payments: Payments{
+			Gateway:          "fake",
+			WebhookTolerance: 5 * time.Minute,
+		},
+		Features:     Features{AI: true, Maps: true, Metrics: true, LegacyRoutes: true},
+		LegacySunset: "2027-04-19",
+		Timeouts: Timeouts{
+			DynamoDB:          5 * time.Second,
+			S3:                30 * time.Second,
+			Maps:              10 * time.Second,
+			OpenAI:            60 * time.Second,
+			ReadHeader:        5 * time.Second,
+			Read:              30 * time.Second,
+			Write:             90 * time.Second,
+			Idle:              120 * time.Second,
+			Shutdown:          30 * time.Second,
+			ReadinessCacheTTL: 10 * time.Second,
+		},
+	}
+}
+
+// Load builds the configuration from args (usually os.Args[1:]). The file is taken from -config,
+// then CONFIG_FILE, then ./.env if it exists. A missing explicit file is an error.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := collect(reflect.ValueOf(&cfg).Elem())
+
+	fs := flag.NewFlagSet("upgraded-telegram", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "optional KEY=VALUE configuration file")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.env] = fs.String(s.flagName(), "", s.usage())
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	file := map[string]string{}
+	path := *configFile
+	if path == "" {
+		if _, err := os.Stat(".env"); err == nil {
+			path = ".env"
+		}
+	}
+	if path != "" {
+		var err error
+		file, err = godotenv.Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config file %s: %w", path, err)
+		}
+	}
+
+	setFlags := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	var errs []error
+	for _, s := range settings {
+		value, ok := file[s.env]
+		if v, found := os.LookupEnv(s.env); found {
+			value, ok = v, true
+		}
+		if setFlags[s.flagName()] {
+			value, ok = *flagValues[s.env], true
+		}
+		if !ok {
+			continue
+		}
+		if err := s.set(value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.env, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Port > 0 && c.Port <= 65535, "PORT must be between 1 and 65535")
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.LogLevel)) == nil, "LOG_LEVEL %q is not a valid level", c.LogLevel)
+
+	check(c.AWS.Region != "", "AWS_REGION is required")
+	check(c.AWS.Bucket != "", "AWS_BUCKET_NAME is required")
+	check((c.AWS.AccessKeyID == "") == (c.AWS.SecretAccessKey == ""), "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set together")
+
+	tables := reflect.ValueOf(c.Tables)
+	for i := 0; i < tables.NumField(); i++ {
+		check(tables.Field(i).String() != "", "%s must not be empty", tables.Type().Field(i).Tag.Get("env"))
+	}
+
+	check(c.Auth.TokenSecret != "", "TOKEN_SECRET is required")
+	check(c.Auth.RefreshTokenSecret != "", "REFRESH_TOKEN_SECRET is required")
+	check(c.Auth.TokenSecret == "" || c.Auth.TokenSecret != c.Auth.RefreshTokenSecret, "TOKEN_SECRET and REFRESH_TOKEN_SECRET must differ")
+	check(c.Auth.AccessTokenTTL > 0, "ACCESS_TOKEN_TTL must be positive")
+	check(c.Auth.RefreshTokenTTL > c.Auth.AccessTokenTTL, "REFRESH_TOKEN_TTL must be longer than ACCESS_TOKEN_TTL")
+
+	if c.RateLimit.Enabled {
+		check(c.RateLimit.Store == "memory" || c.RateLimit.Store == "dynamodb", "RATE_LIMIT_STORE must be memory or dynamodb")
+		check(c.RateLimit.RPS > 0, "RATE_LIMIT_RPS must be positive")
+		check(c.RateLimit.Burst >= 1, "RATE_LIMIT_BURST must be at least 1")
+		check(c.RateLimit.ReadRPS > 0, "RATE_LIMIT_READ_RPS must be positive")
+		check(c.RateLimit.ReadBurst >= 1, "RATE_LIMIT_READ_BURST must be at least 1")
+		check(c.RateLimit.AuthRPS > 0, "RATE_LIMIT_AUTH_RPS must be positive")
+		check(c.RateLimit.AuthBurst >= 1, "RATE_LIMIT_AUTH_BURST must be at least 1")
+	}
+	check(c.Idempotency.Store == "memory" || c.Idempotency.Store == "dynamodb", "IDEMPOTENCY_STORE must be memory or dynamodb")
+	check(c.Idempotency.TTL > 0, "IDEMPOTENCY_TTL must be positive")
+
+	check(money.Valid(c.Money.Currency), "CURRENCY %q is not a supported ISO 4217 currency code", c.Money.Currency)
+	check(c.Money.RatesSource == "static", "EXCHANGE_RATES_SOURCE must be static")
+	check(c.Money.RatesRefresh > 0, "EXCHANGE_RATES_REFRESH must be positive")
+	check(c.Tax.Mode == "exclusive" || c.Tax.Mode == "inclusive", "TAX_MODE must be exclusive or inclusive")
+	country, _, _ := strings.Cut(c.Tax.DefaultRegion, "-")
+	check(c.Tax.DefaultRegion == "" || len(country) == 2, "TAX_DEFAULT_REGION %q must be a country code, optionally followed by -region", c.Tax.DefaultRegion)
+
+	check(c.Search.Refresh >= 0, "SEARCH_REFRESH must not be negative")
+
+	if c.Features.Payments {
+		check(c.Payments.Gateway == "fake", "PAYMENTS_GATEWAY must be fake")
+		check(c.Payments.WebhookSecret != "", "PAYMENTS_WEBHOOK_SECRET is required when FEATURE_PAYMENTS is true")
+		check(c.Payments.WebhookTolerance > 0, "PAYMENTS_WEBHOOK_TOLERANCE must be positive")
+	}
+
+	if _, err := c.RateLimit.Proxies(); err != nil {
+		errs = append(errs, fmt.Errorf("TRUSTED_PROXIES: %w", err))
+	}
+
+	origins := List(c.Security.CORSAllowedOrigins)
+	for _, origin := range origins {
+		if origin == "*" {
+			check(!c.Security.CORSAllowCredentials, "CORS_ALLOWED_ORIGINS cannot be * when CORS_ALLOW_CREDENTIALS is true")
+			continue
+		}
+		u, err := url.Parse(origin)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "",
+			"CORS_ALLOWED_ORIGINS entry %q must be a scheme and host such as https://dashboard.example.com", origin)
+	}
+	check(c.Security.CORSMaxAge >= 0, "CORS_MAX_AGE must not be negative")
+	check(c.Security.HSTSMaxAge >= 0, "HSTS_MAX_AGE must not be negative")
+	check(c.Security.MaxBodyBytes > 0, "MAX_BODY_BYTES must be positive")
+	check(c.Security.MaxUploadBytes > 0, "MAX_UPLOAD_BYTES must be positive")
+
+	_, err := time.Parse(time.DateOnly, c.LegacySunset)
+	check(err == nil, "LEGACY_SUNSET %q must be a date such as 2027-04-19", c.LegacySunset)
+
+	check(!c.Features.AI || c.OpenAIKey != "", "OPENAI_API_KEY is required when FEATURE_AI is enabled")
+	check(!c.Features.Maps || c.MapsKey != "", "GOOGLE_MAPS_API_KEY is required when FEATURE_MAPS is enabled")
+
+	timeouts := reflect.ValueOf(c.Timeouts)
+	for i := 0; i < timeouts.NumField(); i++ {
+		d := time.Duration(timeouts.Field(i).Int())
+		key := timeouts.Type().Field(i).Tag.Get("env")
+		if key == "SHUTDOWN_DELAY" {
+			check(d >= 0, "%s must not be negative", key)
+			continue
+		}
+		check(d > 0, "%s must be positive", key)
+	}
+	check(c.Timeouts.Write > c.Timeouts.S3 && c.Timeouts.Write > c.Timeouts.OpenAI,
+		"SERVER_WRITE_TIMEOUT must be longer than S3_TIMEOUT and OPENAI_TIMEOUT")
+
+	return errors.Join(errs...)
+}
+
+// Summary returns every setting as a log attribute with secrets replaced.
+func (c *Config) Summary() []slog.Attr {
+	var attrs []slog.Attr
+	for _, s := range collect(reflect.ValueOf(c).Elem()) {
+		switch {
+		case s.secret && s.value.String() == "":
+			attrs = append(attrs, slog.String(s.env, "[UNSET]"))
+		case s.secret:
+			attrs = append(attrs, slog.String(s.env, "[REDACTED]"))
+		default:
+			attrs = append(attrs, slog.String(s.env, fmt.Sprint(s.value.Interface())))
+		}
+	}
+	return attrs
+}
+
+type setting struct {
+	env    string
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.env), "_", "-")
+}
+
+func (s setting) usage() string {
+	if s.help != "" {
+		return s.help + " (" + s.env + ")"
+	}
+	return s.env
+}
+
+func (s setting) set(raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(raw)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		s.value.SetInt(int64(n))
+	case float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		s.value.SetFloat(f)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		s.value.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 5s", raw)
+		}
+		s.value.SetInt(int64(d))
+	default:
+		panic(fmt.Sprintf("config: unsupported type %s for %s", s.value.Type(), s.env))
+	}
+	return nil
+}
+
+// collect walks the struct and returns a setting for every field with an env tag.
+func collect(v reflect.Value) []setting {
+	var settings []setting
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		fv := v.Field(i)
+		if env := sf.Tag.Get("env"); env != "" {
+			settings = append(settings, setting{
+				env:    env,
+				help:   sf.Tag.Get("usage"),
+				secret: sf.Tag.Get("secret") == "true",
+				value:  fv,
+			})
+			continue
+		}
+		if fv.Kind() == reflect.Struct {
+			settings = append(settings, collect(fv)...)
+		}
+	}
+	return settings
+}
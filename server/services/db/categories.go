@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Categories are keyed by their slug. The tree is small and read whole, each category names its parent.
+
+func CreateCategory(ctx context.Context, client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
+	ctx, span := tracing.Start(ctx, "db.CreateCategory", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return apperrors.Conflict("category_exists", "A category with this id already exists.").WithCause(err)
+	}
+	return err
+}
+
+func GetCategoryById(ctx context.Context, client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetCategoryById", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("category_not_found", fmt.Sprintf("No category found with id %s.", id))
+	}
+	return result.Item, nil
+}
+
+// GetAllCategories reads the whole tree.
+func GetAllCategories(ctx context.Context, client *dynamodb.Client, tableName string) ([]map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetAllCategories", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	items, _, err := scan(ctx, client, tableName, Page{})
+	return items, err
+}
+
+func UpdateCategory(ctx context.Context, client *dynamodb.Client, tableName string, category Category) error {
+	ctx, span := tracing.Start(ctx, "db.UpdateCategory", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	update := expression.Set(expression.Name("name"), expression.Value(category.Name)).
+		Set(expression.Name("position"), expression.Value(category.Position)).
+		Set(expression.Name("updated_at"), expression.Value(time.Now().UnixMilli()))
+	if category.Description != "" {
+		update = update.Set(expression.Name("description"), expression.Value(category.Description))
+	} else {
+		update = update.Remove(expression.Name("description"))
+	}
+	if category.Parent != "" {
+		update = update.Set(expression.Name("parent"), expression.Value(category.Parent))
+	} else {
+		update = update.Remove(expression.Name("parent"))
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(expression.AttributeExists(expression.Name("id"))).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: category.ID},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	})
+	return notFoundIfConditionFailed(err, "category", category.ID)
+}
+
+func DeleteCategory(ctx context.Context, client *dynamodb.Client, tableName, id string) error {
+	ctx, span := tracing.Start(ctx, "db.DeleteCategory", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	return err
+}
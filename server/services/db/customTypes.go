@@ -1,63 +1,269 @@
 package db
 
+import "upgraded-telegram/main.go/server/services/money"
+
+// `validate` tags are enforced by the validation package before any write
+
 type User struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	ID       string `json:"id" validate:"key"`
+	Name     string `json:"name" validate:"required,max=100"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+	// Role is set by admins only, empty is a customer
+	Role string `json:"role,omitempty" validate:"oneof=customer staff admin"`
 }
 
 type Message struct {
 	ID     string   `json:"id"`
-	Sender string   `json:"sender"`
-	Text   string   `json:"text"`
-	Media  []string `json:"media"`
+	Sender string   `json:"sender" validate:"required"`
+	Text   string   `json:"text" validate:"required_without=Media,max=4000"`
+	Media  []string `json:"media" validate:"max=10"`
 	Date   int64    `json:"date"` // func (t time.Time) UnixMilli() int64
 }
 
 type Chat struct {
-	ID       string   `json:"id"`
-	Users    []string `json:"users"`
+	ID       string   `json:"id" validate:"key"`
+	Users    []string `json:"users" validate:"required,max=100"`
 	Messages []string `json:"messages"`
 	Active   int64    `json:"active"`
 }
 type Event struct {
-	ID                 string   `json:"id"`
-	Name               string   `json:"name"`
-	Description        string   `json:"description"`
+	ID                 string   `json:"id" validate:"key"`
+	Name               string   `json:"name" validate:"required,max=200"`
+	Description        string   `json:"description" validate:"max=2000"`
 	AssignedTo         []string `json:"assigned_to"`
-	StartDate          int64    `json:"start_date"`
-	EndDate            int64    `json:"end_date"`
-	LocationName       string   `json:"location_name"`
-	LocationAddress    string   `json:"location_address"`
-	LocationLong       int64    `json:"location_long"`
-	LocationLat        int64    `json:"location_lat"`
-	Notes              string   `json:"notes"`
-	FirstNotification  int64    `json:"first_notification"`
-	SecondNotification int64    `json:"second_notification"`
+	StartDate          int64    `json:"start_date" validate:"required,min=0"`
+	EndDate            int64    `json:"end_date" validate:"required,gtefield=StartDate"`
+	LocationName       string   `json:"location_name" validate:"max=200"`
+	LocationAddress    string   `json:"location_address" validate:"max=500"`
+	LocationLong       int64    `json:"location_long" validate:"min=-180,max=180"`
+	LocationLat        int64    `json:"location_lat" validate:"min=-90,max=90"`
+	Notes              string   `json:"notes" validate:"max=4000"`
+	FirstNotification  int64    `json:"first_notification" validate:"min=0"`
+	SecondNotification int64    `json:"second_notification" validate:"min=0"`
 	Active             bool     `json:"active"`
 	CreatedAt          int64    `json:"created_at"`
 	UpdatedAt          int64    `json:"updated_at"`
 }
 
+// items and orders are stored with attributevalue.MarshalMap, so their fields also carry `dynamodbav` names
+
+// Item is sold at Price, its base price. Prices holds explicit prices in other currencies, in their minor units;
+// orders in a currency without one convert the base price.
+//
+// An item with Variants is sold by variant, keyed by SKU, each with its own price and stock; DefaultSKU is the one
+// ordered when a line names none. Its Price, Prices and Inventory then mirror the default variant's prices and the
+// total stock of all variants. An item without variants is its own single, default variant with the item's id as
+// SKU. Categories are category ids, Attributes and the variants' attributes are free-form, such as material or size.
 type Item struct {
-	ID          string   `json:"id"`
-	Name        string   `json:"name"`
-	Description string   `json:"description"`
-	Images      []string `json:"images"`
-	Price       int64    `json:"price"`
-	Inventory   int      `json:"inventory"`
-	Active      bool     `json:"active"`
-	CreatedAt   int64    `json:"created_at"`
-	UpdatedAt   int64    `json:"updated_at"`
+	ID          string             `json:"id" dynamodbav:"id" validate:"key"`
+	Name        string             `json:"name" dynamodbav:"name" validate:"required,max=200"`
+	Description string             `json:"description" dynamodbav:"description" validate:"max=2000"`
+	Images      []string           `json:"images" dynamodbav:"images,stringset,omitempty" validate:"max=20"`
+	Price       money.Money        `json:"price" dynamodbav:"price"`
+	Prices      map[string]int64   `json:"prices,omitempty" dynamodbav:"prices,omitempty"`
+	TaxCategory string             `json:"tax_category,omitempty" dynamodbav:"tax_category,omitempty" validate:"max=64"`
+	Inventory   int                `json:"inventory" dynamodbav:"inventory" validate:"min=0"`
+	Variants    map[string]Variant `json:"variants,omitempty" dynamodbav:"variants,omitempty" validate:"max=100"`
+	DefaultSKU  string             `json:"default_sku,omitempty" dynamodbav:"default_sku,omitempty" validate:"max=64"`
+	Categories  []string           `json:"categories,omitempty" dynamodbav:"categories,stringset,omitempty" validate:"max=20"`
+	Tags        []string           `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty" validate:"max=50"`
+	Attributes  map[string]string  `json:"attributes,omitempty" dynamodbav:"attributes,omitempty" validate:"max=50"`
+	Active      bool               `json:"active" dynamodbav:"active"`
+	CreatedAt   int64              `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt   int64              `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Variant is one sellable version of an item, such as a size and color.
+type Variant struct {
+	Attributes map[string]string `json:"attributes,omitempty" dynamodbav:"attributes,omitempty" validate:"max=20"`
+	Price      money.Money       `json:"price" dynamodbav:"price"`
+	Prices     map[string]int64  `json:"prices,omitempty" dynamodbav:"prices,omitempty"`
+	Inventory  int               `json:"inventory" dynamodbav:"inventory" validate:"min=0"`
+}
+
+// Variant returns the resolved SKU, the variant and ok for sku, the default variant when sku is empty. An item
+// without variants is its own only variant, with its ID as the SKU; ok is false when there is no such variant.
+func (i Item) Variant(sku string) (string, Variant, bool) {
+	if len(i.Variants) == 0 {
+		if sku != "" && sku != i.ID {
+			return "", Variant{}, false
+		}
+		return i.ID, Variant{Price: i.Price, Prices: i.Prices, Inventory: i.Inventory}, true
+	}
+	if sku == "" {
+		sku = i.DefaultSKU
+	}
+	variant, ok := i.Variants[sku]
+	return sku, variant, ok
+}
+
+// PriceIn returns the variant's explicit price in currency, or its base price when it has none.
+func (v Variant) PriceIn(currency string) money.Money {
+	if amount, ok := v.Prices[currency]; ok {
+		return money.New(amount, currency)
+	}
+	return v.Price
+}
+
+// Category is a node of the catalog tree, its id is a slug such as "t-shirts". Parent is empty for top-level
+// categories.
+type Category struct {
+	ID          string `json:"id" dynamodbav:"id" validate:"required,max=64"`
+	Name        string `json:"name" dynamodbav:"name" validate:"required,max=100"`
+	Description string `json:"description,omitempty" dynamodbav:"description,omitempty" validate:"max=2000"`
+	Parent      string `json:"parent,omitempty" dynamodbav:"parent,omitempty" validate:"max=64"`
+	Position    int    `json:"position" dynamodbav:"position" validate:"min=0"`
+	CreatedAt   int64  `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt   int64  `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// LineItem is one item of an order. The prices are set by the server when the order is priced, values sent by the
+// client are ignored. ListPrice is the item's price the line was priced from, UnitPrice is the same price in the
+// order's currency. TaxCategory is the item's tax category when the order was priced. SKU selects the variant,
+// the item's default one when it is empty, and is set to the variant that was ordered.
+type LineItem struct {
+	ItemID      string            `json:"item_id" dynamodbav:"item_id" validate:"required"`
+	SKU         string            `json:"sku,omitempty" dynamodbav:"sku,omitempty" validate:"max=64"`
+	Name        string            `json:"name" dynamodbav:"name"`
+	Attributes  map[string]string `json:"attributes,omitempty" dynamodbav:"attributes,omitempty"`
+	Quantity    int               `json:"quantity" dynamodbav:"quantity" validate:"min=1,max=1000"`
+	ListPrice   money.Money       `json:"list_price" dynamodbav:"list_price"`
+	UnitPrice   money.Money       `json:"unit_price" dynamodbav:"unit_price"`
+	Subtotal    money.Money       `json:"subtotal" dynamodbav:"subtotal"`
+	TaxCategory string            `json:"tax_category,omitempty" dynamodbav:"tax_category,omitempty"`
 }
 
+// Order totals are computed by the server in Currency: Total = Subtotal - Discount + Tax, or Subtotal - Discount
+// when prices include tax. ExchangeRates holds the rate from every other currency a line was converted from, and
+// TaxBreakdown how Tax was computed; both are kept as they were when the order was placed.
 type Order struct {
-	ID        string   `json:"id"`
-	User      string   `json:"user"`
-	Items     []string `json:"items"`
-	Total     int64    `json:"total"`
-	Status    string   `json:"status"`
-	CreatedAt int64    `json:"created_at"`
-	UpdatedAt int64    `json:"updated_at"`
+	ID            string            `json:"id" dynamodbav:"id" validate:"key"`
+	User          string            `json:"user" dynamodbav:"user" validate:"required"`
+	Items         []LineItem        `json:"items" dynamodbav:"items" validate:"required,max=99"`
+	Currency      string            `json:"currency" dynamodbav:"currency" validate:"currency"`
+	Subtotal      money.Money       `json:"subtotal" dynamodbav:"subtotal"`
+	Discount      money.Money       `json:"discount" dynamodbav:"discount"`
+	Tax           money.Money       `json:"tax" dynamodbav:"tax"`
+	Total         money.Money       `json:"total" dynamodbav:"total"`
+	ExchangeRates map[string]string `json:"exchange_rates,omitempty" dynamodbav:"exchange_rates,omitempty"`
+	TaxBreakdown  *TaxBreakdown     `json:"tax_breakdown,omitempty" dynamodbav:"tax_breakdown,omitempty"`
+	// ShippingAddress is where the order is taxed, Country and Region are filled in by geocoding when missing
+	ShippingAddress *Address `json:"shipping_address,omitempty" dynamodbav:"shipping_address,omitempty"`
+	// DiscountCode is redeemed when the order is placed, its amount is in Discount
+	DiscountCode string `json:"discount_code,omitempty" dynamodbav:"discount_code,omitempty" validate:"max=64"`
+	Status       string `json:"status" dynamodbav:"status" validate:"oneof=pending failed paid fulfilled shipped delivered canceled refunded"`
+	// PaymentID is the latest payment, another can only be started once it failed or was canceled
+	PaymentID string `json:"payment_id,omitempty" dynamodbav:"payment_id,omitempty"`
+	// History is appended to on every status change and never rewritten
+	History   []StatusChange `json:"history" dynamodbav:"history"`
+	CreatedAt int64          `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt int64          `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Address is a postal address. Country is an ISO 3166-1 alpha-2 code and Region the code of a state or province
+// within it, "US" and "CA" for California.
+type Address struct {
+	Line1      string `json:"line1" dynamodbav:"line1" validate:"max=200"`
+	Line2      string `json:"line2,omitempty" dynamodbav:"line2,omitempty" validate:"max=200"`
+	City       string `json:"city" dynamodbav:"city" validate:"max=100"`
+	PostalCode string `json:"postal_code" dynamodbav:"postal_code" validate:"max=20"`
+	Region     string `json:"region,omitempty" dynamodbav:"region,omitempty" validate:"max=3"`
+	Country    string `json:"country" dynamodbav:"country" validate:"max=2"`
+}
+
+// TaxBreakdown is the tax of an order by rule. Region is where the order was taxed, "US-CA" or "DE", empty when no
+// rule applied. With Inclusive the tax is part of the prices instead of added to them.
+type TaxBreakdown struct {
+	Region    string    `json:"region" dynamodbav:"region"`
+	Inclusive bool      `json:"inclusive" dynamodbav:"inclusive"`
+	Lines     []TaxLine `json:"lines" dynamodbav:"lines"`
+}
+
+// TaxLine is the tax due under one rule: Rate, a decimal such as "0.0725", of Taxable, the discounted subtotal of
+// the order's lines in Category.
+type TaxLine struct {
+	Name     string      `json:"name" dynamodbav:"name"`
+	Region   string      `json:"region" dynamodbav:"region"`
+	Category string      `json:"category" dynamodbav:"category"`
+	Rate     string      `json:"rate" dynamodbav:"rate"`
+	Taxable  money.Money `json:"taxable" dynamodbav:"taxable"`
+	Amount   money.Money `json:"amount" dynamodbav:"amount"`
+}
+
+// StatusChange records who moved an order to a status, when and why. From is empty for the first entry.
+type StatusChange struct {
+	From   string `json:"from,omitempty" dynamodbav:"from,omitempty"`
+	To     string `json:"to" dynamodbav:"to"`
+	By     string `json:"by" dynamodbav:"by"`
+	Role   string `json:"role" dynamodbav:"role"`
+	Reason string `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+	At     int64  `json:"at" dynamodbav:"at"`
+}
+
+// Payment is one attempt to pay an order through a payment gateway, in minor units of the order's currency.
+// Status is requires_confirmation, requires_capture, succeeded, failed or canceled.
+type Payment struct {
+	ID            string `json:"id" dynamodbav:"id"`
+	OrderID       string `json:"order_id" dynamodbav:"order_id"`
+	Gateway       string `json:"gateway" dynamodbav:"gateway"`
+	IntentID      string `json:"intent_id" dynamodbav:"intent_id"`
+	Amount        int64  `json:"amount" dynamodbav:"amount"`
+	Currency      string `json:"currency" dynamodbav:"currency"`
+	Status        string `json:"status" dynamodbav:"status"`
+	Refunded      int64  `json:"refunded" dynamodbav:"refunded"`             // every refund that has not failed
+	RefundSettled int64  `json:"refund_settled" dynamodbav:"refund_settled"` // the refunds the gateway confirmed
+	FailureReason string `json:"failure_reason,omitempty" dynamodbav:"failure_reason,omitempty"`
+	CreatedAt     int64  `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt     int64  `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Refund returns part or all of a succeeded payment. Status is pending, succeeded or failed.
+type Refund struct {
+	ID              string `json:"id" dynamodbav:"id"`
+	PaymentID       string `json:"payment_id" dynamodbav:"payment_id"`
+	OrderID         string `json:"order_id" dynamodbav:"order_id"`
+	GatewayRefundID string `json:"gateway_refund_id" dynamodbav:"gateway_refund_id"`
+	Amount          int64  `json:"amount" dynamodbav:"amount"`
+	Reason          string `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+	Status          string `json:"status" dynamodbav:"status"`
+	CreatedBy       string `json:"created_by" dynamodbav:"created_by"`
+	CreatedAt       int64  `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt       int64  `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// DiscountCode is a coupon redeemed when an order is placed. Value is a percentage from 1 to 100 for percent codes
+// and an amount in minor units of Currency for fixed codes, MinTotal is in Currency too. A code with a Currency
+// only applies to orders in it; fixed codes and minimums without one are in the default currency. A code with
+// ItemIDs only discounts those items. Zero limits and times mean no limit; times are unix milliseconds.
+type DiscountCode struct {
+	Code           string   `json:"code" dynamodbav:"id" validate:"required,max=64"`
+	Type           string   `json:"type" dynamodbav:"type" validate:"required,oneof=percent fixed"`
+	Value          int64    `json:"value" dynamodbav:"value" validate:"required,min=1"`
+	Currency       string   `json:"currency,omitempty" dynamodbav:"currency,omitempty" validate:"currency"`
+	MinTotal       int64    `json:"min_total" dynamodbav:"min_total" validate:"min=0"`
+	ItemIDs        []string `json:"item_ids" dynamodbav:"item_ids,stringset,omitempty" validate:"max=100"`
+	MaxRedemptions int      `json:"max_redemptions" dynamodbav:"max_redemptions" validate:"min=0"`
+	MaxPerUser     int      `json:"max_per_user" dynamodbav:"max_per_user" validate:"min=0"`
+	StartsAt       int64    `json:"starts_at" dynamodbav:"starts_at" validate:"min=0"`
+	EndsAt         int64    `json:"ends_at" dynamodbav:"ends_at" validate:"gtefield=StartsAt"`
+	Active         bool     `json:"active" dynamodbav:"active"`
+	// Redemptions is only changed by placing and canceling orders
+	Redemptions int   `json:"redemptions" dynamodbav:"redemptions"`
+	CreatedAt   int64 `json:"created_at" dynamodbav:"created_at"`
+	UpdatedAt   int64 `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+// Redemption counts one user's orders with a discount code, its id is "<code>|<user>".
+type Redemption struct {
+	ID        string   `json:"id" dynamodbav:"id"`
+	Code      string   `json:"code" dynamodbav:"code"`
+	User      string   `json:"user" dynamodbav:"user"`
+	Count     int      `json:"count" dynamodbav:"count"`
+	Orders    []string `json:"orders" dynamodbav:"orders,stringset,omitempty"`
+	UpdatedAt int64    `json:"updated_at" dynamodbav:"updated_at"`
+}
+
+func RedemptionID(code, user string) string {
+	return code + "|" + user
 }
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Discount codes are keyed by the code itself. Their redemption counts are only written by the checkout store,
+// together with the order that redeems them.
+
+func CreateDiscount(ctx context.Context, client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
+	ctx, span := tracing.Start(ctx, "db.CreateDiscount", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(tableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return apperrors.Conflict("discount_exists", "A discount code with this code already exists.").WithCause(err)
+	}
+	return err
+}
+
+// GetDiscountByCode reads consistently, a code is read right before it is redeemed.
+func GetDiscountByCode(ctx context.Context, client *dynamodb.Client, tableName, code string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetDiscountByCode", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: code},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("discount_not_found", fmt.Sprintf("No discount code %s.", code))
+	}
+	return result.Item, nil
+}
+
+func GetAllDiscounts(ctx context.Context, client *dynamodb.Client, tableName string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetAllDiscounts", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	return scan(ctx, client, tableName, page)
+}
+
+// UpdateDiscount replaces the rules of a code. Its redemption count and creation time are kept, and updated_at
+// changes, which fails any checkout that evaluated the code before the update.
+func UpdateDiscount(ctx context.Context, client *dynamodb.Client, tableName string, discount DiscountCode) error {
+	ctx, span := tracing.Start(ctx, "db.UpdateDiscount", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	update := expression.Set(expression.Name("type"), expression.Value(discount.Type)).
+		Set(expression.Name("value"), expression.Value(discount.Value)).
+		Set(expression.Name("min_total"), expression.Value(discount.MinTotal)).
+		Set(expression.Name("max_redemptions"), expression.Value(discount.MaxRedemptions)).
+		Set(expression.Name("max_per_user"), expression.Value(discount.MaxPerUser)).
+		Set(expression.Name("starts_at"), expression.Value(discount.StartsAt)).
+		Set(expression.Name("ends_at"), expression.Value(discount.EndsAt)).
+		Set(expression.Name("active"), expression.Value(discount.Active)).
+		Set(expression.Name("updated_at"), expression.Value(time.Now().UnixMilli()))
+	// an empty string set cannot be stored
+	if len(discount.ItemIDs) > 0 {
+		update = update.Set(expression.Name("item_ids"), expression.Value(types.AttributeValueMemberSS{Value: discount.ItemIDs}))
+	} else {
+		update = update.Remove(expression.Name("item_ids"))
+	}
+	if discount.Currency != "" {
+		update = update.Set(expression.Name("currency"), expression.Value(discount.Currency))
+	} else {
+		update = update.Remove(expression.Name("currency"))
+	}
+
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(expression.AttributeExists(expression.Name("id"))).Build()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: discount.Code},
+		},
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
+		UpdateExpression:          expr.Update(),
+	})
+	return notFoundIfConditionFailed(err, "discount", discount.Code)
+}
+
+func DeleteDiscount(ctx context.Context, client *dynamodb.Client, tableName, code string) error {
+	ctx, span := tracing.Start(ctx, "db.DeleteDiscount", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: code},
+		},
+	})
+	return err
+}
+
+// GetRedemptions returns how many orders user has placed with code, zero when there are none.
+func GetRedemptions(ctx context.Context, client *dynamodb.Client, tableName, code, user string) (int, error) {
+	ctx, span := tracing.Start(ctx, "db.GetRedemptions", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: RedemptionID(code, user)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || result.Item == nil {
+		return 0, err
+	}
+	var redemption Redemption
+	err = attributevalue.UnmarshalMap(result.Item, &redemption)
+	return redemption.Count, err
+}
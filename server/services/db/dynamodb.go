@@ -2,27 +2,168 @@ package db
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
+	"log/slog"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+// Timeout bounds every DynamoDB call made by this package, including all pages of a scan.
+var Timeout = 5 * time.Second
+
+type TableNames struct {
+	Users    string
+	Chats    string
+	Messages string
+	Events   string
+	Items    string
+	Orders   string
+
+	Payments        string
+	Refunds         string
+	Discounts       string
+	Redemptions     string
+	Categories      string
+	RateLimits      string
+	IdempotencyKeys string
+}
+
+// Tables holds the table names used by the handlers, set it from the configuration at startup.
+var Tables = TableNames{
+	Users:    "users",
+	Chats:    "chats",
+	Messages: "messages",
+	Events:   "events",
+	Items:    "items",
+	Orders:   "orders",
+
+	Payments:        "payments",
+	Refunds:         "refunds",
+	Discounts:       "discounts",
+	Redemptions:     "redemptions",
+	Categories:      "categories",
+	RateLimits:      "rate_limits",
+	IdempotencyKeys: "idempotency_keys",
+}
+
 func ConnectDB(cfg aws.Config) *dynamodb.Client {
 	dynamoClient := dynamodb.NewFromConfig(cfg)
-	_, err := GetTables(dynamoClient)
+	_, err := GetTables(context.Background(), dynamoClient)
 	if err != nil {
 		log.Fatalf("Error connecting to DynamoDB.")
 	}
-	log.Printf("Connected to DynamoDB\n")
+	slog.Info("connected to DynamoDB")
 	return dynamoClient
 }
 
-func GetTables(client *dynamodb.Client) ([]string, error) {
+func GetTables(ctx context.Context, client *dynamodb.Client) ([]string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetTables")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
-	result, err := client.ListTables(context.TODO(), &dynamodb.ListTablesInput{})
+	result, err := client.ListTables(ctx, &dynamodb.ListTablesInput{})
 	if err != nil {
 		return nil, err
 	}
 	return result.TableNames, nil
 }
+
+// Page selects one page of a table scan. A zero Limit reads the whole table.
+type Page struct {
+	Limit  int32
+	Cursor string // the opaque cursor returned with the previous page
+}
+
+// scan returns one page of tableName, or all of it when page.Limit is zero, and the cursor of the next page.
+// The cursor is empty once the table is exhausted; a full last page may still be followed by an empty one.
+func scan(ctx context.Context, client *dynamodb.Client, tableName string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	return scanWhere(ctx, client, tableName, page, nil)
+}
+
+// scanWhere is scan keeping only the records that match filter, when it is not nil. DynamoDB limits the records a
+// scan reads rather than those it returns, so a filtered page keeps reading until it is full or the table is
+// exhausted.
+func scanWhere(ctx context.Context, client *dynamodb.Client, tableName string, page Page, filter *expression.Expression) ([]map[string]types.AttributeValue, string, error) {
+	input := &dynamodb.ScanInput{TableName: aws.String(tableName)}
+	if page.Cursor != "" {
+		id, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+		if err != nil || len(id) == 0 {
+			return nil, "", apperrors.BadRequest("invalid_cursor", "The cursor is not valid.")
+		}
+		input.ExclusiveStartKey = map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: string(id)},
+		}
+	}
+	if filter != nil {
+		input.FilterExpression = filter.Filter()
+		input.ExpressionAttributeNames = filter.Names()
+		input.ExpressionAttributeValues = filter.Values()
+	}
+
+	var items []map[string]types.AttributeValue
+	for {
+		if page.Limit > 0 {
+			// reading no more records than are missing keeps the page from overflowing its limit
+			input.Limit = aws.Int32(page.Limit - int32(len(items)))
+		}
+		out, err := client.Scan(ctx, input)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, out.Items...)
+
+		if out.LastEvaluatedKey == nil {
+			return items, "", nil
+		}
+		if page.Limit > 0 && int32(len(items)) >= page.Limit {
+			var next string
+			if id, ok := out.LastEvaluatedKey["id"].(*types.AttributeValueMemberS); ok {
+				next = base64.RawURLEncoding.EncodeToString([]byte(id.Value))
+			}
+			return items, next, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// maxBatchGet is the most keys a single BatchGetItem call accepts.
+const maxBatchGet = 100
+
+// backoff is the delay before retrying unprocessed batch work, doubling from 50ms up to 1s.
+func backoff(attempt int) time.Duration {
+	if attempt >= 5 {
+		return time.Second
+	}
+	return 50 * time.Millisecond << attempt
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// conditional writes use attribute_exists(id), so a failed condition means the record is missing
+func notFoundIfConditionFailed(err error, entity, id string) error {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return apperrors.NotFound(entity+"_not_found", fmt.Sprintf("No %s found with id %s.", entity, id)).WithCause(err)
+	}
+	return err
+}
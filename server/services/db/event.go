@@ -3,24 +3,39 @@ package db
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"time"
 
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func CreateEvent(client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
-	_, err := client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+func CreateEvent(ctx context.Context, client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
+	ctx, span := tracing.Start(ctx, "db.CreateEvent", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(tableName),
 		Item:      item,
 	})
 	return err
 }
 
-func GetEventById(client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
-	result, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+func GetEventById(ctx context.Context, client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetEventById", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
@@ -29,34 +44,27 @@ func GetEventById(client *dynamodb.Client, tableName, id string) (map[string]typ
 	if err != nil {
 		return nil, err
 	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("event_not_found", fmt.Sprintf("No event found with id %s.", id))
+	}
 	return result.Item, nil
 }
 
-func GetAllEvents(client *dynamodb.Client, tableName string) ([]map[string]types.AttributeValue, error) {
-	var items []map[string]types.AttributeValue
-	var lastEvaluatedKey map[string]types.AttributeValue
-
-	for {
-		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-			TableName:         aws.String(tableName),
-			ExclusiveStartKey: lastEvaluatedKey,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		items = append(items, out.Items...)
+func GetAllEvents(ctx context.Context, client *dynamodb.Client, tableName string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetAllEvents", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
-		if out.LastEvaluatedKey == nil {
-			break
-		}
-		lastEvaluatedKey = out.LastEvaluatedKey
-	}
-
-	return items, nil
+	return scan(ctx, client, tableName, page)
 }
 
-func UpdateEvent(client *dynamodb.Client, tableName string, event Event) error {
+func UpdateEvent(ctx context.Context, client *dynamodb.Client, tableName string, event Event) error {
+	ctx, span := tracing.Start(ctx, "db.UpdateEvent", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
 	updateBuilder := expression.UpdateBuilder{}
 	updatedFields := 0 // Track the number of fields updated
 
@@ -114,34 +122,40 @@ func UpdateEvent(client *dynamodb.Client, tableName string, event Event) error {
 	updatedFields++
 	// Ensure at least one field is being updated
 	if updatedFields == 0 {
-		return fmt.Errorf("must update at least one field")
+		return apperrors.Validation("no_fields_to_update", "Must update at least one field.")
 	}
 
-	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).Build()
+	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(expression.AttributeExists(expression.Name("id"))).Build()
 	if err != nil {
-		fmt.Println("Error in expression builder:", err)
+		slog.ErrorContext(ctx, "failed to build update expression", "error", err)
 		return err
 	}
 
-	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: event.ID},
 		},
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
 		UpdateExpression:          expr.Update(),
 		ReturnValues:              types.ReturnValueUpdatedNew,
 	})
 
 	if err != nil {
-		fmt.Println("Error in client updater:", err)
+		slog.ErrorContext(ctx, "failed to update item", "table", tableName, "error", err)
 	}
-	return err
+	return notFoundIfConditionFailed(err, "event", event.ID)
 }
 
-func DeleteEvent(client *dynamodb.Client, tableName, id string) error {
-	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+func DeleteEvent(ctx context.Context, client *dynamodb.Client, tableName, id string) error {
+	ctx, span := tracing.Start(ctx, "db.DeleteEvent", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
@@ -3,24 +3,39 @@ package db
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"time"
 
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func CreateItem(client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
-	_, err := client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+func CreateItem(ctx context.Context, client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
+	ctx, span := tracing.Start(ctx, "db.CreateItem", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(tableName),
 		Item:      item,
 	})
 	return err
 }
 
-func GetItemById(client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
-	result, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+func GetItemById(ctx context.Context, client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetItemById", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
@@ -29,34 +44,111 @@ func GetItemById(client *dynamodb.Client, tableName, id string) (map[string]type
 	if err != nil {
 		return nil, err
 	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("item_not_found", fmt.Sprintf("No item found with id %s.", id))
+	}
 	return result.Item, nil
 }
 
-func GetAllItems(client *dynamodb.Client, tableName string) ([]map[string]types.AttributeValue, error) {
+// GetItemsByIds reads the items with the given ids, ids that do not exist are left out of the result.
+func GetItemsByIds(ctx context.Context, client *dynamodb.Client, tableName string, ids []string) ([]map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetItemsByIds", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
 	var items []map[string]types.AttributeValue
-	var lastEvaluatedKey map[string]types.AttributeValue
-
-	for {
-		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-			TableName:         aws.String(tableName),
-			ExclusiveStartKey: lastEvaluatedKey,
-		})
-		if err != nil {
-			return nil, err
+	for start := 0; start < len(ids); start += maxBatchGet {
+		var keys []map[string]types.AttributeValue
+		for _, id := range ids[start:min(start+maxBatchGet, len(ids))] {
+			keys = append(keys, map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: id}})
+		}
+		request := map[string]types.KeysAndAttributes{
+			tableName: {Keys: keys, ConsistentRead: aws.Bool(true)},
 		}
 
-		items = append(items, out.Items...)
-
-		if out.LastEvaluatedKey == nil {
-			break
+		// DynamoDB may return part of a batch as unprocessed under load, ask again for the rest
+		for attempt := 0; len(request) > 0; attempt++ {
+			if attempt > 0 {
+				err := sleep(ctx, backoff(attempt))
+				if err != nil {
+					return nil, err
+				}
+			}
+			out, err := client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, out.Responses[tableName]...)
+			request = out.UnprocessedKeys
 		}
-		lastEvaluatedKey = out.LastEvaluatedKey
 	}
-
 	return items, nil
 }
 
-func UpdateItem(client *dynamodb.Client, tableName string, item Item) error {
+// GetActiveItems returns one page of the items for sale.
+func GetActiveItems(ctx context.Context, client *dynamodb.Client, tableName string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetActiveItems", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	expr, err := expression.NewBuilder().WithFilter(expression.Name("active").Equal(expression.Value(true))).Build()
+	if err != nil {
+		return nil, "", err
+	}
+	return scanWhere(ctx, client, tableName, page, &expr)
+}
+
+func GetAllItems(ctx context.Context, client *dynamodb.Client, tableName string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetAllItems", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	return scan(ctx, client, tableName, page)
+}
+
+// GetItemsInCategories returns one page of the active items in any of categories.
+func GetItemsInCategories(ctx context.Context, client *dynamodb.Client, tableName string, categories []string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetItemsInCategories", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	filter := expression.Contains(expression.Name("categories"), categories[0])
+	for _, category := range categories[1:] {
+		filter = filter.Or(expression.Contains(expression.Name("categories"), category))
+	}
+	return getItemsWhere(ctx, client, tableName, filter, page)
+}
+
+// GetItemsWithTag returns one page of the active items tagged with tag.
+func GetItemsWithTag(ctx context.Context, client *dynamodb.Client, tableName, tag string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetItemsWithTag", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	return getItemsWhere(ctx, client, tableName, expression.Contains(expression.Name("tags"), tag), page)
+}
+
+func getItemsWhere(ctx context.Context, client *dynamodb.Client, tableName string, filter expression.ConditionBuilder, page Page) ([]map[string]types.AttributeValue, string, error) {
+	filter = filter.And(expression.Name("active").Equal(expression.Value(true)))
+	expr, err := expression.NewBuilder().WithFilter(filter).Build()
+	if err != nil {
+		return nil, "", err
+	}
+	return scanWhere(ctx, client, tableName, page, &expr)
+}
+
+// UpdateItem returns the item as it is after the update.
+func UpdateItem(ctx context.Context, client *dynamodb.Client, tableName string, item Item) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.UpdateItem", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
 	updateBuilder := expression.UpdateBuilder{}
 	updatedFields := 0 // Track the number of fields updated
 
@@ -70,8 +162,46 @@ func UpdateItem(client *dynamodb.Client, tableName string, item Item) error {
 	}
 	updateBuilder = updateBuilder.Set(expression.Name("price"), expression.Value(item.Price))
 	updatedFields++
+	if item.Prices != nil {
+		updateBuilder = updateBuilder.Set(expression.Name("prices"), expression.Value(item.Prices))
+		updatedFields++
+	}
+	if item.TaxCategory != "" {
+		updateBuilder = updateBuilder.Set(expression.Name("tax_category"), expression.Value(item.TaxCategory))
+	} else {
+		updateBuilder = updateBuilder.Remove(expression.Name("tax_category"))
+	}
+	updatedFields++
 	updateBuilder = updateBuilder.Set(expression.Name("inventory"), expression.Value(item.Inventory))
 	updatedFields++
+	// variants, categories, tags and attributes are replaced as a whole, leaving them out removes them
+	if len(item.Variants) > 0 {
+		updateBuilder = updateBuilder.Set(expression.Name("variants"), expression.Value(item.Variants))
+		updateBuilder = updateBuilder.Set(expression.Name("default_sku"), expression.Value(item.DefaultSKU))
+	} else {
+		updateBuilder = updateBuilder.Remove(expression.Name("variants"))
+		updateBuilder = updateBuilder.Remove(expression.Name("default_sku"))
+	}
+	updatedFields++
+	// an empty string set cannot be stored
+	if len(item.Categories) > 0 {
+		updateBuilder = updateBuilder.Set(expression.Name("categories"), expression.Value(types.AttributeValueMemberSS{Value: item.Categories}))
+	} else {
+		updateBuilder = updateBuilder.Remove(expression.Name("categories"))
+	}
+	updatedFields++
+	if len(item.Tags) > 0 {
+		updateBuilder = updateBuilder.Set(expression.Name("tags"), expression.Value(types.AttributeValueMemberSS{Value: item.Tags}))
+	} else {
+		updateBuilder = updateBuilder.Remove(expression.Name("tags"))
+	}
+	updatedFields++
+	if len(item.Attributes) > 0 {
+		updateBuilder = updateBuilder.Set(expression.Name("attributes"), expression.Value(item.Attributes))
+	} else {
+		updateBuilder = updateBuilder.Remove(expression.Name("attributes"))
+	}
+	updatedFields++
 	updateBuilder = updateBuilder.Set(expression.Name("active"), expression.Value(item.Active))
 	updatedFields++
 	updateBuilder = updateBuilder.Set(expression.Name("updated_at"), expression.Value(time.Now().Unix()))
@@ -79,34 +209,41 @@ func UpdateItem(client *dynamodb.Client, tableName string, item Item) error {
 
 	// Ensure at least one field is being updated
 	if updatedFields == 0 {
-		return fmt.Errorf("must update at least one field")
+		return nil, apperrors.Validation("no_fields_to_update", "Must update at least one field.")
 	}
 
-	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).Build()
+	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(expression.AttributeExists(expression.Name("id"))).Build()
 	if err != nil {
-		fmt.Println("Error in expression builder:", err)
-		return err
+		slog.ErrorContext(ctx, "failed to build update expression", "error", err)
+		return nil, err
 	}
 
-	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+	out, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: item.ID},
 		},
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
 		UpdateExpression:          expr.Update(),
-		ReturnValues:              types.ReturnValueUpdatedNew,
+		ReturnValues:              types.ReturnValueAllNew,
 	})
 
 	if err != nil {
-		fmt.Println("Error in client updater:", err)
+		slog.ErrorContext(ctx, "failed to update item", "table", tableName, "error", err)
+		return nil, notFoundIfConditionFailed(err, "item", item.ID)
 	}
-	return err
+	return out.Attributes, nil
 }
 
-func DeleteItem(client *dynamodb.Client, tableName, id string) error {
-	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+func DeleteItem(ctx context.Context, client *dynamodb.Client, tableName, id string) error {
+	ctx, span := tracing.Start(ctx, "db.DeleteItem", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
@@ -2,22 +2,37 @@ package db
 
 import (
 	"context"
+	"fmt"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func CreateMessage(client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
-	_, err := client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+func CreateMessage(ctx context.Context, client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
+	ctx, span := tracing.Start(ctx, "db.CreateMessage", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(tableName),
 		Item:      item,
 	})
 	return err
 }
 
-func GetMessageById(client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
-	result, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+func GetMessageById(ctx context.Context, client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetMessageById", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
@@ -26,11 +41,19 @@ func GetMessageById(client *dynamodb.Client, tableName, id string) (map[string]t
 	if err != nil {
 		return nil, err
 	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("message_not_found", fmt.Sprintf("No message found with id %s.", id))
+	}
 	return result.Item, nil
 }
 
-func DeleteMessage(client *dynamodb.Client, tableName, id string) error {
-	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+func DeleteMessage(ctx context.Context, client *dynamodb.Client, tableName, id string) error {
+	ctx, span := tracing.Start(ctx, "db.DeleteMessage", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
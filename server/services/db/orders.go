@@ -2,25 +2,41 @@ package db
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
+	"log/slog"
 	"time"
 
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func CreateOrder(client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
-	_, err := client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+func CreateOrder(ctx context.Context, client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
+	ctx, span := tracing.Start(ctx, "db.CreateOrder", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(tableName),
 		Item:      item,
 	})
 	return err
 }
 
-func GetOrderById(client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
-	result, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+func GetOrderById(ctx context.Context, client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetOrderById", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
@@ -29,82 +45,104 @@ func GetOrderById(client *dynamodb.Client, tableName, id string) (map[string]typ
 	if err != nil {
 		return nil, err
 	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("order_not_found", fmt.Sprintf("No order found with id %s.", id))
+	}
 	return result.Item, nil
 }
 
-func GetAllOrders(client *dynamodb.Client, tableName string) ([]map[string]types.AttributeValue, error) {
-	var items []map[string]types.AttributeValue
-	var lastEvaluatedKey map[string]types.AttributeValue
-
-	for {
-		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-			TableName:         aws.String(tableName),
-			ExclusiveStartKey: lastEvaluatedKey,
-		})
-		if err != nil {
-			return nil, err
-		}
+func GetAllOrders(ctx context.Context, client *dynamodb.Client, tableName string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetAllOrders", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
-		items = append(items, out.Items...)
+	return scan(ctx, client, tableName, page)
+}
 
-		if out.LastEvaluatedKey == nil {
-			break
+// GetOrdersByUser returns one page of the orders placed by user from the user-index GSI, partition key user. The
+// cursor is the id of the last order, the index key is completed with user.
+func GetOrdersByUser(ctx context.Context, client *dynamodb.Client, tableName, user string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetOrdersByUser", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(tableName),
+		IndexName:                aws.String("user-index"),
+		KeyConditionExpression:   aws.String("#user = :user"),
+		ExpressionAttributeNames: map[string]string{"#user": "user"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":user": &types.AttributeValueMemberS{Value: user},
+		},
+	}
+	if page.Limit > 0 {
+		input.Limit = aws.Int32(page.Limit)
+	}
+	if page.Cursor != "" {
+		id, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+		if err != nil || len(id) == 0 {
+			return nil, "", apperrors.BadRequest("invalid_cursor", "The cursor is not valid.")
+		}
+		input.ExclusiveStartKey = map[string]types.AttributeValue{
+			"id":   &types.AttributeValueMemberS{Value: string(id)},
+			"user": &types.AttributeValueMemberS{Value: user},
 		}
-		lastEvaluatedKey = out.LastEvaluatedKey
 	}
 
-	return items, nil
+	out, err := client.Query(ctx, input)
+	if err != nil {
+		return nil, "", err
+	}
+	var next string
+	if id, ok := out.LastEvaluatedKey["id"].(*types.AttributeValueMemberS); ok {
+		next = base64.RawURLEncoding.EncodeToString([]byte(id.Value))
+	}
+	return out.Items, next, nil
 }
 
-func UpdateOrders(client *dynamodb.Client, tableName string, order Order) error {
+func UpdateOrders(ctx context.Context, client *dynamodb.Client, tableName string, order Order) error {
+	ctx, span := tracing.Start(ctx, "db.UpdateOrders", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
 	updateBuilder := expression.UpdateBuilder{}
 	updatedFields := 0 // Track the number of fields updated
 
-	updateBuilder = updateBuilder.Set(expression.Name("user"), expression.Value(order.User))
-	updatedFields++
-	if order.Items != nil {
-		updateBuilder = updateBuilder.Set(expression.Name("items"), expression.Value(order.Items))
+	if order.User != "" {
+		updateBuilder = updateBuilder.Set(expression.Name("user"), expression.Value(order.User))
 		updatedFields++
 	}
-	updateBuilder = updateBuilder.Set(expression.Name("total"), expression.Value(order.Total))
-	updatedFields++
 	updateBuilder = updateBuilder.Set(expression.Name("updated_at"), expression.Value(time.Now().Unix()))
 	updatedFields++
 
 	// Ensure at least one field is being updated
 	if updatedFields == 0 {
-		return fmt.Errorf("must update at least one field")
+		return apperrors.Validation("no_fields_to_update", "Must update at least one field.")
 	}
 
-	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).Build()
+	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(expression.AttributeExists(expression.Name("id"))).Build()
 	if err != nil {
-		fmt.Println("Error in expression builder:", err)
+		slog.ErrorContext(ctx, "failed to build update expression", "error", err)
 		return err
 	}
 
-	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: order.ID},
 		},
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
 		UpdateExpression:          expr.Update(),
 		ReturnValues:              types.ReturnValueUpdatedNew,
 	})
 
 	if err != nil {
-		fmt.Println("Error in client updater:", err)
+		slog.ErrorContext(ctx, "failed to update item", "table", tableName, "error", err)
 	}
-	return err
-}
-
-func DeleteOrder(client *dynamodb.Client, tableName, id string) error {
-	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
-		TableName: aws.String(tableName),
-		Key: map[string]types.AttributeValue{
-			"id": &types.AttributeValueMemberS{Value: id},
-		},
-	})
-	return err
+	return notFoundIfConditionFailed(err, "order", order.ID)
 }
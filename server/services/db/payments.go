@@ -0,0 +1,274 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrPaymentChanged is returned when a payment was changed between reading and writing it, read it again.
+var ErrPaymentChanged = errors.New("payment changed concurrently")
+
+// ErrPaymentInProgress is returned when an order is no longer payable, or got another payment than previous.
+var ErrPaymentInProgress = errors.New("order has another payment in progress")
+
+// CreatePayment puts the payment and makes it the order's payment in one transaction, on the condition that the order
+// is still pending or failed and its payment is still previous, none when previous is empty.
+func CreatePayment(ctx context.Context, client *dynamodb.Client, paymentsTable, ordersTable string, item map[string]types.AttributeValue, orderID, paymentID, previous string) error {
+	ctx, span := tracing.Start(ctx, "db.CreatePayment", attribute.String("db.table", paymentsTable))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	condition := "#status IN (:pending, :failed) AND attribute_not_exists(payment_id)"
+	values := map[string]types.AttributeValue{
+		":id":      &types.AttributeValueMemberS{Value: paymentID},
+		":pending": &types.AttributeValueMemberS{Value: "pending"},
+		":failed":  &types.AttributeValueMemberS{Value: "failed"},
+	}
+	if previous != "" {
+		condition = "#status IN (:pending, :failed) AND payment_id = :previous"
+		values[":previous"] = &types.AttributeValueMemberS{Value: previous}
+	}
+	_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:           aws.String(paymentsTable),
+				Item:                item,
+				ConditionExpression: aws.String("attribute_not_exists(id)"),
+			}},
+			{Update: &types.Update{
+				TableName: aws.String(ordersTable),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: orderID},
+				},
+				UpdateExpression:          aws.String("SET payment_id = :id"),
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  map[string]string{"#status": "status"},
+				ExpressionAttributeValues: values,
+			}},
+		},
+	})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 1 && aws.ToString(canceled.CancellationReasons[1].Code) == "ConditionalCheckFailed" {
+		return ErrPaymentInProgress
+	}
+	return err
+}
+
+// GetPaymentById reads consistently, payments are read right before their status is changed.
+func GetPaymentById(ctx context.Context, client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetPaymentById", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("payment_not_found", fmt.Sprintf("No payment found with id %s.", id))
+	}
+	return result.Item, nil
+}
+
+// UpdatePaymentStatus writes payment's status and failure reason if the stored status is still from.
+func UpdatePaymentStatus(ctx context.Context, client *dynamodb.Client, tableName, from string, payment Payment) error {
+	ctx, span := tracing.Start(ctx, "db.UpdatePaymentStatus", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: payment.ID},
+		},
+		UpdateExpression:         aws.String("SET #status = :status, failure_reason = :reason, updated_at = :now"),
+		ConditionExpression:      aws.String("#status = :from"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: payment.Status},
+			":reason": &types.AttributeValueMemberS{Value: payment.FailureReason},
+			":now":    &types.AttributeValueMemberN{Value: strconv.FormatInt(payment.UpdatedAt, 10)},
+			":from":   &types.AttributeValueMemberS{Value: from},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrPaymentChanged
+	}
+	return err
+}
+
+// ErrRefundTooLarge is returned when a refund would take a payment's refunded total past its amount.
+var ErrRefundTooLarge = errors.New("refund larger than the amount left to refund")
+
+// CreateRefund adds the refund's amount to the payment's refunded total on the condition that the total is at most
+// limit (the payment amount less this refund) beforehand, then puts the refund.
+func CreateRefund(ctx context.Context, client *dynamodb.Client, paymentsTable, refundsTable string, refund map[string]types.AttributeValue, paymentID string, amount, limit, now int64) error {
+	ctx, span := tracing.Start(ctx, "db.CreateRefund", attribute.String("db.table", refundsTable))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(paymentsTable),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: paymentID},
+		},
+		UpdateExpression:    aws.String("ADD refunded :amount SET updated_at = :now"),
+		ConditionExpression: aws.String("refunded <= :limit"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":amount": &types.AttributeValueMemberN{Value: strconv.FormatInt(amount, 10)},
+			":limit":  &types.AttributeValueMemberN{Value: strconv.FormatInt(limit, 10)},
+			":now":    &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrRefundTooLarge
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(refundsTable),
+		Item:                refund,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	})
+	if err != nil {
+		// the refund was not stored, give the reserved amount back
+		releaseErr := ReleaseRefund(ctx, client, paymentsTable, paymentID, amount, now)
+		return errors.Join(err, releaseErr)
+	}
+	return nil
+}
+
+// ReleaseRefund takes the amount of a refund that was never stored off the payment's refunded total again.
+func ReleaseRefund(ctx context.Context, client *dynamodb.Client, tableName, paymentID string, amount, now int64) error {
+	ctx, span := tracing.Start(ctx, "db.ReleaseRefund", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: paymentID},
+		},
+		UpdateExpression: aws.String("ADD refunded :amount SET updated_at = :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":amount": &types.AttributeValueMemberN{Value: strconv.FormatInt(-amount, 10)},
+			":now":    &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+		},
+	})
+	return err
+}
+
+func GetRefundById(ctx context.Context, client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetRefundById", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("refund_not_found", fmt.Sprintf("No refund found with id %s.", id))
+	}
+	return result.Item, nil
+}
+
+// SettleRefund moves a pending refund to status and reports whether it was still pending. In the same transaction a
+// succeeded refund adds its amount to the payment's settled total and a failed one takes it off the refunded total.
+func SettleRefund(ctx context.Context, client *dynamodb.Client, paymentsTable, refundsTable string, refund Refund, gatewayRefundID, status string, now int64) (bool, error) {
+	ctx, span := tracing.Start(ctx, "db.SettleRefund", attribute.String("db.table", refundsTable))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	total, amount := "refund_settled", refund.Amount
+	if status == "failed" {
+		total, amount = "refunded", -refund.Amount
+	}
+	_, err := client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName: aws.String(refundsTable),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: refund.ID},
+				},
+				UpdateExpression:         aws.String("SET #status = :status, gateway_refund_id = :gatewayRefundId, updated_at = :now"),
+				ConditionExpression:      aws.String("#status = :pending"),
+				ExpressionAttributeNames: map[string]string{"#status": "status"},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":status":          &types.AttributeValueMemberS{Value: status},
+					":gatewayRefundId": &types.AttributeValueMemberS{Value: gatewayRefundID},
+					":pending":         &types.AttributeValueMemberS{Value: "pending"},
+					":now":             &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+				},
+			}},
+			{Update: &types.Update{
+				TableName: aws.String(paymentsTable),
+				Key: map[string]types.AttributeValue{
+					"id": &types.AttributeValueMemberS{Value: refund.PaymentID},
+				},
+				UpdateExpression:         aws.String("ADD #total :amount SET updated_at = :now"),
+				ExpressionAttributeNames: map[string]string{"#total": total},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":amount": &types.AttributeValueMemberN{Value: strconv.FormatInt(amount, 10)},
+					":now":    &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+				},
+			}},
+		},
+	})
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 && aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// SetRefundGatewayID records the gateway's id for a refund whose status may already have been settled by a webhook.
+func SetRefundGatewayID(ctx context.Context, client *dynamodb.Client, tableName, id, gatewayRefundID string) error {
+	ctx, span := tracing.Start(ctx, "db.SetRefundGatewayID", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+		UpdateExpression:          aws.String("SET gateway_refund_id = :gatewayRefundId"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":gatewayRefundId": &types.AttributeValueMemberS{Value: gatewayRefundID}},
+	})
+	return err
+}
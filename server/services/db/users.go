@@ -3,25 +3,40 @@ package db
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"strings"
 
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/tracing"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-func CreateUser(client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
-	_, err := client.PutItem(context.TODO(), &dynamodb.PutItemInput{
+func CreateUser(ctx context.Context, client *dynamodb.Client, tableName string, item map[string]types.AttributeValue) error {
+	ctx, span := tracing.Start(ctx, "db.CreateUser", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
 		TableName: aws.String(tableName),
 		Item:      item,
 	})
 	return err
 }
 
-func GetUserById(client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
-	result, err := client.GetItem(context.TODO(), &dynamodb.GetItemInput{
+func GetUserById(ctx context.Context, client *dynamodb.Client, tableName, id string) (map[string]types.AttributeValue, error) {
+	ctx, span := tracing.Start(ctx, "db.GetUserById", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
@@ -30,37 +45,30 @@ func GetUserById(client *dynamodb.Client, tableName, id string) (map[string]type
 	if err != nil {
 		return nil, err
 	}
+	if result.Item == nil {
+		return nil, apperrors.NotFound("user_not_found", fmt.Sprintf("No user found with id %s.", id))
+	}
 	return result.Item, nil
 }
 
-func GetAllUsers(client *dynamodb.Client, tableName string) ([]map[string]types.AttributeValue, error) {
-	var items []map[string]types.AttributeValue
-	var lastEvaluatedKey map[string]types.AttributeValue
-
-	for {
-		out, err := client.Scan(context.TODO(), &dynamodb.ScanInput{
-			TableName:         aws.String(tableName),
-			ExclusiveStartKey: lastEvaluatedKey,
-		})
-		if err != nil {
-			return nil, err
-		}
-
-		items = append(items, out.Items...)
-
-		if out.LastEvaluatedKey == nil {
-			break
-		}
-		lastEvaluatedKey = out.LastEvaluatedKey
-	}
+func GetAllUsers(ctx context.Context, client *dynamodb.Client, tableName string, page Page) ([]map[string]types.AttributeValue, string, error) {
+	ctx, span := tracing.Start(ctx, "db.GetAllUsers", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
-	return items, nil
+	return scan(ctx, client, tableName, page)
 }
 
-func GetUserByEmail(client *dynamodb.Client, tableName, email string) (*User, error) {
+func GetUserByEmail(ctx context.Context, client *dynamodb.Client, tableName, email string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "db.GetUserByEmail", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
 	email = strings.ToLower(email)
 
-	result, err := client.Query(context.TODO(), &dynamodb.QueryInput{
+	result, err := client.Query(ctx, &dynamodb.QueryInput{
 		TableName:              aws.String(tableName),
 		IndexName:              aws.String("email-index"), // Use the GSI
 		KeyConditionExpression: aws.String("email = :email"),
@@ -75,7 +83,7 @@ func GetUserByEmail(client *dynamodb.Client, tableName, email string) (*User, er
 	}
 
 	if len(result.Items) == 0 {
-		return nil, err
+		return nil, apperrors.NotFound("user_not_found", "No user found with that email.")
 	}
 
 	var user User
@@ -87,7 +95,12 @@ func GetUserByEmail(client *dynamodb.Client, tableName, email string) (*User, er
 	return &user, nil
 }
 
-func UpdateUser(client *dynamodb.Client, tableName string, user User) error {
+func UpdateUser(ctx context.Context, client *dynamodb.Client, tableName string, user User) error {
+	ctx, span := tracing.Start(ctx, "db.UpdateUser", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
 	updateBuilder := expression.UpdateBuilder{}
 	updatedFields := 0 // Track the number of fields updated
 
@@ -96,79 +109,95 @@ func UpdateUser(client *dynamodb.Client, tableName string, user User) error {
 		updatedFields++
 	}
 	if user.Email != "" {
-		updateBuilder = updateBuilder.Set(expression.Name("email"), expression.Value(user.Email))
+		updateBuilder = updateBuilder.Set(expression.Name("email"), expression.Value(strings.ToLower(user.Email)))
+		updatedFields++
+	}
+	if user.Role != "" {
+		updateBuilder = updateBuilder.Set(expression.Name("role"), expression.Value(user.Role))
 		updatedFields++
 	}
 
 	// Ensure at least one field is being updated
 	if updatedFields == 0 {
-		fmt.Println("No fields to update")
-		return fmt.Errorf("must update at least one field")
+		slog.DebugContext(ctx, "no fields to update", "table", tableName)
+		return apperrors.Validation("no_fields_to_update", "Must update at least one field.")
 	}
 
-	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).Build()
+	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(expression.AttributeExists(expression.Name("id"))).Build()
 	if err != nil {
-		fmt.Println("Error in expression builder:", err)
+		slog.ErrorContext(ctx, "failed to build update expression", "error", err)
 		return err
 	}
 
-	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: user.ID},
 		},
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
 		UpdateExpression:          expr.Update(),
 		ReturnValues:              types.ReturnValueUpdatedNew,
 	})
 
 	if err != nil {
-		fmt.Println("Error in client updater:", err)
+		slog.ErrorContext(ctx, "failed to update item", "table", tableName, "error", err)
 	}
-	return err
+	return notFoundIfConditionFailed(err, "user", user.ID)
 }
 
-func UpdatePassword(client *dynamodb.Client, tableName string, user User) error {
+func UpdatePassword(ctx context.Context, client *dynamodb.Client, tableName string, user User) error {
+	ctx, span := tracing.Start(ctx, "db.UpdatePassword", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
 	updateBuilder := expression.UpdateBuilder{}
 	updatedFields := 0 // Track the number of fields updated
 
-	if user.Name != "" {
+	if user.Password != "" {
 		updateBuilder = updateBuilder.Set(expression.Name("password"), expression.Value(user.Password))
 		updatedFields++
 	}
 
 	// Ensure at least one field is being updated
 	if updatedFields == 0 {
-		fmt.Println("No fields to update")
-		return fmt.Errorf("must update at least one field")
+		slog.DebugContext(ctx, "no fields to update", "table", tableName)
+		return apperrors.Validation("no_fields_to_update", "Must update at least one field.")
 	}
 
-	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).Build()
+	expr, err := expression.NewBuilder().WithUpdate(updateBuilder).WithCondition(expression.AttributeExists(expression.Name("id"))).Build()
 	if err != nil {
-		fmt.Println("Error in expression builder:", err)
+		slog.ErrorContext(ctx, "failed to build update expression", "error", err)
 		return err
 	}
 
-	_, err = client.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: user.ID},
 		},
 		ExpressionAttributeNames:  expr.Names(),
 		ExpressionAttributeValues: expr.Values(),
+		ConditionExpression:       expr.Condition(),
 		UpdateExpression:          expr.Update(),
 		ReturnValues:              types.ReturnValueUpdatedNew,
 	})
 
 	if err != nil {
-		fmt.Println("Error in client updater:", err)
+		slog.ErrorContext(ctx, "failed to update item", "table", tableName, "error", err)
 	}
-	return err
+	return notFoundIfConditionFailed(err, "user", user.ID)
 }
 
-func DeleteUser(client *dynamodb.Client, tableName, id string) error {
-	_, err := client.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+func DeleteUser(ctx context.Context, client *dynamodb.Client, tableName, id string) error {
+	ctx, span := tracing.Start(ctx, "db.DeleteUser", attribute.String("db.table", tableName))
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
 		TableName: aws.String(tableName),
 		Key: map[string]types.AttributeValue{
 			"id": &types.AttributeValueMemberS{Value: id},
@@ -0,0 +1,41 @@
+package services
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Deprecation marks legacy routes that are still served while clients move to their /v1 successors.
+type Deprecation struct {
+	Date   time.Time // when the routes were deprecated
+	Sunset time.Time // when they may be removed
+}
+
+var pathWildcard = regexp.MustCompile(`\{(\w+)(?:\.\.\.)?\}`)
+
+// Wrap adds the Deprecation (RFC 9745) and Sunset (RFC 8594) headers and, when every wildcard in the
+// successor template has a value in the request path, a Link to the successor URL.
+func (d Deprecation) Wrap(successor string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("Deprecation", "@"+strconv.FormatInt(d.Date.Unix(), 10))
+		header.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+
+		complete := true
+		link := pathWildcard.ReplaceAllStringFunc(successor, func(wildcard string) string {
+			value := r.PathValue(pathWildcard.FindStringSubmatch(wildcard)[1])
+			if value == "" {
+				complete = false
+			}
+			return url.PathEscape(value)
+		})
+		if complete {
+			header.Set("Link", "<"+link+`>; rel="successor-version"`)
+		}
+
+		next(w, r)
+	}
+}
@@ -3,26 +3,23 @@ package fileIO
 import (
 	"context"
 	"fmt"
-	"log"
 	"mime/multipart"
-	"os"
 	"time"
 
+	"upgraded-telegram/main.go/server/services/tracing"
+
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/joho/godotenv"
 )
 
-func UploadFile(client *s3.Client, filename string, fileContent multipart.File) (string, error) {
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	bucketName := os.Getenv("AWS_BUCKET_NAME")
+func UploadFile(ctx context.Context, client *s3.Client, filename string, fileContent multipart.File) (url string, err error) {
+	ctx, span := tracing.Start(ctx, "fileIO.UploadFile")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(Bucket),
 		Key:    aws.String(filename),
 		Body:   fileContent, // Directly passing io.Reader
 	})
@@ -30,24 +27,23 @@ func UploadFile(client *s3.Client, filename string, fileContent multipart.File)
 		return "", fmt.Errorf("failed to upload file: %w", err)
 	}
 
-	fileURL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", bucketName, filename)
+	fileURL := fmt.Sprintf("https://%s.s3.amazonaws.com/%s", Bucket, filename)
 	return fileURL, nil
 }
 
-func DownloadFile(client *s3.Client, filename string) (string, error) {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	bucketName := os.Getenv("AWS_BUCKET_NAME")
+func DownloadFile(ctx context.Context, client *s3.Client, filename string) (url string, err error) {
+	ctx, span := tracing.Start(ctx, "fileIO.DownloadFile")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 
 	fileKey := filename
 
 	expiration := time.Duration(5) * time.Minute
 
 	presignClient := s3.NewPresignClient(client)
-	presignedURL, err := presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
+	presignedURL, err := presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(Bucket),
 		Key:    aws.String(fileKey),
 	}, s3.WithPresignExpires(expiration))
 	if err != nil {
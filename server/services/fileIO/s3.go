@@ -3,17 +3,31 @@ package fileIO
 import (
 	"context"
 	"log"
+	"log/slog"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// Timeout bounds every S3 call made by this package.
+var Timeout = 30 * time.Second
+
+// Bucket receives uploads and serves downloads, set it from the configuration at startup.
+var Bucket string
+
 func ConnectS3(cfg aws.Config) *s3.Client {
 	s3Client := s3.NewFromConfig(cfg)
 	_, err := s3Client.ListBuckets(context.TODO(), &s3.ListBucketsInput{})
 	if err != nil {
 		log.Fatalf("unable to load S3 buckets, %v", err)
 	}
-	log.Printf("Connected to S3\n")
+	slog.Info("connected to S3")
 	return s3Client
 }
+
+// CheckBucket confirms the upload bucket exists and is reachable with the current credentials.
+func CheckBucket(ctx context.Context, client *s3.Client) error {
+	_, err := client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(Bucket)})
+	return err
+}
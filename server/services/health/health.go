@@ -0,0 +1,159 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check reports whether a dependency is usable. It should be cheap, readiness runs every check on a cache miss.
+type Check func(ctx context.Context) error
+
+type result struct {
+	err       error
+	checkedAt time.Time
+}
+
+// Checker serves the liveness and readiness probes and tracks whether the server is draining.
+type Checker struct {
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu      sync.Mutex
+	names   []string
+	checks  map[string]Check
+	results map[string]result
+
+	drainOnce sync.Once
+	draining  chan struct{}
+}
+
+// NewChecker caches each check result for ttl so that frequent probes do not hammer the dependencies.
+// A single check run is bounded by timeout.
+func NewChecker(ttl, timeout time.Duration) *Checker {
+	return &Checker{
+		ttl:      ttl,
+		timeout:  timeout,
+		checks:   make(map[string]Check),
+		results:  make(map[string]result),
+		draining: make(chan struct{}),
+	}
+}
+
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.names = append(c.names, name)
+	c.checks[name] = check
+}
+
+// StartDraining makes readiness fail so load balancers stop sending traffic, and closes Draining.
+func (c *Checker) StartDraining() {
+	c.drainOnce.Do(func() { close(c.draining) })
+}
+
+// Draining is closed once shutdown starts. Long-lived handlers (streams, long polls) should
+// select on it and return, http.Server.Shutdown does not interrupt active requests.
+func (c *Checker) Draining() <-chan struct{} {
+	return c.draining
+}
+
+func (c *Checker) isDraining() bool {
+	select {
+	case <-c.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+type status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Liveness only reports that the process is serving requests, it never checks dependencies
+// so a dependency outage does not get the container restarted.
+func (c *Checker) Liveness(w http.ResponseWriter, r *http.Request) {
+	writeStatus(w, http.StatusOK, status{Status: "ok"})
+}
+
+// Readiness reports 503 while draining or when any dependency check fails.
+func (c *Checker) Readiness(w http.ResponseWriter, r *http.Request) {
+	if c.isDraining() {
+		writeStatus(w, http.StatusServiceUnavailable, status{Status: "draining"})
+		return
+	}
+
+	results := c.run(r.Context())
+	response := status{Status: "ok", Checks: make(map[string]string, len(results))}
+	code := http.StatusOK
+	for name, res := range results {
+		if res.err != nil {
+			response.Status = "unavailable"
+			response.Checks[name] = checkStatus(res.err)
+			code = http.StatusServiceUnavailable
+			continue
+		}
+		response.Checks[name] = "ok"
+	}
+	writeStatus(w, code, response)
+}
+
+// checkStatus hides the error of a failed check, the probe is public and the error is logged when the check runs.
+func checkStatus(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	return "unavailable"
+}
+
+// run returns cached results and re-runs expired checks concurrently.
+func (c *Checker) run(ctx context.Context) map[string]result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var wg sync.WaitGroup
+	var resultsMu sync.Mutex
+	fresh := make(map[string]result)
+	for _, name := range c.names {
+		if res, ok := c.results[name]; ok && now.Sub(res.checkedAt) < c.ttl {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+			defer cancel()
+			err := check(checkCtx)
+			if err != nil {
+				slog.WarnContext(ctx, "readiness check failed", "check", name, "error", err)
+			}
+			resultsMu.Lock()
+			fresh[name] = result{err: err, checkedAt: time.Now()}
+			resultsMu.Unlock()
+		}(name, c.checks[name])
+	}
+	wg.Wait()
+
+	for name, res := range fresh {
+		c.results[name] = res
+	}
+	results := make(map[string]result, len(c.results))
+	for name, res := range c.results {
+		results[name] = res
+	}
+	return results
+}
+
+func writeStatus(w http.ResponseWriter, code int, s status) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(s)
+}
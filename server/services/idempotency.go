@@ -0,0 +1,188 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+)
+
+// IdempotencyHeader carries a client-chosen key, such as a UUID, that makes retrying a create request safe.
+const IdempotencyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on responses replayed from the store instead of handled again.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// responses larger than this are not stored, they stay well inside DynamoDB's 400 KB item limit
+const maxIdempotentBody = 256 << 10
+
+// Idempotency handles the first request with a given Idempotency-Key and replays its response to retries.
+// Keys are scoped to the authenticated user and bound to a fingerprint of the method, path and body,
+// so the same key with a different request is rejected with 422.
+type Idempotency struct {
+	store  IdempotencyStore
+	ttl    time.Duration
+	lock   time.Duration
+	routes map[string]bool
+}
+
+// NewIdempotency replays responses for ttl. A request holds its key for at most lock, after which a retry
+// may take the key over; it should be longer than any handler runs, such as the server's write timeout.
+func NewIdempotency(store IdempotencyStore, ttl, lock time.Duration) *Idempotency {
+	return &Idempotency{store: store, ttl: ttl, lock: lock, routes: make(map[string]bool)}
+}
+
+// SetRoute enables Idempotency-Key handling for a registered ServeMux pattern.
+func (id *Idempotency) SetRoute(pattern string) {
+	id.routes[pattern] = true
+}
+
+// Middleware only acts on requests that send an Idempotency-Key to an enabled route. Only responses below
+// 400 are stored; after an error or a panic the key is released so that a retry is handled again.
+// A retry that arrives while the first request is still being handled gets a 409 with Retry-After.
+func (id *Idempotency) Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" || !id.routes[routePattern(mux, r)] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			apperrors.Write(w, r, apperrors.BadRequest("invalid_idempotency_key", "Idempotency-Key must be 1 to 255 printable ASCII characters."))
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			apperrors.Write(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		storeKey := idempotencyScope(r) + "|" + key
+		now := time.Now()
+		record, claimed, err := id.store.Begin(r.Context(), storeKey, IdempotencyRecord{
+			Fingerprint: fingerprint,
+			LockedUntil: now.Add(id.lock),
+			ExpiresAt:   now.Add(id.ttl),
+		}, now)
+		if err != nil {
+			// fail closed, handling the request without the key could create the duplicate it exists to prevent
+			apperrors.Write(w, r, apperrors.Internal("idempotency_unavailable", "Could not check the Idempotency-Key, try again.", err))
+			return
+		}
+
+		if !claimed {
+			switch {
+			case record.Fingerprint != fingerprint:
+				apperrors.Write(w, r, apperrors.Validation("idempotency_key_reused", "This Idempotency-Key was already used for a different request."))
+			case record.Status == 0:
+				w.Header().Set("Retry-After", "1")
+				apperrors.Write(w, r, apperrors.Conflict("idempotency_in_progress", "A request with this Idempotency-Key is still being handled."))
+			default:
+				if record.ContentType != "" {
+					w.Header().Set("Content-Type", record.ContentType)
+				}
+				w.Header().Set(IdempotentReplayedHeader, "true")
+				w.WriteHeader(record.Status)
+				w.Write(record.Body)
+			}
+			return
+		}
+
+		// the response is stored even if the client has gone away, its retry is what needs it
+		ctx := context.WithoutCancel(r.Context())
+		completed := false
+		defer func() {
+			if !completed {
+				if err := id.store.Release(ctx, storeKey, record.Claim); err != nil {
+					slog.WarnContext(ctx, "failed to release idempotency key", "error", err)
+				}
+			}
+		}()
+
+		recorder := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		if recorder.status >= 400 || recorder.body.Len() > maxIdempotentBody {
+			return
+		}
+
+		record.Status = recorder.status
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.Body = recorder.body.Bytes()
+		err = id.store.Complete(ctx, storeKey, record)
+		if errors.Is(err, ErrIdempotencyClaimLost) {
+			// a retry took the key over after the lock ran out, its response is the one kept
+			slog.WarnContext(ctx, "idempotency key was taken over before the response was stored")
+			return
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "failed to store idempotent response", "error", err)
+			return
+		}
+		completed = true
+	})
+}
+
+// idempotencyScope keeps users from replaying each other's responses, unauthenticated requests share one scope.
+func idempotencyScope(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims := ParseAccessToken(token); claims != nil && claims.ID != "" {
+			return "user:" + claims.ID
+		}
+	}
+	return "anonymous"
+}
+
+func validIdempotencyKey(key string) bool {
+	if len(key) > 255 {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x20 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyRecorder passes the response through and keeps a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rw *idempotencyRecorder) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.status = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *idempotencyRecorder) Write(data []byte) (int, error) {
+	rw.wroteHeader = true
+	if rw.body.Len() <= maxIdempotentBody {
+		rw.body.Write(data)
+	}
+	return rw.ResponseWriter.Write(data)
+}
+
+func (rw *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/gofrs/uuid"
+)
+
+// IdempotencyRecord is what is kept for one key: the request it was first used with and, once handled, the response.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int // zero while the first request is still being handled
+	ContentType string
+	Body        []byte
+	LockedUntil time.Time
+	ExpiresAt   time.Time
+	Claim       string // set by Begin, identifies the request holding the key
+}
+
+// ErrIdempotencyClaimLost is returned by Complete when the key was taken over after the request's lock ran out.
+var ErrIdempotencyClaimLost = errors.New("idempotency key was claimed by another request")
+
+// IdempotencyStore keeps idempotency records. Begin must be atomic, including across server instances when
+// the store is shared, so that of several concurrent requests with the same key exactly one claims it.
+type IdempotencyStore interface {
+	// Begin stores record under key and reports true when the key is free: never used, expired, or still in
+	// progress past its LockedUntil. Otherwise it returns the stored record and false.
+	Begin(ctx context.Context, key string, record IdempotencyRecord, now time.Time) (IdempotencyRecord, bool, error)
+	// Complete stores the response of the request that claimed key, record being the one Begin returned. It is
+	// ErrIdempotencyClaimLost when another request has taken the key over since.
+	Complete(ctx context.Context, key string, record IdempotencyRecord) error
+	// Release frees a key whose request was not completed, unless another request has taken it over since.
+	Release(ctx context.Context, key, claim string) error
+}
+
+func claimable(record IdempotencyRecord, now time.Time) bool {
+	return !now.Before(record.ExpiresAt) || record.Status == 0 && !now.Before(record.LockedUntil)
+}
+
+// newClaim returns a random token identifying one claim of a key.
+func newClaim() (string, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// MemoryIdempotencyStore keeps records in process memory. It is the default and is only correct for a single instance.
+type MemoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]IdempotencyRecord
+	stop    chan struct{}
+}
+
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	store := &MemoryIdempotencyStore{
+		records: make(map[string]IdempotencyRecord),
+		stop:    make(chan struct{}),
+	}
+	go store.janitor(time.Minute)
+	return store
+}
+
+func (s *MemoryIdempotencyStore) Begin(ctx context.Context, key string, record IdempotencyRecord, now time.Time) (IdempotencyRecord, bool, error) {
+	claim, err := newClaim()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.records[key]; ok && !claimable(stored, now) {
+		return stored, false, nil
+	}
+	record.Claim = claim
+	s.records[key] = record
+	return record, true, nil
+}
+
+func (s *MemoryIdempotencyStore) Complete(ctx context.Context, key string, record IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored := s.records[key]; stored.Claim != record.Claim || stored.Status != 0 {
+		return ErrIdempotencyClaimLost
+	}
+	record.Body = append([]byte(nil), record.Body...)
+	s.records[key] = record
+	return nil
+}
+
+func (s *MemoryIdempotencyStore) Release(ctx context.Context, key, claim string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored := s.records[key]; stored.Claim == claim && stored.Status == 0 {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// Stop ends the janitor.
+func (s *MemoryIdempotencyStore) Stop() {
+	close(s.stop)
+}
+
+// janitor drops expired records, they behave exactly like unused keys
+func (s *MemoryIdempotencyStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, record := range s.records {
+				if !now.Before(record.ExpiresAt) {
+					delete(s.records, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// DynamoIdempotencyStore shares records between instances through a DynamoDB table with partition key "id".
+// Enable TTL on the "expires_at" attribute so that expired keys are removed.
+type DynamoIdempotencyStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func NewDynamoIdempotencyStore(client *dynamodb.Client, table string) *DynamoIdempotencyStore {
+	return &DynamoIdempotencyStore{client: client, table: table}
+}
+
+// Begin claims the key with one conditional put, the losing request gets the winner's record back from the failed condition.
+func (s *DynamoIdempotencyStore) Begin(ctx context.Context, key string, record IdempotencyRecord, now time.Time) (stored IdempotencyRecord, claimed bool, err error) {
+	ctx, span := tracing.Start(ctx, "idempotency.Begin")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, db.Timeout)
+	defer cancel()
+
+	record.Claim, err = newClaim()
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(s.table),
+		Item:                     idempotencyItem(key, record),
+		ConditionExpression:      aws.String("attribute_not_exists(id) OR expires_at <= :now OR (#status = :zero AND locked_until <= :nowMilli)"),
+		ExpressionAttributeNames: map[string]string{"#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":      &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":nowMilli": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+			":zero":     &types.AttributeValueMemberN{Value: "0"},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return idempotencyRecord(conditionFailed.Item), false, nil
+	}
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+	return record, true, nil
+}
+
+func (s *DynamoIdempotencyStore) Complete(ctx context.Context, key string, record IdempotencyRecord) (err error) {
+	ctx, span := tracing.Start(ctx, "idempotency.Complete")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, db.Timeout)
+	defer cancel()
+
+	_, err = s.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(s.table),
+		Item:                     idempotencyItem(key, record),
+		ConditionExpression:      aws.String("#claim = :claim AND #status = :zero"),
+		ExpressionAttributeNames: map[string]string{"#claim": "claim", "#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":claim": &types.AttributeValueMemberS{Value: record.Claim},
+			":zero":  &types.AttributeValueMemberN{Value: "0"},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return ErrIdempotencyClaimLost
+	}
+	return err
+}
+
+func (s *DynamoIdempotencyStore) Release(ctx context.Context, key, claim string) (err error) {
+	ctx, span := tracing.Start(ctx, "idempotency.Release")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, db.Timeout)
+	defer cancel()
+
+	_, err = s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(s.table),
+		Key:                      map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: key}},
+		ConditionExpression:      aws.String("#claim = :claim AND #status = :zero"),
+		ExpressionAttributeNames: map[string]string{"#claim": "claim", "#status": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":claim": &types.AttributeValueMemberS{Value: claim},
+			":zero":  &types.AttributeValueMemberN{Value: "0"},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	return err
+}
+
+// expires_at is in seconds for DynamoDB TTL, locked_until in milliseconds
+func idempotencyItem(key string, record IdempotencyRecord) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{
+		"id":           &types.AttributeValueMemberS{Value: key},
+		"fingerprint":  &types.AttributeValueMemberS{Value: record.Fingerprint},
+		"status":       &types.AttributeValueMemberN{Value: strconv.Itoa(record.Status)},
+		"locked_until": &types.AttributeValueMemberN{Value: strconv.FormatInt(record.LockedUntil.UnixMilli(), 10)},
+		"expires_at":   &types.AttributeValueMemberN{Value: strconv.FormatInt(record.ExpiresAt.Unix(), 10)},
+	}
+	if record.Claim != "" {
+		item["claim"] = &types.AttributeValueMemberS{Value: record.Claim}
+	}
+	if record.ContentType != "" {
+		item["content_type"] = &types.AttributeValueMemberS{Value: record.ContentType}
+	}
+	if len(record.Body) > 0 {
+		item["body"] = &types.AttributeValueMemberB{Value: record.Body}
+	}
+	return item
+}
+
+func idempotencyRecord(item map[string]types.AttributeValue) IdempotencyRecord {
+	var record IdempotencyRecord
+	if v, ok := item["fingerprint"].(*types.AttributeValueMemberS); ok {
+		record.Fingerprint = v.Value
+	}
+	if v, ok := item["status"].(*types.AttributeValueMemberN); ok {
+		record.Status, _ = strconv.Atoi(v.Value)
+	}
+	if v, ok := item["claim"].(*types.AttributeValueMemberS); ok {
+		record.Claim = v.Value
+	}
+	if v, ok := item["content_type"].(*types.AttributeValueMemberS); ok {
+		record.ContentType = v.Value
+	}
+	if v, ok := item["body"].(*types.AttributeValueMemberB); ok {
+		record.Body = v.Value
+	}
+	if v, ok := item["locked_until"].(*types.AttributeValueMemberN); ok {
+		milli, _ := strconv.ParseInt(v.Value, 10, 64)
+		record.LockedUntil = time.UnixMilli(milli)
+	}
+	if v, ok := item["expires_at"].(*types.AttributeValueMemberN); ok {
+		seconds, _ := strconv.ParseInt(v.Value, 10, 64)
+		record.ExpiresAt = time.Unix(seconds, 0)
+	}
+	return record
+}
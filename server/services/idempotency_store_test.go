@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func pending(now time.Time) IdempotencyRecord {
+	return IdempotencyRecord{Fingerprint: "f", LockedUntil: now.Add(time.Second), ExpiresAt: now.Add(time.Hour)}
+}
+
+func TestOnlyOneConcurrentBeginClaimsAKey(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	defer store.Stop()
+	now := time.Now()
+
+	var claims atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, claimed, err := store.Begin(context.Background(), "k", pending(now), now)
+			if err != nil {
+				t.Error(err)
+			}
+			if claimed {
+				claims.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := claims.Load(); n != 1 {
+		t.Fatalf("%d requests claimed the key, want 1", n)
+	}
+}
+
+func TestStaleClaimCannotOverwriteTheRequestThatTookOver(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	defer store.Stop()
+	now := time.Now()
+	ctx := context.Background()
+
+	first, claimed, _ := store.Begin(ctx, "k", pending(now), now)
+	if !claimed {
+		t.Fatal("first request did not claim the key")
+	}
+	// the first request's lock runs out and a retry takes the key over while the first is still running
+	later := now.Add(2 * time.Second)
+	second, claimed, _ := store.Begin(ctx, "k", pending(later), later)
+	if !claimed || second.Claim == first.Claim {
+		t.Fatal("retry did not take over the stale key")
+	}
+
+	err := store.Release(ctx, "k", first.Claim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored, claimed, _ := store.Begin(ctx, "k", pending(later), later); claimed || stored.Claim != second.Claim {
+		t.Fatal("the stale request released the retry's claim")
+	}
+
+	first.Status = http.StatusCreated
+	first.Body = []byte("first")
+	if err := store.Complete(ctx, "k", first); !errors.Is(err, ErrIdempotencyClaimLost) {
+		t.Fatalf("stale complete: got %v, want ErrIdempotencyClaimLost", err)
+	}
+	second.Status = http.StatusCreated
+	second.Body = []byte("second")
+	if err := store.Complete(ctx, "k", second); err != nil {
+		t.Fatal(err)
+	}
+	stored, _, _ := store.Begin(ctx, "k", pending(later), later)
+	if string(stored.Body) != "second" {
+		t.Fatalf("replays %q, want the retry's response", stored.Body)
+	}
+}
+
+func TestIdempotencyMiddlewareHandlesRacingRetriesOnce(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	defer store.Stop()
+
+	var handled atomic.Int32
+	release := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /things", func(w http.ResponseWriter, r *http.Request) {
+		handled.Add(1)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"1"}`))
+	})
+	idempotency := NewIdempotency(store, time.Hour, time.Minute)
+	idempotency.SetRoute("POST /things")
+	handler := idempotency.Middleware(mux, mux)
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/things", strings.NewReader(`{}`))
+		req.Header.Set(IdempotencyHeader, "key-1")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- send() }()
+	for handled.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if rec := send(); rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("retry during the first request: %d, want 409 with Retry-After", rec.Code)
+	}
+	close(release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Fatalf("first request: %d", rec.Code)
+	}
+
+	rec := send()
+	if rec.Code != http.StatusCreated || rec.Header().Get(IdempotentReplayedHeader) != "true" || rec.Body.String() != `{"id":"1"}` {
+		t.Fatalf("retry after the first request: %d %q, want the replayed response", rec.Code, rec.Body)
+	}
+	if handled.Load() != 1 {
+		t.Fatalf("handled %d times", handled.Load())
+	}
+}
@@ -0,0 +1,173 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Level can be changed while the server is running, see SetLevel.
+var Level = new(slog.LevelVar)
+
+const redacted = "[REDACTED]"
+
+// attribute keys whose values are never written to the log
+var sensitiveKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"authorization": true,
+	"secret":        true,
+	"api_key":       true,
+	"email":         true,
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+// Init installs a JSON logger as the slog and log package default.
+func Init(level string) error {
+	if err := SetLevel(level); err != nil {
+		return err
+	}
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       Level,
+		ReplaceAttr: redact,
+	})
+	slog.SetDefault(slog.New(&contextHandler{Handler: handler}))
+	return nil
+}
+
+func SetLevel(level string) error {
+	if level == "" {
+		level = "info"
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return err
+	}
+	Level.Set(l)
+	return nil
+}
+
+// RequestInfo is shared by the middleware chain so that inner middleware (authentication)
+// can annotate log lines written by outer middleware (request logging).
+type RequestInfo struct {
+	mu        sync.Mutex
+	RequestID string
+	UserID    string
+	Route     string
+}
+
+func (info *RequestInfo) SetUserID(id string) {
+	info.mu.Lock()
+	info.UserID = id
+	info.mu.Unlock()
+}
+
+func (info *RequestInfo) SetRoute(route string) {
+	info.mu.Lock()
+	info.Route = route
+	info.mu.Unlock()
+}
+
+type requestInfoKey struct{}
+
+func WithRequestInfo(ctx context.Context, info *RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// InfoFrom returns the request info stored in ctx, or nil.
+func InfoFrom(ctx context.Context) *RequestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*RequestInfo)
+	return info
+}
+
+// contextHandler adds the request ID, user ID, route pattern and trace ID to every record logged with a request context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if info := InfoFrom(ctx); info != nil {
+		info.mu.Lock()
+		if info.RequestID != "" {
+			record.AddAttrs(slog.String("request_id", info.RequestID))
+		}
+		if info.UserID != "" {
+			record.AddAttrs(slog.String("user_id", info.UserID))
+		}
+		if info.Route != "" {
+			record.AddAttrs(slog.String("route", info.Route))
+		}
+		info.mu.Unlock()
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, emailPattern.ReplaceAllString(a.Value.String(), redacted))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, emailPattern.ReplaceAllString(err.Error(), redacted))
+		}
+		// structs and maps are flattened to JSON so their fields can be redacted too
+		raw, err := json.Marshal(a.Value.Any())
+		if err != nil {
+			return a
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return a
+		}
+		return slog.Any(a.Key, redactValue(v))
+	}
+	return a
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if sensitiveKeys[strings.ToLower(k)] {
+				t[k] = redacted
+			} else {
+				t[k] = redactValue(val)
+			}
+		}
+		return t
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redactValue(val)
+		}
+		return t
+	case string:
+		return emailPattern.ReplaceAllString(t, redacted)
+	}
+	return v
+}
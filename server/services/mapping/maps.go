@@ -3,36 +3,42 @@ package mapping
 import (
 	"context"
 	"log"
-	"os"
+	"log/slog"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/metrics"
+	"upgraded-telegram/main.go/server/services/tracing"
 
-	"github.com/joho/godotenv"
 	"googlemaps.github.io/maps"
 )
 
 // repo and documentation https://github.com/googlemaps/google-maps-services-go?tab=readme-ov-file
 
-func FindMaps() *maps.Client {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-	mapsKey := os.Getenv("GOOGLE_MAPS_API_KEY")
+// Timeout bounds every Google Maps call made by this package.
+var Timeout = 10 * time.Second
 
-	mapClient, err := maps.NewClient(maps.WithAPIKey(mapsKey))
+func FindMaps(mapsKey string) *maps.Client {
+	mapClient, err := maps.NewClient(maps.WithAPIKey(mapsKey), maps.WithHTTPClient(tracing.HTTPClient()))
 	if err != nil {
 		log.Fatalf("fatal error: %s", err)
 	}
 
-	log.Println("Connecting to Google Maps")
+	slog.Info("connected to Google Maps")
 	return mapClient
 }
 
-func GetRoute(client *maps.Client, origin string, destination string) ([]maps.Route, error) {
+func GetRoute(ctx context.Context, client *maps.Client, origin string, destination string) ([]maps.Route, error) {
+	ctx, span := tracing.Start(ctx, "mapping.GetRoute")
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 	req := &maps.DirectionsRequest{
-		Origin:      "Sydney",
-		Destination: "Perth",
+		Origin:      origin,
+		Destination: destination,
 	}
-	route, _, err := client.Directions(context.Background(), req)
+	start := time.Now()
+	route, _, err := client.Directions(ctx, req)
+	metrics.ObserveCall("maps", "Directions", start, err)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -40,22 +46,34 @@ func GetRoute(client *maps.Client, origin string, destination string) ([]maps.Ro
 	return route, nil
 }
 
-func Geocode(client *maps.Client, address string) ([]maps.GeocodingResult, error) {
+func Geocode(ctx context.Context, client *maps.Client, address string) ([]maps.GeocodingResult, error) {
+	ctx, span := tracing.Start(ctx, "mapping.Geocode")
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 	r := &maps.GeocodingRequest{
 		Address: address,
 	}
-	geocodingResponse, err := client.Geocode(context.Background(), r)
+	start := time.Now()
+	geocodingResponse, err := client.Geocode(ctx, r)
+	metrics.ObserveCall("maps", "Geocode", start, err)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, err
 	}
 	return geocodingResponse, nil
 }
 
-func ReverseGeocode(client *maps.Client, lat float64, long float64) ([]maps.GeocodingResult, error) {
+func ReverseGeocode(ctx context.Context, client *maps.Client, lat float64, long float64) ([]maps.GeocodingResult, error) {
+	ctx, span := tracing.Start(ctx, "mapping.ReverseGeocode")
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
 	r := &maps.GeocodingRequest{
 		LatLng: &maps.LatLng{Lat: lat, Lng: long},
 	}
-	reverseGeocodingResponse, err := client.ReverseGeocode(context.Background(), r)
+	start := time.Now()
+	reverseGeocodingResponse, err := client.ReverseGeocode(ctx, r)
+	metrics.ObserveCall("maps", "ReverseGeocode", start, err)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// labels only ever hold route patterns, dependency names and operation names so cardinality stays bounded
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route pattern and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	httpInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_flight",
+		Help: "HTTP requests currently being served.",
+	})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_rejections_total",
+		Help: "Requests rejected by the rate limiter.",
+	}, []string{"policy"})
+
+	dependencyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dependency_call_duration_seconds",
+		Help:    "Latency of calls to external dependencies by dependency and operation.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"dependency", "operation"})
+
+	dependencyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "dependency_call_errors_total",
+		Help: "Failed calls to external dependencies by dependency and operation.",
+	}, []string{"dependency", "operation"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// Middleware records request metrics. It must wrap the ServeMux so that r.Pattern is set once the request is routed.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		httpInFlight.Inc()
+		defer httpInFlight.Dec()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		route := RoutePath(r.Pattern)
+		if route == "" {
+			route = "unmatched"
+		}
+		labels := prometheus.Labels{
+			"method": normalizeMethod(r.Method),
+			"route":  route,
+			"status": strconv.Itoa(recorder.status),
+		}
+		httpRequests.With(labels).Inc()
+		httpDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// RoutePath drops the method from a ServeMux pattern, "GET /items/{id}" becomes "/items/{id}".
+func RoutePath(pattern string) string {
+	if _, path, ok := strings.Cut(pattern, " "); ok {
+		return path
+	}
+	return pattern
+}
+
+func normalizeMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func RateLimited(policy string) {
+	rateLimited.WithLabelValues(policy).Inc()
+}
+
+// ObserveCall records the latency and outcome of a call to an external dependency.
+func ObserveCall(dependency, operation string, start time.Time, err error) {
+	dependencyDuration.WithLabelValues(dependency, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		dependencyErrors.WithLabelValues(dependency, operation).Inc()
+	}
+}
+
+// AWSMiddleware records every AWS SDK call, labeled by service (dynamodb, s3) and operation (PutItem, GetObject).
+// Add it to aws.Config.APIOptions.
+func AWSMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("PrometheusMetrics",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			start := time.Now()
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			ObserveCall(strings.ToLower(awsmiddleware.GetServiceID(ctx)), awsmiddleware.GetOperationName(ctx), start, err)
+			return out, metadata, err
+		}), middleware.Before)
+}
+
+// HTTPMiddleware matches the openai-go option.Middleware signature. The operation is the first
+// path segment after the API version, e.g. "chat" or "files", so resource IDs never become labels.
+func HTTPMiddleware(dependency string) func(*http.Request, func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	return func(req *http.Request, next func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+		start := time.Now()
+		resp, err := next(req)
+		callErr := err
+		if err == nil && resp.StatusCode >= 500 {
+			callErr = errServerResponse
+		}
+		ObserveCall(dependency, operationFromPath(req.URL.Path), start, callErr)
+		return resp, err
+	}
+}
+
+var errServerResponse = errors.New("server error response")
+
+func operationFromPath(path string) string {
+	for _, segment := range strings.Split(strings.Trim(path, "/"), "/") {
+		if segment == "" || (segment[0] == 'v' && len(segment) > 1 && segment[1] >= '0' && segment[1] <= '9') {
+			continue
+		}
+		return segment
+	}
+	return "root"
+}
@@ -1,23 +1,23 @@
 package services
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
-	"golang.org/x/time/rate"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/logging"
+
+	"github.com/gofrs/uuid"
 )
 
 type ResponseWriterWrapper struct {
 	http.ResponseWriter
 	statusCode int
-	body       *bytes.Buffer
+	written    int
 }
 
 func (rw *ResponseWriterWrapper) WriteHeader(code int) {
@@ -26,31 +26,79 @@ func (rw *ResponseWriterWrapper) WriteHeader(code int) {
 }
 
 func (rw *ResponseWriterWrapper) Write(data []byte) (int, error) {
-	rw.body.Write(data)
-	return rw.ResponseWriter.Write(data)
+	n, err := rw.ResponseWriter.Write(data)
+	rw.written += n
+	return n, err
+}
+
+func (rw *ResponseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// assign every request an ID, keeping one supplied by the client or an upstream proxy when it is safe to log
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(apperrors.RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = ""
+			r.Header.Del(apperrors.RequestIDHeader)
+			id, err := uuid.NewV4()
+			if err == nil {
+				requestID = id.String()
+				r.Header.Set(apperrors.RequestIDHeader, requestID)
+			}
+		}
+		w.Header().Set(apperrors.RequestIDHeader, requestID)
+
+		ctx := logging.WithRequestInfo(r.Context(), &logging.RequestInfo{RequestID: requestID})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts up to 64 letters, digits, '.', '_' and '-', anything else is replaced rather than logged
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
 }
 
 func LoggerMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		if info := logging.InfoFrom(r.Context()); info != nil {
+			info.SetRoute(r.Pattern)
+		}
+
 		wrapper := &ResponseWriterWrapper{
 			ResponseWriter: w,
 			statusCode:     http.StatusOK,
-			body:           new(bytes.Buffer),
 		}
 
 		next(wrapper, r)
 
-		duration := time.Since(start)
-		logMsg := fmt.Sprintf("[%s] %s from %s - Status: %d, Duration: %s",
-			r.Method, r.URL.Path, r.RemoteAddr, wrapper.statusCode, duration)
-
-		if wrapper.statusCode >= 400 {
-			logMsg += fmt.Sprintf(" - Response: %s", wrapper.body.String())
+		level := slog.LevelInfo
+		switch {
+		case wrapper.statusCode >= 500:
+			level = slog.LevelError
+		case wrapper.statusCode >= 400:
+			level = slog.LevelWarn
 		}
 
-		log.Println(logMsg)
+		slog.LogAttrs(r.Context(), level, "request completed",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.Int("status", wrapper.statusCode),
+			slog.Int("bytes", wrapper.written),
+			slog.Duration("duration", time.Since(start)),
+		)
 	}
 }
 
@@ -63,15 +111,18 @@ func VerifyJWT(next http.HandlerFunc) http.HandlerFunc {
 		}
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, `{"error": "Authentication Header is missing!"}`, http.StatusUnauthorized)
+			apperrors.Write(w, r, apperrors.Unauthorized("missing_authorization", "Authorization header is missing."))
 			return
 		}
 		token := strings.TrimPrefix(authHeader, "Bearer ")
 		userClaims := ParseAccessToken(token)
 		if userClaims == nil {
-			http.Error(w, `{"error": "Failed to verify token!"}`, http.StatusUnauthorized)
+			apperrors.Write(w, r, apperrors.Unauthorized("invalid_token", "Failed to verify token."))
 			return
 		}
+		if info := logging.InfoFrom(r.Context()); info != nil {
+			info.SetUserID(userClaims.ID)
+		}
 		next(w, r)
 	}
 }
@@ -91,7 +142,7 @@ func VerifyRefreshToken(next http.HandlerFunc) http.HandlerFunc {
 		var req VerifyRefreshRequest
 
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, `{"error": "Invalid request body"}`, http.StatusBadRequest)
+			apperrors.Write(w, r, apperrors.BadRequest("invalid_body", "Invalid request body."))
 			return
 		}
 		defer r.Body.Close()
@@ -101,7 +152,7 @@ func VerifyRefreshToken(next http.HandlerFunc) http.HandlerFunc {
 
 		claims := ParseRefreshToken(token)
 		if claims == nil {
-			http.Error(w, `{"error": "Failed to verify token!"}`, http.StatusUnauthorized)
+			apperrors.Write(w, r, apperrors.Unauthorized("invalid_refresh_token", "Failed to verify refresh token."))
 			return
 		}
 
@@ -111,54 +162,3 @@ func VerifyRefreshToken(next http.HandlerFunc) http.HandlerFunc {
 		next(w, r)
 	}
 }
-
-type RateLimiter struct {
-	limiters map[string]*rate.Limiter
-	mu       sync.Mutex
-	rate     rate.Limit
-	burst    int
-}
-
-func NewRateLimiter(r rate.Limit, b int) *RateLimiter {
-	return &RateLimiter{
-		limiters: make(map[string]*rate.Limiter),
-		rate:     r,
-		burst:    b,
-	}
-}
-
-func (rl *RateLimiter) GetLimiter(ip string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	if limiter, exists := rl.limiters[ip]; exists {
-		return limiter
-	}
-
-	limiter := rate.NewLimiter(rl.rate, rl.burst)
-	rl.limiters[ip] = limiter
-
-	// Cleanup old limiters periodically
-	go func() {
-		time.Sleep(10 * time.Minute)
-		rl.mu.Lock()
-		delete(rl.limiters, ip)
-		rl.mu.Unlock()
-	}()
-
-	return limiter
-}
-
-func (rl *RateLimiter) RateLimitMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr // Get the client's IP address
-		limiter := rl.GetLimiter(ip)
-
-		if !limiter.Allow() {
-			http.Error(w, "Too many requests. Try again later.", http.StatusTooManyRequests)
-			return
-		}
-
-		next.ServeHTTP(w, r)
-	})
-}
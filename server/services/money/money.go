@@ -0,0 +1,148 @@
+// Package money represents amounts as integer minor units of an ISO 4217 currency, 1999 USD being $19.99 and
+// 1999 JPY ¥1999. Arithmetic never mixes currencies and never overflows silently; converting between
+// currencies goes through Rates and rounds half to even.
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var (
+	ErrCurrencyMismatch = errors.New("amounts are in different currencies")
+	ErrOverflow         = errors.New("amount out of range")
+)
+
+// Default is the currency of amounts that do not name one: items and orders stored before amounts had a
+// currency, and new ones created without one. Set it from the configuration at startup.
+var Default = "USD"
+
+// exponents holds the number of minor unit digits of every supported currency.
+var exponents = map[string]int{
+	"AED": 2, "AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CLP": 0, "CNY": 2, "CZK": 2, "DKK": 2,
+	"EUR": 2, "GBP": 2, "HKD": 2, "HUF": 2, "IDR": 2, "ILS": 2, "INR": 2, "ISK": 0, "JOD": 3, "JPY": 0,
+	"KRW": 0, "KWD": 3, "MXN": 2, "MYR": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PHP": 2, "PLN": 2, "RON": 2,
+	"SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TND": 3, "TRY": 2, "TWD": 2, "UAH": 2, "USD": 2, "VND": 0,
+	"ZAR": 2,
+}
+
+// Valid reports whether currency is a supported ISO 4217 code.
+func Valid(currency string) bool {
+	_, ok := exponents[currency]
+	return ok
+}
+
+// Exponent returns the number of minor unit digits of currency.
+func Exponent(currency string) int {
+	return exponents[currency]
+}
+
+// Money is an amount in minor units of Currency.
+type Money struct {
+	Amount   int64  `json:"amount" dynamodbav:"amount" validate:"min=0"`
+	Currency string `json:"currency" dynamodbav:"currency" validate:"currency"`
+}
+
+func New(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+func (m Money) Add(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, ErrCurrencyMismatch
+	}
+	if o.Amount > 0 && m.Amount > math.MaxInt64-o.Amount || o.Amount < 0 && m.Amount < math.MinInt64-o.Amount {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(Money{Amount: -o.Amount, Currency: o.Currency})
+}
+
+// Mul multiplies by a quantity.
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount != 0 && n != 0 {
+		product := m.Amount * n
+		if product/n != m.Amount || m.Amount == -1 && n == math.MinInt64 || n == -1 && m.Amount == math.MinInt64 {
+			return Money{}, ErrOverflow
+		}
+		return Money{Amount: product, Currency: m.Currency}, nil
+	}
+	return Money{Currency: m.Currency}, nil
+}
+
+// Times multiplies by a rate, such as a tax rate, rounding half to even to whole minor units.
+func (m Money) Times(rate *big.Rat) (Money, error) {
+	amount := roundHalfEven(new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate))
+	if !amount.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: amount.Int64(), Currency: m.Currency}, nil
+}
+
+// String formats the amount in major units, "19.99 USD".
+func (m Money) String() string {
+	exp := Exponent(m.Currency)
+	if exp == 0 {
+		return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+	}
+	sign := ""
+	amount := m.Amount
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := fmt.Sprintf("%0*d", exp+1, amount)
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:] + " " + m.Currency
+}
+
+// UnmarshalJSON also accepts a bare number of minor units of Default, as amounts were sent before they had a
+// currency.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var amount int64
+	if json.Unmarshal(data, &amount) == nil {
+		*m = Money{Amount: amount, Currency: Default}
+		return nil
+	}
+	type plain Money
+	var p plain
+	err := json.Unmarshal(data, &p)
+	*m = Money(p)
+	return err
+}
+
+// UnmarshalDynamoDBAttributeValue also reads amounts stored as a bare number before they had a currency,
+// as minor units of Default.
+func (m *Money) UnmarshalDynamoDBAttributeValue(av types.AttributeValue) error {
+	if n, ok := av.(*types.AttributeValueMemberN); ok {
+		amount, err := strconv.ParseInt(strings.TrimSpace(n.Value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("money: legacy amount %q: %w", n.Value, err)
+		}
+		*m = Money{Amount: amount, Currency: Default}
+		return nil
+	}
+	// the alias drops this method so that the map is decoded field by field
+	type plain Money
+	var p plain
+	err := attributevalue.Unmarshal(av, &p)
+	*m = Money(p)
+	return err
+}
@@ -0,0 +1,107 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestTimesRoundsHalfToEven(t *testing.T) {
+	tests := []struct {
+		amount Money
+		rate   *big.Rat
+		want   int64
+	}{
+		{amount: New(125, "USD"), rate: big.NewRat(1, 10), want: 12},
+		{amount: New(135, "USD"), rate: big.NewRat(1, 10), want: 14},
+		{amount: New(126, "USD"), rate: big.NewRat(1, 10), want: 13},
+		{amount: New(-125, "USD"), rate: big.NewRat(1, 10), want: -12},
+		{amount: New(-135, "USD"), rate: big.NewRat(1, 10), want: -14},
+		// no minor units, 80.4
+		{amount: New(1005, "JPY"), rate: big.NewRat(8, 100), want: 80},
+		// no minor units, 2.5
+		{amount: New(125, "JPY"), rate: big.NewRat(2, 100), want: 2},
+		// three minor units, 617.25
+		{amount: New(12345, "KWD"), rate: big.NewRat(5, 100), want: 617},
+		// three minor units, 0.5
+		{amount: New(1, "BHD"), rate: big.NewRat(1, 2), want: 0},
+	}
+	for _, tt := range tests {
+		got, err := tt.amount.Times(tt.rate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != New(tt.want, tt.amount.Currency) {
+			t.Errorf("%v times %s is %v, want %d", tt.amount, tt.rate.RatString(), got, tt.want)
+		}
+	}
+
+	_, err := New(math.MaxInt64, "USD").Times(big.NewRat(3, 2))
+	if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("got %v, want ErrOverflow", err)
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := map[Money]string{
+		New(1999, "USD"): "19.99 USD",
+		New(5, "USD"):    "0.05 USD",
+		New(-5, "EUR"):   "-0.05 EUR",
+		New(1999, "JPY"): "1999 JPY",
+		New(-7, "KRW"):   "-7 KRW",
+		New(1500, "KWD"): "1.500 KWD",
+		New(-1, "BHD"):   "-0.001 BHD",
+	}
+	for m, want := range tests {
+		if m.String() != want {
+			t.Errorf("got %q, want %q", m.String(), want)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	sum, err := New(1999, "USD").Add(New(1, "USD"))
+	if err != nil || sum != New(2000, "USD") {
+		t.Fatalf("got %v, %v", sum, err)
+	}
+	_, err = New(1999, "USD").Add(New(1, "EUR"))
+	if !errors.Is(err, ErrCurrencyMismatch) {
+		t.Fatalf("mixing currencies: got %v", err)
+	}
+
+	for name, err := range map[string]error{
+		"add":              second(New(math.MaxInt64, "USD").Add(New(1, "USD"))),
+		"add negative":     second(New(math.MinInt64, "USD").Add(New(-1, "USD"))),
+		"sub":              second(New(0, "USD").Sub(New(math.MinInt64, "USD"))),
+		"mul":              second(New(math.MaxInt64/2+1, "USD").Mul(2)),
+		"mul minus one":    second(New(math.MinInt64, "USD").Mul(-1)),
+		"mul by min int64": second(New(-1, "USD").Mul(math.MinInt64)),
+	} {
+		if !errors.Is(err, ErrOverflow) {
+			t.Errorf("%s: got %v, want ErrOverflow", name, err)
+		}
+	}
+
+	product, err := New(250, "JPY").Mul(4)
+	if err != nil || product != New(1000, "JPY") {
+		t.Fatalf("got %v, %v", product, err)
+	}
+}
+
+func second(_ Money, err error) error {
+	return err
+}
+
+func TestUnmarshalJSONReadsBareAmountsInTheDefaultCurrency(t *testing.T) {
+	var legacy, current Money
+	err := json.Unmarshal([]byte(`1999`), &legacy)
+	if err != nil || legacy != New(1999, Default) {
+		t.Fatalf("got %v, %v", legacy, err)
+	}
+	err = json.Unmarshal([]byte(`{"amount": 1999, "currency": "JPY"}`), &current)
+	if err != nil || current != New(1999, "JPY") {
+		t.Fatalf("got %v, %v", current, err)
+	}
+}
@@ -0,0 +1,217 @@
+package money
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrNoRate is returned when there is no exchange rate between two currencies.
+var ErrNoRate = errors.New("no exchange rate")
+
+// Rates are exchange rates against Base: one unit of Base buys Rates[c] units of c. Rates between two other
+// currencies are crossed through Base.
+type Rates struct {
+	Base  string              `json:"base"`
+	AsOf  time.Time           `json:"as_of"`
+	Rates map[string]*big.Rat `json:"rates"`
+}
+
+// Rate returns how many units of to one unit of from buys.
+func (r Rates) Rate(from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+	fromRate, ok := r.against(from)
+	if !ok {
+		return nil, fmt.Errorf("%w from %s", ErrNoRate, from)
+	}
+	toRate, ok := r.against(to)
+	if !ok {
+		return nil, fmt.Errorf("%w to %s", ErrNoRate, to)
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+func (r Rates) against(currency string) (*big.Rat, bool) {
+	if currency == r.Base && r.Base != "" {
+		return big.NewRat(1, 1), true
+	}
+	rate, ok := r.Rates[currency]
+	return rate, ok && rate.Sign() > 0
+}
+
+// Convert converts m to the currency to, rounding half to even to whole minor units of to.
+// It also returns the rate used, formatted as a decimal.
+func (r Rates) Convert(m Money, to string) (Money, string, error) {
+	rate, err := r.Rate(m.Currency, to)
+	if err != nil {
+		return Money{}, "", err
+	}
+	// amount / 10^exp(from) * rate * 10^exp(to)
+	x := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate)
+	shift := Exponent(to) - Exponent(m.Currency)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(shift))), nil))
+	if shift >= 0 {
+		x.Mul(x, scale)
+	} else {
+		x.Quo(x, scale)
+	}
+
+	amount := roundHalfEven(x)
+	if !amount.IsInt64() {
+		return Money{}, "", ErrOverflow
+	}
+	return Money{Amount: amount.Int64(), Currency: to}, FormatRate(rate), nil
+}
+
+// FormatRate formats a rate with up to ten decimals.
+func FormatRate(rate *big.Rat) string {
+	s := rate.FloatString(10)
+	for s[len(s)-1] == '0' {
+		s = s[:len(s)-1]
+	}
+	if s[len(s)-1] == '.' {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func roundHalfEven(x *big.Rat) *big.Int {
+	q, m := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int))
+	// q is truncated towards zero, m has the sign of x
+	twice := new(big.Int).Abs(m)
+	twice.Lsh(twice, 1)
+	switch twice.Cmp(x.Denom()) {
+	case 1:
+		q.Add(q, big.NewInt(int64(x.Sign())))
+	case 0:
+		if q.Bit(0) == 1 {
+			q.Add(q, big.NewInt(int64(x.Sign())))
+		}
+	}
+	return q
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Source provides exchange rates.
+type Source interface {
+	Rates(ctx context.Context) (Rates, error)
+}
+
+// StaticFile reads rates from a JSON file, for offline use and tests:
+//
+//	{"base": "USD", "as_of": "2026-10-19T00:00:00Z", "rates": {"EUR": "0.9185", "JPY": "149.62"}}
+//
+// Rates are decimal strings so that they are read exactly. Without a path there are no rates, and only
+// amounts already in the wanted currency can be used.
+type StaticFile struct {
+	path string
+}
+
+func NewStaticFile(path string) *StaticFile {
+	return &StaticFile{path: path}
+}
+
+func (f *StaticFile) Rates(ctx context.Context) (Rates, error) {
+	if f.path == "" {
+		return Rates{}, nil
+	}
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return Rates{}, err
+	}
+	var rates Rates
+	err = json.Unmarshal(data, &rates)
+	if err != nil {
+		return Rates{}, fmt.Errorf("exchange rates %s: %w", f.path, err)
+	}
+	if !Valid(rates.Base) {
+		return Rates{}, fmt.Errorf("exchange rates %s: unknown base currency %q", f.path, rates.Base)
+	}
+	for currency := range rates.Rates {
+		if !Valid(currency) {
+			return Rates{}, fmt.Errorf("exchange rates %s: unknown currency %q", f.path, currency)
+		}
+	}
+	return rates, nil
+}
+
+// Table caches the rates of a Source for refresh. When reading the source fails the previous rates are kept,
+// so a source that is briefly down does not stop checkouts in other currencies.
+type Table struct {
+	source  Source
+	refresh time.Duration
+
+	mu      sync.Mutex
+	rates   Rates
+	fetched time.Time
+}
+
+func NewTable(source Source, refresh time.Duration) *Table {
+	return &Table{source: source, refresh: refresh}
+}
+
+func (t *Table) Rates(ctx context.Context) (Rates, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.fetched.IsZero() && time.Since(t.fetched) < t.refresh {
+		return t.rates, nil
+	}
+	rates, err := t.source.Rates(ctx)
+	if err != nil {
+		if t.fetched.IsZero() {
+			return Rates{}, err
+		}
+		slog.WarnContext(ctx, "failed to refresh exchange rates, keeping the previous ones", "as_of", t.rates.AsOf, "error", err)
+		return t.rates, nil
+	}
+	t.rates = rates
+	t.fetched = time.Now()
+	return rates, nil
+}
+
+// ratesJSON is the encoding of Rates, with every rate as a decimal string.
+type ratesJSON struct {
+	Base  string            `json:"base"`
+	AsOf  time.Time         `json:"as_of"`
+	Rates map[string]string `json:"rates"`
+}
+
+func (r Rates) MarshalJSON() ([]byte, error) {
+	rates := make(map[string]string, len(r.Rates))
+	for currency, rate := range r.Rates {
+		rates[currency] = FormatRate(rate)
+	}
+	return json.Marshal(ratesJSON{r.Base, r.AsOf, rates})
+}
+
+func (r *Rates) UnmarshalJSON(data []byte) error {
+	var decoded ratesJSON
+	err := json.Unmarshal(data, &decoded)
+	if err != nil {
+		return err
+	}
+	*r = Rates{Base: decoded.Base, AsOf: decoded.AsOf, Rates: make(map[string]*big.Rat, len(decoded.Rates))}
+	for currency, value := range decoded.Rates {
+		rate, ok := new(big.Rat).SetString(value)
+		if !ok || rate.Sign() <= 0 {
+			return fmt.Errorf("bad exchange rate %q for %q", value, currency)
+		}
+		r.Rates[currency] = rate
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package money
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func rat(s string) *big.Rat {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		panic(s)
+	}
+	return r
+}
+
+func TestConvert(t *testing.T) {
+	rates := Rates{Base: "USD", Rates: map[string]*big.Rat{"EUR": rat("0.9185"), "JPY": rat("149.62"), "KWD": rat("0.3075"), "KRW": rat("200")}}
+
+	tests := []struct {
+		name string
+		from Money
+		to   string
+		want int64
+		rate string
+	}{
+		{name: "same currency", from: New(1999, "USD"), to: "USD", want: 1999, rate: "1"},
+		// 918.5 cents
+		{name: "half to even", from: New(1000, "USD"), to: "EUR", want: 918, rate: "0.9185"},
+		// 919.4185 cents
+		{name: "down", from: New(1001, "USD"), to: "EUR", want: 919, rate: "0.9185"},
+		// to no minor units, 2990.9038 yen
+		{name: "to fewer decimals", from: New(1999, "USD"), to: "JPY", want: 2991, rate: "149.62"},
+		// from no minor units, 66.84 cents
+		{name: "to more decimals", from: New(100, "JPY"), to: "USD", want: 67, rate: "0.0066835984"},
+		// 0.5 and 1.5 cents
+		{name: "half a cent down", from: New(1, "KRW"), to: "USD", want: 0, rate: "0.005"},
+		{name: "half a cent up", from: New(3, "KRW"), to: "USD", want: 2, rate: "0.005"},
+		// to three minor units, 3.075 dinar
+		{name: "to three decimals", from: New(1000, "USD"), to: "KWD", want: 3075, rate: "0.3075"},
+		// crossed through the base, 10 euro is 1628.96 yen
+		{name: "crossed", from: New(1000, "EUR"), to: "JPY", want: 1629, rate: "162.8960261296"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rate, err := rates.Convert(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != New(tt.want, tt.to) || rate != tt.rate {
+				t.Fatalf("got %v at %s, want %d %s at %s", got, rate, tt.want, tt.to, tt.rate)
+			}
+		})
+	}
+
+	_, _, err := rates.Convert(New(100, "GBP"), "USD")
+	if !errors.Is(err, ErrNoRate) {
+		t.Fatalf("unknown currency: got %v, want ErrNoRate", err)
+	}
+	_, _, err = rates.Convert(New(100, "USD"), "GBP")
+	if !errors.Is(err, ErrNoRate) {
+		t.Fatalf("unknown currency: got %v, want ErrNoRate", err)
+	}
+	_, _, err = rates.Convert(New(math.MaxInt64, "USD"), "JPY")
+	if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("got %v, want ErrOverflow", err)
+	}
+}
+
+func TestFormatRate(t *testing.T) {
+	for rate, want := range map[*big.Rat]string{
+		big.NewRat(2, 1):  "2",
+		big.NewRat(3, 2):  "1.5",
+		big.NewRat(1, 3):  "0.3333333333",
+		rat("149.62"):     "149.62",
+		rat("0.00000001"): "0.00000001",
+	} {
+		if got := FormatRate(rate); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestRatesJSON(t *testing.T) {
+	var rates Rates
+	err := rates.UnmarshalJSON([]byte(`{"base": "USD", "rates": {"EUR": "0.9185", "JPY": "149.62"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rates.Rates["EUR"].Cmp(rat("0.9185")) != 0 {
+		t.Fatalf("EUR is %s, want exactly 0.9185", rates.Rates["EUR"].RatString())
+	}
+	data, err := rates.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var again Rates
+	err = again.UnmarshalJSON(data)
+	if err != nil || again.Rates["JPY"].Cmp(rates.Rates["JPY"]) != 0 {
+		t.Fatalf("round trip of %s: %v", data, err)
+	}
+
+	for _, bad := range []string{`{"rates": {"EUR": "-1"}}`, `{"rates": {"EUR": "0"}}`, `{"rates": {"EUR": "lots"}}`} {
+		if err := new(Rates).UnmarshalJSON([]byte(bad)); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
+
+func TestStaticFile(t *testing.T) {
+	rates, err := NewStaticFile("").Rates(context.Background())
+	if err != nil || len(rates.Rates) != 0 {
+		t.Fatalf("without a path: got %+v, %v", rates, err)
+	}
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"unknown-base.json":     `{"base": "XXX", "rates": {}}`,
+		"unknown-currency.json": `{"base": "USD", "rates": {"XXX": "2"}}`,
+	} {
+		path := filepath.Join(dir, name)
+		err := os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewStaticFile(path).Rates(context.Background()); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
+
+type flakySource struct {
+	calls int
+	err   error
+}
+
+func (s *flakySource) Rates(ctx context.Context) (Rates, error) {
+	s.calls++
+	if s.err != nil {
+		return Rates{}, s.err
+	}
+	return Rates{Base: "USD", Rates: map[string]*big.Rat{"EUR": big.NewRat(int64(s.calls), 1)}}, nil
+}
+
+func TestTableKeepsTheLastRatesWhenTheSourceFails(t *testing.T) {
+	source := &flakySource{err: errors.New("down")}
+	_, err := NewTable(source, time.Hour).Rates(context.Background())
+	if err == nil {
+		t.Fatal("no rates yet, want the source's error")
+	}
+
+	source.err = nil
+	table := NewTable(source, 0)
+	first, err := table.Rates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	source.err = errors.New("down")
+	kept, err := table.Rates(context.Background())
+	if err != nil || kept.Rates["EUR"].Cmp(first.Rates["EUR"]) != 0 {
+		t.Fatalf("got %+v, %v; want the previous rates", kept, err)
+	}
+
+	cached := NewTable(source, time.Hour)
+	source.err = nil
+	calls := source.calls
+	for i := 0; i < 3; i++ {
+		_, err := cached.Rates(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if source.calls != calls+1 {
+		t.Fatalf("source read %d times within the refresh interval, want once", source.calls-calls)
+	}
+}
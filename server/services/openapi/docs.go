@@ -0,0 +1,68 @@
+package openapi
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"html/template"
+	"net/http"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+)
+
+// swaggerUI is loaded from a CDN at a pinned version so the server does not vendor the assets.
+const swaggerUI = "https://unpkg.com/swagger-ui-dist@5.17.14"
+
+var docsPage = template.Must(template.New("docs").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="{{.Assets}}/swagger-ui.css">
+</head>
+<body>
+<div id="docs"></div>
+<script src="{{.Assets}}/swagger-ui-bundle.js"></script>
+<script nonce="{{.Nonce}}">
+SwaggerUIBundle({ url: {{.SpecURL}}, dom_id: "#docs" });
+</script>
+</body>
+</html>
+`))
+
+// ServeSpec serves the document generated by Build.
+func (reg *Registry) ServeSpec(w http.ResponseWriter, r *http.Request) {
+	if reg.spec == nil {
+		apperrors.Write(w, r, apperrors.NotFound("spec_not_built", "The API description is not available yet."))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(reg.spec)
+}
+
+// DocsHandler serves an interactive page for the document at specURL. The page needs scripts and styles,
+// so it relaxes the API's default Content-Security-Policy for this response only.
+func (reg *Registry) DocsHandler(specURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nonceBytes := make([]byte, 16)
+		_, err := rand.Read(nonceBytes)
+		if err != nil {
+			apperrors.Write(w, r, apperrors.Internal("nonce_failed", "Failed to render the docs page.", err))
+			return
+		}
+		nonce := base64.StdEncoding.EncodeToString(nonceBytes)
+
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; "+
+			"script-src 'nonce-"+nonce+"' "+swaggerUI+"/; "+
+			"style-src 'unsafe-inline' "+swaggerUI+"/; "+
+			"img-src 'self' data:; connect-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		docsPage.Execute(w, map[string]string{
+			"Title":   reg.info.Title,
+			"Assets":  swaggerUI,
+			"SpecURL": specURL,
+			"Nonce":   nonce,
+		})
+	}
+}
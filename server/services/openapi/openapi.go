@@ -0,0 +1,281 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+)
+
+// OpenAPI 3.1 https://spec.openapis.org/oas/v3.1.0
+// The document is built from the route patterns the server actually registered, each matched with a description.
+
+const Version = "3.1.0"
+
+type Document struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       Info                                   `json:"info"`
+	Paths      map[string]map[string]*operationObject `json:"paths"`
+	Components components                             `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Operation describes one route. Path parameters are taken from the route pattern.
+type Operation struct {
+	Summary     string
+	Tag         string
+	Public      bool // served without a bearer token
+	Deprecated  bool
+	Idempotent  bool // accepts an Idempotency-Key header
+	Query       []Param
+	Body        any  // example value of the JSON request body, nil when there is none
+	Partial     bool // every body field is optional, as for PATCH
+	NoBody      bool // the body may be left out altogether
+	Upload      bool // the body is multipart/form-data with a "file" field
+	Status      int  // success status, 200 when zero
+	Response    any  // example value of the success body
+	ContentType string
+}
+
+type Param struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+type operationObject struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Security    []map[string][]string `json:"security"`
+	Parameters  []parameter           `json:"parameters,omitempty"`
+	RequestBody *requestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*response  `json:"responses"`
+}
+
+type parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Schema      *Schema `json:"schema"`
+}
+
+type requestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]mediaType `json:"content"`
+}
+
+type response struct {
+	Ref         string               `json:"$ref,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Content     map[string]mediaType `json:"content,omitempty"`
+}
+
+type mediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type components struct {
+	Schemas         schemas                   `json:"schemas"`
+	Responses       map[string]*response      `json:"responses"`
+	SecuritySchemes map[string]securityScheme `json:"securitySchemes"`
+}
+
+type securityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme"`
+	BearerFormat string `json:"bearerFormat"`
+}
+
+// error responses shared by every operation, all rendered as problem documents
+var problemResponses = map[int]string{
+	http.StatusBadRequest:            "The request is malformed.",
+	http.StatusUnauthorized:          "The bearer token is missing or invalid.",
+	http.StatusNotFound:              "The resource does not exist.",
+	http.StatusConflict:              "The request conflicts with the current state of the resource.",
+	http.StatusRequestEntityTooLarge: "The request body is too large.",
+	http.StatusUnprocessableEntity:   "One or more fields are invalid.",
+	http.StatusTooManyRequests:       "The rate limit was exceeded, see Retry-After.",
+}
+
+// Registry holds the description of every route the server may register.
+type Registry struct {
+	info       Info
+	operations map[string]Operation
+	spec       []byte
+}
+
+func NewRegistry(info Info) *Registry {
+	return &Registry{info: info, operations: map[string]Operation{}}
+}
+
+// Describe documents the route registered with pattern, for example "GET /v1/items/{id}".
+func (reg *Registry) Describe(pattern string, op Operation) {
+	reg.operations[pattern] = op
+}
+
+// Build generates the document for the registered patterns, which ServeSpec then serves.
+// It fails, listing them, when any pattern has no description.
+func (reg *Registry) Build(patterns []string) error {
+	var missing []string
+	for _, pattern := range patterns {
+		if _, ok := reg.operations[pattern]; !ok {
+			missing = append(missing, pattern)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.New("routes without an OpenAPI description: " + strings.Join(missing, ", "))
+	}
+
+	spec, err := json.Marshal(reg.document(patterns))
+	if err != nil {
+		return err
+	}
+	reg.spec = spec
+	return nil
+}
+
+func (reg *Registry) document(patterns []string) *Document {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    reg.info,
+		Paths:   map[string]map[string]*operationObject{},
+		Components: components{
+			Schemas:   schemas{},
+			Responses: map[string]*response{},
+			SecuritySchemes: map[string]securityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+	}
+
+	problem := doc.Components.Schemas.of(apperrors.Problem{}, false)
+	for status, description := range problemResponses {
+		doc.Components.Responses[strconv.Itoa(status)] = &response{
+			Description: description,
+			Content:     map[string]mediaType{apperrors.ProblemContentType: {Schema: problem}},
+		}
+	}
+	doc.Components.Responses["default"] = &response{
+		Description: "Unexpected error.",
+		Content:     map[string]mediaType{apperrors.ProblemContentType: {Schema: problem}},
+	}
+
+	for _, pattern := range patterns {
+		method, path, _ := strings.Cut(pattern, " ")
+		path, params := parsePath(path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*operationObject{}
+		}
+		doc.Paths[path][strings.ToLower(method)] = reg.operation(doc.Components.Schemas, method, path, params, reg.operations[pattern])
+	}
+	return doc
+}
+
+func (reg *Registry) operation(s schemas, method, path string, pathParams []string, op Operation) *operationObject {
+	object := &operationObject{
+		OperationID: operationID(method, path),
+		Summary:     op.Summary,
+		Deprecated:  op.Deprecated,
+		Security:    []map[string][]string{},
+		Responses:   map[string]*response{},
+	}
+	if op.Tag != "" {
+		object.Tags = []string{op.Tag}
+	}
+
+	errorStatuses := []int{http.StatusTooManyRequests}
+	if !op.Public {
+		object.Security = append(object.Security, map[string][]string{"bearerAuth": {}})
+		errorStatuses = append(errorStatuses, http.StatusUnauthorized)
+	}
+
+	for _, name := range pathParams {
+		object.Parameters = append(object.Parameters, parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	if len(pathParams) > 0 {
+		errorStatuses = append(errorStatuses, http.StatusNotFound)
+	}
+	for _, param := range op.Query {
+		object.Parameters = append(object.Parameters, parameter{Name: param.Name, In: "query", Description: param.Description, Required: param.Required, Schema: &Schema{Type: "string"}})
+	}
+	if len(op.Query) > 0 {
+		errorStatuses = append(errorStatuses, http.StatusBadRequest)
+	}
+	if op.Idempotent {
+		description := "A retry with the same key and body gets the first response back; the same key with a different body is a 422."
+		maxLength := 255
+		object.Parameters = append(object.Parameters, parameter{Name: "Idempotency-Key", In: "header", Description: description, Schema: &Schema{Type: "string", MaxLength: &maxLength}})
+		errorStatuses = append(errorStatuses, http.StatusBadRequest, http.StatusConflict, http.StatusUnprocessableEntity)
+	}
+
+	switch {
+	case op.Upload:
+		file := &Schema{Type: "object", Required: []string{"file"}, Properties: map[string]*Schema{"file": {Type: "string", Format: "binary"}}}
+		object.RequestBody = &requestBody{Required: true, Content: map[string]mediaType{"multipart/form-data": {Schema: file}}}
+		errorStatuses = append(errorStatuses, http.StatusBadRequest, http.StatusRequestEntityTooLarge)
+	case op.Body != nil:
+		object.RequestBody = &requestBody{Required: !op.NoBody, Content: map[string]mediaType{"application/json": {Schema: s.of(op.Body, op.Partial)}}}
+		errorStatuses = append(errorStatuses, http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity)
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := &response{Description: http.StatusText(status)}
+	if op.Response != nil {
+		contentType := op.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		success.Content = map[string]mediaType{contentType: {Schema: s.of(op.Response, false)}}
+	}
+	object.Responses[strconv.Itoa(status)] = success
+
+	for _, status := range errorStatuses {
+		code := strconv.Itoa(status)
+		object.Responses[code] = &response{Ref: "#/components/responses/" + code}
+	}
+	object.Responses["default"] = &response{Ref: "#/components/responses/default"}
+	return object
+}
+
+// parsePath turns a mux path into an OpenAPI path and lists its wildcards.
+// "{$}" anchors are dropped and "{name...}" becomes "{name}".
+func parsePath(path string) (string, []string) {
+	path = strings.TrimSuffix(path, "{$}")
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.Trim(segment, "{}"), "...")
+		segments[i] = "{" + name + "}"
+		params = append(params, name)
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationID derives a stable id such as "getV1ItemsId" from the method and path.
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,198 @@
+package openapi
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is a JSON Schema as used by OpenAPI 3.1.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	MinProperties        *int               `json:"minProperties,omitempty"`
+	MaxProperties        *int               `json:"maxProperties,omitempty"`
+}
+
+// Object describes an inline JSON object whose properties are given as example Go values,
+// for example Object{"message": "", "item": db.Item{}}.
+type Object map[string]any
+
+// Optional marks a property of an Object that may be left out.
+type Optional struct {
+	Value any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemas collects named struct types as reusable components while schemas are generated.
+type schemas map[string]*Schema
+
+// of returns the schema for an example value. Named structs are added to the components and referenced;
+// partial structs get a separate "<Name>Patch" component with no required fields.
+func (s schemas) of(v any, partial bool) *Schema {
+	if object, ok := v.(Object); ok {
+		schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for name, value := range object {
+			if optional, ok := value.(Optional); ok {
+				schema.Properties[name] = s.of(optional.Value, false)
+				continue
+			}
+			schema.Properties[name] = s.of(value, false)
+			schema.Required = append(schema.Required, name)
+		}
+		slices.Sort(schema.Required)
+		return schema
+	}
+	return s.ofType(reflect.TypeOf(v), partial)
+}
+
+func (s schemas) ofType(t reflect.Type, partial bool) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: s.ofType(t.Elem(), false)}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.ofType(t.Elem(), false)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t, partial)
+		}
+		name := t.Name()
+		if partial {
+			name += "Patch"
+		}
+		if _, ok := s[name]; !ok {
+			s[name] = nil // reserve the name so recursive types terminate
+			s[name] = s.object(t, partial)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	// interfaces and anything else accept any JSON value
+	return &Schema{}
+}
+
+func (s schemas) object(t reflect.Type, partial bool) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		// embedded structs without a name are flattened, as encoding/json does
+		if name == "" && sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			embedded := s.object(sf.Type, partial)
+			for key, property := range embedded.Properties {
+				schema.Properties[key] = property
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+
+		property := s.ofType(sf.Type, false)
+		required := applyRules(property, sf.Tag.Get("validate"))
+		schema.Properties[name] = property
+		if required && !partial {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+// applyRules copies the `validate` tag of a field onto its schema and reports whether the field is required.
+// Rules that depend on sibling fields (gtefield, required_without) cannot be expressed and are left out.
+func applyRules(schema *Schema, tag string) bool {
+	required := false
+	if tag == "" {
+		return false
+	}
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "required":
+			required = true
+		case "email":
+			schema.Format = "email"
+		case "oneof":
+			schema.Enum = strings.Fields(param)
+		case "min", "max":
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				continue
+			}
+			setBound(schema, name == "min", limit)
+		}
+	}
+	return required
+}
+
+func setBound(schema *Schema, lower bool, limit float64) {
+	n := int(limit)
+	switch schema.Type {
+	case "string":
+		if lower {
+			schema.MinLength = &n
+		} else {
+			schema.MaxLength = &n
+		}
+	case "array":
+		if lower {
+			schema.MinItems = &n
+		} else {
+			schema.MaxItems = &n
+		}
+	case "object":
+		if lower {
+			schema.MinProperties = &n
+		} else {
+			schema.MaxProperties = &n
+		}
+	default:
+		if lower {
+			schema.Minimum = &limit
+		} else {
+			schema.Maximum = &limit
+		}
+	}
+}
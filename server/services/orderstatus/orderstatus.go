@@ -0,0 +1,100 @@
+// Package orderstatus defines the order lifecycle: the statuses, the transitions between them and which roles
+// may make each transition.
+//
+//	pending -> paid -> fulfilled -> shipped -> delivered
+//	pending -> failed -> paid
+//	pending, failed -> canceled
+//	paid, fulfilled, shipped, delivered -> refunded
+package orderstatus
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+const (
+	Pending   = "pending"
+	Paid      = "paid"
+	Fulfilled = "fulfilled"
+	Shipped   = "shipped"
+	Delivered = "delivered"
+	Canceled  = "canceled"
+	Refunded  = "refunded"
+	Failed    = "failed" // the last payment attempt failed, the stock stays reserved until the order is paid or canceled
+)
+
+type rule struct {
+	roles []string
+	owner bool // the customer who placed the order may also make the transition
+}
+
+var (
+	staff    = rule{roles: []string{services.RoleStaff, services.RoleAdmin}}
+	customer = rule{roles: staff.roles, owner: true}
+	// payments are confirmed by the gateway's webhooks, staff may also mark an order paid by hand
+	payment = rule{roles: []string{services.RoleSystem, services.RoleStaff, services.RoleAdmin}}
+	// refunds are settled by the gateway's webhooks too, admins may also mark an order refunded by hand
+	refund = rule{roles: []string{services.RoleSystem, services.RoleAdmin}}
+	system = rule{roles: []string{services.RoleSystem}}
+)
+
+// transitions lists the allowed targets of every status, canceled and refunded are final. A paid order is never
+// canceled, that would return its stock but keep the money; it is refunded instead.
+var transitions = map[string]map[string]rule{
+	Pending:   {Paid: payment, Failed: system, Canceled: customer},
+	Failed:    {Paid: payment, Canceled: customer},
+	Paid:      {Fulfilled: staff, Refunded: refund},
+	Fulfilled: {Shipped: staff, Refunded: refund},
+	Shipped:   {Delivered: staff, Refunded: refund},
+	Delivered: {Refunded: refund},
+}
+
+var statuses = []string{Pending, Failed, Paid, Fulfilled, Shipped, Delivered, Canceled, Refunded}
+
+func Valid(status string) bool {
+	return slices.Contains(statuses, status)
+}
+
+// Next returns the statuses an order in status can move to, in lifecycle order.
+func Next(status string) []string {
+	var next []string
+	for _, s := range statuses {
+		if _, ok := transitions[status][s]; ok {
+			next = append(next, s)
+		}
+	}
+	return next
+}
+
+// Check reports whether change.By, acting as change.Role, may move order to change.To.
+// An unknown status is a 422, a transition the lifecycle does not allow a 409 and a missing role a 403.
+func Check(order db.Order, change db.StatusChange) error {
+	if !Valid(change.To) {
+		return apperrors.Validation("invalid_status", fmt.Sprintf("Status must be one of %s.", strings.Join(statuses, ", ")))
+	}
+	r, ok := transitions[order.Status][change.To]
+	if !ok {
+		detail := fmt.Sprintf("An order that is %s cannot become %s.", order.Status, change.To)
+		if next := Next(order.Status); len(next) > 0 {
+			detail += fmt.Sprintf(" It can become %s.", strings.Join(next, ", "))
+		}
+		return apperrors.Conflict("invalid_transition", detail)
+	}
+	if slices.Contains(r.roles, change.Role) || r.owner && change.By == order.User {
+		return nil
+	}
+	return apperrors.Forbidden("transition_forbidden", fmt.Sprintf("Your role may not move an order from %s to %s.", order.Status, change.To))
+}
+
+// Apply moves order to change.To and appends change, with From filled in, to its history.
+func Apply(order *db.Order, change db.StatusChange) {
+	change.From = order.Status
+	order.Status = change.To
+	order.History = append(order.History, change)
+	order.UpdatedAt = change.At
+}
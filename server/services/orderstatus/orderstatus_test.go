@@ -0,0 +1,33 @@
+package orderstatus
+
+import (
+	"errors"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+func TestAPaidOrderIsRefundedNotCanceled(t *testing.T) {
+	order := db.Order{ID: "o1", User: "u_1", Status: Paid}
+
+	err := Check(order, db.StatusChange{To: Canceled, By: "u_9", Role: services.RoleAdmin})
+	var appErr *apperrors.Error
+	if !errors.As(err, &appErr) || appErr.Code != "invalid_transition" {
+		t.Fatalf("canceling a paid order: got %v, want invalid_transition", err)
+	}
+	err = Check(order, db.StatusChange{To: Refunded, By: "u_9", Role: services.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a settled refund moves it too
+	err = Check(order, db.StatusChange{To: Refunded, By: "gateway:fake", Role: services.RoleSystem})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Check(order, db.StatusChange{To: Refunded, By: "u_2", Role: services.RoleStaff})
+	if !errors.As(err, &appErr) || appErr.Code != "transition_forbidden" {
+		t.Fatalf("staff refunding: got %v, want transition_forbidden", err)
+	}
+}
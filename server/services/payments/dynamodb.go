@@ -0,0 +1,95 @@
+package payments
+
+import (
+	"context"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+)
+
+// DynamoStore keeps payments and refunds in their own tables, the refunded total lives on the payment.
+type DynamoStore struct {
+	client   *dynamodb.Client
+	payments string
+	refunds  string
+	orders   string
+}
+
+func NewDynamoStore(client *dynamodb.Client, paymentsTable, refundsTable, ordersTable string) *DynamoStore {
+	return &DynamoStore{client: client, payments: paymentsTable, refunds: refundsTable, orders: ordersTable}
+}
+
+func (s *DynamoStore) Order(ctx context.Context, id string) (db.Order, error) {
+	resp, err := db.GetOrderById(ctx, s.client, s.orders, id)
+	if err != nil {
+		return db.Order{}, err
+	}
+	var order db.Order
+	err = attributevalue.UnmarshalMap(resp, &order)
+	if err != nil {
+		return db.Order{}, apperrors.Internal("decode_failed", "Failed to decode order.", err)
+	}
+	return order, nil
+}
+
+func (s *DynamoStore) Payment(ctx context.Context, id string) (db.Payment, error) {
+	resp, err := db.GetPaymentById(ctx, s.client, s.payments, id)
+	if err != nil {
+		return db.Payment{}, err
+	}
+	var payment db.Payment
+	err = attributevalue.UnmarshalMap(resp, &payment)
+	if err != nil {
+		return db.Payment{}, apperrors.Internal("decode_failed", "Failed to decode payment.", err)
+	}
+	return payment, nil
+}
+
+func (s *DynamoStore) CreatePayment(ctx context.Context, payment db.Payment, previous string) error {
+	item, err := attributevalue.MarshalMap(payment)
+	if err != nil {
+		return apperrors.Internal("encode_failed", "Failed to encode payment.", err)
+	}
+	return db.CreatePayment(ctx, s.client, s.payments, s.orders, item, payment.OrderID, payment.ID, previous)
+}
+
+func (s *DynamoStore) UpdatePaymentStatus(ctx context.Context, from string, payment db.Payment) error {
+	return db.UpdatePaymentStatus(ctx, s.client, s.payments, from, payment)
+}
+
+func (s *DynamoStore) CreateRefund(ctx context.Context, refund db.Refund, amount int64) error {
+	item, err := attributevalue.MarshalMap(refund)
+	if err != nil {
+		return apperrors.Internal("encode_failed", "Failed to encode refund.", err)
+	}
+	return db.CreateRefund(ctx, s.client, s.payments, s.refunds, item, refund.PaymentID, refund.Amount, amount-refund.Amount, refund.CreatedAt)
+}
+
+func (s *DynamoStore) Refund(ctx context.Context, id string) (db.Refund, error) {
+	resp, err := db.GetRefundById(ctx, s.client, s.refunds, id)
+	if err != nil {
+		return db.Refund{}, err
+	}
+	var refund db.Refund
+	err = attributevalue.UnmarshalMap(resp, &refund)
+	if err != nil {
+		return db.Refund{}, apperrors.Internal("decode_failed", "Failed to decode refund.", err)
+	}
+	return refund, nil
+}
+
+func (s *DynamoStore) SetRefundGatewayID(ctx context.Context, id, gatewayRefundID string) error {
+	return db.SetRefundGatewayID(ctx, s.client, s.refunds, id, gatewayRefundID)
+}
+
+func (s *DynamoStore) SettleRefund(ctx context.Context, refund db.Refund, gatewayRefundID, status string, now int64) (db.Payment, bool, error) {
+	changed, err := db.SettleRefund(ctx, s.client, s.payments, s.refunds, refund, gatewayRefundID, status, now)
+	if err != nil || !changed {
+		return db.Payment{}, false, err
+	}
+	payment, err := s.Payment(ctx, refund.PaymentID)
+	return payment, true, err
+}
@@ -0,0 +1,183 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+
+	"github.com/gofrs/uuid"
+)
+
+// DeclinedPaymentMethod makes the fake gateway decline a payment, any other payment method is approved.
+const DeclinedPaymentMethod = "pm_card_declined"
+
+// Deliver sends a signed webhook body to the server.
+type Deliver func(ctx context.Context, body []byte, signature string) error
+
+// FakeGateway is an in-memory gateway for development and tests. Like a real provider it reports every settled
+// payment and refund as a signed webhook, delivered in the background through deliver and retried a few times.
+type FakeGateway struct {
+	secret  string
+	deliver Deliver
+
+	mu       sync.Mutex
+	intents  map[string]Intent
+	refunded map[string]int64
+}
+
+func NewFakeGateway(secret string, deliver Deliver) *FakeGateway {
+	return &FakeGateway{secret: secret, deliver: deliver, intents: map[string]Intent{}, refunded: map[string]int64{}}
+}
+
+// PostWebhook delivers webhooks with an HTTP POST to url, such as this server's own webhook route.
+func PostWebhook(url string) Deliver {
+	return func(ctx context.Context, body []byte, signature string) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(SignatureHeader, signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("webhook answered %s", resp.Status)
+		}
+		return nil
+	}
+}
+
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+func (g *FakeGateway) CreateIntent(ctx context.Context, req IntentRequest) (Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent := Intent{
+		ID:            "pi_" + newID(),
+		PaymentID:     req.PaymentID,
+		OrderID:       req.OrderID,
+		Amount:        req.Amount,
+		Currency:      req.Currency,
+		Status:        RequiresConfirmation,
+		ClientSecret:  "secret_" + newID(),
+		ManualCapture: req.ManualCapture,
+	}
+	g.intents[intent.ID] = intent
+	return intent, nil
+}
+
+func (g *FakeGateway) Confirm(ctx context.Context, intentID, paymentMethod string) (Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, err := g.intent(intentID, RequiresConfirmation)
+	if err != nil {
+		return Intent{}, err
+	}
+	event := EventPaymentSucceeded
+	switch {
+	case paymentMethod == DeclinedPaymentMethod:
+		intent.Status = Failed
+		intent.FailureReason = "card_declined"
+		event = EventPaymentFailed
+	case intent.ManualCapture:
+		intent.Status = RequiresCapture
+		event = EventPaymentRequiresCapture
+	default:
+		intent.Status = Succeeded
+	}
+	g.intents[intentID] = intent
+	g.send(Event{Type: event, Intent: &intent})
+	return intent, nil
+}
+
+func (g *FakeGateway) Capture(ctx context.Context, intentID string) (Intent, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, err := g.intent(intentID, RequiresCapture)
+	if err != nil {
+		return Intent{}, err
+	}
+	intent.Status = Succeeded
+	g.intents[intentID] = intent
+	g.send(Event{Type: EventPaymentSucceeded, Intent: &intent})
+	return intent, nil
+}
+
+func (g *FakeGateway) Refund(ctx context.Context, intentID, refundID string, amount int64) (RefundResult, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	intent, err := g.intent(intentID, Succeeded)
+	if err != nil {
+		return RefundResult{}, err
+	}
+	if amount <= 0 || g.refunded[intentID]+amount > intent.Amount {
+		return RefundResult{}, apperrors.Validation("refund_too_large", "The refund is larger than the amount left to refund.")
+	}
+	g.refunded[intentID] += amount
+
+	// refunds settle asynchronously, as with real providers
+	result := RefundResult{ID: "re_" + newID(), RefundID: refundID, IntentID: intentID, Amount: amount, Status: RefundPending}
+	settled := result
+	settled.Status = RefundSucceeded
+	g.send(Event{Type: EventRefundSucceeded, Refund: &settled})
+	return result, nil
+}
+
+// intent returns the intent if it is in status, g.mu must be held
+func (g *FakeGateway) intent(id, status string) (Intent, error) {
+	intent, ok := g.intents[id]
+	if !ok {
+		return Intent{}, apperrors.NotFound("intent_not_found", fmt.Sprintf("No payment intent found with id %s.", id))
+	}
+	if intent.Status != status {
+		return Intent{}, apperrors.Conflict("invalid_intent_status", fmt.Sprintf("The payment is %s, it must be %s.", intent.Status, status))
+	}
+	return intent, nil
+}
+
+func (g *FakeGateway) send(event Event) {
+	event.ID = "evt_" + newID()
+	event.Created = time.Now().Unix()
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("failed to encode fake webhook", "error", err)
+		return
+	}
+
+	go func() {
+		for attempt := 0; attempt < 5; attempt++ {
+			time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := g.deliver(ctx, body, Sign(g.secret, body, time.Now()))
+			cancel()
+			if err == nil {
+				return
+			}
+			slog.Warn("fake gateway webhook delivery failed", "event", event.ID, "type", event.Type, "attempt", attempt+1, "error", err)
+		}
+	}()
+}
+
+func newID() string {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return fmt.Sprint(time.Now().UnixNano())
+	}
+	return id.String()
+}
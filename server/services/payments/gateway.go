@@ -0,0 +1,84 @@
+// Package payments takes payment for orders through a pluggable Gateway. Payments and refunds are kept as their
+// own records; the gateway's signed webhooks are the authority on whether a payment succeeded, and they move
+// the order to paid or failed.
+package payments
+
+import (
+	"context"
+)
+
+// intent statuses, mirrored by db.Payment.Status
+const (
+	RequiresConfirmation = "requires_confirmation"
+	RequiresCapture      = "requires_capture"
+	Succeeded            = "succeeded"
+	Failed               = "failed"
+	Canceled             = "canceled"
+)
+
+// refund statuses
+const (
+	RefundPending   = "pending"
+	RefundSucceeded = "succeeded"
+	RefundFailed    = "failed"
+)
+
+// IntentRequest asks the gateway to prepare a charge of Amount for one payment of an order.
+type IntentRequest struct {
+	PaymentID     string
+	OrderID       string
+	Amount        int64
+	Currency      string
+	ManualCapture bool // confirming only authorizes the amount, Capture charges it
+}
+
+// Intent is the gateway's view of a payment. PaymentID and OrderID are echoed back as metadata.
+type Intent struct {
+	ID            string `json:"id"`
+	PaymentID     string `json:"payment_id"`
+	OrderID       string `json:"order_id"`
+	Amount        int64  `json:"amount"`
+	Currency      string `json:"currency"`
+	Status        string `json:"status"`
+	ClientSecret  string `json:"client_secret,omitempty"`
+	FailureReason string `json:"failure_reason,omitempty"`
+	ManualCapture bool   `json:"manual_capture"`
+}
+
+// RefundResult is the gateway's view of a refund, RefundID is the id of our db.Refund.
+type RefundResult struct {
+	ID       string `json:"id"`
+	RefundID string `json:"refund_id"`
+	IntentID string `json:"intent_id"`
+	Amount   int64  `json:"amount"`
+	Status   string `json:"status"`
+}
+
+// Gateway is a payment provider. Calls return the provider's state right away, but providers may settle later;
+// the final state always arrives as a webhook Event.
+type Gateway interface {
+	Name() string
+	CreateIntent(ctx context.Context, req IntentRequest) (Intent, error)
+	// Confirm charges, or with ManualCapture authorizes, the intent with a payment method from the client.
+	Confirm(ctx context.Context, intentID, paymentMethod string) (Intent, error)
+	Capture(ctx context.Context, intentID string) (Intent, error)
+	Refund(ctx context.Context, intentID, refundID string, amount int64) (RefundResult, error)
+}
+
+// event types
+const (
+	EventPaymentRequiresCapture = "payment.requires_capture"
+	EventPaymentSucceeded       = "payment.succeeded"
+	EventPaymentFailed          = "payment.failed"
+	EventRefundSucceeded        = "refund.succeeded"
+	EventRefundFailed           = "refund.failed"
+)
+
+// Event is the body of a webhook. Intent is set for payment events and Refund for refund events.
+type Event struct {
+	ID      string        `json:"id"`
+	Type    string        `json:"type"`
+	Created int64         `json:"created"`
+	Intent  *Intent       `json:"intent,omitempty"`
+	Refund  *RefundResult `json:"refund,omitempty"`
+}
@@ -0,0 +1,136 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/checkout"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+)
+
+// MemoryStore keeps payments and refunds in memory for development and tests, reading orders from a
+// checkout.MemoryStore. A single lock around each call gives it the same conditions as the DynamoDB writes.
+type MemoryStore struct {
+	orders *checkout.MemoryStore
+
+	mu       sync.Mutex
+	payments map[string]db.Payment
+	refunds  map[string]db.Refund
+	latest   map[string]string // order id to the PaymentID kept on the order
+}
+
+func NewMemoryStore(orders *checkout.MemoryStore) *MemoryStore {
+	return &MemoryStore{orders: orders, payments: map[string]db.Payment{}, refunds: map[string]db.Refund{}, latest: map[string]string{}}
+}
+
+func (s *MemoryStore) Order(ctx context.Context, id string) (db.Order, error) {
+	order, ok := s.orders.Order(id)
+	if !ok {
+		return db.Order{}, apperrors.NotFound("order_not_found", fmt.Sprintf("No order found with id %s.", id))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order.PaymentID = s.latest[id]
+	return order, nil
+}
+
+func (s *MemoryStore) Payment(ctx context.Context, id string) (db.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[id]
+	if !ok {
+		return db.Payment{}, apperrors.NotFound("payment_not_found", fmt.Sprintf("No payment found with id %s.", id))
+	}
+	return payment, nil
+}
+
+func (s *MemoryStore) CreatePayment(ctx context.Context, payment db.Payment, previous string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.payments[payment.ID]; ok {
+		return apperrors.Conflict("payment_exists", "A payment with this id already exists.")
+	}
+	order, ok := s.orders.Order(payment.OrderID)
+	if !ok || (order.Status != orderstatus.Pending && order.Status != orderstatus.Failed) || s.latest[order.ID] != previous {
+		return db.ErrPaymentInProgress
+	}
+	s.payments[payment.ID] = payment
+	s.latest[order.ID] = payment.ID
+	return nil
+}
+
+func (s *MemoryStore) UpdatePaymentStatus(ctx context.Context, from string, payment db.Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.payments[payment.ID]
+	if !ok || stored.Status != from {
+		return db.ErrPaymentChanged
+	}
+	stored.Status = payment.Status
+	stored.FailureReason = payment.FailureReason
+	stored.UpdatedAt = payment.UpdatedAt
+	s.payments[payment.ID] = stored
+	return nil
+}
+
+func (s *MemoryStore) CreateRefund(ctx context.Context, refund db.Refund, amount int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[refund.PaymentID]
+	if !ok || payment.Refunded+refund.Amount > amount {
+		return db.ErrRefundTooLarge
+	}
+	if _, ok := s.refunds[refund.ID]; ok {
+		return apperrors.Conflict("refund_exists", "A refund with this id already exists.")
+	}
+	payment.Refunded += refund.Amount
+	payment.UpdatedAt = refund.CreatedAt
+	s.payments[payment.ID] = payment
+	s.refunds[refund.ID] = refund
+	return nil
+}
+
+func (s *MemoryStore) Refund(ctx context.Context, id string) (db.Refund, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refund, ok := s.refunds[id]
+	if !ok {
+		return db.Refund{}, apperrors.NotFound("refund_not_found", fmt.Sprintf("No refund found with id %s.", id))
+	}
+	return refund, nil
+}
+
+func (s *MemoryStore) SetRefundGatewayID(ctx context.Context, id, gatewayRefundID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refund := s.refunds[id]
+	refund.GatewayRefundID = gatewayRefundID
+	s.refunds[id] = refund
+	return nil
+}
+
+func (s *MemoryStore) SettleRefund(ctx context.Context, refund db.Refund, gatewayRefundID, status string, now int64) (db.Payment, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.refunds[refund.ID]
+	if !ok || stored.Status != RefundPending {
+		return db.Payment{}, false, nil
+	}
+	stored.Status = status
+	stored.GatewayRefundID = gatewayRefundID
+	stored.UpdatedAt = now
+	s.refunds[refund.ID] = stored
+
+	payment := s.payments[stored.PaymentID]
+	if status == RefundFailed {
+		payment.Refunded -= stored.Amount
+	} else {
+		payment.RefundSettled += stored.Amount
+	}
+	payment.UpdatedAt = now
+	s.payments[payment.ID] = payment
+	return payment, true, nil
+}
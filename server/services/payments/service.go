@@ -0,0 +1,321 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/checkout"
+	"upgraded-telegram/main.go/server/services/config"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+)
+
+// processed webhook event ids are kept this long, a redelivery after that is still harmless because applying
+// an event twice changes nothing
+const eventRetention = 24 * time.Hour
+
+// Service takes payments for orders and refunds them, keeping db.Payment and db.Refund records in step with the gateway.
+type Service struct {
+	gateway Gateway
+	store   Store
+	orders  checkout.Store
+	events  services.IdempotencyStore
+	conf    config.Payments
+}
+
+// NewService records payments in store and moves orders through orders. events remembers which webhooks
+// were already handled.
+func NewService(gateway Gateway, store Store, orders checkout.Store, events services.IdempotencyStore, conf config.Payments) *Service {
+	return &Service{gateway: gateway, store: store, orders: orders, events: events, conf: conf}
+}
+
+func (s *Service) Order(ctx context.Context, id string) (db.Order, error) {
+	return s.store.Order(ctx, id)
+}
+
+func (s *Service) Payment(ctx context.Context, id string) (db.Payment, error) {
+	return s.store.Payment(ctx, id)
+}
+
+// CreatePayment starts paying the total of a pending order, or of one whose last payment failed. An order has one
+// payment in progress at a time, so it cannot be charged twice; the client confirms that one instead.
+// The client secret is only returned here, the client confirms the payment with it.
+func (s *Service) CreatePayment(ctx context.Context, order db.Order, manualCapture bool) (db.Payment, string, error) {
+	if order.Status != orderstatus.Pending && order.Status != orderstatus.Failed {
+		return db.Payment{}, "", apperrors.Conflict("order_not_payable", fmt.Sprintf("An order that is %s cannot be paid.", order.Status))
+	}
+	if order.Total.Amount <= 0 {
+		return db.Payment{}, "", apperrors.Validation("nothing_to_pay", "The order total is zero.")
+	}
+	if order.PaymentID != "" {
+		latest, err := s.store.Payment(ctx, order.PaymentID)
+		if err != nil {
+			return db.Payment{}, "", err
+		}
+		if latest.Status != Failed && latest.Status != Canceled {
+			return db.Payment{}, "", paymentInProgress(order.PaymentID)
+		}
+	}
+
+	payment := db.Payment{
+		ID:        "pay_" + newID(),
+		OrderID:   order.ID,
+		Gateway:   s.gateway.Name(),
+		Amount:    order.Total.Amount,
+		Currency:  order.Total.Currency,
+		CreatedAt: time.Now().UnixMilli(),
+	}
+	intent, err := s.gateway.CreateIntent(ctx, IntentRequest{
+		PaymentID:     payment.ID,
+		OrderID:       order.ID,
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		ManualCapture: manualCapture,
+	})
+	if err != nil {
+		return db.Payment{}, "", err
+	}
+	payment.IntentID = intent.ID
+	payment.Status = intent.Status
+	payment.UpdatedAt = payment.CreatedAt
+
+	err = s.store.CreatePayment(ctx, payment, order.PaymentID)
+	if errors.Is(err, db.ErrPaymentInProgress) {
+		// another payment was started since the order was read, the intent is left unconfirmed
+		return db.Payment{}, "", paymentInProgress("")
+	}
+	if err != nil {
+		return db.Payment{}, "", err
+	}
+	return payment, intent.ClientSecret, nil
+}
+
+func (s *Service) Confirm(ctx context.Context, payment db.Payment, paymentMethod string) (db.Payment, error) {
+	intent, err := s.gateway.Confirm(ctx, payment.IntentID, paymentMethod)
+	if err != nil {
+		return db.Payment{}, err
+	}
+	return s.apply(ctx, intent)
+}
+
+// Capture charges a payment that was confirmed with manual capture.
+func (s *Service) Capture(ctx context.Context, payment db.Payment) (db.Payment, error) {
+	intent, err := s.gateway.Capture(ctx, payment.IntentID)
+	if err != nil {
+		return db.Payment{}, err
+	}
+	return s.apply(ctx, intent)
+}
+
+// Refund returns amount of a succeeded payment, all that is left when amount is zero. The amount is reserved on
+// the payment before the gateway is asked, so concurrent refunds can never add up to more than was paid.
+// The refund is pending until the gateway settles it, actor is recorded as the one who asked for it.
+func (s *Service) Refund(ctx context.Context, payment db.Payment, amount int64, reason string, actor db.StatusChange) (db.Refund, error) {
+	if payment.Status != Succeeded {
+		return db.Refund{}, apperrors.Conflict("payment_not_refundable", fmt.Sprintf("A payment that is %s cannot be refunded.", payment.Status))
+	}
+	if amount == 0 {
+		amount = payment.Amount - payment.Refunded
+	}
+	if amount <= 0 || amount > payment.Amount-payment.Refunded {
+		return db.Refund{}, refundTooLarge(payment)
+	}
+
+	now := time.Now().UnixMilli()
+	refund := db.Refund{
+		ID:        "ref_" + newID(),
+		PaymentID: payment.ID,
+		OrderID:   payment.OrderID,
+		Amount:    amount,
+		Reason:    reason,
+		Status:    RefundPending,
+		CreatedBy: actor.By,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err := s.store.CreateRefund(ctx, refund, payment.Amount)
+	if errors.Is(err, db.ErrRefundTooLarge) {
+		return db.Refund{}, refundTooLarge(payment)
+	}
+	if err != nil {
+		return db.Refund{}, err
+	}
+
+	result, err := s.gateway.Refund(ctx, payment.IntentID, refund.ID, amount)
+	if err != nil {
+		// nothing was refunded, give the reserved amount back
+		settleErr := s.settleRefund(ctx, RefundResult{RefundID: refund.ID, Amount: amount, Status: RefundFailed})
+		return db.Refund{}, errors.Join(err, settleErr)
+	}
+	refund.GatewayRefundID = result.ID
+	err = s.store.SetRefundGatewayID(ctx, refund.ID, result.ID)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to record gateway refund id", "refund", refund.ID, "error", err)
+	}
+	return refund, nil
+}
+
+func paymentInProgress(id string) error {
+	if id == "" {
+		return apperrors.Conflict("payment_in_progress", "The order already has a payment in progress.")
+	}
+	return apperrors.Conflict("payment_in_progress", fmt.Sprintf("Payment %s of the order is still in progress, confirm it or wait until it settles.", id))
+}
+
+func refundTooLarge(payment db.Payment) error {
+	return apperrors.Validation("refund_too_large", fmt.Sprintf("At most %d of the payment is left to refund.", payment.Amount-payment.Refunded))
+}
+
+// HandleWebhook verifies and applies one gateway event. Each event id is handled once; an event that fails is
+// released so that the gateway's retry can handle it again.
+func (s *Service) HandleWebhook(ctx context.Context, body []byte, signature string) error {
+	now := time.Now()
+	err := Verify(s.conf.WebhookSecret, body, signature, now, s.conf.WebhookTolerance)
+	if err != nil {
+		return err
+	}
+	var event Event
+	err = json.Unmarshal(body, &event)
+	if err != nil || event.ID == "" {
+		return apperrors.BadRequest("invalid_event", "The webhook body is not a payment event.")
+	}
+
+	key := "payment-event|" + event.ID
+	record, claimed, err := s.events.Begin(ctx, key, services.IdempotencyRecord{
+		Fingerprint: event.ID,
+		LockedUntil: now.Add(time.Minute),
+		ExpiresAt:   now.Add(eventRetention),
+	}, now)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		if record.Status == 0 {
+			return apperrors.Conflict("event_in_progress", "This event is already being handled.")
+		}
+		slog.InfoContext(ctx, "ignoring replayed payment event", "event", event.ID, "type", event.Type)
+		return nil
+	}
+
+	err = s.handle(ctx, event)
+	if err != nil {
+		if releaseErr := s.events.Release(context.WithoutCancel(ctx), key, record.Claim); releaseErr != nil {
+			slog.WarnContext(ctx, "failed to release payment event", "event", event.ID, "error", releaseErr)
+		}
+		return err
+	}
+	record.Status = 200
+	err = s.events.Complete(context.WithoutCancel(ctx), key, record)
+	if errors.Is(err, services.ErrIdempotencyClaimLost) {
+		// handled here, and by the delivery that took the event over once this one's lock ran out
+		slog.WarnContext(ctx, "payment event was taken over before it was recorded", "event", event.ID)
+		return nil
+	}
+	return err
+}
+
+func (s *Service) handle(ctx context.Context, event Event) error {
+	switch event.Type {
+	case EventPaymentRequiresCapture, EventPaymentSucceeded, EventPaymentFailed:
+		if event.Intent == nil {
+			return apperrors.BadRequest("invalid_event", "A payment event must carry its intent.")
+		}
+		_, err := s.apply(ctx, *event.Intent)
+		return err
+	case EventRefundSucceeded, EventRefundFailed:
+		if event.Refund == nil {
+			return apperrors.BadRequest("invalid_event", "A refund event must carry its refund.")
+		}
+		return s.settleRefund(ctx, *event.Refund)
+	}
+	// acknowledge event types we do not use so that the gateway stops sending them
+	slog.InfoContext(ctx, "ignoring payment event", "event", event.ID, "type", event.Type)
+	return nil
+}
+
+// progress orders intent statuses, a status only ever moves forward
+var progress = map[string]int{RequiresConfirmation: 0, RequiresCapture: 1, Succeeded: 2, Failed: 2, Canceled: 2}
+
+// apply records the intent's status on its payment and moves the order once the payment settled. Applying the same
+// intent twice, or an older one after a newer, changes nothing, so synchronous results and webhooks can both be applied.
+func (s *Service) apply(ctx context.Context, intent Intent) (db.Payment, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		payment, err := s.Payment(ctx, intent.PaymentID)
+		if err != nil {
+			return db.Payment{}, err
+		}
+		if payment.IntentID != intent.ID {
+			return db.Payment{}, apperrors.BadRequest("intent_mismatch", fmt.Sprintf("Payment %s does not belong to intent %s.", payment.ID, intent.ID))
+		}
+		if progress[intent.Status] <= progress[payment.Status] {
+			return payment, nil
+		}
+
+		from := payment.Status
+		payment.Status = intent.Status
+		payment.FailureReason = intent.FailureReason
+		payment.UpdatedAt = time.Now().UnixMilli()
+		err = s.store.UpdatePaymentStatus(ctx, from, payment)
+		if errors.Is(err, db.ErrPaymentChanged) {
+			continue
+		}
+		if err != nil {
+			return db.Payment{}, err
+		}
+
+		change := db.StatusChange{By: "gateway:" + payment.Gateway, Role: services.RoleSystem, Reason: fmt.Sprintf("payment %s", payment.ID), At: payment.UpdatedAt}
+		switch payment.Status {
+		case Succeeded:
+			change.To = orderstatus.Paid
+			s.moveOrder(ctx, payment.OrderID, change)
+		case Failed:
+			change.To = orderstatus.Failed
+			change.Reason += " " + payment.FailureReason
+			s.moveOrder(ctx, payment.OrderID, change)
+		}
+		return payment, nil
+	}
+	return db.Payment{}, apperrors.Conflict("payment_contention", "The payment is being changed by other requests, try again.")
+}
+
+// settleRefund records the final status of a refund, a failed refund gives its amount back to the payment. The
+// order moves to refunded once the gateway confirmed refunds of the whole payment.
+func (s *Service) settleRefund(ctx context.Context, result RefundResult) error {
+	refund, err := s.store.Refund(ctx, result.RefundID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	payment, changed, err := s.store.SettleRefund(ctx, refund, result.ID, result.Status, now)
+	if err != nil || !changed || result.Status != RefundSucceeded || payment.RefundSettled != payment.Amount {
+		return err
+	}
+	s.moveOrder(ctx, payment.OrderID, db.StatusChange{
+		To:     orderstatus.Refunded,
+		By:     "gateway:" + payment.Gateway,
+		Role:   services.RoleSystem,
+		Reason: fmt.Sprintf("refund %s", refund.ID),
+		At:     now,
+	})
+	return nil
+}
+
+// moveOrder follows a settled payment or refund. The money has moved whatever state the order is in, so an order
+// that cannot make the transition (already paid, or canceled meanwhile) is logged for staff instead of failing.
+func (s *Service) moveOrder(ctx context.Context, orderID string, change db.StatusChange) {
+	_, err := s.orders.Transition(ctx, orderID, change)
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) && appErr.Code == "invalid_transition" {
+		slog.WarnContext(ctx, "payment settled for an order that cannot follow it", "order", orderID, "status", change.To, "reason", change.Reason, "error", err)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to move order after payment", "order", orderID, "status", change.To, "error", err)
+	}
+}
@@ -0,0 +1,351 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"upgraded-telegram/main.go/server/services"
+	"upgraded-telegram/main.go/server/services/checkout"
+	"upgraded-telegram/main.go/server/services/config"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+	"upgraded-telegram/main.go/server/services/orderstatus"
+)
+
+type delivery struct {
+	body      []byte
+	signature string
+}
+
+// newService returns a service on memory stores whose fake gateway hands its webhooks to the returned channel.
+func newService(t *testing.T) (*Service, *checkout.MemoryStore, chan delivery) {
+	t.Helper()
+	orders := checkout.NewMemoryStore()
+	events := services.NewMemoryIdempotencyStore()
+	t.Cleanup(events.Stop)
+	sent := make(chan delivery, 64)
+	gateway := NewFakeGateway(secret, func(ctx context.Context, body []byte, signature string) error {
+		sent <- delivery{body: body, signature: signature}
+		return nil
+	})
+	service := NewService(gateway, NewMemoryStore(orders), orders, events, config.Payments{WebhookSecret: secret, WebhookTolerance: 5 * time.Minute})
+	return service, orders, sent
+}
+
+// paid places an order of total and pays it.
+func paid(t *testing.T, service *Service, orders *checkout.MemoryStore, total int64) db.Payment {
+	t.Helper()
+	ctx := context.Background()
+	order := db.Order{ID: "o1", User: "user-1", Status: orderstatus.Pending, Total: money.New(total, "USD")}
+	err := orders.PlaceOrder(ctx, order, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, _, err := service.CreatePayment(ctx, order, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment, err = service.Confirm(ctx, payment, "pm_card_visa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != Succeeded {
+		t.Fatalf("payment is %s", payment.Status)
+	}
+	return payment
+}
+
+func next(t *testing.T, sent chan delivery) delivery {
+	t.Helper()
+	select {
+	case d := <-sent:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook was sent")
+	}
+	return delivery{}
+}
+
+func signed(t *testing.T, event Event) delivery {
+	t.Helper()
+	body, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery{body: body, signature: Sign(secret, body, time.Now())}
+}
+
+// handle hands the next n webhooks the gateway sent to service.
+func handle(t *testing.T, service *Service, sent chan delivery, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		d := next(t, sent)
+		err := service.HandleWebhook(context.Background(), d.body, d.signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func status(t *testing.T, orders *checkout.MemoryStore) string {
+	t.Helper()
+	order, ok := orders.Order("o1")
+	if !ok {
+		t.Fatal("order o1 is gone")
+	}
+	return order.Status
+}
+
+func refunded(t *testing.T, service *Service, id string) int64 {
+	t.Helper()
+	payment, err := service.Payment(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payment.Refunded
+}
+
+func TestReplayedWebhooksAreIgnored(t *testing.T) {
+	service, orders, sent := newService(t)
+	paid(t, service, orders, 1000)
+	ctx := context.Background()
+
+	d := next(t, sent)
+	err := service.HandleWebhook(ctx, d.body, d.signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event Event
+	err = json.Unmarshal(d.body, &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// signed again, as a retry of the gateway would be
+	err = service.HandleWebhook(ctx, d.body, Sign(secret, d.body, time.Now()))
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	record, claimed, err := service.events.Begin(ctx, "payment-event|"+event.ID, services.IdempotencyRecord{Fingerprint: event.ID}, time.Now())
+	if err != nil || claimed || record.Status != 200 {
+		t.Fatalf("event record %+v, claimed %t, %v", record, claimed, err)
+	}
+
+	// an old signature is not accepted again at all
+	stale := Sign(secret, d.body, time.Now().Add(-time.Hour))
+	err = service.HandleWebhook(ctx, d.body, stale)
+	if code(err) != "stale_webhook" {
+		t.Fatalf("got %v, want stale_webhook", err)
+	}
+}
+
+func TestAWebhookBeingHandledIsNotHandledTwice(t *testing.T) {
+	service, _, _ := newService(t)
+	ctx := context.Background()
+	d := signed(t, Event{ID: "evt_1", Type: "customer.created"})
+
+	now := time.Now()
+	_, claimed, err := service.events.Begin(ctx, "payment-event|evt_1", services.IdempotencyRecord{Fingerprint: "evt_1", LockedUntil: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}, now)
+	if err != nil || !claimed {
+		t.Fatalf("claimed %t, %v", claimed, err)
+	}
+	err = service.HandleWebhook(ctx, d.body, d.signature)
+	if code(err) != "event_in_progress" {
+		t.Fatalf("got %v, want event_in_progress", err)
+	}
+}
+
+func TestPartialRefunds(t *testing.T) {
+	service, orders, sent := newService(t)
+	payment := paid(t, service, orders, 1000)
+	ctx := context.Background()
+	admin := db.StatusChange{By: "admin-1", Role: services.RoleAdmin}
+
+	refund, err := service.Refund(ctx, payment, 300, "damaged", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Amount != 300 || refund.Status != RefundPending || refunded(t, service, payment.ID) != 300 {
+		t.Fatalf("got %+v and %d refunded", refund, refunded(t, service, payment.ID))
+	}
+	// the payment's and the refund's webhooks
+	handle(t, service, sent, 2)
+	if status(t, orders) != orderstatus.Paid {
+		t.Fatalf("order is %s after a partial refund, want paid", status(t, orders))
+	}
+
+	// no amount refunds the rest
+	payment, _ = service.Payment(ctx, payment.ID)
+	refund, err = service.Refund(ctx, payment, 0, "", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.Amount != 700 || refunded(t, service, payment.ID) != 1000 {
+		t.Fatalf("got %+v and %d refunded", refund, refunded(t, service, payment.ID))
+	}
+	// pending at the gateway is not refunded yet
+	if status(t, orders) != orderstatus.Paid {
+		t.Fatalf("order is %s before the refund settled, want paid", status(t, orders))
+	}
+	handle(t, service, sent, 1)
+	if status(t, orders) != orderstatus.Refunded {
+		t.Fatalf("order is %s, want refunded", status(t, orders))
+	}
+}
+
+func TestOverRefunds(t *testing.T) {
+	service, orders, _ := newService(t)
+	payment := paid(t, service, orders, 1000)
+	ctx := context.Background()
+	admin := db.StatusChange{By: "admin-1", Role: services.RoleAdmin}
+
+	for _, amount := range []int64{1001, -1} {
+		_, err := service.Refund(ctx, payment, amount, "", admin)
+		if code(err) != "refund_too_large" {
+			t.Fatalf("%d: got %v, want refund_too_large", amount, err)
+		}
+	}
+
+	_, err := service.Refund(ctx, payment, 600, "", admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// payment was read before the first refund, the stored total still stops the second
+	_, err = service.Refund(ctx, payment, 600, "", admin)
+	if code(err) != "refund_too_large" {
+		t.Fatalf("got %v, want refund_too_large", err)
+	}
+	if refunded(t, service, payment.ID) != 600 {
+		t.Fatalf("%d refunded, want 600", refunded(t, service, payment.ID))
+	}
+}
+
+func TestConcurrentRefundsDoNotAddUpToMoreThanWasPaid(t *testing.T) {
+	service, orders, sent := newService(t)
+	payment := paid(t, service, orders, 1000)
+
+	var mu sync.Mutex
+	succeeded := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.Refund(context.Background(), payment, 200, fmt.Sprintf("refund %d", i), db.StatusChange{By: "admin-1", Role: services.RoleAdmin})
+			switch code(err) {
+			case "":
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			case "refund_too_large":
+			default:
+				t.Errorf("refund %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != 5 || refunded(t, service, payment.ID) != 1000 {
+		t.Fatalf("%d refunds and %d refunded, want 5 and 1000", succeeded, refunded(t, service, payment.ID))
+	}
+	handle(t, service, sent, 1+succeeded)
+	order, _ := orders.Order("o1")
+	if order.Status != orderstatus.Refunded || order.History[len(order.History)-1].Role != services.RoleSystem {
+		t.Fatalf("order is %s, want refunded by the gateway: %+v", order.Status, order.History)
+	}
+}
+
+func TestAFailedRefundGivesItsAmountBack(t *testing.T) {
+	service, orders, _ := newService(t)
+	payment := paid(t, service, orders, 1000)
+	ctx := context.Background()
+
+	refund, err := service.Refund(ctx, payment, 400, "", db.StatusChange{By: "admin-1", Role: services.RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := signed(t, Event{ID: "evt_failed", Type: EventRefundFailed, Refund: &RefundResult{ID: "re_1", RefundID: refund.ID, Amount: 400, Status: RefundFailed}})
+	for i := 0; i < 2; i++ {
+		err = service.HandleWebhook(ctx, d.body, d.signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refunded(t, service, payment.ID) != 0 {
+		t.Fatalf("%d refunded, want 0", refunded(t, service, payment.ID))
+	}
+	if status(t, orders) != orderstatus.Paid {
+		t.Fatalf("order is %s, want paid", status(t, orders))
+	}
+}
+
+func TestAnOrderHasOnePaymentInProgress(t *testing.T) {
+	service, orders, _ := newService(t)
+	ctx := context.Background()
+	placed := db.Order{ID: "o1", User: "user-1", Status: orderstatus.Pending, Total: money.New(1000, "USD")}
+	err := orders.PlaceOrder(ctx, placed, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the order as it was read before any payment, by many requests at once
+	var mu sync.Mutex
+	var created []db.Payment
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			payment, _, err := service.CreatePayment(ctx, placed, false)
+			switch code(err) {
+			case "":
+				mu.Lock()
+				created = append(created, payment)
+				mu.Unlock()
+			case "payment_in_progress":
+			default:
+				t.Errorf("payment %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(created) != 1 {
+		t.Fatalf("%d payments created, want 1", len(created))
+	}
+
+	order, err := service.Order(ctx, "o1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.PaymentID != created[0].ID {
+		t.Fatalf("order has payment %q, want %s", order.PaymentID, created[0].ID)
+	}
+	_, _, err = service.CreatePayment(ctx, order, false)
+	if code(err) != "payment_in_progress" {
+		t.Fatalf("got %v, want payment_in_progress", err)
+	}
+
+	// once it failed the order can be paid again
+	_, err = service.Confirm(ctx, created[0], DeclinedPaymentMethod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, _ = service.Order(ctx, "o1")
+	retry, _, err := service.CreatePayment(ctx, order, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = service.Confirm(ctx, retry, "pm_card_visa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order, _ = service.Order(ctx, "o1")
+	_, _, err = service.CreatePayment(ctx, order, false)
+	if code(err) != "order_not_payable" {
+		t.Fatalf("paid order: got %v, want order_not_payable", err)
+	}
+}
@@ -0,0 +1,34 @@
+package payments
+
+import (
+	"context"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+// Store keeps payments and their refunds. A payment's refunded total counts every refund that has not failed,
+// reserving each refund against it is what keeps concurrent refunds from adding up to more than was paid.
+type Store interface {
+	Order(ctx context.Context, id string) (db.Order, error)
+	Payment(ctx context.Context, id string) (db.Payment, error)
+
+	// CreatePayment stores payment as the payment of its order if the order is still pending or failed and its
+	// payment is still previous, db.ErrPaymentInProgress otherwise.
+	CreatePayment(ctx context.Context, payment db.Payment, previous string) error
+
+	// UpdatePaymentStatus writes payment's status and failure reason if the stored status is still from,
+	// db.ErrPaymentChanged otherwise.
+	UpdatePaymentStatus(ctx context.Context, from string, payment db.Payment) error
+
+	// CreateRefund adds refund.Amount to the refunded total of a payment of amount and stores the refund,
+	// db.ErrRefundTooLarge when the total would pass amount.
+	CreateRefund(ctx context.Context, refund db.Refund, amount int64) error
+
+	Refund(ctx context.Context, id string) (db.Refund, error)
+	SetRefundGatewayID(ctx context.Context, id, gatewayRefundID string) error
+
+	// SettleRefund moves a pending refund to status together with its payment's totals: a succeeded refund is
+	// added to RefundSettled, a failed one taken off Refunded. It returns the payment as read right after and
+	// whether the refund was still pending.
+	SettleRefund(ctx context.Context, refund db.Refund, gatewayRefundID, status string, now int64) (db.Payment, bool, error)
+}
@@ -0,0 +1,67 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+)
+
+// SignatureHeader carries "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">". The timestamp is signed with
+// the body, so an old webhook cannot be replayed with a new timestamp.
+const SignatureHeader = "Payment-Signature"
+
+// Sign returns the SignatureHeader value for body sent at.
+func Sign(secret string, body []byte, at time.Time) string {
+	t := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + t + ",v1=" + signature(secret, t, body)
+}
+
+// Verify checks the signature of body and that it was signed within tolerance of now.
+// Several v1 entries are accepted so that a secret can be rotated.
+func Verify(secret string, body []byte, header string, now time.Time, tolerance time.Duration) error {
+	var t string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return apperrors.BadRequest("invalid_signature_header", fmt.Sprintf("%s must look like t=<timestamp>,v1=<signature>.", SignatureHeader))
+	}
+
+	expected := signature(secret, t, body)
+	valid := false
+	for _, candidate := range signatures {
+		if hmac.Equal([]byte(candidate), []byte(expected)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return apperrors.BadRequest("invalid_signature", "The webhook signature does not match.")
+	}
+
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > tolerance || age < -tolerance {
+		return apperrors.BadRequest("stale_webhook", "The webhook timestamp is outside the allowed tolerance.")
+	}
+	return nil
+}
+
+func signature(secret, t string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+)
+
+const secret = "webhook-secret"
+
+func code(err error) string {
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) {
+		return appErr.Code
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id": "evt_1", "type": "payment.succeeded"}`)
+	now := time.Unix(1_700_000_000, 0)
+	signed := Sign(secret, body, now)
+	_, v1, _ := strings.Cut(signed, ",")
+
+	tests := []struct {
+		name   string
+		body   string
+		header string
+		want   string
+	}{
+		{name: "valid", header: signed},
+		{name: "rotated secret", header: Sign("old-secret", body, now) + "," + v1},
+		{name: "other secret", header: Sign("old-secret", body, now), want: "invalid_signature"},
+		{name: "changed body", body: `{"id": "evt_1", "type": "refund.succeeded"}`, header: signed, want: "invalid_signature"},
+		{name: "new timestamp on an old signature", header: "t=1700000060," + v1, want: "invalid_signature"},
+		{name: "no timestamp", header: v1, want: "invalid_signature_header"},
+		{name: "no signature", header: "t=1700000000", want: "invalid_signature_header"},
+		{name: "empty", header: "", want: "invalid_signature_header"},
+		{name: "at the tolerance", header: Sign(secret, body, now.Add(-5*time.Minute))},
+		{name: "too old", header: Sign(secret, body, now.Add(-5*time.Minute-time.Second)), want: "stale_webhook"},
+		{name: "from the future", header: Sign(secret, body, now.Add(5*time.Minute+time.Second)), want: "stale_webhook"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := body
+			if tt.body != "" {
+				b = []byte(tt.body)
+			}
+			err := Verify(secret, b, tt.header, now, 5*time.Minute)
+			if code(err) != tt.want {
+				t.Fatalf("got %q, want %q", code(err), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package pricing
+
+import (
+	"fmt"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// Amounts are integer minor units of a currency, see the money package. Orders never trust amounts sent by the
+// client: every line is priced from the item as it is stored when the order is placed, in the order's currency.
+
+// Breakdown is the server-computed cost of an order, every amount in Currency.
+type Breakdown struct {
+	Currency      string
+	Lines         []db.LineItem
+	Subtotal      money.Money
+	Discount      money.Money
+	Tax           money.Money
+	Total         money.Money
+	ExchangeRates map[string]string
+	TaxBreakdown  *db.TaxBreakdown
+}
+
+// Apply copies the breakdown onto an order.
+func (b Breakdown) Apply(order *db.Order) {
+	order.Currency = b.Currency
+	order.Items = b.Lines
+	order.Subtotal = b.Subtotal
+	order.Discount = b.Discount
+	order.Tax = b.Tax
+	order.Total = b.Total
+	order.ExchangeRates = b.ExchangeRates
+	order.TaxBreakdown = b.TaxBreakdown
+}
+
+// WithDiscount takes amount, at most the subtotal, off the order and totals it again.
+func (b *Breakdown) WithDiscount(amount money.Money) {
+	b.Discount = money.New(min(max(amount.Amount, 0), b.Subtotal.Amount), b.Currency)
+	b.total()
+}
+
+// WithTax records the tax of the discounted order, apply the discount first. Tax included in the prices is only
+// reported, other tax is added to the total.
+func (b *Breakdown) WithTax(breakdown db.TaxBreakdown, tax money.Money) {
+	b.TaxBreakdown = &breakdown
+	b.Tax = tax
+	b.total()
+}
+
+func (b *Breakdown) total() {
+	total := b.Subtotal.Amount - b.Discount.Amount
+	if b.TaxBreakdown == nil || !b.TaxBreakdown.Inclusive {
+		total += b.Tax.Amount
+	}
+	b.Total = money.New(total, b.Currency)
+}
+
+// ItemIDs lists the distinct items referenced by lines, in order of first appearance.
+func ItemIDs(lines []db.LineItem) []string {
+	seen := map[string]bool{}
+	var ids []string
+	for _, line := range lines {
+		if !seen[line.ItemID] {
+			seen[line.ItemID] = true
+			ids = append(ids, line.ItemID)
+		}
+	}
+	return ids
+}
+
+// Price snapshots the current name and price of every item into its line and totals the order in currency.
+// An item without an explicit price in currency has its base price converted with rates, rounded to whole minor
+// units per unit so that the unit price times the quantity is the line subtotal. A line without a SKU orders the
+// item's default variant, lines for the same variant are merged. Unknown and inactive items, unknown variants and
+// items that cannot be priced in currency are reported as field errors.
+func Price(lines []db.LineItem, items map[string]db.Item, currency string, rates money.Rates) (Breakdown, error) {
+	breakdown := Breakdown{Currency: currency, Subtotal: money.New(0, currency), Discount: money.New(0, currency), Tax: money.New(0, currency)}
+	var fields []apperrors.FieldError
+	index := map[string]int{}
+
+	for i, line := range lines {
+		field := fmt.Sprintf("items[%d].item_id", i)
+		item, ok := items[line.ItemID]
+		switch {
+		case !ok:
+			fields = append(fields, apperrors.FieldError{Field: field, Code: "item_not_found", Message: "does not exist"})
+			continue
+		case !item.Active:
+			fields = append(fields, apperrors.FieldError{Field: field, Code: "item_inactive", Message: "is not available for sale"})
+			continue
+		}
+
+		sku, variant, ok := item.Variant(line.SKU)
+		if !ok {
+			fields = append(fields, apperrors.FieldError{Field: fmt.Sprintf("items[%d].sku", i), Code: "variant_not_found", Message: fmt.Sprintf("item %s has no variant %s", item.ID, line.SKU)})
+			continue
+		}
+		key := item.ID + "|" + sku
+		if j, ok := index[key]; ok {
+			breakdown.Lines[j].Quantity += line.Quantity
+			continue
+		}
+
+		list := variant.PriceIn(currency)
+		unit, rate, err := rates.Convert(list, currency)
+		if err != nil {
+			fields = append(fields, apperrors.FieldError{Field: field, Code: "currency_unavailable", Message: fmt.Sprintf("cannot be priced in %s", currency)})
+			continue
+		}
+		if list.Currency != currency {
+			if breakdown.ExchangeRates == nil {
+				breakdown.ExchangeRates = map[string]string{}
+			}
+			breakdown.ExchangeRates[list.Currency] = rate
+		}
+
+		index[key] = len(breakdown.Lines)
+		breakdown.Lines = append(breakdown.Lines, db.LineItem{
+			ItemID:      item.ID,
+			SKU:         sku,
+			Name:        item.Name,
+			Attributes:  variant.Attributes,
+			Quantity:    line.Quantity,
+			ListPrice:   list,
+			UnitPrice:   unit,
+			TaxCategory: item.TaxCategory,
+		})
+	}
+	if len(fields) > 0 {
+		return Breakdown{}, apperrors.InvalidFields(fields)
+	}
+
+	for i := range breakdown.Lines {
+		line := &breakdown.Lines[i]
+		subtotal, err := line.UnitPrice.Mul(int64(line.Quantity))
+		if err == nil {
+			line.Subtotal = subtotal
+			breakdown.Subtotal, err = breakdown.Subtotal.Add(subtotal)
+		}
+		if err != nil {
+			return Breakdown{}, apperrors.Validation("total_too_large", "The order total is too large.")
+		}
+	}
+
+	// discounts are applied with WithDiscount once the code is known, then tax with WithTax
+	breakdown.total()
+	return breakdown, nil
+}
@@ -0,0 +1,143 @@
+// Package promotions evaluates discount codes against an order. Evaluating only decides the discount; the
+// redemption itself is written by the checkout store in the same transaction as the order, which re-checks the
+// usage limits so that they hold under concurrent checkouts.
+package promotions
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// code types
+const (
+	Percent = "percent"
+	Fixed   = "fixed"
+)
+
+// reasons a code does not apply, reported on the order's discount_code field
+const (
+	CodeNotFound     = "code_not_found"
+	CodeInactive     = "code_inactive"
+	CodeNotStarted   = "code_not_started"
+	CodeExpired      = "code_expired"
+	CodeExhausted    = "code_exhausted"
+	CodeLimitReached = "code_limit_reached"
+	CodeChanged      = "code_changed"
+	MinTotalNotMet   = "min_total_not_met"
+	NoEligibleItems  = "no_eligible_items"
+	CurrencyMismatch = "code_currency_mismatch"
+)
+
+// Normalize makes codes case-insensitive: they are stored and looked up upper case.
+func Normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// CheckRules validates what the validate tags cannot: the characters of the code and the range of Value.
+func CheckRules(code db.DiscountCode) error {
+	var fields []apperrors.FieldError
+	for _, r := range code.Code {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			fields = append(fields, apperrors.FieldError{Field: "code", Code: "invalid_code", Message: "may only contain letters, digits, - and _"})
+			break
+		}
+	}
+	if code.Type == Percent && code.Value > 100 {
+		fields = append(fields, apperrors.FieldError{Field: "value", Code: "too_large", Message: "must be at most 100 for percent codes"})
+	}
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+// Unavailable reports why a code cannot be redeemed at now, ignoring the per-user limit. It is shared with the
+// checkout stores, which explain a failed redemption with it.
+func Unavailable(code db.DiscountCode, now int64) string {
+	switch {
+	case !code.Active:
+		return CodeInactive
+	case code.StartsAt > now:
+		return CodeNotStarted
+	case code.EndsAt != 0 && code.EndsAt <= now:
+		return CodeExpired
+	case code.MaxRedemptions != 0 && code.Redemptions >= code.MaxRedemptions:
+		return CodeExhausted
+	}
+	return ""
+}
+
+// Evaluate returns the discount code gives on the priced lines of an order with subtotal, for a user who already
+// redeemed it used times. The discount is in the order's currency and never exceeds the eligible amount. Codes in
+// another currency than the order's are rejected rather than converted.
+func Evaluate(code db.DiscountCode, lines []db.LineItem, subtotal money.Money, used int, now int64) (money.Money, error) {
+	none := money.New(0, subtotal.Currency)
+	if reason := Unavailable(code, now); reason != "" {
+		return none, Rejected(reason, code.Code)
+	}
+	if code.MaxPerUser != 0 && used >= code.MaxPerUser {
+		return none, Rejected(CodeLimitReached, code.Code)
+	}
+	if currency := Currency(code); currency != "" && currency != subtotal.Currency {
+		return none, Rejected(CurrencyMismatch, code.Code)
+	}
+	if subtotal.Amount < code.MinTotal {
+		return none, Rejected(MinTotalNotMet, code.Code)
+	}
+
+	eligible := subtotal.Amount
+	if len(code.ItemIDs) > 0 {
+		eligible = 0
+		for _, line := range lines {
+			if slices.Contains(code.ItemIDs, line.ItemID) {
+				eligible += line.Subtotal.Amount
+			}
+		}
+		if eligible == 0 {
+			return none, Rejected(NoEligibleItems, code.Code)
+		}
+	}
+
+	if code.Type == Percent {
+		// rounded down to whole minor units, split so that large amounts cannot overflow
+		return money.New(eligible/100*code.Value+eligible%100*code.Value/100, subtotal.Currency), nil
+	}
+	return money.New(min(code.Value, eligible), subtotal.Currency), nil
+}
+
+// Currency returns the currency code applies in, empty for a percent code without a minimum that applies in any.
+// Amounts of codes that do not name a currency are in the default one.
+func Currency(code db.DiscountCode) string {
+	if code.Currency == "" && (code.Type == Fixed || code.MinTotal > 0) {
+		return money.Default
+	}
+	return code.Currency
+}
+
+var messages = map[string]string{
+	CodeNotFound:     "does not exist",
+	CodeInactive:     "is not active",
+	CodeNotStarted:   "is not valid yet",
+	CodeExpired:      "has expired",
+	CodeExhausted:    "has been used up",
+	CodeLimitReached: "has already been used the maximum number of times by you",
+	CodeChanged:      "was changed while the order was placed, try again",
+	MinTotalNotMet:   "requires a larger order",
+	NoEligibleItems:  "does not apply to any item in the order",
+	CurrencyMismatch: "does not apply to orders in this currency",
+}
+
+// Rejected is the error for a code that does not apply to the order.
+func Rejected(reason, code string) error {
+	return apperrors.InvalidFields([]apperrors.FieldError{Field(reason, code)})
+}
+
+// Field describes reason on the order's discount_code field.
+func Field(reason, code string) apperrors.FieldError {
+	return apperrors.FieldError{Field: "discount_code", Code: reason, Message: fmt.Sprintf("code %s %s", code, messages[reason])}
+}
@@ -0,0 +1,101 @@
+package promotions
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+func line(item string, amount int64, currency string) db.LineItem {
+	return db.LineItem{ItemID: item, Quantity: 1, Subtotal: money.New(amount, currency)}
+}
+
+// reason returns the code a rejection reports on discount_code, empty when err is nil.
+func reason(t *testing.T, err error) string {
+	t.Helper()
+	if err == nil {
+		return ""
+	}
+	var appErr *apperrors.Error
+	if !errors.As(err, &appErr) || len(appErr.Fields) != 1 || appErr.Fields[0].Field != "discount_code" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	return appErr.Fields[0].Code
+}
+
+func TestEvaluate(t *testing.T) {
+	percent := func(value int64) db.DiscountCode {
+		return db.DiscountCode{Code: "SAVE", Type: Percent, Value: value, Active: true}
+	}
+	fixed := func(value int64, currency string) db.DiscountCode {
+		return db.DiscountCode{Code: "OFF", Type: Fixed, Value: value, Currency: currency, Active: true}
+	}
+	scoped := func(code db.DiscountCode, items ...string) db.DiscountCode {
+		code.ItemIDs = items
+		return code
+	}
+	mug, shirt := line("mug", 999, "USD"), line("shirt", 2501, "USD")
+
+	tests := []struct {
+		name     string
+		code     db.DiscountCode
+		lines    []db.LineItem
+		subtotal money.Money
+		used     int
+		want     int64
+		reason   string
+	}{
+		// percentages round down to whole minor units
+		{name: "percent", code: percent(15), lines: []db.LineItem{mug}, subtotal: money.New(999, "USD"), want: 149},
+		{name: "percent of one cent", code: percent(50), lines: []db.LineItem{line("gum", 1, "USD")}, subtotal: money.New(1, "USD"), want: 0},
+		{name: "percent without decimals", code: percent(33), lines: []db.LineItem{line("tea", 100, "JPY")}, subtotal: money.New(100, "JPY"), want: 33},
+		{name: "hundred percent", code: percent(100), lines: []db.LineItem{mug}, subtotal: money.New(999, "USD"), want: 999},
+		{name: "percent of a huge total", code: percent(50), lines: []db.LineItem{line("yacht", math.MaxInt64, "USD")}, subtotal: money.New(math.MaxInt64, "USD"), want: math.MaxInt64 / 2},
+
+		// codes for some items only discount those
+		{name: "percent of one item", code: scoped(percent(10), "shirt"), lines: []db.LineItem{mug, shirt}, subtotal: money.New(3500, "USD"), want: 250},
+		{name: "fixed up to the item", code: scoped(fixed(5000, "USD"), "mug"), lines: []db.LineItem{mug, shirt}, subtotal: money.New(3500, "USD"), want: 999},
+		{name: "no item in the order", code: scoped(percent(10), "hat"), lines: []db.LineItem{mug, shirt}, subtotal: money.New(3500, "USD"), reason: NoEligibleItems},
+
+		// codes in one currency are not converted
+		{name: "fixed in the order's currency", code: fixed(500, "EUR"), lines: []db.LineItem{line("mug", 900, "EUR")}, subtotal: money.New(900, "EUR"), want: 500},
+		{name: "fixed in another currency", code: fixed(500, "EUR"), lines: []db.LineItem{mug}, subtotal: money.New(999, "USD"), reason: CurrencyMismatch},
+		{name: "fixed without currency is the default", code: fixed(500, ""), lines: []db.LineItem{line("mug", 900, "EUR")}, subtotal: money.New(900, "EUR"), reason: CurrencyMismatch},
+		{name: "percent in any currency", code: percent(10), lines: []db.LineItem{line("mug", 900, "EUR")}, subtotal: money.New(900, "EUR"), want: 90},
+		{name: "percent with a minimum in another currency", code: db.DiscountCode{Code: "SAVE", Type: Percent, Value: 10, MinTotal: 500, Active: true}, lines: []db.LineItem{line("mug", 900, "EUR")}, subtotal: money.New(900, "EUR"), reason: CurrencyMismatch},
+
+		// limits
+		{name: "fixed larger than the order", code: fixed(5000, "USD"), lines: []db.LineItem{mug}, subtotal: money.New(999, "USD"), want: 999},
+		{name: "below the minimum", code: db.DiscountCode{Code: "BIG", Type: Fixed, Value: 500, MinTotal: 1000, Active: true}, lines: []db.LineItem{mug}, subtotal: money.New(999, "USD"), reason: MinTotalNotMet},
+		{name: "used up by the user", code: db.DiscountCode{Code: "ONCE", Type: Percent, Value: 10, MaxPerUser: 1, Active: true}, lines: []db.LineItem{mug}, subtotal: money.New(999, "USD"), used: 1, reason: CodeLimitReached},
+		{name: "used up", code: db.DiscountCode{Code: "FEW", Type: Percent, Value: 10, MaxRedemptions: 3, Redemptions: 3, Active: true}, lines: []db.LineItem{mug}, subtotal: money.New(999, "USD"), reason: CodeExhausted},
+		{name: "inactive", code: db.DiscountCode{Code: "OFF", Type: Percent, Value: 10}, lines: []db.LineItem{mug}, subtotal: money.New(999, "USD"), reason: CodeInactive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			discount, err := Evaluate(tt.code, tt.lines, tt.subtotal, tt.used, 1_000)
+			if got := reason(t, err); got != tt.reason {
+				t.Fatalf("got %q, want %q", got, tt.reason)
+			}
+			if tt.reason != "" {
+				return
+			}
+			if discount != money.New(tt.want, tt.subtotal.Currency) {
+				t.Fatalf("discount is %v, want %d %s", discount, tt.want, tt.subtotal.Currency)
+			}
+		})
+	}
+}
+
+func TestUnavailableAtTheEdgesOfItsWindow(t *testing.T) {
+	code := db.DiscountCode{Code: "SALE", Type: Percent, Value: 10, Active: true, StartsAt: 1_000, EndsAt: 2_000}
+	for now, want := range map[int64]string{999: CodeNotStarted, 1_000: "", 1_999: "", 2_000: CodeExpired} {
+		if got := Unavailable(code, now); got != want {
+			t.Errorf("at %d: got %q, want %q", now, got, want)
+		}
+	}
+}
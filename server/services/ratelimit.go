@@ -0,0 +1,148 @@
+package services
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/metrics"
+
+	"golang.org/x/time/rate"
+)
+
+// Policy allows each client Rate requests per second with bursts of up to Burst.
+// A zero Rate means the route is not limited.
+type Policy struct {
+	Name  string
+	Rate  rate.Limit
+	Burst int
+}
+
+// Unlimited is used for probes and metrics scrapes, which must never be rejected.
+var Unlimited = Policy{Name: "unlimited"}
+
+type RateLimiter struct {
+	store          RateLimitStore
+	read           Policy
+	write          Policy
+	routes         map[string]Policy
+	trustedProxies []netip.Prefix
+}
+
+// NewRateLimiter applies read to GET and HEAD requests and write to everything else unless a route
+// has its own policy. X-Forwarded-For is only trusted when the connection comes from trustedProxies.
+func NewRateLimiter(store RateLimitStore, read, write Policy, trustedProxies []netip.Prefix) *RateLimiter {
+	return &RateLimiter{
+		store:          store,
+		read:           read,
+		write:          write,
+		routes:         make(map[string]Policy),
+		trustedProxies: trustedProxies,
+	}
+}
+
+// SetRoutePolicy overrides the policy for a registered ServeMux pattern.
+func (rl *RateLimiter) SetRoutePolicy(pattern string, policy Policy) {
+	rl.routes[pattern] = policy
+}
+
+func (rl *RateLimiter) policyFor(mux *http.ServeMux, r *http.Request) Policy {
+	if policy, ok := rl.routes[routePattern(mux, r)]; ok {
+		return policy
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return rl.read
+	}
+	return rl.write
+}
+
+// clientKey prefers the authenticated user so that users behind one NAT do not share a budget.
+// Only a verified token selects the key, rotating junk tokens cannot escape the per-IP limit.
+func (rl *RateLimiter) clientKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		if claims := ParseAccessToken(token); claims != nil && claims.ID != "" {
+			return "user:" + claims.ID
+		}
+	}
+	return "ip:" + rl.ClientIP(r)
+}
+
+// ClientIP is the connection address, or when that is a trusted proxy, the right-most
+// X-Forwarded-For entry that is not itself a trusted proxy.
+func (rl *RateLimiter) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !rl.trusted(addr) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !rl.trusted(hop) {
+			return hop.Unmap().String()
+		}
+	}
+	return host
+}
+
+func (rl *RateLimiter) trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range rl.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitMiddleware checks each request against the policy of the route mux will route it to.
+func (rl *RateLimiter) RateLimitMiddleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := rl.policyFor(mux, r)
+		if policy.Rate == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		decision, err := rl.store.Take(r.Context(), policy.Name+"|"+rl.clientKey(r), policy, time.Now())
+		if err != nil {
+			// fail open, an unavailable store must not take the API down with it
+			slog.WarnContext(r.Context(), "rate limit store unavailable", "policy", policy.Name, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// draft-ietf-httpapi-ratelimit-headers: the quota is the burst, refilled over burst/rate seconds
+		window := math.Ceil(float64(policy.Burst) / float64(policy.Rate))
+		w.Header().Set("RateLimit-Policy", strconv.Itoa(policy.Burst)+";w="+strconv.Itoa(int(window)))
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(policy.Burst))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.ResetAfter)))
+
+		if !decision.Allowed {
+			metrics.RateLimited(policy.Name)
+			w.Header().Set("Retry-After", strconv.Itoa(max(1, ceilSeconds(decision.RetryAfter))))
+			apperrors.Write(w, r, apperrors.TooManyRequests("rate_limited", "Too many requests. Try again later."))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/tracing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Limits use GCRA (the generic cell rate algorithm). The only state per key is the theoretical
+// arrival time (TAT): the moment the key's budget would be completely refilled. A request is allowed
+// when it arrives no earlier than TAT - burst*interval, and then moves TAT forward by one interval.
+// Because the state is a single number, a shared store can update it with one compare-and-set.
+
+// Decision is the outcome of taking one request from a key's budget.
+type Decision struct {
+	Allowed    bool
+	Remaining  int
+	ResetAfter time.Duration // until the budget is full again
+	RetryAfter time.Duration // until the next request would be allowed, zero when allowed
+}
+
+// RateLimitStore keeps GCRA state. Implementations must be safe for concurrent use, including
+// from several server instances when the store is shared.
+type RateLimitStore interface {
+	Take(ctx context.Context, key string, policy Policy, now time.Time) (Decision, error)
+}
+
+// gcra computes the decision and the new TAT for a key whose stored TAT is tat (zero when unseen).
+func gcra(tat time.Time, policy Policy, now time.Time) (Decision, time.Time) {
+	interval := time.Duration(float64(time.Second) / float64(policy.Rate))
+	window := interval * time.Duration(policy.Burst)
+
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(interval)
+	allowAt := newTAT.Add(-window)
+
+	if now.Before(allowAt) {
+		return Decision{
+			Allowed:    false,
+			Remaining:  0,
+			ResetAfter: tat.Sub(now),
+			RetryAfter: allowAt.Sub(now),
+		}, tat
+	}
+	return Decision{
+		Allowed:    true,
+		Remaining:  int(now.Sub(allowAt) / interval),
+		ResetAfter: newTAT.Sub(now),
+	}, newTAT
+}
+
+// MemoryStore keeps state in process memory. It is the default and is only correct for a single instance.
+type MemoryStore struct {
+	mu   sync.Mutex
+	tats map[string]time.Time
+	stop chan struct{}
+}
+
+func NewMemoryStore() *MemoryStore {
+	store := &MemoryStore{
+		tats: make(map[string]time.Time),
+		stop: make(chan struct{}),
+	}
+	go store.janitor(time.Minute)
+	return store
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	decision, tat := gcra(s.tats[key], policy, now)
+	s.tats[key] = tat
+	return decision, nil
+}
+
+// Stop ends the janitor.
+func (s *MemoryStore) Stop() {
+	close(s.stop)
+}
+
+// janitor drops keys whose budget is full again, they behave exactly like unseen keys
+func (s *MemoryStore) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, tat := range s.tats {
+				if tat.Before(now) {
+					delete(s.tats, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// DynamoStore shares state between instances through a DynamoDB table with partition key "id".
+// Enable TTL on the "expires_at" attribute so that idle keys are removed.
+type DynamoStore struct {
+	client dynamoItems
+	table  string
+}
+
+// dynamoItems is the part of the DynamoDB client the store uses, *dynamodb.Client or a local stand-in.
+type dynamoItems interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+func NewDynamoStore(client dynamoItems, table string) *DynamoStore {
+	return &DynamoStore{client: client, table: table}
+}
+
+// writes that lose a race are retried with the TAT written by the winner. A key that still loses after that is
+// being hit from many places at once, so it is denied: failing open would let the busiest client through.
+const dynamoStoreAttempts = 5
+
+func (s *DynamoStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (decision Decision, err error) {
+	ctx, span := tracing.Start(ctx, "ratelimit.Take")
+	defer func() { tracing.End(span, err) }()
+	ctx, cancel := context.WithTimeout(ctx, db.Timeout)
+	defer cancel()
+
+	var tat time.Time
+	for attempt := 0; attempt < dynamoStoreAttempts; attempt++ {
+		out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(s.table),
+			Key:            map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: key}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return Decision{}, err
+		}
+
+		var stored int64
+		if v, ok := out.Item["tat"].(*types.AttributeValueMemberN); ok {
+			stored, _ = strconv.ParseInt(v.Value, 10, 64)
+		}
+		tat = time.Time{}
+		if stored > 0 {
+			tat = time.Unix(0, stored)
+		}
+
+		decision, newTAT := gcra(tat, policy, now)
+		if !decision.Allowed {
+			return decision, nil
+		}
+
+		input := &dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item: map[string]types.AttributeValue{
+				"id":         &types.AttributeValueMemberS{Value: key},
+				"tat":        &types.AttributeValueMemberN{Value: strconv.FormatInt(newTAT.UnixNano(), 10)},
+				"expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(newTAT.Add(time.Minute).Unix(), 10)},
+			},
+		}
+		if stored > 0 {
+			input.ConditionExpression = aws.String("tat = :tat")
+			input.ExpressionAttributeValues = map[string]types.AttributeValue{
+				":tat": &types.AttributeValueMemberN{Value: strconv.FormatInt(stored, 10)},
+			}
+		} else {
+			input.ConditionExpression = aws.String("attribute_not_exists(id)")
+		}
+
+		_, err = s.client.PutItem(ctx, input)
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			continue
+		}
+		if err != nil {
+			return Decision{}, err
+		}
+		return decision, nil
+	}
+	return contended(tat, policy, now), nil
+}
+
+// contended denies a request whose key changed under every attempt, asking it to retry after one interval.
+func contended(tat time.Time, policy Policy, now time.Time) Decision {
+	interval := time.Duration(float64(time.Second) / float64(policy.Rate))
+	return Decision{
+		Allowed:    false,
+		Remaining:  0,
+		ResetAfter: max(tat.Sub(now), interval),
+		RetryAfter: interval,
+	}
+}
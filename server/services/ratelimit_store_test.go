@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// localDynamo is a stand-in for the rate limit table. It evaluates the two conditions DynamoStore writes with, and
+// can be told to lose every conditional write or to fail outright.
+type localDynamo struct {
+	mu      sync.Mutex
+	items   map[string]map[string]types.AttributeValue
+	contend bool
+	down    bool
+}
+
+func newLocalDynamo() *localDynamo {
+	return &localDynamo{items: map[string]map[string]types.AttributeValue{}}
+}
+
+func (d *localDynamo) GetItem(ctx context.Context, in *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return nil, errors.New("connection refused")
+	}
+	id := in.Key["id"].(*types.AttributeValueMemberS).Value
+	return &dynamodb.GetItemOutput{Item: d.items[id]}, nil
+}
+
+func (d *localDynamo) PutItem(ctx context.Context, in *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.down {
+		return nil, errors.New("connection refused")
+	}
+	id := in.Item["id"].(*types.AttributeValueMemberS).Value
+	current, exists := d.items[id]
+	ok := !d.contend
+	switch aws.ToString(in.ConditionExpression) {
+	case "attribute_not_exists(id)":
+		ok = ok && !exists
+	case "tat = :tat":
+		ok = ok && exists && current["tat"].(*types.AttributeValueMemberN).Value == in.ExpressionAttributeValues[":tat"].(*types.AttributeValueMemberN).Value
+	}
+	if !ok {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	d.items[id] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+var testPolicy = Policy{Name: "test", Rate: 1, Burst: 3}
+
+func TestMemoryStoreAllowsBurstThenDenies(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Stop()
+	now := time.Now()
+
+	for i := 0; i < testPolicy.Burst; i++ {
+		decision, _ := store.Take(context.Background(), "k", testPolicy, now)
+		if !decision.Allowed {
+			t.Fatalf("request %d denied within the burst", i+1)
+		}
+	}
+	decision, _ := store.Take(context.Background(), "k", testPolicy, now)
+	if decision.Allowed || decision.RetryAfter != time.Second {
+		t.Fatalf("got %+v, want denied with a 1s retry", decision)
+	}
+	decision, _ = store.Take(context.Background(), "k", testPolicy, now.Add(time.Second))
+	if !decision.Allowed {
+		t.Fatal("denied after the budget refilled")
+	}
+}
+
+func TestDynamoStoreNeverExceedsBurstUnderConcurrency(t *testing.T) {
+	store := NewDynamoStore(newLocalDynamo(), "rate_limits")
+	now := time.Now()
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			decision, err := store.Take(context.Background(), "k", testPolicy, now)
+			if err != nil {
+				t.Error(err)
+			}
+			if decision.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n > int32(testPolicy.Burst) {
+		t.Fatalf("%d requests allowed, burst is %d", n, testPolicy.Burst)
+	}
+}
+
+func TestDynamoStoreDeniesOnContention(t *testing.T) {
+	table := newLocalDynamo()
+	table.contend = true
+	store := NewDynamoStore(table, "rate_limits")
+
+	decision, err := store.Take(context.Background(), "k", testPolicy, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decision.Allowed || decision.RetryAfter <= 0 {
+		t.Fatalf("got %+v, want denied with a retry", decision)
+	}
+}
+
+func TestRateLimitMiddlewareFailsOpenOnlyWhenTheStoreIsDown(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		table   *localDynamo
+		allowed bool
+	}{
+		{"down", &localDynamo{down: true}, true},
+		{"contended", &localDynamo{items: map[string]map[string]types.AttributeValue{}, contend: true}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {})
+			limiter := NewRateLimiter(NewDynamoStore(tc.table, "rate_limits"), testPolicy, testPolicy, nil)
+			handler := limiter.RateLimitMiddleware(mux, mux)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			if got := rec.Code == http.StatusOK; got != tc.allowed {
+				t.Fatalf("status %d, allowed should be %v", rec.Code, tc.allowed)
+			}
+		})
+	}
+}
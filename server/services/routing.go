@@ -0,0 +1,50 @@
+package services
+
+import (
+	"net/http"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+)
+
+// RoutingErrors serves mux and replaces its plain-text 404 and 405 responses with problem documents.
+// The Allow header the mux sets on a 405 is kept.
+func RoutingErrors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if routePattern(mux, r) != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(&routingErrorWriter{ResponseWriter: w, r: r}, r)
+	})
+}
+
+type routingErrorWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	replaced bool
+}
+
+func (rw *routingErrorWriter) WriteHeader(code int) {
+	switch code {
+	case http.StatusNotFound:
+		rw.replaced = true
+		apperrors.Write(rw.ResponseWriter, rw.r, apperrors.NotFound("route_not_found", "No route matches "+rw.r.URL.Path+"."))
+	case http.StatusMethodNotAllowed:
+		rw.replaced = true
+		apperrors.Write(rw.ResponseWriter, rw.r, apperrors.MethodNotAllowed("method_not_allowed", rw.r.Method+" is not allowed on "+rw.r.URL.Path+"."))
+	default:
+		rw.ResponseWriter.WriteHeader(code)
+	}
+}
+
+// Write drops the mux's plain-text body once the problem document has been written.
+func (rw *routingErrorWriter) Write(data []byte) (int, error) {
+	if rw.replaced {
+		return len(data), nil
+	}
+	return rw.ResponseWriter.Write(data)
+}
+
+func (rw *routingErrorWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,151 @@
+// Package search is an embedded full-text index of the catalog. It lives in memory, is built from the items table
+// when the server starts and is kept in sync by the item handlers, with a periodic rebuild to pick up stock
+// changed by orders and writes made by other instances.
+package search
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+// ErrNotReady is returned by searches made before the index was first built.
+var ErrNotReady = errors.New("search index is not built yet")
+
+// ErrRebuilding is returned by Rebuild while another rebuild is running.
+var ErrRebuilding = errors.New("search index is already being rebuilt")
+
+// Loader reads every item to build the index from.
+type Loader func(ctx context.Context) ([]db.Item, error)
+
+// Index holds the searchable items. Changes made while a rebuild reads the table are recorded and applied to the
+// new index before it replaces the old one, so they are not lost to the older copy the rebuild read.
+type Index struct {
+	load    Loader
+	refresh time.Duration
+	stop    chan struct{}
+
+	rebuild sync.Mutex // one rebuild at a time
+
+	mu      sync.RWMutex
+	state   *state
+	journal []change // non-nil while a rebuild runs
+	built   time.Time
+}
+
+type change struct {
+	id   string
+	item *db.Item // nil deletes
+}
+
+// NewIndex builds the index in the background, then again every refresh unless refresh is zero.
+func NewIndex(load Loader, refresh time.Duration) *Index {
+	x := &Index{load: load, refresh: refresh, stop: make(chan struct{}), state: newState()}
+	go x.loop()
+	return x
+}
+
+// Stop ends the periodic rebuilds.
+func (x *Index) Stop() {
+	close(x.stop)
+}
+
+func (x *Index) loop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-x.stop
+		cancel()
+	}()
+
+	var tick <-chan time.Time
+	if x.refresh > 0 {
+		ticker := time.NewTicker(x.refresh)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		n, err := x.Rebuild(ctx)
+		switch {
+		case errors.Is(err, ErrRebuilding), ctx.Err() != nil:
+		case err != nil:
+			slog.WarnContext(ctx, "failed to rebuild the search index, keeping the previous one", "error", err)
+		default:
+			slog.DebugContext(ctx, "search index rebuilt", "items", n)
+		}
+		select {
+		case <-x.stop:
+			return
+		case <-tick:
+		}
+	}
+}
+
+// Rebuild reads every item and replaces the index, returning how many items it holds.
+func (x *Index) Rebuild(ctx context.Context) (int, error) {
+	if !x.rebuild.TryLock() {
+		return 0, ErrRebuilding
+	}
+	defer x.rebuild.Unlock()
+
+	x.mu.Lock()
+	x.journal = []change{}
+	x.mu.Unlock()
+
+	items, err := x.load(ctx)
+	if err != nil {
+		x.mu.Lock()
+		x.journal = nil
+		x.mu.Unlock()
+		return 0, err
+	}
+	fresh := newState()
+	for _, item := range items {
+		fresh.put(item)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, c := range x.journal {
+		if c.item == nil {
+			fresh.delete(c.id)
+		} else {
+			fresh.put(*c.item)
+		}
+	}
+	x.journal = nil
+	x.state = fresh
+	x.built = time.Now()
+	return len(fresh.docs), nil
+}
+
+// Put adds or replaces an item.
+func (x *Index) Put(item db.Item) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.state.put(item)
+	if x.journal != nil {
+		x.journal = append(x.journal, change{id: item.ID, item: &item})
+	}
+}
+
+// Delete removes an item, if it is indexed.
+func (x *Index) Delete(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.state.delete(id)
+	if x.journal != nil {
+		x.journal = append(x.journal, change{id: id})
+	}
+}
+
+// Stats returns how many items the index holds and when it was last rebuilt, zero before it first was.
+func (x *Index) Stats() (int, time.Time) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.state.docs), x.built
+}
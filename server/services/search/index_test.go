@@ -0,0 +1,49 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+func TestRebuildKeepsChangesMadeWhileReading(t *testing.T) {
+	loading, release := make(chan struct{}), make(chan struct{})
+	x := &Index{state: newState(), load: func(ctx context.Context) ([]db.Item, error) {
+		close(loading)
+		<-release
+		// as the table was before the changes below
+		return []db.Item{{ID: "1", Name: "Mug"}, {ID: "2", Name: "Old Mug"}}, nil
+	}}
+
+	done := make(chan error)
+	go func() {
+		_, err := x.Rebuild(context.Background())
+		done <- err
+	}()
+	<-loading
+	if _, err := x.Rebuild(context.Background()); !errors.Is(err, ErrRebuilding) {
+		t.Fatalf("second rebuild: got %v, want ErrRebuilding", err)
+	}
+	x.Put(db.Item{ID: "1", Name: "Blue Mug"})
+	x.Put(db.Item{ID: "3", Name: "New Mug"})
+	x.Delete("2")
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	got := ids(search(t, x, Query{Text: "mug"}))
+	slices.Sort(got)
+	if !slices.Equal(got, []string{"1", "3"}) {
+		t.Fatalf("got %v, want 1 and 3", got)
+	}
+	if got := ids(search(t, x, Query{Text: "blue"})); !slices.Equal(got, []string{"1"}) {
+		t.Fatalf("got %v, want the item as it was put", got)
+	}
+	if n, at := x.Stats(); n != 2 || at.IsZero() {
+		t.Fatalf("stats %d, %v", n, at)
+	}
+}
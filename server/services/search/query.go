@@ -0,0 +1,232 @@
+package search
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"sort"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// Sort orders
+const (
+	Relevance = "relevance" // best match first, then newest; the default with a text query
+	Newest    = "newest"    // the default without one
+	PriceAsc  = "price_asc"
+	PriceDesc = "price_desc"
+)
+
+// Sorts lists the valid sort orders.
+var Sorts = []string{Relevance, Newest, PriceAsc, PriceDesc}
+
+// Query selects and orders items. Nil filters match everything. Prices are in minor units of Currency, an item
+// matches a price range only when it has a price in it: an explicit one, or a base price in that currency.
+// Categories matches items in any of them, callers expand a category to those below it.
+type Query struct {
+	Text       string
+	Currency   string
+	MinPrice   *int64
+	MaxPrice   *int64
+	InStock    *bool
+	Active     *bool
+	Categories []string
+	Sort       string
+	Limit      int
+	Cursor     string
+}
+
+// Result is one page of matches. Total and the facets count every match, not only those on the page.
+type Result struct {
+	Items      []db.Item `json:"items"`
+	Total      int       `json:"total"`
+	Facets     Facets    `json:"facets"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// Facets count the matches by category, tag and stock, and give the range of their prices in the query's currency.
+type Facets struct {
+	Categories map[string]int `json:"categories"`
+	Tags       map[string]int `json:"tags"`
+	InStock    int            `json:"in_stock"`
+	OutOfStock int            `json:"out_of_stock"`
+	Price      *PriceRange    `json:"price,omitempty"`
+}
+
+type PriceRange struct {
+	Min money.Money `json:"min"`
+	Max money.Money `json:"max"`
+}
+
+// key orders matches ascending: descending orders are stored negated, items without a price sort last.
+type key struct {
+	Score float64 `json:"s,omitempty"`
+	N     int64   `json:"n,omitempty"`
+	ID    string  `json:"id"`
+}
+
+func (k key) less(o key) bool {
+	if k.Score != o.Score {
+		return k.Score < o.Score
+	}
+	if k.N != o.N {
+		return k.N < o.N
+	}
+	return k.ID < o.ID
+}
+
+// cursor is the key of the last item of a page, with the sort it was made for.
+type cursor struct {
+	Sort string `json:"sort"`
+	Key  key    `json:"key"`
+}
+
+type match struct {
+	doc *document
+	key key
+}
+
+// Search runs q. A cursor from a different sort, or one that does not decode, is a 400.
+func (x *Index) Search(q Query) (Result, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if x.built.IsZero() {
+		return Result{}, ErrNotReady
+	}
+
+	words := terms(q.Text)
+	if q.Sort == "" {
+		q.Sort = Newest
+		if len(words) > 0 {
+			q.Sort = Relevance
+		}
+	}
+	if q.Currency == "" {
+		q.Currency = money.Default
+	}
+	var after *key
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil || c.Sort != q.Sort {
+			return Result{}, apperrors.BadRequest("invalid_cursor", "The cursor is not valid.")
+		}
+		after = &c.Key
+	}
+
+	categories := map[string]bool{}
+	for _, c := range q.Categories {
+		categories[c] = true
+	}
+
+	result := Result{Items: []db.Item{}, Facets: Facets{Categories: map[string]int{}, Tags: map[string]int{}}}
+	var matches []match
+	for _, doc := range x.state.matching(words) {
+		item := doc.item
+		price, priced := priceIn(item, q.Currency)
+		switch {
+		case q.Active != nil && item.Active != *q.Active:
+			continue
+		case q.InStock != nil && (item.Inventory > 0) != *q.InStock:
+			continue
+		case (q.MinPrice != nil || q.MaxPrice != nil) && !priced:
+			continue
+		case q.MinPrice != nil && price < *q.MinPrice, q.MaxPrice != nil && price > *q.MaxPrice:
+			continue
+		case len(categories) > 0 && !inAny(item.Categories, categories):
+			continue
+		}
+
+		k := key{ID: item.ID}
+		switch q.Sort {
+		case Relevance:
+			k.Score = -x.state.score(doc, words)
+			k.N = -item.CreatedAt
+		case Newest:
+			k.N = -item.CreatedAt
+		case PriceAsc, PriceDesc:
+			k.N = math.MaxInt64
+			if priced && q.Sort == PriceAsc {
+				k.N = price
+			} else if priced {
+				k.N = -price
+			}
+		}
+		matches = append(matches, match{doc: doc, key: k})
+		count(&result.Facets, item, price, priced, q.Currency)
+	}
+	result.Total = len(matches)
+
+	sort.Slice(matches, func(i, j int) bool { return matches[i].key.less(matches[j].key) })
+	start := 0
+	if after != nil {
+		start = sort.Search(len(matches), func(i int) bool { return after.less(matches[i].key) })
+	}
+	end := len(matches)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		result.NextCursor = encodeCursor(cursor{Sort: q.Sort, Key: matches[end-1].key})
+	}
+	for _, m := range matches[start:end] {
+		result.Items = append(result.Items, m.doc.item)
+	}
+	return result, nil
+}
+
+func count(facets *Facets, item db.Item, price int64, priced bool, currency string) {
+	for _, c := range item.Categories {
+		facets.Categories[c]++
+	}
+	for _, t := range item.Tags {
+		facets.Tags[t]++
+	}
+	if item.Inventory > 0 {
+		facets.InStock++
+	} else {
+		facets.OutOfStock++
+	}
+	if !priced {
+		return
+	}
+	if facets.Price == nil {
+		facets.Price = &PriceRange{Min: money.New(price, currency), Max: money.New(price, currency)}
+	}
+	facets.Price.Min.Amount = min(facets.Price.Min.Amount, price)
+	facets.Price.Max.Amount = max(facets.Price.Max.Amount, price)
+}
+
+// priceIn is the item's price in currency, when it has one without converting.
+func priceIn(item db.Item, currency string) (int64, bool) {
+	if amount, ok := item.Prices[currency]; ok {
+		return amount, true
+	}
+	if item.Price.Currency == currency {
+		return item.Price.Amount, true
+	}
+	return 0, false
+}
+
+func inAny(values []string, set map[string]bool) bool {
+	for _, v := range values {
+		if set[v] {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
@@ -0,0 +1,232 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// built returns an index of items, built before it is returned.
+func built(t *testing.T, items ...db.Item) *Index {
+	t.Helper()
+	x := &Index{load: func(ctx context.Context) ([]db.Item, error) { return items, nil }, stop: make(chan struct{}), state: newState()}
+	_, err := x.Rebuild(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return x
+}
+
+func search(t *testing.T, x *Index, q Query) Result {
+	t.Helper()
+	result, err := x.Search(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func ids(result Result) []string {
+	out := []string{}
+	for _, item := range result.Items {
+		out = append(out, item.ID)
+	}
+	return out
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func code(err error) string {
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) {
+		return appErr.Code
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestRanking(t *testing.T) {
+	x := built(t,
+		db.Item{ID: "1", Name: "Mug", CreatedAt: 1},
+		db.Item{ID: "2", Name: "Big Blue Mug", CreatedAt: 2},
+		db.Item{ID: "3", Name: "Travel Cup", Description: "holds as much as a mug", CreatedAt: 3},
+		db.Item{ID: "4", Name: "Plate", CreatedAt: 4},
+		db.Item{ID: "5", Name: "Plate", CreatedAt: 5},
+		db.Item{ID: "6", Name: "Blue Plate", Description: "blue and blue again", CreatedAt: 6},
+	)
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		// a shorter name is a closer match, and the name counts more than the description
+		{name: "by relevance", q: Query{Text: "mug"}, want: []string{"1", "2", "3"}},
+		{name: "case and punctuation", q: Query{Text: "MUG!"}, want: []string{"1", "2", "3"}},
+		// equal scores go newest first, the longer name after them
+		{name: "ties", q: Query{Text: "plate"}, want: []string{"5", "4", "6"}},
+		{name: "every word", q: Query{Text: "blue plate"}, want: []string{"6"}},
+		// the word repeated in the description ranks it above a single one in the name
+		{name: "repeated", q: Query{Text: "blue"}, want: []string{"6", "2"}},
+		{name: "no match", q: Query{Text: "bowl"}, want: []string{}},
+		{name: "newest without text", q: Query{}, want: []string{"6", "5", "4", "3", "2", "1"}},
+		{name: "newest with text", q: Query{Text: "mug", Sort: Newest}, want: []string{"3", "2", "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := search(t, x, tt.q)
+			if got := ids(result); !slices.Equal(got, tt.want) || result.Total != len(tt.want) {
+				t.Fatalf("got %v of %d, want %v", got, result.Total, tt.want)
+			}
+		})
+	}
+}
+
+func catalog() []db.Item {
+	return []db.Item{
+		{ID: "tea", Name: "Tea", Price: money.New(500, "USD"), Prices: map[string]int64{"EUR": 450}, Inventory: 3, Active: true, Categories: []string{"drinks"}, Tags: []string{"hot"}, CreatedAt: 1},
+		{ID: "coffee", Name: "Coffee", Price: money.New(1200, "USD"), Active: true, Categories: []string{"drinks", "beans"}, CreatedAt: 2},
+		{ID: "mug", Name: "Mug", Price: money.New(900, "EUR"), Inventory: 10, Active: true, Categories: []string{"kitchen"}, Tags: []string{"hot", "gift"}, CreatedAt: 3},
+		{ID: "old", Name: "Old Mug", Price: money.New(300, "USD"), Inventory: 5, Categories: []string{"kitchen"}, CreatedAt: 4},
+	}
+}
+
+func TestFilters(t *testing.T) {
+	x := built(t, catalog()...)
+	tests := []struct {
+		name string
+		q    Query
+		want []string
+	}{
+		{name: "active", q: Query{Active: ptr(true)}, want: []string{"coffee", "mug", "tea"}},
+		{name: "inactive", q: Query{Active: ptr(false)}, want: []string{"old"}},
+		{name: "in stock", q: Query{InStock: ptr(true)}, want: []string{"mug", "old", "tea"}},
+		{name: "out of stock", q: Query{InStock: ptr(false)}, want: []string{"coffee"}},
+		// items without a price in the currency are left out of a price range
+		{name: "min price", q: Query{Currency: "USD", MinPrice: ptr[int64](500)}, want: []string{"coffee", "tea"}},
+		{name: "max price", q: Query{Currency: "USD", MaxPrice: ptr[int64](500)}, want: []string{"old", "tea"}},
+		{name: "explicit price", q: Query{Currency: "EUR", MaxPrice: ptr[int64](500)}, want: []string{"tea"}},
+		{name: "priced in the currency", q: Query{Currency: "EUR", MinPrice: ptr[int64](0)}, want: []string{"mug", "tea"}},
+		{name: "empty range", q: Query{Currency: "USD", MinPrice: ptr[int64](600), MaxPrice: ptr[int64](1000)}, want: []string{}},
+		{name: "category", q: Query{Categories: []string{"beans"}}, want: []string{"coffee"}},
+		{name: "any category", q: Query{Categories: []string{"beans", "kitchen"}}, want: []string{"coffee", "mug", "old"}},
+		{name: "all filters", q: Query{Text: "mug", Active: ptr(true), InStock: ptr(true), Categories: []string{"kitchen"}}, want: []string{"mug"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ids(search(t, x, tt.q))
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortByPrice(t *testing.T) {
+	x := built(t, catalog()...)
+	tests := []struct {
+		q    Query
+		want []string
+	}{
+		// items without a price in the currency go last, in either direction
+		{q: Query{Currency: "USD", Sort: PriceAsc}, want: []string{"old", "tea", "coffee", "mug"}},
+		{q: Query{Currency: "USD", Sort: PriceDesc}, want: []string{"coffee", "tea", "old", "mug"}},
+		{q: Query{Currency: "EUR", Sort: PriceAsc}, want: []string{"tea", "mug", "coffee", "old"}},
+	}
+	for _, tt := range tests {
+		if got := ids(search(t, x, tt.q)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s in %s: got %v, want %v", tt.q.Sort, tt.q.Currency, got, tt.want)
+		}
+	}
+}
+
+func TestFacetsCountEveryMatch(t *testing.T) {
+	x := built(t, catalog()...)
+	result := search(t, x, Query{Currency: "USD", Limit: 1})
+	if len(result.Items) != 1 || result.Total != 4 {
+		t.Fatalf("got %d items of %d", len(result.Items), result.Total)
+	}
+	f := result.Facets
+	if f.Categories["drinks"] != 2 || f.Categories["beans"] != 1 || f.Categories["kitchen"] != 2 || len(f.Categories) != 3 {
+		t.Fatalf("categories %v", f.Categories)
+	}
+	if f.Tags["hot"] != 2 || f.Tags["gift"] != 1 || f.InStock != 3 || f.OutOfStock != 1 {
+		t.Fatalf("got %+v", f)
+	}
+	// the mug has no dollar price
+	if f.Price == nil || f.Price.Min != money.New(300, "USD") || f.Price.Max != money.New(1200, "USD") {
+		t.Fatalf("price range %+v", f.Price)
+	}
+
+	result = search(t, x, Query{Currency: "GBP"})
+	if result.Facets.Price != nil {
+		t.Fatalf("price range %+v without a price in the currency", result.Facets.Price)
+	}
+}
+
+func TestPages(t *testing.T) {
+	var items []db.Item
+	for i, id := range []string{"1", "2", "3", "4", "5"} {
+		items = append(items, db.Item{ID: id, Name: "Mug", CreatedAt: int64(i + 1)})
+	}
+	x := built(t, items...)
+
+	walk := func(q Query, changed func()) [][]string {
+		var pages [][]string
+		for {
+			result := search(t, x, q)
+			pages = append(pages, ids(result))
+			if len(pages) == 1 && result.Total != 5 {
+				t.Fatalf("total %d, want 5", result.Total)
+			}
+			if result.NextCursor == "" {
+				return pages
+			}
+			q.Cursor = result.NextCursor
+			if len(pages) == 1 {
+				changed()
+			}
+		}
+	}
+	want := [][]string{{"5", "4"}, {"3", "2"}, {"1"}}
+	same := func(got [][]string) bool {
+		return slices.EqualFunc(got, want, func(a, b []string) bool { return slices.Equal(a, b) })
+	}
+	if pages := walk(Query{Text: "mug", Limit: 2}, func() {}); !same(pages) {
+		t.Fatalf("by relevance: got pages %v", pages)
+	}
+	// an item removed from an earlier page does not move the next one
+	if pages := walk(Query{Text: "mug", Sort: Newest, Limit: 2}, func() { x.Delete("5") }); !same(pages) {
+		t.Fatalf("newest: got pages %v", pages)
+	}
+
+	first := search(t, x, Query{Text: "mug", Limit: 1})
+	for name, q := range map[string]Query{
+		"another sort": {Text: "mug", Sort: Newest, Cursor: first.NextCursor},
+		"not base64":   {Cursor: "!!"},
+		"not json":     {Cursor: "bm90IGpzb24"},
+	} {
+		_, err := x.Search(q)
+		if code(err) != "invalid_cursor" {
+			t.Errorf("%s: got %v, want invalid_cursor", name, err)
+		}
+	}
+}
+
+func TestSearchBeforeTheFirstBuild(t *testing.T) {
+	x := &Index{state: newState()}
+	x.Put(db.Item{ID: "1", Name: "Mug"})
+	_, err := x.Search(Query{Text: "mug"})
+	if !errors.Is(err, ErrNotReady) {
+		t.Fatalf("got %v, want ErrNotReady", err)
+	}
+}
@@ -0,0 +1,151 @@
+package search
+
+import (
+	"math"
+	"strings"
+	"unicode"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+// fields of an item that are searched, a match in the name counts more than one in the description
+const (
+	fieldName = iota
+	fieldDescription
+	fieldCount
+)
+
+var boosts = [fieldCount]float64{fieldName: 3, fieldDescription: 1}
+
+// BM25 parameters, the usual defaults
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+type document struct {
+	item   db.Item
+	terms  [fieldCount]map[string]int // term frequencies
+	length [fieldCount]int
+}
+
+// state is one generation of the index: the documents and, for each term, the documents containing it.
+type state struct {
+	docs     map[string]*document
+	postings map[string]map[string]bool
+	length   [fieldCount]int // summed over all documents
+}
+
+func newState() *state {
+	return &state{docs: map[string]*document{}, postings: map[string]map[string]bool{}}
+}
+
+func (s *state) put(item db.Item) {
+	s.delete(item.ID)
+	doc := &document{item: item}
+	for field, text := range [fieldCount]string{fieldName: item.Name, fieldDescription: item.Description} {
+		doc.terms[field] = map[string]int{}
+		for _, term := range tokenize(text) {
+			doc.terms[field][term]++
+			doc.length[field]++
+			if s.postings[term] == nil {
+				s.postings[term] = map[string]bool{}
+			}
+			s.postings[term][item.ID] = true
+		}
+		s.length[field] += doc.length[field]
+	}
+	s.docs[item.ID] = doc
+}
+
+func (s *state) delete(id string) {
+	doc, ok := s.docs[id]
+	if !ok {
+		return
+	}
+	for field := range doc.terms {
+		for term := range doc.terms[field] {
+			delete(s.postings[term], id)
+			if len(s.postings[term]) == 0 {
+				delete(s.postings, term)
+			}
+		}
+		s.length[field] -= doc.length[field]
+	}
+	delete(s.docs, id)
+}
+
+// matching returns the documents containing every term.
+func (s *state) matching(terms []string) []*document {
+	if len(terms) == 0 {
+		docs := make([]*document, 0, len(s.docs))
+		for _, doc := range s.docs {
+			docs = append(docs, doc)
+		}
+		return docs
+	}
+	// walk the rarest term's postings and check the others
+	rarest := terms[0]
+	for _, term := range terms[1:] {
+		if len(s.postings[term]) < len(s.postings[rarest]) {
+			rarest = term
+		}
+	}
+	var docs []*document
+	for id := range s.postings[rarest] {
+		all := true
+		for _, term := range terms {
+			if !s.postings[term][id] {
+				all = false
+				break
+			}
+		}
+		if all {
+			docs = append(docs, s.docs[id])
+		}
+	}
+	return docs
+}
+
+// score ranks a document for terms with BM25, summing boosted per-field scores.
+func (s *state) score(doc *document, terms []string) float64 {
+	n := float64(len(s.docs))
+	total := 0.0
+	for _, term := range terms {
+		df := float64(len(s.postings[term]))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for field := 0; field < fieldCount; field++ {
+			tf := float64(doc.terms[field][term])
+			if tf == 0 {
+				continue
+			}
+			avg := float64(s.length[field]) / n
+			norm := 1 - b
+			if avg > 0 {
+				norm += b * float64(doc.length[field]) / avg
+			}
+			total += boosts[field] * idf * tf * (k1 + 1) / (tf + k1*norm)
+		}
+	}
+	return total
+}
+
+// tokenize splits text into lower case words of letters and digits.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// terms tokenizes a query, dropping repeated words.
+func terms(query string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, term := range tokenize(query) {
+		if !seen[term] {
+			seen[term] = true
+			out = append(out, term)
+		}
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+package search
+
+import (
+	"slices"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services/db"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := map[string][]string{
+		"Blue Mug, 350ml!":   {"blue", "mug", "350ml"},
+		"T-Shirt_XL":         {"t", "shirt", "xl"},
+		"Café  Ärmel":        {"café", "ärmel"},
+		"it's 2x-strong":     {"it", "s", "2x", "strong"},
+		"":                   nil,
+		" -- / ... ":         nil,
+		"mug\tmug\nMUG":      {"mug", "mug", "mug"},
+		"日本茶 tea":            {"日本茶", "tea"},
+		"100% cotton (blue)": {"100", "cotton", "blue"},
+	}
+	for text, want := range tests {
+		if got := tokenize(text); !slices.Equal(got, want) {
+			t.Errorf("%q: got %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestTermsDropsRepeatedWords(t *testing.T) {
+	got := terms("Mug mug MUGS, mug")
+	if want := []string{"mug", "mugs"}; !slices.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestPutReplacesAndDeleteRemoves(t *testing.T) {
+	s := newState()
+	s.put(db.Item{ID: "1", Name: "Blue Mug", Description: "a mug for tea"})
+	s.put(db.Item{ID: "2", Name: "Red Mug"})
+	if len(s.postings["mug"]) != 2 || s.length != [fieldCount]int{4, 4} {
+		t.Fatalf("postings %v, lengths %v", s.postings, s.length)
+	}
+
+	// a replaced item's old words no longer find it
+	s.put(db.Item{ID: "1", Name: "Green Cup"})
+	if s.postings["blue"] != nil || s.postings["tea"] != nil || len(s.postings["mug"]) != 1 || !s.postings["green"]["1"] {
+		t.Fatalf("postings %v", s.postings)
+	}
+	if s.length != [fieldCount]int{4, 0} {
+		t.Fatalf("lengths %v", s.length)
+	}
+
+	s.delete("2")
+	s.delete("missing")
+	if len(s.docs) != 1 || s.postings["mug"] != nil || s.postings["red"] != nil || s.length != [fieldCount]int{2, 0} {
+		t.Fatalf("docs %v, postings %v, lengths %v", s.docs, s.postings, s.length)
+	}
+}
+
+func TestMatchingNeedsEveryTerm(t *testing.T) {
+	s := newState()
+	s.put(db.Item{ID: "1", Name: "Blue Mug"})
+	s.put(db.Item{ID: "2", Name: "Red Mug", Description: "not blue at all"})
+	s.put(db.Item{ID: "3", Name: "Blue Plate"})
+
+	tests := []struct {
+		terms []string
+		want  []string
+	}{
+		{terms: []string{"mug"}, want: []string{"1", "2"}},
+		// a word in the description counts as much as one in the name
+		{terms: []string{"blue", "mug"}, want: []string{"1", "2"}},
+		{terms: []string{"blue", "plate"}, want: []string{"3"}},
+		{terms: []string{"blue", "cup"}},
+		{terms: []string{"bl"}},
+		// no terms match everything
+		{terms: nil, want: []string{"1", "2", "3"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, doc := range s.matching(tt.terms) {
+			got = append(got, doc.item.ID)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.terms, got, tt.want)
+		}
+	}
+}
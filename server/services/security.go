@@ -0,0 +1,162 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+)
+
+// RecoverMiddleware turns a handler panic into a logged 500 problem instead of a dropped connection.
+func RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wrapper := &ResponseWriterWrapper{ResponseWriter: w}
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+			// http.ErrAbortHandler is the documented way to abort a response, let the server handle it
+			if recovered == http.ErrAbortHandler {
+				panic(recovered)
+			}
+			slog.ErrorContext(r.Context(), "handler panicked", "panic", recovered, "stack", string(debug.Stack()))
+			if wrapper.statusCode == 0 && wrapper.written == 0 {
+				apperrors.Write(w, r, apperrors.Internal("internal_error", "An unexpected error occurred.", fmt.Errorf("panic: %v", recovered)))
+			}
+		}()
+		next.ServeHTTP(wrapper, r)
+	})
+}
+
+// SecurityHeaders sets headers that are safe for a JSON API. hstsMaxAge is only sent when positive,
+// enable it once the API is served over HTTPS.
+func SecurityHeaders(hstsMaxAge time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		header.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if hstsMaxAge > 0 {
+			header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(hstsMaxAge.Seconds()))+"; includeSubDomains")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type CORS struct {
+	AllowedOrigins   []string // exact origins such as https://dashboard.example.com, or "*"
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration // how long browsers may cache a preflight response
+}
+
+func (c *CORS) allowed(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware answers preflight requests itself, so they never reach authentication or rate limiting,
+// and adds the CORS response headers to every request from an allowed origin.
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !c.allowed(origin) {
+			if preflight {
+				apperrors.Write(w, r, apperrors.Forbidden("cors_origin_denied", "Origin is not allowed."))
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// "*" cannot be combined with credentials, so the origin is echoed instead
+		if !c.AllowCredentials && len(c.AllowedOrigins) == 1 && c.AllowedOrigins[0] == "*" {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(c.ExposedHeaders) > 0 {
+				header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		header.Set("Access-Control-Allow-Methods", strings.Join(c.AllowedMethods, ", "))
+		if len(c.AllowedHeaders) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+		}
+		if c.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// BodyLimiter caps request bodies at a default size with per-route overrides, such as file uploads.
+type BodyLimiter struct {
+	defaultLimit int64
+	routes       map[string]int64
+}
+
+func NewBodyLimiter(defaultLimit int64) *BodyLimiter {
+	return &BodyLimiter{defaultLimit: defaultLimit, routes: make(map[string]int64)}
+}
+
+// SetRouteLimit overrides the limit for a registered ServeMux pattern.
+func (bl *BodyLimiter) SetRouteLimit(pattern string, limit int64) {
+	bl.routes[pattern] = limit
+}
+
+// Middleware rejects a declared Content-Length over the limit with 413 straight away. Bodies without
+// one are cut off by http.MaxBytesReader, and decoding them reports 413 through apperrors.From.
+func (bl *BodyLimiter) Middleware(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := bl.defaultLimit
+		if routeLimit, ok := bl.routes[routePattern(mux, r)]; ok {
+			limit = routeLimit
+		}
+		if r.ContentLength > limit {
+			apperrors.Write(w, r, &http.MaxBytesError{Limit: limit})
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routePattern is the pattern mux will route r to, before the mux has set r.Pattern.
+func routePattern(mux *http.ServeMux, r *http.Request) string {
+	_, pattern := mux.Handler(r)
+	return pattern
+}
@@ -0,0 +1,98 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/mapping"
+
+	"googlemaps.github.io/maps"
+)
+
+// ErrNotFound is returned by a Locator that cannot place an address.
+var ErrNotFound = errors.New("address not found")
+
+// Locator finds the country and region codes of a postal address.
+type Locator interface {
+	Locate(ctx context.Context, address db.Address) (country, region string, err error)
+}
+
+// Geocoder locates addresses with Google Maps through the mapping package.
+type Geocoder struct {
+	client *maps.Client
+}
+
+func NewGeocoder(client *maps.Client) *Geocoder {
+	return &Geocoder{client: client}
+}
+
+func (g *Geocoder) Locate(ctx context.Context, address db.Address) (string, string, error) {
+	parts := []string{address.Line1, address.Line2, address.City, address.PostalCode, address.Region, address.Country}
+	var query []string
+	for _, part := range parts {
+		if part != "" {
+			query = append(query, part)
+		}
+	}
+	results, err := mapping.Geocode(ctx, g.client, strings.Join(query, ", "))
+	if err != nil {
+		return "", "", err
+	}
+	if len(results) == 0 {
+		return "", "", ErrNotFound
+	}
+
+	var country, region string
+	for _, component := range results[0].AddressComponents {
+		for _, kind := range component.Types {
+			switch kind {
+			case "country":
+				country = component.ShortName
+			case "administrative_area_level_1":
+				region = component.ShortName
+			}
+		}
+	}
+	if country == "" {
+		return "", "", ErrNotFound
+	}
+	return country, region, nil
+}
+
+// Region returns where an order shipped to address is taxed, "US-CA", or just the country when the address has
+// no region. An address without a country is geocoded with locator, which fills in its country and region; without
+// a locator the country is required. Without an address the order is taxed in fallback, which may be empty.
+func Region(ctx context.Context, locator Locator, address *db.Address, fallback string) (string, error) {
+	if address == nil {
+		return fallback, nil
+	}
+	if address.Country == "" {
+		if locator == nil {
+			return "", apperrors.InvalidFields([]apperrors.FieldError{{Field: "shipping_address.country", Code: "required", Message: "is required"}})
+		}
+		country, region, err := locator.Locate(ctx, *address)
+		if errors.Is(err, ErrNotFound) {
+			return "", apperrors.InvalidFields([]apperrors.FieldError{{Field: "shipping_address", Code: "address_not_found", Message: "could not be located, add its country"}})
+		}
+		if err != nil {
+			return "", apperrors.Internal("geocoding_failed", "Failed to locate the shipping address.", err)
+		}
+		address.Country = country
+		if address.Region == "" {
+			address.Region = region
+		}
+	}
+
+	address.Country = strings.ToUpper(address.Country)
+	address.Region = strings.ToUpper(address.Region)
+	if len(address.Country) != 2 {
+		return "", apperrors.InvalidFields([]apperrors.FieldError{{Field: "shipping_address.country", Code: "invalid_country", Message: "must be an ISO 3166-1 alpha-2 code"}})
+	}
+	if address.Region == "" {
+		return address.Country, nil
+	}
+	return address.Country + "-" + address.Region, nil
+}
@@ -0,0 +1,259 @@
+// Package tax computes the tax of an order from rules by region and item tax category. The result is stored on the
+// order as a db.TaxBreakdown, so changing the rules only affects orders placed afterwards.
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// Standard is the category of items that do not name one. Categories without a rule of their own in a region are
+// also taxed at its standard rate; give a category a zero rate to exempt it.
+const Standard = "standard"
+
+// Rule taxes items of Category shipped to Region at Rate. Region is a country, "US", or a country and a region,
+// "US-CA"; a rule for the region takes precedence over one for its country.
+type Rule struct {
+	Name     string
+	Region   string
+	Category string
+	Rate     *big.Rat
+}
+
+// Rules are the configured rules. With Inclusive item prices include tax, which is then taken out of them
+// instead of added.
+type Rules struct {
+	Inclusive bool
+	rules     map[string]Rule // by region and category
+}
+
+func NewRules(inclusive bool, rules []Rule) Rules {
+	r := Rules{Inclusive: inclusive, rules: make(map[string]Rule, len(rules))}
+	for _, rule := range rules {
+		r.rules[ruleKey(rule.Region, rule.Category)] = rule
+	}
+	return r
+}
+
+func ruleKey(region, category string) string {
+	return region + "|" + category
+}
+
+// LoadFile reads rules from a JSON file, rates are decimal strings so that they are read exactly:
+//
+//	{"rules": [
+//	  {"name": "California sales tax", "region": "US-CA", "rate": "0.0725"},
+//	  {"name": "USt", "region": "DE", "rate": "0.19"},
+//	  {"name": "USt ermäßigt", "region": "DE", "category": "food", "rate": "0.07"}
+//	]}
+//
+// A rule without a category is the region's standard rate. Without a path there are no rules and no tax.
+func LoadFile(path string, inclusive bool) (Rules, error) {
+	if path == "" {
+		return NewRules(inclusive, nil), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Rules{}, err
+	}
+	var file struct {
+		Rules []struct {
+			Name     string `json:"name"`
+			Region   string `json:"region"`
+			Category string `json:"category"`
+			Rate     string `json:"rate"`
+		} `json:"rules"`
+	}
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return Rules{}, fmt.Errorf("tax rules %s: %w", path, err)
+	}
+
+	rules := make([]Rule, 0, len(file.Rules))
+	seen := map[string]bool{}
+	for i, r := range file.Rules {
+		rule := Rule{Name: r.Name, Region: strings.ToUpper(r.Region), Category: r.Category, Rate: new(big.Rat)}
+		if rule.Category == "" {
+			rule.Category = Standard
+		}
+		if _, ok := rule.Rate.SetString(r.Rate); !ok || rule.Rate.Sign() < 0 || rule.Rate.Cmp(big.NewRat(1, 1)) >= 0 {
+			return Rules{}, fmt.Errorf("tax rules %s: rule %d: rate %q must be a decimal from 0 to below 1", path, i, r.Rate)
+		}
+		country, _, _ := strings.Cut(rule.Region, "-")
+		if len(country) != 2 {
+			return Rules{}, fmt.Errorf("tax rules %s: rule %d: region %q must be a country code, optionally followed by -region", path, i, r.Region)
+		}
+		key := ruleKey(rule.Region, rule.Category)
+		if seen[key] {
+			return Rules{}, fmt.Errorf("tax rules %s: rule %d: more than one rule for %s %s", path, i, rule.Region, rule.Category)
+		}
+		seen[key] = true
+		if rule.Name == "" {
+			rule.Name = rule.Region + " " + rule.Category
+		}
+		rules = append(rules, rule)
+	}
+	return NewRules(inclusive, rules), nil
+}
+
+// Len returns the number of rules.
+func (r Rules) Len() int {
+	return len(r.rules)
+}
+
+// Match returns the rule for items of category shipped to region, trying the region before its country and the
+// category before the standard rate.
+func (r Rules) Match(region, category string) (Rule, bool) {
+	regions := []string{region}
+	if country, _, found := strings.Cut(region, "-"); found {
+		regions = append(regions, country)
+	}
+	categories := []string{category}
+	if category != Standard {
+		categories = append(categories, Standard)
+	}
+	for _, c := range categories {
+		for _, reg := range regions {
+			if rule, ok := r.rules[ruleKey(reg, c)]; ok {
+				return rule, true
+			}
+		}
+	}
+	return Rule{}, false
+}
+
+// Compute taxes the priced lines of an order shipped to region, after taking discount off them. The discount is
+// spread over the lines in proportion to their subtotals. Tax is rounded half to even once per rule rather than
+// per line. An empty region or one without rules gives an empty breakdown.
+func (r Rules) Compute(region string, lines []db.LineItem, discount money.Money) (db.TaxBreakdown, error) {
+	breakdown := db.TaxBreakdown{Region: region, Inclusive: r.Inclusive, Lines: []db.TaxLine{}}
+	if region == "" || len(lines) == 0 {
+		return breakdown, nil
+	}
+	currency := lines[0].Subtotal.Currency
+
+	shares := allocate(lines, discount.Amount)
+	taxable := map[string]int64{}
+	matched := map[string]Rule{}
+	for i, line := range lines {
+		category := line.TaxCategory
+		if category == "" {
+			category = Standard
+		}
+		rule, ok := r.Match(region, category)
+		if !ok {
+			continue
+		}
+		// lines of a category taxed at the standard rate are reported under the standard rule
+		key := ruleKey(rule.Region, rule.Category)
+		matched[key] = rule
+		taxable[key] += line.Subtotal.Amount - shares[i]
+	}
+
+	keys := make([]string, 0, len(matched))
+	for key := range matched {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rule := matched[key]
+		base := money.New(taxable[key], currency)
+		rate := rule.Rate
+		if r.Inclusive {
+			// the tax inside a gross amount is gross * rate / (1 + rate)
+			rate = new(big.Rat).Quo(rule.Rate, new(big.Rat).Add(big.NewRat(1, 1), rule.Rate))
+		}
+		amount, err := base.Times(rate)
+		if err != nil {
+			return db.TaxBreakdown{}, err
+		}
+		breakdown.Lines = append(breakdown.Lines, db.TaxLine{
+			Name:     rule.Name,
+			Region:   rule.Region,
+			Category: rule.Category,
+			Rate:     money.FormatRate(rule.Rate),
+			Taxable:  base,
+			Amount:   amount,
+		})
+	}
+	return breakdown, nil
+}
+
+// Total sums the tax of a breakdown.
+func Total(breakdown db.TaxBreakdown, currency string) (money.Money, error) {
+	total := money.New(0, currency)
+	for _, line := range breakdown.Lines {
+		var err error
+		total, err = total.Add(line.Amount)
+		if err != nil {
+			return money.Money{}, err
+		}
+	}
+	return total, nil
+}
+
+// allocate splits amount over lines in proportion to their subtotals, the rounding remainder going to the lines
+// with the largest fractions so that the shares add up to amount exactly.
+func allocate(lines []db.LineItem, amount int64) []int64 {
+	shares := make([]int64, len(lines))
+	var subtotal int64
+	for _, line := range lines {
+		subtotal += line.Subtotal.Amount
+	}
+	if amount <= 0 || subtotal <= 0 {
+		return shares
+	}
+
+	remainders := make([]*big.Int, len(lines))
+	left := amount
+	for i, line := range lines {
+		q, m := new(big.Int).QuoRem(new(big.Int).Mul(big.NewInt(amount), big.NewInt(line.Subtotal.Amount)), big.NewInt(subtotal), new(big.Int))
+		shares[i] = q.Int64()
+		remainders[i] = m
+		left -= shares[i]
+	}
+	order := make([]int, len(lines))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return remainders[order[a]].Cmp(remainders[order[b]]) > 0 })
+	for _, i := range order[:left] {
+		shares[i]++
+	}
+	return shares
+}
+
+// Calculator taxes orders with rules, in the region of their shipping address.
+type Calculator struct {
+	rules         Rules
+	locator       Locator
+	defaultRegion string
+}
+
+// NewCalculator taxes orders without a shipping address in defaultRegion, or not at all when it is empty.
+// locator may be nil, addresses must then name their country.
+func NewCalculator(rules Rules, locator Locator, defaultRegion string) *Calculator {
+	return &Calculator{rules: rules, locator: locator, defaultRegion: defaultRegion}
+}
+
+// Tax locates address, filling in its country and region, and computes the tax of the discounted lines.
+func (c *Calculator) Tax(ctx context.Context, address *db.Address, lines []db.LineItem, discount money.Money) (db.TaxBreakdown, money.Money, error) {
+	region, err := Region(ctx, c.locator, address, c.defaultRegion)
+	if err != nil {
+		return db.TaxBreakdown{}, money.Money{}, err
+	}
+	breakdown, err := c.rules.Compute(region, lines, discount)
+	if err != nil {
+		return db.TaxBreakdown{}, money.Money{}, err
+	}
+	total, err := Total(breakdown, discount.Currency)
+	return breakdown, total, err
+}
@@ -0,0 +1,259 @@
+package tax
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"upgraded-telegram/main.go/server/services/db"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+func rate(s string) *big.Rat {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		panic(s)
+	}
+	return r
+}
+
+func rules(inclusive bool) Rules {
+	return NewRules(inclusive, []Rule{
+		{Name: "US", Region: "US", Category: Standard, Rate: rate("0.05")},
+		{Name: "US books", Region: "US", Category: "books", Rate: rate("0")},
+		{Name: "CA", Region: "US-CA", Category: Standard, Rate: rate("0.0725")},
+		{Name: "CA food", Region: "US-CA", Category: "food", Rate: rate("0")},
+		{Name: "USt", Region: "DE", Category: Standard, Rate: rate("0.19")},
+		{Name: "USt food", Region: "DE", Category: "food", Rate: rate("0.07")},
+		{Name: "ten", Region: "XX", Category: Standard, Rate: rate("0.1")},
+	})
+}
+
+func line(item string, amount int64, category string) db.LineItem {
+	return db.LineItem{ItemID: item, Quantity: 1, Subtotal: money.New(amount, "EUR"), TaxCategory: category}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		region, category string
+		want             string
+	}{
+		{region: "US-CA", category: Standard, want: "CA"},
+		{region: "US-CA", category: "food", want: "CA food"},
+		// a category without a rule in the region is taxed at its standard rate
+		{region: "US-CA", category: "toys", want: "CA"},
+		// the region's country when the region has no rules
+		{region: "US-NY", category: Standard, want: "US"},
+		{region: "US-NY", category: "food", want: "US"},
+		// the country's rule for the category before the region's standard rate
+		{region: "US-CA", category: "books", want: "US books"},
+		{region: "DE-BY", category: "food", want: "USt food"},
+		{region: "DE", category: Standard, want: "USt"},
+		{region: "FR", category: Standard},
+	}
+	r := rules(false)
+	for _, tt := range tests {
+		rule, ok := r.Match(tt.region, tt.category)
+		if rule.Name != tt.want || ok != (tt.want != "") {
+			t.Errorf("%s %s: got %q, want %q", tt.region, tt.category, rule.Name, tt.want)
+		}
+	}
+}
+
+func TestComputeRoundsOncePerRule(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []db.LineItem
+		want  int64
+	}{
+		// 1.5 per line but 3 in total
+		{name: "summed before rounding", lines: []db.LineItem{line("a", 15, ""), line("b", 15, "")}, want: 3},
+		{name: "half down to even", lines: []db.LineItem{line("a", 25, "")}, want: 2},
+		{name: "half up to even", lines: []db.LineItem{line("a", 35, "")}, want: 4},
+		{name: "not a half", lines: []db.LineItem{line("a", 36, "")}, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakdown, err := rules(false).Compute("XX", tt.lines, money.New(0, "EUR"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(breakdown.Lines) != 1 || breakdown.Lines[0].Amount != money.New(tt.want, "EUR") {
+				t.Fatalf("got %+v, want one line of %d", breakdown.Lines, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeByRule(t *testing.T) {
+	lines := []db.LineItem{line("mug", 1000, ""), line("bread", 1000, "food"), line("toy", 500, "toys")}
+	breakdown, err := rules(false).Compute("DE-BY", lines, money.New(0, "EUR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// toys have no rule of their own and are taxed, and reported, with the standard rate
+	want := []db.TaxLine{
+		{Name: "USt food", Region: "DE", Category: "food", Rate: "0.07", Taxable: money.New(1000, "EUR"), Amount: money.New(70, "EUR")},
+		{Name: "USt", Region: "DE", Category: Standard, Rate: "0.19", Taxable: money.New(1500, "EUR"), Amount: money.New(285, "EUR")},
+	}
+	if len(breakdown.Lines) != len(want) || breakdown.Lines[0] != want[0] || breakdown.Lines[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", breakdown.Lines, want)
+	}
+	total, err := Total(breakdown, "EUR")
+	if err != nil || total != money.New(355, "EUR") {
+		t.Fatalf("total %v, %v", total, err)
+	}
+
+	for _, region := range []string{"", "FR"} {
+		breakdown, err := rules(false).Compute(region, lines, money.New(0, "EUR"))
+		if err != nil || len(breakdown.Lines) != 0 {
+			t.Fatalf("region %q: got %+v, %v; want no tax", region, breakdown.Lines, err)
+		}
+	}
+}
+
+func TestComputeWithADiscount(t *testing.T) {
+	lines := []db.LineItem{line("mug", 1000, ""), line("bread", 1000, "food")}
+	breakdown, err := rules(false).Compute("DE", lines, money.New(500, "EUR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 750 each: 52.5 of food and 142.5 at the standard rate
+	if breakdown.Lines[0].Taxable.Amount != 750 || breakdown.Lines[0].Amount.Amount != 52 ||
+		breakdown.Lines[1].Taxable.Amount != 750 || breakdown.Lines[1].Amount.Amount != 142 {
+		t.Fatalf("got %+v", breakdown.Lines)
+	}
+
+	// a discount that does not split evenly still comes off in full
+	lines = []db.LineItem{line("a", 100, ""), line("b", 100, "food"), line("c", 100, "books")}
+	breakdown, err = rules(false).Compute("DE", lines, money.New(100, "EUR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var taxable int64
+	for _, l := range breakdown.Lines {
+		taxable += l.Taxable.Amount
+	}
+	if taxable != 200 {
+		t.Fatalf("%d taxable, want 200: %+v", taxable, breakdown.Lines)
+	}
+}
+
+func TestComputeInclusive(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []db.LineItem
+		discount int64
+		want     []int64
+	}{
+		// 119 includes 19
+		{name: "exact", lines: []db.LineItem{line("mug", 119, "")}, want: []int64{19}},
+		// 159.66
+		{name: "rounded", lines: []db.LineItem{line("mug", 1000, "")}, want: []int64{160}},
+		// 2140 less 214 is 1926, 1926 * 7/107 is 126
+		{name: "discounted", lines: []db.LineItem{line("bread", 2140, "food")}, discount: 214, want: []int64{126}},
+		{name: "by rule", lines: []db.LineItem{line("mug", 1190, ""), line("bread", 1070, "food")}, want: []int64{70, 190}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breakdown, err := rules(true).Compute("DE", tt.lines, money.New(tt.discount, "EUR"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !breakdown.Inclusive || len(breakdown.Lines) != len(tt.want) {
+				t.Fatalf("got %+v", breakdown)
+			}
+			for i, want := range tt.want {
+				if breakdown.Lines[i].Amount.Amount != want {
+					t.Fatalf("got %+v, want %v", breakdown.Lines, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocateAddsUp(t *testing.T) {
+	tests := []struct {
+		subtotals []int64
+		amount    int64
+		want      []int64
+	}{
+		{subtotals: []int64{300, 100, 100}, amount: 100, want: []int64{60, 20, 20}},
+		// the remainder goes to the earliest of equal fractions
+		{subtotals: []int64{1, 1, 1}, amount: 2, want: []int64{1, 1, 0}},
+		{subtotals: []int64{100, 100, 100}, amount: 100, want: []int64{34, 33, 33}},
+		// and to the largest fractions first: 6.67, 2.22, 1.11
+		{subtotals: []int64{600, 200, 100}, amount: 10, want: []int64{7, 2, 1}},
+		{subtotals: []int64{999, 1}, amount: 1000, want: []int64{999, 1}},
+		{subtotals: []int64{500, 500}, amount: 0, want: []int64{0, 0}},
+		{subtotals: []int64{0, 0}, amount: 10, want: []int64{0, 0}},
+	}
+	for _, tt := range tests {
+		var lines []db.LineItem
+		for _, s := range tt.subtotals {
+			lines = append(lines, line("x", s, ""))
+		}
+		got := allocate(lines, tt.amount)
+		for i := range tt.want {
+			if got[i] != tt.want[i] {
+				t.Errorf("%v split over %v: got %v, want %v", tt.amount, tt.subtotals, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// whatever the split, the shares add up to the amount and never exceed a line
+	subtotals := []int64{333, 17, 1250, 9, 4001, 77}
+	var lines []db.LineItem
+	var total int64
+	for _, s := range subtotals {
+		lines = append(lines, line("x", s, ""))
+		total += s
+	}
+	for amount := int64(1); amount <= total; amount += 97 {
+		var sum int64
+		for i, share := range allocate(lines, amount) {
+			if share < 0 || share > subtotals[i] {
+				t.Fatalf("%d: share %d of line %d", amount, share, subtotals[i])
+			}
+			sum += share
+		}
+		if sum != amount {
+			t.Fatalf("%d split into shares of %d", amount, sum)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "rules.json")
+		err := os.WriteFile(path, []byte(content), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	r, err := LoadFile(write(`{"rules": [{"region": "us-ca", "rate": "0.0725"}, {"name": "USt ermäßigt", "region": "DE", "category": "food", "rate": "0.07"}]}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rule, ok := r.Match("US-CA", Standard)
+	if !ok || rule.Name != "US-CA standard" || rule.Rate.Cmp(rate("0.0725")) != 0 {
+		t.Fatalf("got %+v", rule)
+	}
+
+	for _, bad := range []string{
+		`{"rules": [{"region": "DE", "rate": "1"}]}`,
+		`{"rules": [{"region": "DE", "rate": "-0.1"}]}`,
+		`{"rules": [{"region": "DE", "rate": "19%"}]}`,
+		`{"rules": [{"region": "DEU", "rate": "0.19"}]}`,
+		`{"rules": [{"region": "DE", "rate": "0.19"}, {"region": "de", "category": "standard", "rate": "0.16"}]}`,
+	} {
+		if _, err := LoadFile(write(bad), false); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+}
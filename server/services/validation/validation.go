@@ -0,0 +1,299 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"upgraded-telegram/main.go/server/services/apperrors"
+	"upgraded-telegram/main.go/server/services/money"
+)
+
+// Rules are declared with a `validate` struct tag, for example `validate:"required,max=200"`.
+//
+//	required            must be present when creating
+//	key                 must be present when updating (the record id)
+//	min=N, max=N        numeric bounds, or length bounds for strings and slices
+//	email               must be a bare email address
+//	currency            must be a supported ISO 4217 currency code
+//	oneof=a b c         must be one of the listed values
+//	gtefield=Field      must be >= the named sibling field
+//	required_without=F  must be present when creating unless sibling F is present
+//
+// Nested structs, pointers to structs, slices of structs and maps of structs by string keys are validated
+// recursively, map entries under their key.
+
+type mode int
+
+const (
+	modeCreate mode = iota
+	modeUpdate
+)
+
+// Decode reads a single JSON object from the request body, rejecting unknown fields.
+func Decode(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(v)
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		var maxBytesErr *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesErr):
+			return apperrors.From(err)
+		case errors.Is(err, io.EOF):
+			return apperrors.BadRequest("empty_body", "Request body must not be empty.")
+		case errors.As(err, &syntaxErr):
+			return apperrors.BadRequest("malformed_json", fmt.Sprintf("Request body contains malformed JSON at position %d.", syntaxErr.Offset))
+		case errors.As(err, &typeErr):
+			return apperrors.InvalidFields([]apperrors.FieldError{{
+				Field:   typeErr.Field,
+				Code:    "invalid_type",
+				Message: fmt.Sprintf("must be of type %s", typeErr.Type),
+			}})
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+			return apperrors.InvalidFields([]apperrors.FieldError{{
+				Field:   field,
+				Code:    "unknown_field",
+				Message: "is not a recognized field",
+			}})
+		default:
+			return apperrors.BadRequest("invalid_body", "Invalid request body.").WithCause(err)
+		}
+	}
+
+	if decoder.More() {
+		return apperrors.BadRequest("invalid_body", "Request body must contain a single JSON object.")
+	}
+	return nil
+}
+
+// DecodeOptional is Decode for a body that may be left out, an empty body leaves v as it is.
+func DecodeOptional(r *http.Request, v interface{}) error {
+	err := Decode(r, v)
+	var appErr *apperrors.Error
+	if errors.As(err, &appErr) && appErr.Code == "empty_body" {
+		return nil
+	}
+	return err
+}
+
+// Create validates a payload that will create a new record.
+func Create(v interface{}) error {
+	return validate(v, modeCreate)
+}
+
+// Update validates a partial payload: fields left at their zero value are not checked.
+func Update(v interface{}) error {
+	return validate(v, modeUpdate)
+}
+
+func validate(v interface{}, m mode) error {
+	var fields []apperrors.FieldError
+	checkStruct(reflect.Indirect(reflect.ValueOf(v)), "", m, &fields)
+	if len(fields) > 0 {
+		return apperrors.InvalidFields(fields)
+	}
+	return nil
+}
+
+func checkStruct(sv reflect.Value, prefix string, m mode, fields *[]apperrors.FieldError) {
+	if sv.Kind() != reflect.Struct {
+		return
+	}
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		sf := st.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		fv := sv.Field(i)
+		name := prefix + jsonName(sf)
+
+		if tag := sf.Tag.Get("validate"); tag != "" {
+			if fe := checkField(sv, fv, tag, m); fe != nil {
+				fe.Field = name
+				*fields = append(*fields, *fe)
+				continue
+			}
+		}
+
+		switch fv.Kind() {
+		case reflect.Struct:
+			checkStruct(fv, name+".", m, fields)
+		case reflect.Pointer:
+			if !fv.IsNil() {
+				checkStruct(fv.Elem(), name+".", m, fields)
+			}
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() == reflect.Struct {
+				for j := 0; j < fv.Len(); j++ {
+					checkStruct(fv.Index(j), fmt.Sprintf("%s[%d].", name, j), m, fields)
+				}
+			}
+		case reflect.Map:
+			if fv.Type().Elem().Kind() == reflect.Struct && fv.Type().Key().Kind() == reflect.String {
+				keys := fv.MapKeys()
+				sort.Slice(keys, func(a, b int) bool { return keys[a].String() < keys[b].String() })
+				for _, key := range keys {
+					checkStruct(fv.MapIndex(key), name+"."+key.String()+".", m, fields)
+				}
+			}
+		}
+	}
+}
+
+// checkField returns the first rule the field breaks, or nil.
+func checkField(parent, fv reflect.Value, tag string, m mode) *apperrors.FieldError {
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		if fe := checkRule(parent, fv, name, param, m); fe != nil {
+			return fe
+		}
+	}
+	return nil
+}
+
+func checkRule(parent, fv reflect.Value, name, param string, m mode) *apperrors.FieldError {
+	empty := isEmpty(fv)
+	switch name {
+	case "required":
+		if m == modeCreate && empty {
+			return fieldError("required", "is required")
+		}
+		return nil
+	case "key":
+		if m == modeUpdate && empty {
+			return fieldError("required", "is required")
+		}
+		return nil
+	case "required_without":
+		if m == modeCreate && empty && isEmpty(parent.FieldByName(param)) {
+			return fieldError("required", fmt.Sprintf("is required when %s is not provided", jsonNameOf(parent, param)))
+		}
+		return nil
+	}
+
+	// every other rule only checks values that were actually provided
+	if empty && (m == modeUpdate || name != "min") {
+		return nil
+	}
+
+	switch name {
+	case "min", "max":
+		limit, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validation: bad %s parameter %q", name, param))
+		}
+		n, isLength := measure(fv)
+		if name == "min" && n < limit {
+			if isLength {
+				return fieldError("too_short", fmt.Sprintf("must contain at least %s", param))
+			}
+			return fieldError("too_small", fmt.Sprintf("must be at least %s", param))
+		}
+		if name == "max" && n > limit {
+			if isLength {
+				return fieldError("too_long", fmt.Sprintf("must contain at most %s", param))
+			}
+			return fieldError("too_large", fmt.Sprintf("must be at most %s", param))
+		}
+	case "email":
+		s := fv.String()
+		addr, err := mail.ParseAddress(s)
+		if err != nil || addr.Address != s {
+			return fieldError("invalid_email", "must be a valid email address")
+		}
+	case "currency":
+		if !money.Valid(fv.String()) {
+			return fieldError("invalid_currency", "must be a supported ISO 4217 currency code")
+		}
+	case "oneof":
+		s := fmt.Sprint(fv.Interface())
+		for _, allowed := range strings.Fields(param) {
+			if s == allowed {
+				return nil
+			}
+		}
+		return fieldError("invalid_value", fmt.Sprintf("must be one of: %s", strings.Join(strings.Fields(param), ", ")))
+	case "gtefield":
+		other := parent.FieldByName(param)
+		if isEmpty(other) {
+			return nil
+		}
+		if n, _ := measure(fv); n < toFloat(other) {
+			return fieldError("out_of_order", fmt.Sprintf("must not be before %s", jsonNameOf(parent, param)))
+		}
+	default:
+		panic(fmt.Sprintf("validation: unknown rule %q", name))
+	}
+	return nil
+}
+
+func fieldError(code, message string) *apperrors.FieldError {
+	return &apperrors.FieldError{Code: code, Message: message}
+}
+
+func isEmpty(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(v.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
+
+// measure returns a numeric value, or the length for strings and slices.
+func measure(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true
+	case reflect.Slice, reflect.Map:
+		return float64(v.Len()), true
+	default:
+		return toFloat(v), false
+	}
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return 0
+}
+
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+func jsonNameOf(parent reflect.Value, field string) string {
+	sf, ok := parent.Type().FieldByName(field)
+	if !ok {
+		return field
+	}
+	return jsonName(sf)
+}
@@ -3,14 +3,12 @@ package server
 import (
 	"encoding/json"
 	"fmt"
-	"log"
-	"os"
 	"time"
 
+	"upgraded-telegram/main.go/server/services/config"
 	"upgraded-telegram/main.go/server/services/db"
 
 	"github.com/brianvoe/gofakeit/v6"
-	"github.com/joho/godotenv"
 	vegeta "github.com/tsenart/vegeta/v12/lib"
 )
 
@@ -25,15 +23,11 @@ func generateRandomUserPayload() []byte {
 	return jsonPayload
 }
 
-func SimulateRequests() {
+func SimulateRequests(conf *config.Config) {
 	gofakeit.Seed(time.Now().UnixNano())
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	jwt := os.Getenv("SIMULATOR_JWT")
+	jwt := conf.SimulatorJWT
+	target := fmt.Sprintf("http://0.0.0.0:%d/v1/users", conf.Port)
 
 	rate := vegeta.Rate{Freq: 100, Per: time.Second} // 100 RPS
 	duration := 10 * time.Second
@@ -41,7 +35,7 @@ func SimulateRequests() {
 
 	targeter := func(t *vegeta.Target) error {
 		t.Method = "POST"
-		t.URL = "http://0.0.0.0:8080/users/new"
+		t.URL = target
 		t.Header = map[string][]string{
 			"Content-Type":  {"application/json"},
 			"Authorization": {"Bearer " + jwt},